@@ -2,19 +2,45 @@ package taglib
 
 import (
 	"bytes"
+	"cmp"
 	"context"
+	"crypto/sha256"
 	_ "embed"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"hash"
+	"image"
+	"image/color"
+	_ "image/gif" // Register decoder for ReadImageDecoded
+	"image/jpeg"
+	"image/png"
 	"io"
+	"io/fs"
+	"maps"
+	"math"
+	"math/bits"
+	"net/http"
+	"net/url"
 	"os"
+	"path"
 	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
+	"unicode/utf16"
+	"unicode/utf8"
 
 	"github.com/tetratelabs/wazero"
 	"github.com/tetratelabs/wazero/api"
+	experimentalsys "github.com/tetratelabs/wazero/experimental/sys"
+	"github.com/tetratelabs/wazero/experimental/sysfs"
 	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+	"github.com/tetratelabs/wazero/sys"
 )
 
 //go:embed taglib.wasm
@@ -23,6 +49,25 @@ var binaryPath string
 
 var ErrInvalidFile = fmt.Errorf("invalid file")
 var ErrSavingFile = fmt.Errorf("can't save file")
+var ErrInvalidImage = fmt.Errorf("invalid image")
+var ErrUnsupportedFormat = fmt.Errorf("unsupported format")
+var ErrNoImage = fmt.Errorf("no image")
+var ErrTooLarge = fmt.Errorf("value too large")
+var ErrNoOwnership = fmt.Errorf("no ownership")
+var ErrNoMusicalKey = fmt.Errorf("no musical key")
+var ErrInvalidLanguage = fmt.Errorf("invalid language")
+var ErrInvalidScript = fmt.Errorf("invalid script")
+
+// TruncationError is returned by write operations using [TruncateWarn] or [TruncateError]
+// when some values are too long for the fixed-size fields of the target file.
+type TruncationError struct {
+	// Keys are the tag keys whose values won't fit
+	Keys []string
+}
+
+func (e *TruncationError) Error() string {
+	return fmt.Sprintf("values too long for target fields: %s", strings.Join(e.Keys, ", "))
+}
 
 // Version returns the version of the embedded TagLib library (e.g., "2.2.1").
 func Version() string {
@@ -112,6 +157,123 @@ func (f FileFormat) String() string {
 	}
 }
 
+// Extension returns the conventional file extension for the format, including the leading dot (e.g. ".flac").
+// Returns an empty string for [FormatUnknown].
+func (f FileFormat) Extension() string {
+	switch f {
+	case FormatMPEG:
+		return ".mp3"
+	case FormatMP4:
+		return ".m4a"
+	case FormatFLAC:
+		return ".flac"
+	case FormatOggVorbis:
+		return ".ogg"
+	case FormatOggOpus:
+		return ".opus"
+	case FormatOggFLAC:
+		return ".oga"
+	case FormatOggSpeex:
+		return ".spx"
+	case FormatWAV:
+		return ".wav"
+	case FormatAIFF:
+		return ".aiff"
+	case FormatASF:
+		return ".wma"
+	case FormatAPE:
+		return ".ape"
+	case FormatWavPack:
+		return ".wv"
+	case FormatDSF:
+		return ".dsf"
+	case FormatDSDIFF:
+		return ".dff"
+	case FormatTrueAudio:
+		return ".tta"
+	case FormatMPC:
+		return ".mpc"
+	case FormatShorten:
+		return ".shn"
+	case FormatMatroska:
+		return ".mka"
+	default:
+		return ""
+	}
+}
+
+// IsOgg reports whether the format is carried in an Ogg container (Vorbis, Opus, FLAC, or Speex).
+func (f FileFormat) IsOgg() bool {
+	switch f {
+	case FormatOggVorbis, FormatOggOpus, FormatOggFLAC, FormatOggSpeex:
+		return true
+	default:
+		return false
+	}
+}
+
+// IsDSD reports whether the format stores DSD audio (DSF or DSDIFF).
+func (f FileFormat) IsDSD() bool {
+	return f == FormatDSF || f == FormatDSDIFF
+}
+
+// IsMPEG4 reports whether the format is an MPEG-4 (ISO base media) container.
+func (f FileFormat) IsMPEG4() bool {
+	return f == FormatMP4
+}
+
+// IsRIFF reports whether the format is a chunk-based RIFF/IFF container (WAV or AIFF).
+func (f FileFormat) IsRIFF() bool {
+	return f == FormatWAV || f == FormatAIFF
+}
+
+// extensionFormats maps lowercase file extensions to the format TagLib resolves them to.
+var extensionFormats = map[string]FileFormat{
+	".mp3":  FormatMPEG,
+	".mp2":  FormatMPEG,
+	".aac":  FormatMPEG,
+	".m4a":  FormatMP4,
+	".m4b":  FormatMP4,
+	".m4p":  FormatMP4,
+	".m4r":  FormatMP4,
+	".m4v":  FormatMP4,
+	".mp4":  FormatMP4,
+	".3g2":  FormatMP4,
+	".flac": FormatFLAC,
+	".ogg":  FormatOggVorbis,
+	".opus": FormatOggOpus,
+	".oga":  FormatOggFLAC,
+	".spx":  FormatOggSpeex,
+	".wav":  FormatWAV,
+	".aif":  FormatAIFF,
+	".aiff": FormatAIFF,
+	".aifc": FormatAIFF,
+	".wma":  FormatASF,
+	".asf":  FormatASF,
+	".ape":  FormatAPE,
+	".wv":   FormatWavPack,
+	".dsf":  FormatDSF,
+	".dff":  FormatDSDIFF,
+	".tta":  FormatTrueAudio,
+	".mpc":  FormatMPC,
+	".mpp":  FormatMPC,
+	".shn":  FormatShorten,
+	".mka":  FormatMatroska,
+	".mkv":  FormatMatroska,
+	".webm": FormatMatroska,
+}
+
+// FileFormatFromExtension returns the format conventionally associated with a file extension such as
+// ".flac" or "flac". The match is case-insensitive. Returns [FormatUnknown] for unrecognised extensions.
+// This is the inverse of [FileFormat.Extension].
+func FileFormatFromExtension(ext string) FileFormat {
+	ext = strings.ToLower(ext)
+	if !strings.HasPrefix(ext, ".") {
+		ext = "." + ext
+	}
+	return extensionFormats[ext]
+}
+
 // ReadStyle controls how thoroughly audio properties are read from a file.
 // Higher accuracy requires reading more of the file, which takes longer.
 type ReadStyle uint8
@@ -130,7 +292,10 @@ type OpenOption func(*openOptions)
 
 type openOptions struct {
 	readStyle ReadStyle
-	filename  string // hint for format detection in OpenStream
+	filename  string          // hint for format detection in OpenStream
+	ctx       context.Context // bounds stream I/O in OpenStream
+	format    FileFormat      // forced format, or FormatUnknown to detect it
+	limits    readLimits
 }
 
 // WithReadStyle sets the read style for audio properties.
@@ -150,13 +315,65 @@ func WithFilename(name string) OpenOption {
 	}
 }
 
+// WithContext bounds the stream I/O of [OpenStream] by ctx. Once ctx is cancelled or its deadline passes,
+// reads and seeks fail immediately, even if the underlying reader is blocked, so TagLib can't hang on a stalled source.
+// A reader abandoned this way is never used again, but its blocked call keeps running until it returns by itself.
+// ctx stays bound to the [File] until it is closed, covering later calls that read the stream, such as
+// [File.Image] and [File.SetReadStyle], not just the open. Cancelling ctx after opening makes those calls fail.
+func WithContext(ctx context.Context) OpenOption {
+	return func(o *openOptions) {
+		o.ctx = ctx
+	}
+}
+
+// WithFormat opens the file as format, using TagLib's parser for that format rather than detecting it from the
+// extension and content, which rescues files that are detected as the wrong format. Returns [ErrInvalidFile] if the
+// file can't be parsed as format. [FormatUnknown] detects the format as usual, which is the default. The format
+// also applies to [File.SetReadStyle] and to the files opened by [File.Reopen].
+func WithFormat(format FileFormat) OpenOption {
+	return func(o *openOptions) {
+		o.format = format
+	}
+}
+
+// WithMaxImageBytes limits the size of images read from the file to n bytes. Reading a larger image returns
+// [ErrTooLarge] rather than copying it out of the Wasm module. 0 means no limit, which is the default.
+func WithMaxImageBytes(n int) OpenOption {
+	return func(o *openOptions) {
+		o.limits.maxBytes = n
+	}
+}
+
+// WithMaxTagBytes limits the combined size of the tags read from the file at once to n bytes. Reading larger
+// tags returns [ErrTooLarge] rather than copying them out of the Wasm module. 0 means no limit, which is the default.
+func WithMaxTagBytes(n int) OpenOption {
+	return func(o *openOptions) {
+		o.limits.maxStringBytes = n
+	}
+}
+
+// WithMaxValueBytes limits the size of each tag read from the file, counting its key, to n bytes. Reading a
+// larger tag returns [ErrTooLarge] as soon as the limit is passed, so a crafted file with a huge value can't
+// cause a huge allocation. 0 means no limit, which is the default.
+func WithMaxValueBytes(n int) OpenOption {
+	return func(o *openOptions) {
+		o.limits.maxValueBytes = n
+	}
+}
+
 // File represents an open audio file handle for efficient multiple operations.
 // Use [Open] or [OpenReadOnly] to create a File, and always call [File.Close] when done.
 type File struct {
-	mod      module
-	handle   uint32
-	format   FileFormat
-	streamId uint32 // non-zero if opened via OpenStream
+	mod       module
+	handle    uint32
+	format    FileFormat
+	path      string     // absolute path, empty if opened via OpenStream
+	streamId  uint32     // non-zero if opened via OpenStream
+	filename  string     // WithFilename hint, if opened via OpenStream
+	forced    FileFormat // WithFormat format, if any
+	readOnly  bool
+	readStyle ReadStyle
+	raw       *Properties // properties read from the file rather than TagLib, once read by Properties
 }
 
 // Open opens an audio file for reading and writing.
@@ -167,7 +384,7 @@ func Open(path string, opts ...OpenOption) (*File, error) {
 	for _, opt := range opts {
 		opt(o)
 	}
-	return openFile(path, false, o.readStyle)
+	return openFile(path, false, o)
 }
 
 // OpenReadOnly opens an audio file for reading only.
@@ -178,11 +395,13 @@ func OpenReadOnly(path string, opts ...OpenOption) (*File, error) {
 	for _, opt := range opts {
 		opt(o)
 	}
-	return openFile(path, true, o.readStyle)
+	return openFile(path, true, o)
 }
 
 // OpenStream opens an audio stream for reading metadata.
-// The reader must remain valid for the lifetime of the returned File.
+// The reader must remain valid for the lifetime of the returned File. Values that TagLib doesn't read, such as
+// [Properties.ChapterCount] and [Properties.ChannelLayout], are only read if r is also an [io.ReaderAt], since r is
+// never moved other than by TagLib.
 // The returned File must be closed with [File.Close] when done.
 // This is useful for reading from network streams, archives, or in-memory buffers.
 // Options can be provided to configure behavior (e.g., [WithReadStyle]).
@@ -191,6 +410,12 @@ func OpenStream(r io.ReadSeeker, opts ...OpenOption) (*File, error) {
 	for _, opt := range opts {
 		opt(o)
 	}
+	if o.ctx != nil {
+		if err := o.ctx.Err(); err != nil {
+			return nil, err
+		}
+		r = &ctxStream{ctx: o.ctx, r: r}
+	}
 	streamId := registerStream(r)
 
 	mod, err := newModuleForStream()
@@ -198,9 +423,10 @@ func OpenStream(r io.ReadSeeker, opts ...OpenOption) (*File, error) {
 		unregisterStream(streamId)
 		return nil, fmt.Errorf("init module: %w", err)
 	}
+	mod.limits = o.limits
 
 	var result wasmOpenResult
-	if err := mod.call("taglib_stream_open", &result, wasmUint32(streamId), wasmString(o.filename), wasmUint8(o.readStyle)); err != nil {
+	if err := mod.openStream(&result, streamId, o.filename, o.readStyle, o.format); err != nil {
 		mod.close()
 		unregisterStream(streamId)
 		return nil, fmt.Errorf("call: %w", err)
@@ -208,37 +434,78 @@ func OpenStream(r io.ReadSeeker, opts ...OpenOption) (*File, error) {
 	if result.handle == 0 {
 		mod.close()
 		unregisterStream(streamId)
+		if o.ctx != nil && o.ctx.Err() != nil {
+			return nil, o.ctx.Err()
+		}
 		return nil, ErrInvalidFile
 	}
 
 	return &File{
-		mod:      mod,
-		handle:   result.handle,
-		format:   FileFormat(result.format),
-		streamId: streamId,
+		mod:       mod,
+		handle:    result.handle,
+		format:    FileFormat(result.format),
+		streamId:  streamId,
+		filename:  o.filename,
+		forced:    o.format,
+		readOnly:  true,
+		readStyle: o.readStyle,
 	}, nil
 }
 
-func openFile(path string, readOnly bool, readStyle ReadStyle) (*File, error) {
+// OpenURL opens a remote audio file for reading metadata, like [OpenStream] over a [RangeReader] using
+// [http.DefaultClient]. Only the parts of the file TagLib reads are fetched, rather than the whole file. ctx bounds
+// the requests, as with [WithContext], and the last element of the URL's path is used as the [WithFilename] hint
+// unless one is given.
+func OpenURL(ctx context.Context, rawURL string, opts ...OpenOption) (*File, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("parse url: %w", err)
+	}
+	r, err := NewRangeReader(ctx, nil, rawURL)
+	if err != nil {
+		return nil, err
+	}
+	opts = append([]OpenOption{WithFilename(path.Base(u.Path)), WithContext(ctx)}, opts...)
+	return OpenStream(r, opts...)
+}
+
+// OpenFileHandle opens an already open file for reading metadata, like [OpenStream]. If the file is a regular file
+// that can still be found by its name, it is opened by path instead, which is faster than reading it through the
+// stream. Otherwise, such as for pipes or files that were renamed or removed since they were opened, it is read as a
+// stream, from f's name as the [WithFilename] hint unless one is given.
+// f must remain open for the lifetime of the returned File, which is read-only either way and doesn't close f.
+func OpenFileHandle(f *os.File, opts ...OpenOption) (*File, error) {
+	o := &openOptions{readStyle: ReadStyleAverage}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	if info, err := f.Stat(); err == nil && info.Mode().IsRegular() && f.Name() != "" {
+		if named, err := os.Stat(f.Name()); err == nil && os.SameFile(info, named) {
+			return openFile(f.Name(), true, o)
+		}
+	}
+	if o.filename == "" {
+		opts = append(opts, WithFilename(filepath.Base(f.Name())))
+	}
+	return OpenStream(f, opts...)
+}
+
+func openFile(path string, readOnly bool, o *openOptions) (*File, error) {
 	var err error
 	path, err = filepath.Abs(path)
 	if err != nil {
 		return nil, fmt.Errorf("make path abs: %w", err)
 	}
 
-	dir := filepath.Dir(path)
-	var mod module
-	if readOnly {
-		mod, err = newModuleRO(dir)
-	} else {
-		mod, err = newModule(dir)
-	}
+	mod, err := newFileModule(path, readOnly)
 	if err != nil {
 		return nil, fmt.Errorf("init module: %w", err)
 	}
+	mod.limits = o.limits
 
 	var result wasmOpenResult
-	if err := mod.call("taglib_file_open", &result, wasmString(wasmPath(path)), wasmUint8(readStyle)); err != nil {
+	if err := mod.openPath(&result, path, o.readStyle, o.format); err != nil {
 		mod.close()
 		return nil, fmt.Errorf("call: %w", err)
 	}
@@ -248,12 +515,135 @@ func openFile(path string, readOnly bool, readStyle ReadStyle) (*File, error) {
 	}
 
 	return &File{
-		mod:    mod,
-		handle: result.handle,
-		format: FileFormat(result.format),
+		mod:       mod,
+		handle:    result.handle,
+		format:    FileFormat(result.format),
+		path:      path,
+		forced:    o.format,
+		readOnly:  readOnly,
+		readStyle: o.readStyle,
 	}, nil
 }
 
+func newFileModule(path string, readOnly bool) (module, error) {
+	if readOnly {
+		return newModuleRO(path)
+	}
+	return newModule(path)
+}
+
+// Reopen closes the current file and opens the file at path in its place, with the same mode and options.
+// If path is in the same directory as the current file, the module is reused rather than creating a new one,
+// which makes processing the files of a directory one after another cheaper.
+// Files opened with [OpenStream] are reopened read-only. If Reopen fails, the File is closed.
+func (f *File) Reopen(path string) error {
+	if f.handle == 0 {
+		return fmt.Errorf("reopen closed file")
+	}
+
+	var err error
+	path, err = filepath.Abs(path)
+	if err != nil {
+		return fmt.Errorf("make path abs: %w", err)
+	}
+
+	var out wasmBool
+	_ = f.mod.call("taglib_file_close", &out, wasmUint32(f.handle))
+	f.handle = 0
+	if f.streamId != 0 {
+		unregisterStream(f.streamId)
+		f.streamId = 0
+	}
+
+	if f.mod.files != nil && filepath.Dir(f.path) == filepath.Dir(path) {
+		// Swap the mounted file rather than creating a new module
+		f.mod.files.names = map[string]bool{filepath.Base(path): true}
+		f.mod.files.aliases = nil
+	} else {
+		limits := f.mod.limits
+		f.mod.close()
+		f.mod, err = newFileModule(path, f.readOnly)
+		if err != nil {
+			f.mod = module{}
+			return fmt.Errorf("init module: %w", err)
+		}
+		f.mod.limits = limits
+	}
+
+	var result wasmOpenResult
+	if err := f.mod.openPath(&result, path, f.readStyle, f.forced); err != nil {
+		f.mod.close()
+		f.mod = module{}
+		return fmt.Errorf("call: %w", err)
+	}
+	if result.handle == 0 {
+		f.mod.close()
+		f.mod = module{}
+		return ErrInvalidFile
+	}
+
+	f.handle = result.handle
+	f.format = FileFormat(result.format)
+	f.path = path
+	f.raw = nil
+	return nil
+}
+
+// replaceFile closes the handle of f while replace changes the file at its path, and opens it again afterwards, so
+// that the handle never reads a file that changed under it. The file is opened again even if replace fails. If it
+// can't be, the File is closed.
+func (f *File) replaceFile(replace func() error) error {
+	var out wasmBool
+	_ = f.mod.call("taglib_file_close", &out, wasmUint32(f.handle))
+	f.handle = 0
+	replaceErr := replace()
+
+	var result wasmOpenResult
+	if err := f.mod.openPath(&result, f.path, f.readStyle, f.forced); err != nil {
+		f.mod.close()
+		f.mod = module{}
+		return fmt.Errorf("call: %w", err)
+	}
+	if result.handle == 0 {
+		f.mod.close()
+		f.mod = module{}
+		return ErrInvalidFile
+	}
+	f.handle = result.handle
+	f.raw = nil
+	return replaceErr
+}
+
+// SetReadStyle re-reads the file's audio properties with style, without closing and reopening the File. This
+// allows a cheap first look with [ReadStyleFast], then accurate values, such as the duration of a VBR file, only
+// when they turn out to be needed. It only affects subsequent calls to [File.Properties] and the like.
+func (f *File) SetReadStyle(style ReadStyle) error {
+	if f.handle == 0 {
+		return fmt.Errorf("set read style on closed file")
+	}
+
+	var result wasmOpenResult
+	var err error
+	if f.streamId != 0 {
+		err = f.mod.openStream(&result, f.streamId, f.filename, style, f.forced)
+	} else {
+		err = f.mod.openPath(&result, f.path, style, f.forced)
+	}
+	if err != nil {
+		return fmt.Errorf("call: %w", err)
+	}
+	if result.handle == 0 {
+		return ErrInvalidFile
+	}
+
+	var out wasmBool
+	_ = f.mod.call("taglib_file_close", &out, wasmUint32(f.handle))
+	f.handle = result.handle
+	f.readStyle = style
+	f.raw = nil
+	return nil
+}
+
 // Close releases the file handle and associated resources.
 // After Close is called, the File should not be used.
 func (f *File) Close() error {
@@ -278,12 +668,17 @@ func (f *File) Format() FileFormat {
 
 // Tags reads all normalized metadata tags from the file.
 func (f *File) Tags() map[string][]string {
+	tags, _ := f.readTags()
+	return tags
+}
+
+func (f *File) readTags() (map[string][]string, error) {
 	var raw wasmStrings
 	if err := f.mod.call("taglib_handle_tags", &raw, wasmUint32(f.handle)); err != nil {
-		return nil
+		return nil, fmt.Errorf("call: %w", err)
 	}
 	if raw == nil {
-		return nil
+		return nil, ErrInvalidFile
 	}
 
 	tags := map[string][]string{}
@@ -294,847 +689,9530 @@ func (f *File) Tags() map[string][]string {
 		}
 		tags[k] = append(tags[k], v)
 	}
-	return tags
+	if _, ok := tags[Genre]; ok && f.format == FormatMP4 {
+		f.readRaw(func(r io.ReaderAt) {
+			if genres, ok := mp4Genres(r); ok {
+				tags[Genre] = genres
+			}
+		})
+	}
+	return tags, nil
 }
 
-// RawTags reads format-specific tags from the file.
-// For MP3/WAV/AIFF: returns ID3v2 frames
-// For MP4: returns MP4 atoms
-// For ASF: returns ASF attributes
-// For other formats (FLAC, OGG, etc.): returns same as Tags() (Vorbis Comments)
-func (f *File) RawTags() map[string][]string {
-	var raw wasmStrings
-	if err := f.mod.call("taglib_handle_raw_tags", &raw, wasmUint32(f.handle)); err != nil {
-		return nil
-	}
-	if raw == nil {
-		return nil
+// IsCompilation reports whether the file is marked as part of a compilation.
+// This reads [Compilation], which TagLib maps from ID3v2 TCMP, MP4 cpil, and Vorbis COMPILATION.
+func (f *File) IsCompilation() (bool, error) {
+	tags, err := f.readTags()
+	if err != nil {
+		return false, err
 	}
+	return len(tags[Compilation]) > 0 && parseTagBool(tags[Compilation][0]), nil
+}
 
-	tags := map[string][]string{}
-	for _, row := range raw {
-		k, v, ok := strings.Cut(row, "\t")
-		if !ok {
-			continue
-		}
-		tags[k] = append(tags[k], v)
+// SetCompilation marks or unmarks the file as part of a compilation.
+// Unmarking removes the [Compilation] tag rather than writing "0".
+func (f *File) SetCompilation(compilation bool) error {
+	var vs []string
+	if compilation {
+		vs = []string{"1"}
 	}
-	return tags
+	return f.WriteTags(map[string][]string{Compilation: vs}, 0)
 }
 
-// AllTags contains both normalized and format-specific tags.
-type AllTags struct {
-	// Tags contains normalized tag keys (TITLE, ARTIST, etc.)
-	Tags map[string][]string
-	// Raw contains format-specific tags (ID3v2 frames, MP4 atoms, etc.)
-	Raw map[string][]string
-	// Format is the detected audio file format
-	Format FileFormat
+// SortNames holds the sort order fields of a file, used to sort by names other than the displayed ones,
+// such as "Beatles, The" for "The Beatles". Empty fields aren't set.
+type SortNames struct {
+	Title       string // TITLESORT: ID3v2 TSOT, MP4 sonm
+	Album       string // ALBUMSORT: ID3v2 TSOA, MP4 soal
+	Artist      string // ARTISTSORT: ID3v2 TSOP, MP4 soar
+	AlbumArtist string // ALBUMARTISTSORT: ID3v2 TSO2, MP4 soaa
+	Composer    string // COMPOSERSORT: ID3v2 TSOC, MP4 soco
 }
 
-// AllTags reads both normalized and format-specific tags in a single struct.
-func (f *File) AllTags() AllTags {
-	return AllTags{
-		Tags:   f.Tags(),
-		Raw:    f.RawTags(),
-		Format: f.format,
+// SortNames reads the sort order fields of the file. Only the first value of each is used.
+func (f *File) SortNames() (SortNames, error) {
+	tags, err := f.readTags()
+	if err != nil {
+		return SortNames{}, err
 	}
+	return SortNames{
+		Title:       firstTag(tags, TitleSort),
+		Album:       firstTag(tags, AlbumSort),
+		Artist:      firstTag(tags, ArtistSort),
+		AlbumArtist: firstTag(tags, AlbumArtistSort),
+		Composer:    firstTag(tags, ComposerSort),
+	}, nil
 }
 
-// Properties reads the audio properties from the file.
-func (f *File) Properties() Properties {
-	var raw wasmFileProperties
-	if err := f.mod.call("taglib_handle_properties", &raw, wasmUint32(f.handle)); err != nil {
-		return Properties{}
-	}
+// SetSortNames writes all the sort order fields of the file. Empty fields remove the tag.
+func (f *File) SetSortNames(names SortNames) error {
+	return f.WriteTags(map[string][]string{
+		TitleSort:       tagValue(names.Title),
+		AlbumSort:       tagValue(names.Album),
+		ArtistSort:      tagValue(names.Artist),
+		AlbumArtistSort: tagValue(names.AlbumArtist),
+		ComposerSort:    tagValue(names.Composer),
+	}, 0)
+}
 
-	var images []ImageDesc
-	for _, row := range raw.imageDescs {
-		parts := strings.SplitN(row, "\t", 3)
-		if len(parts) != 3 {
-			continue
-		}
-		images = append(images, ImageDesc{
-			Type:        parts[0],
-			Description: parts[1],
-			MIMEType:    parts[2],
-		})
-	}
+// ArtistCredits holds the artist and album artist of a file, each both as the canonical name and as credited on the
+// release, as MusicBrainz Picard writes them. For example, an artist canonically named "Prince" may be credited as
+// "The Artist Formerly Known as Prince". These are unrelated to the performer roles of [ReadCredits].
+// Empty fields aren't set.
+type ArtistCredits struct {
+	Artist            string // ARTIST
+	ArtistCredit      string // ARTIST_CREDIT: ID3v2 TXXX:ARTIST_CREDIT, MP4 ----:com.apple.iTunes:ARTIST_CREDIT
+	AlbumArtist       string // ALBUMARTIST
+	AlbumArtistCredit string // ALBUMARTIST_CREDIT: ID3v2 TXXX:ALBUMARTIST_CREDIT, MP4 ----:com.apple.iTunes:ALBUMARTIST_CREDIT
+}
 
-	return Properties{
-		Length:        time.Duration(raw.lengthInMilliseconds) * time.Millisecond,
-		Channels:      uint(raw.channels),
-		SampleRate:    uint(raw.sampleRate),
-		Bitrate:       uint(raw.bitrate),
-		BitsPerSample: uint(raw.bitsPerSample),
-		Codec:         raw.codec,
-		Images:        images,
+// Tag keys of the credited names of [ArtistCredits].
+const (
+	artistCreditKey      = "ARTIST_CREDIT"
+	albumArtistCreditKey = "ALBUMARTIST_CREDIT"
+)
+
+// ArtistCredits reads the canonical and credited names of the artist and album artist of the file. Only the first value
+// of each is used.
+func (f *File) ArtistCredits() (ArtistCredits, error) {
+	tags, err := f.readTags()
+	if err != nil {
+		return ArtistCredits{}, err
 	}
+	return ArtistCredits{
+		Artist:            firstTag(tags, Artist),
+		ArtistCredit:      firstTag(tags, artistCreditKey),
+		AlbumArtist:       firstTag(tags, AlbumArtist),
+		AlbumArtistCredit: firstTag(tags, albumArtistCreditKey),
+	}, nil
 }
 
-// Image reads the embedded image at the specified index from the file.
-// Index 0 is the first image. Returns empty byte slice if index is out of range.
-func (f *File) Image(index int) ([]byte, error) {
-	var img wasmBytes
-	if err := f.mod.call("taglib_handle_image", &img, wasmUint32(f.handle), wasmInt(index)); err != nil {
-		return nil, fmt.Errorf("call: %w", err)
+// SetArtistCredits writes all the canonical and credited names of the file together, so that they can't get out of sync.
+// Empty fields remove the tag. Returns an [*UnsupportedKeysError] if credits are given for a format that can't
+// store them, such as ASF, without writing anything.
+func (f *File) SetArtistCredits(credits ArtistCredits) error {
+	tags := map[string][]string{
+		Artist:      tagValue(credits.Artist),
+		AlbumArtist: tagValue(credits.AlbumArtist),
 	}
-	return img, nil
+	if keySupported(f.format, artistCreditKey) {
+		tags[artistCreditKey] = tagValue(credits.ArtistCredit)
+		tags[albumArtistCreditKey] = tagValue(credits.AlbumArtistCredit)
+	} else if credits.ArtistCredit != "" || credits.AlbumArtistCredit != "" {
+		return &UnsupportedKeysError{Format: f.format, Keys: []string{albumArtistCreditKey, artistCreditKey}}
+	}
+	return f.WriteTags(tags, 0)
 }
 
-// WriteTags writes the metadata key-values pairs to the file.
-// The behavior can be controlled with [WriteOption].
-func (f *File) WriteTags(tags map[string][]string, opts WriteOption) error {
-	var raw []string
-	for k, vs := range tags {
-		raw = append(raw, fmt.Sprintf("%s\t%s", k, strings.Join(vs, "\v")))
+// PodcastInfo holds the podcast fields of a podcast episode file. Empty fields aren't set.
+type PodcastInfo struct {
+	Podcast     bool   // PODCAST: ID3v2 PCST, MP4 pcst
+	Category    string // PODCASTCATEGORY: ID3v2 TCAT, MP4 catg
+	Description string // PODCASTDESC: ID3v2 TDES, MP4 desc
+	EpisodeGUID string // PODCASTID: ID3v2 TGID, MP4 egid. The GUID of the episode in its feed.
+	FeedURL     string // PODCASTURL: ID3v2 WFED, MP4 purl
+}
+
+// PodcastInfo reads the podcast fields of the file. Only the first value of each is used.
+func (f *File) PodcastInfo() (PodcastInfo, error) {
+	tags, err := f.readTags()
+	if err != nil {
+		return PodcastInfo{}, err
 	}
 
-	var out wasmBool
-	if err := f.mod.call("taglib_handle_write_tags", &out, wasmUint32(f.handle), wasmStrings(raw), wasmUint8(opts)); err != nil {
-		return fmt.Errorf("call: %w", err)
+	podcast, err := f.isPodcast(tags)
+	if err != nil {
+		return PodcastInfo{}, err
 	}
-	if !out {
-		return ErrSavingFile
+
+	return PodcastInfo{
+		Podcast:     podcast,
+		Category:    firstTag(tags, PodcastCategory),
+		Description: firstTag(tags, PodcastDesc),
+		EpisodeGUID: firstTag(tags, PodcastID),
+		FeedURL:     firstTag(tags, PodcastURL),
+	}, nil
+}
+
+// SetPodcastInfo writes all the podcast fields of the file. Empty fields remove the tag, as does a false Podcast.
+func (f *File) SetPodcastInfo(info PodcastInfo) error {
+	var podcast []string
+	if info.Podcast {
+		podcast = []string{"1"}
 	}
-	return nil
+	return f.WriteTags(map[string][]string{
+		Podcast:         podcast,
+		PodcastCategory: tagValue(info.Category),
+		PodcastDesc:     tagValue(info.Description),
+		PodcastID:       tagValue(info.EpisodeGUID),
+		PodcastURL:      tagValue(info.FeedURL),
+	}, 0)
 }
 
-// WriteImage writes an image with custom metadata.
-// Index specifies which image slot to write to (0 = first image).
-// Set image to nil to clear the image at that index.
-func (f *File) WriteImage(image []byte, index int, imageType, description, mimeType string) error {
-	var out wasmBool
-	if err := f.mod.call("taglib_handle_write_image", &out, wasmUint32(f.handle), wasmBytes(image), wasmUint32(uint32(len(image))), wasmInt(index), wasmString(imageType), wasmString(description), wasmString(mimeType)); err != nil {
-		return fmt.Errorf("call: %w", err)
+// isPodcast reports whether the file with tags is marked as a podcast episode.
+func (f *File) isPodcast(tags map[string][]string) (bool, error) {
+	if len(tags[Podcast]) > 0 && parseTagBool(tags[Podcast][0]) {
+		return true, nil
 	}
-	if !out {
-		return ErrSavingFile
+	if !hasID3v2Tags(f.format) {
+		return false, nil
 	}
-	return nil
+	// The ID3v2 PCST frame has no value to map, so only its presence marks a podcast
+	raw, err := f.readRawTags()
+	if err != nil {
+		return false, err
+	}
+	_, podcast := raw["PCST"]
+	return podcast, nil
 }
 
-// These constants define normalized tag keys used by TagLib's [property mapping].
-// When using [ReadTags], the library will map format-specific metadata to these standardized keys.
-// Similarly, [WriteTags] will map these keys back to the appropriate format-specific fields.
-//
-// While these constants provide a consistent interface across different audio formats,
-// you can also use custom tag keys if the underlying format supports arbitrary tags.
-//
-// [property mapping]: https://taglib.org/api/p_propertymapping.html
-const (
-	AcoustIDFingerprint       = "ACOUSTID_FINGERPRINT"
-	AcoustIDID                = "ACOUSTID_ID"
-	Album                     = "ALBUM"
-	AlbumArtist               = "ALBUMARTIST"
-	AlbumArtistSort           = "ALBUMARTISTSORT"
-	AlbumSort                 = "ALBUMSORT"
-	Arranger                  = "ARRANGER"
-	Artist                    = "ARTIST"
-	Artists                   = "ARTISTS"
-	ArtistSort                = "ARTISTSORT"
-	ArtistWebpage             = "ARTISTWEBPAGE"
-	ASIN                      = "ASIN"
-	AudioSourceWebpage        = "AUDIOSOURCEWEBPAGE"
-	Barcode                   = "BARCODE"
-	BPM                       = "BPM"
-	CatalogNumber             = "CATALOGNUMBER"
-	Comment                   = "COMMENT"
-	Compilation               = "COMPILATION"
-	Composer                  = "COMPOSER"
-	ComposerSort              = "COMPOSERSORT"
-	Conductor                 = "CONDUCTOR"
-	Copyright                 = "COPYRIGHT"
-	CopyrightURL              = "COPYRIGHTURL"
-	Date                      = "DATE"
-	DiscNumber                = "DISCNUMBER"
-	DiscSubtitle              = "DISCSUBTITLE"
-	DJMixer                   = "DJMIXER"
-	EncodedBy                 = "ENCODEDBY"
-	Encoding                  = "ENCODING"
-	EncodingTime              = "ENCODINGTIME"
-	Engineer                  = "ENGINEER"
-	FileType                  = "FILETYPE"
-	FileWebpage               = "FILEWEBPAGE"
-	GaplessPlayback           = "GAPLESSPLAYBACK"
-	Genre                     = "GENRE"
-	Grouping                  = "GROUPING"
-	InitialKey                = "INITIALKEY"
-	InvolvedPeople            = "INVOLVEDPEOPLE"
-	ISRC                      = "ISRC"
-	Label                     = "LABEL"
-	Language                  = "LANGUAGE"
-	Length                    = "LENGTH"
-	License                   = "LICENSE"
-	Lyricist                  = "LYRICIST"
-	Lyrics                    = "LYRICS"
-	Media                     = "MEDIA"
-	Mixer                     = "MIXER"
-	Mood                      = "MOOD"
-	MovementCount             = "MOVEMENTCOUNT"
-	MovementName              = "MOVEMENTNAME"
-	MovementNumber            = "MOVEMENTNUMBER"
-	MusicBrainzAlbumID        = "MUSICBRAINZ_ALBUMID"
-	MusicBrainzAlbumArtistID  = "MUSICBRAINZ_ALBUMARTISTID"
-	MusicBrainzArtistID       = "MUSICBRAINZ_ARTISTID"
-	MusicBrainzReleaseGroupID = "MUSICBRAINZ_RELEASEGROUPID"
-	MusicBrainzReleaseTrackID = "MUSICBRAINZ_RELEASETRACKID"
-	MusicBrainzTrackID        = "MUSICBRAINZ_TRACKID"
-	MusicBrainzWorkID         = "MUSICBRAINZ_WORKID"
-	MusicianCredits           = "MUSICIANCREDITS"
-	MusicIPPUID               = "MUSICIP_PUID"
-	OriginalAlbum             = "ORIGINALALBUM"
-	OriginalArtist            = "ORIGINALARTIST"
-	OriginalDate              = "ORIGINALDATE"
-	OriginalFilename          = "ORIGINALFILENAME"
-	OriginalLyricist          = "ORIGINALLYRICIST"
-	Owner                     = "OWNER"
-	PaymentWebpage            = "PAYMENTWEBPAGE"
-	Performer                 = "PERFORMER"
-	PlaylistDelay             = "PLAYLISTDELAY"
-	Podcast                   = "PODCAST"
-	PodcastCategory           = "PODCASTCATEGORY"
-	PodcastDesc               = "PODCASTDESC"
-	PodcastID                 = "PODCASTID"
-	PodcastURL                = "PODCASTURL"
-	ProducedNotice            = "PRODUCEDNOTICE"
-	Producer                  = "PRODUCER"
-	PublisherWebpage          = "PUBLISHERWEBPAGE"
-	RadioStation              = "RADIOSTATION"
-	RadioStationOwner         = "RADIOSTATIONOWNER"
-	RadioStationWebpage       = "RADIOSTATIONWEBPAGE"
-	ReleaseCountry            = "RELEASECOUNTRY"
-	ReleaseDate               = "RELEASEDATE"
-	ReleaseStatus             = "RELEASESTATUS"
-	ReleaseType               = "RELEASETYPE"
-	Remixer                   = "REMIXER"
-	Script                    = "SCRIPT"
-	ShowSort                  = "SHOWSORT"
-	ShowWorkMovement          = "SHOWWORKMOVEMENT"
-	Subtitle                  = "SUBTITLE"
-	TaggingDate               = "TAGGINGDATE"
-	Title                     = "TITLE"
-	TitleSort                 = "TITLESORT"
-	TrackNumber               = "TRACKNUMBER"
-	TVEpisode                 = "TVEPISODE"
-	TVEpisodeID               = "TVEPISODEID"
-	TVNetwork                 = "TVNETWORK"
-	TVSeason                  = "TVSEASON"
-	TVShow                    = "TVSHOW"
-	URL                       = "URL"
-	Work                      = "WORK"
-)
+// Flags holds the boolean tags of a file. Each is false when its tag is absent.
+type Flags struct {
+	Compilation      bool // COMPILATION: ID3v2 TCMP, MP4 cpil
+	GaplessPlayback  bool // GAPLESSPLAYBACK: MP4 pgap
+	ShowWorkMovement bool // SHOWWORKMOVEMENT: MP4 shwm
+	Podcast          bool // PODCAST: ID3v2 PCST, MP4 pcst
+}
 
-// ReadTags reads all metadata tags from an audio file at the given path.
-func ReadTags(path string) (map[string][]string, error) {
-	var err error
-	path, err = filepath.Abs(path)
+// Flags reads the boolean tags of the file. Values such as "1", "true", and "yes" are read as true, as are MP4
+// atoms set to 1 and, like [File.PodcastInfo], an ID3v2 PCST frame of any value.
+func (f *File) Flags() (Flags, error) {
+	tags, err := f.readTags()
 	if err != nil {
-		return nil, fmt.Errorf("make path abs %w", err)
+		return Flags{}, err
 	}
-
-	dir := filepath.Dir(path)
-	mod, err := newModuleRO(dir)
+	flag := func(key string) bool {
+		return len(tags[key]) > 0 && parseTagBool(tags[key][0])
+	}
+	podcast, err := f.isPodcast(tags)
 	if err != nil {
-		return nil, fmt.Errorf("init module: %w", err)
+		return Flags{}, err
 	}
-	defer mod.close()
+	return Flags{
+		Compilation:      flag(Compilation),
+		GaplessPlayback:  flag(GaplessPlayback),
+		ShowWorkMovement: flag(ShowWorkMovement),
+		Podcast:          podcast,
+	}, nil
+}
 
-	var raw wasmStrings
-	if err := mod.call("taglib_file_tags", &raw, wasmString(wasmPath(path))); err != nil {
-		return nil, fmt.Errorf("call: %w", err)
+// SetFlags writes all the boolean tags of the file. True flags are written as "1", and false ones remove the tag
+// rather than writing "0". Setting a flag the format can't store, as reported by [SupportedKeys], fails with an
+// [*UnsupportedKeysError] without writing any.
+func (f *File) SetFlags(flags Flags) error {
+	tags := map[string][]string{}
+	var unsupported []string
+	for key, set := range map[string]bool{
+		Compilation:      flags.Compilation,
+		GaplessPlayback:  flags.GaplessPlayback,
+		ShowWorkMovement: flags.ShowWorkMovement,
+		Podcast:          flags.Podcast,
+	} {
+		tags[key] = nil
+		if set {
+			tags[key] = []string{"1"}
+			// TagLib writes PODCAST as an ID3v2 PCST frame, which is read back by its presence
+			if !keySupported(f.format, key) && !(key == Podcast && hasID3v2Tags(f.format)) {
+				unsupported = append(unsupported, key)
+			}
+		}
 	}
-	if raw == nil {
-		return nil, ErrInvalidFile
+	if len(unsupported) > 0 {
+		slices.Sort(unsupported)
+		return &UnsupportedKeysError{Format: f.format, Keys: unsupported}
 	}
+	return f.WriteTags(tags, 0)
+}
 
-	var tags = map[string][]string{}
-	for _, row := range raw {
-		k, v, ok := strings.Cut(row, "\t")
-		if !ok {
-			continue
-		}
-		tags[k] = append(tags[k], v)
-	}
-	return tags, nil
+// ReleaseInfo holds the MusicBrainz release attributes of a file. Empty fields aren't set.
+type ReleaseInfo struct {
+	Media          string   // MEDIA: ID3v2 TMED, MP4 ----:com.apple.iTunes:MEDIA. Such as "CD", "Digital Media", or "Vinyl".
+	ReleaseType    []string // RELEASETYPE: ID3v2 TXXX:MusicBrainz Album Type. The primary type, then any secondary types.
+	ReleaseStatus  string   // RELEASESTATUS: ID3v2 TXXX:MusicBrainz Album Status. Such as "official" or "bootleg".
+	ReleaseCountry string   // RELEASECOUNTRY: ID3v2 TXXX:MusicBrainz Album Release Country. Such as "GB" or "XW".
+	Barcode        string   // BARCODE: ID3v2 TXXX:BARCODE, ASF WM/Barcode
+	CatalogNumber  string   // CATALOGNUMBER: ID3v2 TXXX:CATALOGNUMBER, ASF WM/CatalogNo
+	Label          string   // LABEL: ID3v2 TPUB, ASF WM/Publisher
 }
 
-// ReadID3v2Frames reads all ID3v2 frames from an audio file at the given path.
-// Supported formats: MP3, WAV, and AIFF.
-// This provides direct access to the raw ID3v2 frames, including custom frames like TXXX.
-// The returned map has frame IDs as keys (like "TIT2", "TPE1", "TXXX") and frame data as values.
-// For TXXX frames, the description is included in the key as "TXXX:description".
-func ReadID3v2Frames(path string) (map[string][]string, error) {
-	var err error
-	path, err = filepath.Abs(path)
+// ReleaseInfo reads the release attributes of the file. Only the first value of each is used, except for
+// ReleaseType, which MusicBrainz Picard writes as several values such as "album" and "live".
+func (f *File) ReleaseInfo() (ReleaseInfo, error) {
+	tags, err := f.readTags()
 	if err != nil {
-		return nil, fmt.Errorf("make path abs %w", err)
+		return ReleaseInfo{}, err
 	}
+	return ReleaseInfo{
+		Media:          firstTag(tags, Media),
+		ReleaseType:    tags[ReleaseType],
+		ReleaseStatus:  firstTag(tags, ReleaseStatus),
+		ReleaseCountry: firstTag(tags, ReleaseCountry),
+		Barcode:        firstTag(tags, Barcode),
+		CatalogNumber:  firstTag(tags, CatalogNumber),
+		Label:          firstTag(tags, Label),
+	}, nil
+}
 
-	dir := filepath.Dir(path)
-	mod, err := newModuleRO(dir)
+// SetReleaseInfo writes all the release attributes of the file. Empty fields remove the tag.
+func (f *File) SetReleaseInfo(info ReleaseInfo) error {
+	return f.WriteTags(map[string][]string{
+		Media:          tagValue(info.Media),
+		ReleaseType:    info.ReleaseType,
+		ReleaseStatus:  tagValue(info.ReleaseStatus),
+		ReleaseCountry: tagValue(info.ReleaseCountry),
+		Barcode:        tagValue(info.Barcode),
+		CatalogNumber:  tagValue(info.CatalogNumber),
+		Label:          tagValue(info.Label),
+	}, 0)
+}
+
+// OriginalInfo holds the attributes of the original release of a cover song or remix. Empty fields aren't set.
+type OriginalInfo struct {
+	Album    string // ORIGINALALBUM: ID3v2 TOAL, ASF WM/OriginalAlbumTitle
+	Artist   string // ORIGINALARTIST: ID3v2 TOPE, ASF WM/OriginalArtist
+	Date     string // ORIGINALDATE: ID3v2 TDOR, or TORY in ID3v2.3, ASF WM/OriginalReleaseYear
+	Lyricist string // ORIGINALLYRICIST: ID3v2 TOLY, ASF WM/OriginalLyricist
+	Filename string // ORIGINALFILENAME: ID3v2 TOFN, ASF WM/OriginalFilename
+}
+
+// OriginalInfo reads the original release attributes of the file. Only the first value of each is used.
+func (f *File) OriginalInfo() (OriginalInfo, error) {
+	tags, err := f.readTags()
 	if err != nil {
-		return nil, fmt.Errorf("init module: %w", err)
+		return OriginalInfo{}, err
 	}
-	defer mod.close()
+	return OriginalInfo{
+		Album:    firstTag(tags, OriginalAlbum),
+		Artist:   firstTag(tags, OriginalArtist),
+		Date:     firstTag(tags, OriginalDate),
+		Lyricist: firstTag(tags, OriginalLyricist),
+		Filename: firstTag(tags, OriginalFilename),
+	}, nil
+}
 
-	var raw wasmStrings
-	if err := mod.call("taglib_file_id3v2_frames", &raw, wasmString(wasmPath(path))); err != nil {
-		return nil, fmt.Errorf("call: %w", err)
+// SetOriginalInfo writes all the original release attributes of the file. Empty fields remove the tag.
+func (f *File) SetOriginalInfo(info OriginalInfo) error {
+	return f.WriteTags(map[string][]string{
+		OriginalAlbum:    tagValue(info.Album),
+		OriginalArtist:   tagValue(info.Artist),
+		OriginalDate:     tagValue(info.Date),
+		OriginalLyricist: tagValue(info.Lyricist),
+		OriginalFilename: tagValue(info.Filename),
+	}, 0)
+}
+
+// ITunesGrouping returns the grouping field as used by iTunes and most players.
+// This reads [Grouping], which TagLib maps from ID3v2 GRP1, MP4 ©grp, and Vorbis GROUPING.
+// Returns an empty string if the file has no grouping.
+func (f *File) ITunesGrouping() (string, error) {
+	tags, err := f.readTags()
+	if err != nil {
+		return "", err
 	}
-	if raw == nil {
-		return nil, ErrInvalidFile
+	if len(tags[Grouping]) == 0 {
+		return "", nil
 	}
+	return tags[Grouping][0], nil
+}
 
-	// If raw is empty, the file has no ID3v2 frames
-	var frames = map[string][]string{}
-	for _, row := range raw {
-		parts := strings.SplitN(row, "\t", 2)
-		if len(parts) != 2 {
-			continue
-		}
-		frames[parts[0]] = append(frames[parts[0]], parts[1])
+// SetITunesGrouping writes the grouping field read by [File.ITunesGrouping]. An empty grouping removes the tag.
+func (f *File) SetITunesGrouping(grouping string) error {
+	var vs []string
+	if grouping != "" {
+		vs = []string{grouping}
 	}
-	return frames, nil
+	return f.WriteTags(map[string][]string{Grouping: vs}, 0)
 }
 
-// ReadID3v1Frames reads all ID3v1 tags from an MP3 file at the given path.
-// This provides access to the standard ID3v1 fields: title, artist, album, year, comment, track, and genre.
-// The returned map has standardized keys (like "TITLE", "ARTIST", "ALBUM") and values.
-// Note that ID3v1 is a much simpler format than ID3v2 with a fixed set of fields.
-func ReadID3v1Frames(path string) (map[string][]string, error) {
-	var err error
-	path, err = filepath.Abs(path)
+// ContentGroup returns the ID3v2 content group, the TIT1 frame that older versions of iTunes used for grouping.
+// TagLib maps TIT1 to [Work], so for formats tagged with ID3v2 this reads [Work]. Other formats have no
+// equivalent field, so a CONTENTGROUP tag is used instead, which is stored as a free-form tag such as an MP4
+// "----:com.apple.iTunes:CONTENTGROUP" atom.
+// Returns an empty string if the file has no content group.
+func (f *File) ContentGroup() (string, error) {
+	tags, err := f.readTags()
 	if err != nil {
-		return nil, fmt.Errorf("make path abs %w", err)
+		return "", err
+	}
+	key := contentGroupKey(f.format)
+	if len(tags[key]) == 0 {
+		return "", nil
 	}
+	return tags[key][0], nil
+}
 
-	dir := filepath.Dir(path)
-	mod, err := newModuleRO(dir)
+// SetContentGroup writes the content group read by [File.ContentGroup]. An empty group removes the tag.
+func (f *File) SetContentGroup(group string) error {
+	var vs []string
+	if group != "" {
+		vs = []string{group}
+	}
+	return f.WriteTags(map[string][]string{contentGroupKey(f.format): vs}, 0)
+}
+
+// ContentRating is the iTunes content advisory of a file.
+type ContentRating string
+
+// Content ratings, as iTunes shows them.
+const (
+	ContentRatingNone     ContentRating = "none"
+	ContentRatingClean    ContentRating = "clean"
+	ContentRatingExplicit ContentRating = "explicit"
+)
+
+// iTunesAdvisoryKey is the tag key of the content rating in formats other than MP4, as MusicBrainz Picard writes it.
+const iTunesAdvisoryKey = "ITUNESADVISORY"
+
+// ContentRating returns the content advisory of the file. For MP4 this reads the rtng atom, and for other formats
+// an ITUNESADVISORY tag, which is stored as an ID3v2 TXXX frame, a Vorbis comment, or an APE item. Both hold 1 or 4
+// for explicit and 2 for clean, and "explicit" and "clean" are accepted too.
+// Returns [ContentRatingNone] if the file has no rating.
+func (f *File) ContentRating() (ContentRating, error) {
+	if f.format == FormatMP4 {
+		var rtng []byte
+		f.readRaw(func(r io.ReaderAt) {
+			rtng, _ = mp4ItemData(r, "rtng")
+		})
+		if len(rtng) > 0 {
+			return parseContentRating(strconv.Itoa(int(rtng[len(rtng)-1]))), nil
+		}
+	}
+	tags, err := f.readTags()
 	if err != nil {
-		return nil, fmt.Errorf("init module: %w", err)
+		return "", err
 	}
-	defer mod.close()
+	if len(tags[iTunesAdvisoryKey]) == 0 {
+		return ContentRatingNone, nil
+	}
+	return parseContentRating(tags[iTunesAdvisoryKey][0]), nil
+}
 
-	var raw wasmStrings
-	if err := mod.call("taglib_file_id3v1_tags", &raw, wasmString(wasmPath(path))); err != nil {
-		return nil, fmt.Errorf("call: %w", err)
+// SetContentRating writes the content advisory read by [File.ContentRating]. [ContentRatingNone] removes it.
+func (f *File) SetContentRating(rating ContentRating) error {
+	var value string
+	switch rating {
+	case ContentRatingNone:
+	case ContentRatingExplicit:
+		value = "1"
+	case ContentRatingClean:
+		value = "2"
+	default:
+		return fmt.Errorf("invalid content rating %q", rating)
 	}
-	if raw == nil {
-		return nil, ErrInvalidFile
+
+	if f.format != FormatMP4 {
+		var vs []string
+		if value != "" {
+			vs = []string{value}
+		}
+		return f.WriteTags(map[string][]string{iTunesAdvisoryKey: vs}, 0)
 	}
 
-	// If raw is empty, the file has no ID3v1 tags
-	var frames = map[string][]string{}
-	for _, row := range raw {
-		parts := strings.SplitN(row, "\t", 2)
-		if len(parts) != 2 {
-			continue
+	if f.readOnly || f.path == "" {
+		return ErrSavingFile
+	}
+	// The handle is closed while the file changes, as TagLib would otherwise save the atoms it read before, without
+	// the rating
+	return f.replaceFile(func() error {
+		return writeMP4Atoms(f.path, map[string][]string{"rtng": {value}})
+	})
+}
+
+// StoreMetadata holds the iTunes Store metadata of a purchased MP4 file. Empty fields aren't set.
+type StoreMetadata struct {
+	PurchaseDate time.Time // purd
+	AccountID    string    // apID, the Apple ID of the purchaser
+	OwnerName    string    // ownr, the name of the purchaser
+	CatalogID    int64     // cnID, of the track
+	ArtistID     int64     // atID
+	PlaylistID   int64     // plID, of the album
+	GenreID      int64     // geID
+	StorefrontID int64     // sfID, of the country store
+}
+
+// purdLayouts are the layouts of the purchase date of the iTunes Store, which is in UTC.
+var purdLayouts = []string{"2006-01-02 15:04:05", time.RFC3339, "2006-01-02T15:04:05", "2006-01-02"}
+
+// StoreMetadata reads the iTunes Store metadata of the file from its MP4 atoms. A purchase date that can't be parsed
+// is left zero. Files of other formats have no store metadata, so for them it is always empty.
+func (f *File) StoreMetadata() (StoreMetadata, error) {
+	var meta StoreMetadata
+	if f.format != FormatMP4 {
+		return meta, nil
+	}
+	f.readRaw(func(r io.ReaderAt) {
+		text := func(name string) string {
+			data, _ := mp4ItemData(r, name)
+			return string(data)
 		}
-		frames[parts[0]] = append(frames[parts[0]], parts[1])
+		integer := func(name string) int64 {
+			data, _ := mp4ItemData(r, name)
+			return mp4Integer(data)
+		}
+		for _, layout := range purdLayouts {
+			if t, err := time.Parse(layout, text("purd")); err == nil {
+				meta.PurchaseDate = t
+				break
+			}
+		}
+		meta.AccountID = text("apID")
+		meta.OwnerName = text("ownr")
+		meta.CatalogID = integer("cnID")
+		meta.ArtistID = integer("atID")
+		meta.PlaylistID = integer("plID")
+		meta.GenreID = integer("geID")
+		meta.StorefrontID = integer("sfID")
+	})
+	return meta, nil
+}
+
+// mp4Integer decodes the value of an MP4 integer item, which is big endian and 1, 2, 4, or 8 bytes long.
+// Returns 0 for other lengths.
+func mp4Integer(data []byte) int64 {
+	switch len(data) {
+	case 1:
+		return int64(int8(data[0]))
+	case 2:
+		return int64(int16(uint16(data[0])<<8 | uint16(data[1])))
+	case 4:
+		return int64(int32(be32(data)))
+	case 8:
+		return int64(uint64(be32(data))<<32 | uint64(be32(data[4:])))
 	}
-	return frames, nil
+	return 0
 }
 
-// ReadMP4Atoms reads all MP4 atoms from an M4A/MP4 file at the given path.
-// This provides direct access to the raw MP4 atoms/items, including standard atoms like ©ART (artist),
-// ©alb (album), trkn (track number), etc.
-// The returned map has atom names as keys and atom data as values.
-// For IntPair atoms (like trkn, disk), values are split into separate keys with :num and :total suffixes.
-// For example, track 3 of 12 returns as "trkn:num" -> ["3"] and "trkn:total" -> ["12"].
-func ReadMP4Atoms(path string) (map[string][]string, error) {
-	var err error
-	path, err = filepath.Abs(path)
-	if err != nil {
-		return nil, fmt.Errorf("make path abs %w", err)
+// parseContentRating parses an rtng or ITUNESADVISORY value.
+func parseContentRating(s string) ContentRating {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "1", "4", "explicit":
+		return ContentRatingExplicit
+	case "2", "clean":
+		return ContentRatingClean
 	}
+	return ContentRatingNone
+}
 
-	dir := filepath.Dir(path)
-	mod, err := newModuleRO(dir)
+// Language returns the languages of the lyrics or spoken content of the file, as the ISO 639 codes stored in
+// [Language], which TagLib maps from ID3v2 TLAN and MP4 ----:com.apple.iTunes:LANGUAGE. Files in several languages
+// have several values, and "zxx" marks files without lyrics, such as instrumentals.
+func (f *File) Language() ([]string, error) {
+	tags, err := f.readTags()
 	if err != nil {
-		return nil, fmt.Errorf("init module: %w", err)
+		return nil, err
 	}
-	defer mod.close()
+	return tags[Language], nil
+}
+
+// SetLanguage writes the languages of the file as [Language] values, normalized to ISO 639-2/T codes such as "eng"
+// and "deu", the same as ISO 639-3 codes for individual languages. ISO 639-1 codes such as "en", ISO 639-2/B codes
+// such as "ger", and English names such as "English" are accepted for the languages with an ISO 639-1 code and a few
+// common in music. Any other three letter code, such as the ISO 639-2 and 639-3 codes "bho" and "ast", is kept as is
+// in lower case. Anything else fails with [ErrInvalidLanguage] without writing anything. No languages removes the tag.
+func (f *File) SetLanguage(langs []string) error {
+	var codes []string
+	for _, lang := range langs {
+		code, ok := normalizeLanguage(lang)
+		if !ok {
+			return fmt.Errorf("%w: %q", ErrInvalidLanguage, lang)
+		}
+		if !slices.Contains(codes, code) {
+			codes = append(codes, code)
+		}
+	}
+	return f.WriteTags(map[string][]string{Language: codes}, 0)
+}
+
+// Script returns the script the titles and other text of the file are written in, as the ISO 15924 code stored in
+// [Script], such as "Latn" or "Cyrl". Only the first value is used. Returns an empty string if the file has none.
+func (f *File) Script() (string, error) {
+	tags, err := f.readTags()
+	if err != nil {
+		return "", err
+	}
+	if len(tags[Script]) == 0 {
+		return "", nil
+	}
+	return tags[Script][0], nil
+}
+
+// SetScript writes the script of the file as a [Script] value, normalized to an ISO 15924 code in title case such as
+// "Latn". Names such as "Latin" are accepted, as is "Qaaa", which MusicBrainz uses for text in several scripts.
+// Scripts that aren't known fail with [ErrInvalidScript]. An empty script removes the tag.
+func (f *File) SetScript(script string) error {
+	if script == "" {
+		return f.WriteTags(map[string][]string{Script: nil}, 0)
+	}
+	code, ok := normalizeScript(script)
+	if !ok {
+		return fmt.Errorf("%w: %q", ErrInvalidScript, script)
+	}
+	return f.WriteTags(map[string][]string{Script: {code}}, 0)
+}
+
+// normalizeLanguage returns the ISO 639-2/T code of the language code or English name s. Three letter codes that
+// aren't in languages are taken to be ISO 639-2 or 639-3 codes, which are too many to list.
+func normalizeLanguage(s string) (string, bool) {
+	s = strings.TrimSpace(s)
+	for _, l := range languages {
+		if strings.EqualFold(s, l.part2T) || (l.part1 != "" && strings.EqualFold(s, l.part1)) ||
+			(l.part2B != "" && strings.EqualFold(s, l.part2B)) || strings.EqualFold(s, l.name) {
+			return l.part2T, true
+		}
+	}
+	if len(s) == 3 && strings.Trim(strings.ToLower(s), "abcdefghijklmnopqrstuvwxyz") == "" {
+		return strings.ToLower(s), true
+	}
+	return "", false
+}
+
+// normalizeScript returns the ISO 15924 code of the script code or English name s.
+func normalizeScript(s string) (string, bool) {
+	s = strings.TrimSpace(s)
+	for _, sc := range scripts {
+		if strings.EqualFold(s, sc.code) || strings.EqualFold(s, sc.name) {
+			return sc.code, true
+		}
+	}
+	return "", false
+}
+
+// languages are the languages with an ISO 639-1 code, a few common in music that have none, and the special codes,
+// by ISO 639-1 code, ISO 639-2/T code, ISO 639-2/B code where it differs, and English name.
+var languages = []struct{ part1, part2T, part2B, name string }{
+	{"aa", "aar", "", "Afar"},
+	{"ab", "abk", "", "Abkhazian"},
+	{"ae", "ave", "", "Avestan"},
+	{"af", "afr", "", "Afrikaans"},
+	{"ak", "aka", "", "Akan"},
+	{"am", "amh", "", "Amharic"},
+	{"an", "arg", "", "Aragonese"},
+	{"ar", "ara", "", "Arabic"},
+	{"as", "asm", "", "Assamese"},
+	{"av", "ava", "", "Avaric"},
+	{"ay", "aym", "", "Aymara"},
+	{"az", "aze", "", "Azerbaijani"},
+	{"ba", "bak", "", "Bashkir"},
+	{"be", "bel", "", "Belarusian"},
+	{"bg", "bul", "", "Bulgarian"},
+	{"bi", "bis", "", "Bislama"},
+	{"bm", "bam", "", "Bambara"},
+	{"bn", "ben", "", "Bengali"},
+	{"bo", "bod", "tib", "Tibetan"},
+	{"br", "bre", "", "Breton"},
+	{"bs", "bos", "", "Bosnian"},
+	{"ca", "cat", "", "Catalan"},
+	{"ce", "che", "", "Chechen"},
+	{"ch", "cha", "", "Chamorro"},
+	{"co", "cos", "", "Corsican"},
+	{"cr", "cre", "", "Cree"},
+	{"cs", "ces", "cze", "Czech"},
+	{"cu", "chu", "", "Church Slavic"},
+	{"cv", "chv", "", "Chuvash"},
+	{"cy", "cym", "wel", "Welsh"},
+	{"da", "dan", "", "Danish"},
+	{"de", "deu", "ger", "German"},
+	{"dv", "div", "", "Divehi"},
+	{"dz", "dzo", "", "Dzongkha"},
+	{"ee", "ewe", "", "Ewe"},
+	{"el", "ell", "gre", "Greek"},
+	{"en", "eng", "", "English"},
+	{"eo", "epo", "", "Esperanto"},
+	{"es", "spa", "", "Spanish"},
+	{"et", "est", "", "Estonian"},
+	{"eu", "eus", "baq", "Basque"},
+	{"fa", "fas", "per", "Persian"},
+	{"ff", "ful", "", "Fulah"},
+	{"fi", "fin", "", "Finnish"},
+	{"fj", "fij", "", "Fijian"},
+	{"fo", "fao", "", "Faroese"},
+	{"fr", "fra", "fre", "French"},
+	{"fy", "fry", "", "Western Frisian"},
+	{"ga", "gle", "", "Irish"},
+	{"gd", "gla", "", "Scottish Gaelic"},
+	{"gl", "glg", "", "Galician"},
+	{"gn", "grn", "", "Guarani"},
+	{"gu", "guj", "", "Gujarati"},
+	{"gv", "glv", "", "Manx"},
+	{"ha", "hau", "", "Hausa"},
+	{"he", "heb", "", "Hebrew"},
+	{"hi", "hin", "", "Hindi"},
+	{"ho", "hmo", "", "Hiri Motu"},
+	{"hr", "hrv", "", "Croatian"},
+	{"ht", "hat", "", "Haitian"},
+	{"hu", "hun", "", "Hungarian"},
+	{"hy", "hye", "arm", "Armenian"},
+	{"hz", "her", "", "Herero"},
+	{"ia", "ina", "", "Interlingua"},
+	{"id", "ind", "", "Indonesian"},
+	{"ie", "ile", "", "Interlingue"},
+	{"ig", "ibo", "", "Igbo"},
+	{"ii", "iii", "", "Sichuan Yi"},
+	{"ik", "ipk", "", "Inupiaq"},
+	{"io", "ido", "", "Ido"},
+	{"is", "isl", "ice", "Icelandic"},
+	{"it", "ita", "", "Italian"},
+	{"iu", "iku", "", "Inuktitut"},
+	{"ja", "jpn", "", "Japanese"},
+	{"jv", "jav", "", "Javanese"},
+	{"ka", "kat", "geo", "Georgian"},
+	{"kg", "kon", "", "Kongo"},
+	{"ki", "kik", "", "Kikuyu"},
+	{"kj", "kua", "", "Kuanyama"},
+	{"kk", "kaz", "", "Kazakh"},
+	{"kl", "kal", "", "Kalaallisut"},
+	{"km", "khm", "", "Khmer"},
+	{"kn", "kan", "", "Kannada"},
+	{"ko", "kor", "", "Korean"},
+	{"kr", "kau", "", "Kanuri"},
+	{"ks", "kas", "", "Kashmiri"},
+	{"ku", "kur", "", "Kurdish"},
+	{"kv", "kom", "", "Komi"},
+	{"kw", "cor", "", "Cornish"},
+	{"ky", "kir", "", "Kirghiz"},
+	{"la", "lat", "", "Latin"},
+	{"lb", "ltz", "", "Luxembourgish"},
+	{"lg", "lug", "", "Ganda"},
+	{"li", "lim", "", "Limburgish"},
+	{"ln", "lin", "", "Lingala"},
+	{"lo", "lao", "", "Lao"},
+	{"lt", "lit", "", "Lithuanian"},
+	{"lu", "lub", "", "Luba-Katanga"},
+	{"lv", "lav", "", "Latvian"},
+	{"mg", "mlg", "", "Malagasy"},
+	{"mh", "mah", "", "Marshallese"},
+	{"mi", "mri", "mao", "Maori"},
+	{"mk", "mkd", "mac", "Macedonian"},
+	{"ml", "mal", "", "Malayalam"},
+	{"mn", "mon", "", "Mongolian"},
+	{"mr", "mar", "", "Marathi"},
+	{"ms", "msa", "may", "Malay"},
+	{"mt", "mlt", "", "Maltese"},
+	{"my", "mya", "bur", "Burmese"},
+	{"na", "nau", "", "Nauru"},
+	{"nb", "nob", "", "Norwegian Bokmål"},
+	{"nd", "nde", "", "North Ndebele"},
+	{"ne", "nep", "", "Nepali"},
+	{"ng", "ndo", "", "Ndonga"},
+	{"nl", "nld", "dut", "Dutch"},
+	{"nn", "nno", "", "Norwegian Nynorsk"},
+	{"no", "nor", "", "Norwegian"},
+	{"nr", "nbl", "", "South Ndebele"},
+	{"nv", "nav", "", "Navajo"},
+	{"ny", "nya", "", "Chichewa"},
+	{"oc", "oci", "", "Occitan"},
+	{"oj", "oji", "", "Ojibwa"},
+	{"om", "orm", "", "Oromo"},
+	{"or", "ori", "", "Oriya"},
+	{"os", "oss", "", "Ossetian"},
+	{"pa", "pan", "", "Punjabi"},
+	{"pi", "pli", "", "Pali"},
+	{"pl", "pol", "", "Polish"},
+	{"ps", "pus", "", "Pashto"},
+	{"pt", "por", "", "Portuguese"},
+	{"qu", "que", "", "Quechua"},
+	{"rm", "roh", "", "Romansh"},
+	{"rn", "run", "", "Rundi"},
+	{"ro", "ron", "rum", "Romanian"},
+	{"ru", "rus", "", "Russian"},
+	{"rw", "kin", "", "Kinyarwanda"},
+	{"sa", "san", "", "Sanskrit"},
+	{"sc", "srd", "", "Sardinian"},
+	{"sd", "snd", "", "Sindhi"},
+	{"se", "sme", "", "Northern Sami"},
+	{"sg", "sag", "", "Sango"},
+	{"si", "sin", "", "Sinhala"},
+	{"sk", "slk", "slo", "Slovak"},
+	{"sl", "slv", "", "Slovenian"},
+	{"sm", "smo", "", "Samoan"},
+	{"sn", "sna", "", "Shona"},
+	{"so", "som", "", "Somali"},
+	{"sq", "sqi", "alb", "Albanian"},
+	{"sr", "srp", "", "Serbian"},
+	{"ss", "ssw", "", "Swati"},
+	{"st", "sot", "", "Southern Sotho"},
+	{"su", "sun", "", "Sundanese"},
+	{"sv", "swe", "", "Swedish"},
+	{"sw", "swa", "", "Swahili"},
+	{"ta", "tam", "", "Tamil"},
+	{"te", "tel", "", "Telugu"},
+	{"tg", "tgk", "", "Tajik"},
+	{"th", "tha", "", "Thai"},
+	{"ti", "tir", "", "Tigrinya"},
+	{"tk", "tuk", "", "Turkmen"},
+	{"tl", "tgl", "", "Tagalog"},
+	{"tn", "tsn", "", "Tswana"},
+	{"to", "ton", "", "Tonga"},
+	{"tr", "tur", "", "Turkish"},
+	{"ts", "tso", "", "Tsonga"},
+	{"tt", "tat", "", "Tatar"},
+	{"tw", "twi", "", "Twi"},
+	{"ty", "tah", "", "Tahitian"},
+	{"ug", "uig", "", "Uyghur"},
+	{"uk", "ukr", "", "Ukrainian"},
+	{"ur", "urd", "", "Urdu"},
+	{"uz", "uzb", "", "Uzbek"},
+	{"ve", "ven", "", "Venda"},
+	{"vi", "vie", "", "Vietnamese"},
+	{"vo", "vol", "", "Volapük"},
+	{"wa", "wln", "", "Walloon"},
+	{"wo", "wol", "", "Wolof"},
+	{"xh", "xho", "", "Xhosa"},
+	{"yi", "yid", "", "Yiddish"},
+	{"yo", "yor", "", "Yoruba"},
+	{"za", "zha", "", "Zhuang"},
+	{"zh", "zho", "chi", "Chinese"},
+	{"zu", "zul", "", "Zulu"},
+	{"", "ang", "", "Old English"},
+	{"", "ceb", "", "Cebuano"},
+	{"", "chr", "", "Cherokee"},
+	{"", "fil", "", "Filipino"},
+	{"", "grc", "", "Ancient Greek"},
+	{"", "gsw", "", "Swiss German"},
+	{"", "haw", "", "Hawaiian"},
+	{"", "hmn", "", "Hmong"},
+	{"", "nds", "", "Low German"},
+	{"", "sco", "", "Scots"},
+	{"", "yue", "", "Cantonese"},
+	{"", "mis", "", "Uncoded languages"},
+	{"", "mul", "", "Multiple languages"},
+	{"", "und", "", "Undetermined"},
+	{"", "zxx", "", "No linguistic content"},
+}
+
+// scripts are the ISO 15924 scripts in current use and a few well known historic ones, such as Runic and Gothic, by
+// code and English name.
+var scripts = []struct{ code, name string }{
+	{"Adlm", "Adlam"},
+	{"Arab", "Arabic"},
+	{"Armn", "Armenian"},
+	{"Bali", "Balinese"},
+	{"Beng", "Bengali"},
+	{"Bopo", "Bopomofo"},
+	{"Brai", "Braille"},
+	{"Cans", "Canadian Aboriginal Syllabics"},
+	{"Cher", "Cherokee"},
+	{"Copt", "Coptic"},
+	{"Cyrl", "Cyrillic"},
+	{"Deva", "Devanagari"},
+	{"Dsrt", "Deseret"},
+	{"Egyp", "Egyptian Hieroglyphs"},
+	{"Ethi", "Ethiopic"},
+	{"Geor", "Georgian"},
+	{"Goth", "Gothic"},
+	{"Grek", "Greek"},
+	{"Gujr", "Gujarati"},
+	{"Guru", "Gurmukhi"},
+	{"Hang", "Hangul"},
+	{"Hani", "Han"},
+	{"Hans", "Simplified Han"},
+	{"Hant", "Traditional Han"},
+	{"Hebr", "Hebrew"},
+	{"Hira", "Hiragana"},
+	{"Hrkt", "Japanese Syllabaries"},
+	{"Java", "Javanese"},
+	{"Jpan", "Japanese"},
+	{"Kana", "Katakana"},
+	{"Khmr", "Khmer"},
+	{"Knda", "Kannada"},
+	{"Kore", "Korean"},
+	{"Laoo", "Lao"},
+	{"Latn", "Latin"},
+	{"Mlym", "Malayalam"},
+	{"Mong", "Mongolian"},
+	{"Mymr", "Myanmar"},
+	{"Nkoo", "N'Ko"},
+	{"Ogam", "Ogham"},
+	{"Orya", "Oriya"},
+	{"Qaaa", "Multiple Scripts"},
+	{"Runr", "Runic"},
+	{"Sinh", "Sinhala"},
+	{"Syrc", "Syriac"},
+	{"Taml", "Tamil"},
+	{"Telu", "Telugu"},
+	{"Tfng", "Tifinagh"},
+	{"Tglg", "Tagalog"},
+	{"Thaa", "Thaana"},
+	{"Thai", "Thai"},
+	{"Tibt", "Tibetan"},
+	{"Vaii", "Vai"},
+	{"Yiii", "Yi"},
+	{"Zinh", "Inherited"},
+	{"Zmth", "Mathematical Notation"},
+	{"Zsym", "Symbols"},
+	{"Zxxx", "Unwritten"},
+	{"Zyyy", "Common"},
+	{"Zzzz", "Unknown"},
+}
+
+// SeriesInfo holds the series an audiobook belongs to, and its position within it. Empty fields aren't set.
+type SeriesInfo struct {
+	SeriesName  string // SERIES: ID3v2 TXXX:SERIES, MP4 ----:com.apple.iTunes:SERIES
+	SeriesIndex string // SERIES-PART: ID3v2 TXXX:SERIES-PART, MP4 ----:com.apple.iTunes:SERIES-PART. Such as "3" or "2.5".
+}
+
+// Tag keys of the series of audiobooks, as Audiobookshelf and tone write them.
+const (
+	seriesKey     = "SERIES"
+	seriesPartKey = "SERIES-PART"
+)
+
+// seriesPartKeys are the tag keys other tools store the position in the series under.
+var seriesPartKeys = []string{seriesPartKey, "SERIES_PART", "SERIES PART", "SERIESPART"}
+
+// SeriesInfo reads the series of the file, an audiobook, from the SERIES and SERIES-PART tags, which are stored as
+// ID3v2 TXXX frames, MP4 freeform atoms, Vorbis comments, and APE items. The position is also read from the
+// SERIES_PART, "SERIES PART", and SERIESPART spellings. Only the first value of each is used.
+func (f *File) SeriesInfo() (SeriesInfo, error) {
+	tags, err := f.readTags()
+	if err != nil {
+		return SeriesInfo{}, err
+	}
+	var info SeriesInfo
+	if len(tags[seriesKey]) > 0 {
+		info.SeriesName = tags[seriesKey][0]
+	}
+	for _, key := range seriesPartKeys {
+		if len(tags[key]) > 0 {
+			info.SeriesIndex = tags[key][0]
+			break
+		}
+	}
+	return info, nil
+}
+
+// SetSeriesInfo writes the series read by [File.SeriesInfo] as SERIES and SERIES-PART tags, removing the position
+// under other spellings. Empty fields remove the tag. ASF files can't store the tags, so writing a series to them
+// fails with an [*UnsupportedKeysError].
+func (f *File) SetSeriesInfo(info SeriesInfo) error {
+	if !keySupported(f.format, seriesKey) && info != (SeriesInfo{}) {
+		return &UnsupportedKeysError{Format: f.format, Keys: []string{seriesKey, seriesPartKey}}
+	}
+	tags := map[string][]string{
+		seriesKey: tagValue(info.SeriesName),
+	}
+	for _, key := range seriesPartKeys {
+		tags[key] = nil
+	}
+	tags[seriesPartKey] = tagValue(info.SeriesIndex)
+	return f.WriteTags(tags, 0)
+}
+
+// Year returns the release year of the file from the first four digits of [Date], such as "1993-04-02" or
+// "1993", falling back to [OriginalDate]. TagLib maps [Date] from ID3v2 TDRC, and the legacy ID3v2.3 TYER frame.
+// Returns 0 if the year is unknown.
+func (f *File) Year() (int, error) {
+	tags, err := f.readTags()
+	if err != nil {
+		return 0, err
+	}
+	for _, key := range []string{Date, OriginalDate} {
+		if len(tags[key]) > 0 {
+			if year := parseYear(tags[key][0]); year > 0 {
+				return year, nil
+			}
+		}
+	}
+	return 0, nil
+}
+
+// parseYear parses the year at the start of a date. Returns 0 if it doesn't start with four digits.
+func parseYear(date string) int {
+	date = strings.TrimSpace(date)
+	if len(date) < 4 || (len(date) > 4 && date[4] >= '0' && date[4] <= '9') {
+		return 0
+	}
+	year, err := strconv.Atoi(date[:4])
+	if err != nil || year <= 0 {
+		return 0
+	}
+	return year
+}
+
+// EncodingInfo holds what encoded and tagged a file. Empty fields aren't set.
+type EncodingInfo struct {
+	EncodedBy       string // ENCODEDBY: ID3v2 TENC, MP4 ©enc, Vorbis ENCODEDBY, ASF WM/EncodedBy
+	EncoderSettings string // ENCODING: ID3v2 TSSE, MP4 ©too, ASF WM/EncodingSettings, or Vorbis ENCODER
+	// TaggingSoftware is the vendor string of the Vorbis comment block of FLAC and Ogg files, such as
+	// "reference libFLAC 1.4.3", which is set by the software that last wrote it. Other formats have no separate
+	// field for it, so EncoderSettings is used instead.
+	TaggingSoftware string
+}
+
+// EncodingInfo reads what encoded and tagged the file. Only the first value of each tag is used.
+func (f *File) EncodingInfo() (EncodingInfo, error) {
+	tags, err := f.readTags()
+	if err != nil {
+		return EncodingInfo{}, err
+	}
+
+	info := EncodingInfo{
+		EncodedBy:       firstTag(tags, EncodedBy),
+		EncoderSettings: firstTag(tags, Encoding, "ENCODER"),
+	}
+	if f.format == FormatFLAC || f.format.IsOgg() {
+		info.TaggingSoftware = f.vendorString()
+	} else {
+		info.TaggingSoftware = info.EncoderSettings
+	}
+	return info, nil
+}
+
+// ReadVendorString reads the vendor string of the Vorbis comment block of the FLAC or Ogg file at path, such as
+// "reference libFLAC 1.3.2", which identifies the software that last wrote it. It isn't a tag, so it isn't
+// included in [ReadTags]. Returns an empty string for other formats.
+func ReadVendorString(path string) (string, error) {
+	f, err := OpenReadOnly(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	return f.vendorString(), nil
+}
+
+func (f *File) vendorString() string {
+	var vendor string
+	f.readRaw(func(r io.ReaderAt) {
+		vendor, _ = vendorString(r, f.format)
+	})
+	return vendor
+}
+
+// DiscInfo holds the position of a file in a multi-disc set. Zero and empty fields aren't set.
+type DiscInfo struct {
+	DiscNumber   int    // DISCNUMBER: ID3v2 TPOS, MP4 disk, Vorbis DISCNUMBER
+	DiscTotal    int    // The total number of discs, from DISCNUMBER as "number/total" or DISCTOTAL
+	DiscSubtitle string // DISCSUBTITLE: ID3v2 TSST, MP4 ----:com.apple.iTunes:DISCSUBTITLE, Vorbis DISCSUBTITLE
+}
+
+// DiscInfo reads the disc number, total, and subtitle of the file. The total is read from a "number/total"
+// disc number, falling back to DISCTOTAL or TOTALDISCS as used in Vorbis comments.
+func (f *File) DiscInfo() (DiscInfo, error) {
+	tags, err := f.readTags()
+	if err != nil {
+		return DiscInfo{}, err
+	}
+
+	number, total, _ := strings.Cut(strings.TrimSpace(firstTag(tags, DiscNumber)), "/")
+	if total == "" {
+		total = strings.TrimSpace(firstTag(tags, "DISCTOTAL", "TOTALDISCS"))
+	}
+	var info DiscInfo
+	info.DiscNumber, _ = strconv.Atoi(strings.TrimSpace(number))
+	info.DiscTotal, _ = strconv.Atoi(strings.TrimSpace(total))
+	info.DiscSubtitle = strings.TrimSpace(firstTag(tags, DiscSubtitle))
+	return info, nil
+}
+
+// SetDiscInfo writes all the disc fields of the file. Zero and empty fields remove the tag.
+// The total is written as DISCTOTAL for FLAC, Ogg, APE, and WavPack files, whose tags conventionally keep it
+// separate, and as part of a "number/total" disc number otherwise.
+func (f *File) SetDiscInfo(info DiscInfo) error {
+	tags := map[string][]string{
+		DiscNumber:   nil,
+		DiscSubtitle: nil,
+		"DISCTOTAL":  nil,
+		"TOTALDISCS": nil,
+	}
+	if info.DiscSubtitle != "" {
+		tags[DiscSubtitle] = []string{info.DiscSubtitle}
+	}
+
+	switch {
+	case info.DiscTotal > 0 && hasSeparateTotals(f.format):
+		tags["DISCTOTAL"] = []string{strconv.Itoa(info.DiscTotal)}
+		if info.DiscNumber > 0 {
+			tags[DiscNumber] = []string{strconv.Itoa(info.DiscNumber)}
+		}
+	case info.DiscTotal > 0:
+		tags[DiscNumber] = []string{fmt.Sprintf("%d/%d", info.DiscNumber, info.DiscTotal)}
+	case info.DiscNumber > 0:
+		tags[DiscNumber] = []string{strconv.Itoa(info.DiscNumber)}
+	}
+	return f.WriteTags(tags, 0)
+}
+
+// hasSeparateTotals reports whether the tags of files of format conventionally keep the track and disc totals in
+// separate TRACKTOTAL and DISCTOTAL tags, rather than as part of a "number/total" value.
+func hasSeparateTotals(format FileFormat) bool {
+	return format == FormatFLAC || format == FormatAPE || format == FormatWavPack || format.IsOgg()
+}
+
+// contentGroupKey returns the tag key that holds the ID3v2 TIT1 content group in files of format.
+func contentGroupKey(format FileFormat) string {
+	if hasID3v2Tags(format) {
+		return Work
+	}
+	return "CONTENTGROUP"
+}
+
+// hasID3v2Tags reports whether TagLib reads and writes the tags of files of format as ID3v2 frames.
+func hasID3v2Tags(format FileFormat) bool {
+	switch format {
+	case FormatMPEG, FormatWAV, FormatAIFF, FormatDSF, FormatDSDIFF, FormatTrueAudio:
+		return true
+	}
+	return false
+}
+
+// BPM returns the tempo of the file in beats per minute, which may be fractional such as 128.5.
+// This reads [BPM], which TagLib maps from ID3v2 TBPM, MP4 tmpo, Vorbis BPM, and ASF WM/BeatsPerMinute.
+// Returns 0 if the file has no BPM or it isn't a number.
+func (f *File) BPM() (float64, error) {
+	tags, err := f.readTags()
+	if err != nil {
+		return 0, err
+	}
+	if len(tags[BPM]) == 0 {
+		return 0, nil
+	}
+	bpm, err := strconv.ParseFloat(strings.TrimSpace(tags[BPM][0]), 64)
+	if err != nil || bpm < 0 || math.IsInf(bpm, 0) || math.IsNaN(bpm) {
+		return 0, nil
+	}
+	return bpm, nil
+}
+
+// SetBPM writes the tempo of the file in beats per minute. A BPM of 0 or less, or one that isn't finite, removes
+// the tag. The ID3v2 TBPM frame and MP4 tmpo atom are integers, so for those the BPM is rounded to the nearest
+// integer. Other formats keep fractional BPMs as they are.
+func (f *File) SetBPM(bpm float64) error {
+	var vs []string
+	switch {
+	case bpm <= 0 || math.IsInf(bpm, 0) || math.IsNaN(bpm):
+	case hasID3v2Tags(f.format) || f.format == FormatMP4:
+		vs = []string{strconv.FormatFloat(math.Round(bpm), 'f', 0, 64)}
+	default:
+		vs = []string{strconv.FormatFloat(bpm, 'f', -1, 64)}
+	}
+	return f.WriteTags(map[string][]string{BPM: vs}, 0)
+}
+
+// TaggedLength returns the duration stored in the [Length] tag of the file, which TagLib maps from the ID3v2 TLEN
+// frame and Vorbis and APE LENGTH tags, in milliseconds. This is separate from [Properties.Length], which is read
+// from the audio, so that tags left stale by editing can be detected. Reports false if the file has no length tag
+// or it isn't a number of milliseconds.
+func (f *File) TaggedLength() (time.Duration, bool, error) {
+	tags, err := f.readTags()
+	if err != nil {
+		return 0, false, err
+	}
+	if len(tags[Length]) == 0 {
+		return 0, false, nil
+	}
+	ms, err := strconv.ParseFloat(strings.TrimSpace(tags[Length][0]), 64)
+	if err != nil || ms < 0 || math.IsInf(ms, 0) || math.IsNaN(ms) || ms > float64(math.MaxInt64/time.Millisecond) {
+		return 0, false, nil
+	}
+	return time.Duration(ms * float64(time.Millisecond)), true, nil
+}
+
+// Genres returns the genres of the file, with numeric ID3v1 genre references resolved to their names.
+// This reads [Genre], resolving values like "17" and the ID3v2.3 syntax "(17)" to "Rock", "(17)(4)" to "Rock" and
+// "Disco", and the refinement "(17)Rock" to "Rock". The "RX" and "(RX)" references resolve to "Remix", "CR" and "(CR)"
+// to "Cover", and "((" escapes a literal parenthesis. Duplicate genres are removed and unknown references are ignored.
+// Returns an empty slice if the file has no genres.
+func (f *File) Genres() ([]string, error) {
+	tags, err := f.readTags()
+	if err != nil {
+		return nil, err
+	}
+	return resolveGenres(tags[Genre]), nil
+}
+
+// SetGenres writes the genres of the file, resolving numeric ID3v1 genre references to their names as [File.Genres]
+// does, so that no numeric references are written. An empty genres removes the tag.
+func (f *File) SetGenres(genres []string) error {
+	return f.WriteTags(map[string][]string{Genre: resolveGenres(genres)}, 0)
+}
+
+// resolveGenres resolves the numeric genre references of values as described by [File.Genres].
+func resolveGenres(values []string) []string {
+	genres := []string{}
+	add := func(genre string) {
+		genre = strings.TrimSpace(genre)
+		if genre != "" && !slices.ContainsFunc(genres, func(g string) bool { return strings.EqualFold(g, genre) }) {
+			genres = append(genres, genre)
+		}
+	}
+
+	for _, v := range values {
+		v = strings.TrimSpace(v)
+		// ID3v2.4 has the same references without parentheses
+		if name, ok := genreByReference(v); ok {
+			add(name)
+			continue
+		}
+		for strings.HasPrefix(v, "(") && !strings.HasPrefix(v, "((") {
+			ref, rest, ok := strings.Cut(v[1:], ")")
+			if !ok {
+				break
+			}
+			if name, ok := genreByReference(ref); ok {
+				add(name)
+			}
+			v = rest
+		}
+		add(strings.TrimPrefix(v, "("))
+	}
+	return genres
+}
+
+// genreByReference returns the name of the genre referenced by ref, the number of an ID3v1 genre or "RX" or "CR".
+func genreByReference(ref string) (string, bool) {
+	switch ref {
+	case "RX":
+		return "Remix", true
+	case "CR":
+		return "Cover", true
+	}
+	n, err := strconv.Atoi(ref)
+	if err != nil || n < 0 || n >= len(id3v1Genres) || ref[0] == '+' {
+		return "", false
+	}
+	return id3v1Genres[n], true
+}
+
+// id3v1Genres are the names of the ID3v1 genres by number, including the Winamp extensions, as TagLib names them.
+var id3v1Genres = [...]string{
+	"Blues",
+	"Classic Rock",
+	"Country",
+	"Dance",
+	"Disco",
+	"Funk",
+	"Grunge",
+	"Hip-Hop",
+	"Jazz",
+	"Metal",
+	"New Age",
+	"Oldies",
+	"Other",
+	"Pop",
+	"R&B",
+	"Rap",
+	"Reggae",
+	"Rock",
+	"Techno",
+	"Industrial",
+	"Alternative",
+	"Ska",
+	"Death Metal",
+	"Pranks",
+	"Soundtrack",
+	"Euro-Techno",
+	"Ambient",
+	"Trip-Hop",
+	"Vocal",
+	"Jazz-Funk",
+	"Fusion",
+	"Trance",
+	"Classical",
+	"Instrumental",
+	"Acid",
+	"House",
+	"Game",
+	"Sound Clip",
+	"Gospel",
+	"Noise",
+	"Alternative Rock",
+	"Bass",
+	"Soul",
+	"Punk",
+	"Space",
+	"Meditative",
+	"Instrumental Pop",
+	"Instrumental Rock",
+	"Ethnic",
+	"Gothic",
+	"Darkwave",
+	"Techno-Industrial",
+	"Electronic",
+	"Pop-Folk",
+	"Eurodance",
+	"Dream",
+	"Southern Rock",
+	"Comedy",
+	"Cult",
+	"Gangsta",
+	"Top 40",
+	"Christian Rap",
+	"Pop/Funk",
+	"Jungle",
+	"Native American",
+	"Cabaret",
+	"New Wave",
+	"Psychedelic",
+	"Rave",
+	"Showtunes",
+	"Trailer",
+	"Lo-Fi",
+	"Tribal",
+	"Acid Punk",
+	"Acid Jazz",
+	"Polka",
+	"Retro",
+	"Musical",
+	"Rock & Roll",
+	"Hard Rock",
+	"Folk",
+	"Folk Rock",
+	"National Folk",
+	"Swing",
+	"Fast Fusion",
+	"Bebop",
+	"Latin",
+	"Revival",
+	"Celtic",
+	"Bluegrass",
+	"Avant-garde",
+	"Gothic Rock",
+	"Progressive Rock",
+	"Psychedelic Rock",
+	"Symphonic Rock",
+	"Slow Rock",
+	"Big Band",
+	"Chorus",
+	"Easy Listening",
+	"Acoustic",
+	"Humour",
+	"Speech",
+	"Chanson",
+	"Opera",
+	"Chamber Music",
+	"Sonata",
+	"Symphony",
+	"Booty Bass",
+	"Primus",
+	"Porn Groove",
+	"Satire",
+	"Slow Jam",
+	"Club",
+	"Tango",
+	"Samba",
+	"Folklore",
+	"Ballad",
+	"Power Ballad",
+	"Rhythmic Soul",
+	"Freestyle",
+	"Duet",
+	"Punk Rock",
+	"Drum Solo",
+	"A Cappella",
+	"Euro-House",
+	"Dancehall",
+	"Goa",
+	"Drum & Bass",
+	"Club-House",
+	"Hardcore Techno",
+	"Terror",
+	"Indie",
+	"Britpop",
+	"Worldbeat",
+	"Polsk Punk",
+	"Beat",
+	"Christian Gangsta Rap",
+	"Heavy Metal",
+	"Black Metal",
+	"Crossover",
+	"Contemporary Christian",
+	"Christian Rock",
+	"Merengue",
+	"Salsa",
+	"Thrash Metal",
+	"Anime",
+	"Jpop",
+	"Synthpop",
+	"Abstract",
+	"Art Rock",
+	"Baroque",
+	"Bhangra",
+	"Big Beat",
+	"Breakbeat",
+	"Chillout",
+	"Downtempo",
+	"Dub",
+	"EBM",
+	"Eclectic",
+	"Electro",
+	"Electroclash",
+	"Emo",
+	"Experimental",
+	"Garage",
+	"Global",
+	"IDM",
+	"Illbient",
+	"Industro-Goth",
+	"Jam Band",
+	"Krautrock",
+	"Leftfield",
+	"Lounge",
+	"Math Rock",
+	"New Romantic",
+	"Nu-Breakz",
+	"Post-Punk",
+	"Post-Rock",
+	"Psytrance",
+	"Shoegaze",
+	"Space Rock",
+	"Trop Rock",
+	"World Music",
+	"Neoclassical",
+	"Audiobook",
+	"Audio Theatre",
+	"Neue Deutsche Welle",
+	"Podcast",
+	"Indie Rock",
+	"G-Funk",
+	"Dubstep",
+	"Garage Rock",
+	"Psybient",
+}
+
+// MusicalKey returns the initial key of the file, parsed from [InitialKey] as described by [ParseMusicalKey] so that
+// keys written by taggers using different notations compare equal. Returns [ErrNoMusicalKey] if the file has no key.
+func (f *File) MusicalKey() (MusicalKey, error) {
+	tags, err := f.readTags()
+	if err != nil {
+		return MusicalKey{}, err
+	}
+	if len(tags[InitialKey]) == 0 || strings.TrimSpace(tags[InitialKey][0]) == "" {
+		return MusicalKey{}, ErrNoMusicalKey
+	}
+	return ParseMusicalKey(tags[InitialKey][0])
+}
+
+// SetMusicalKey writes the initial key of the file to [InitialKey] in standard notation, as [MusicalKey.String]
+// returns it.
+func (f *File) SetMusicalKey(key MusicalKey) error {
+	if key.Tonic < 0 || key.Tonic > 11 {
+		return fmt.Errorf("invalid tonic %d", key.Tonic)
+	}
+	return f.WriteTags(map[string][]string{InitialKey: {key.String()}}, 0)
+}
+
+// MusicalKey is the key of a piece of music, such as A minor.
+type MusicalKey struct {
+	// Tonic is the pitch class of the tonic, from 0 for C to 11 for B
+	Tonic int
+	// Minor is true for minor keys and false for major keys
+	Minor bool
+}
+
+// musicalKeyNames are the names of the major and minor tonics by pitch class, spelled as DJ software commonly does.
+var musicalKeyNames = [2][12]string{
+	{"C", "Db", "D", "Eb", "E", "F", "F#", "G", "Ab", "A", "Bb", "B"},
+	{"C", "C#", "D", "Eb", "E", "F", "F#", "G", "G#", "A", "Bb", "B"},
+}
+
+// String returns the key in standard notation, such as "Am" for A minor and "Eb" for E-flat major.
+// This fits the three characters the ID3v2 TKEY frame allows.
+func (k MusicalKey) String() string {
+	if k.Minor {
+		return musicalKeyNames[1][k.Tonic] + "m"
+	}
+	return musicalKeyNames[0][k.Tonic]
+}
+
+// Camelot returns the key in Camelot notation, such as "8A" for A minor and "8B" for C major.
+func (k MusicalKey) Camelot() string {
+	if k.Minor {
+		return strconv.Itoa(k.wheel()) + "A"
+	}
+	return strconv.Itoa(k.wheel()) + "B"
+}
+
+// OpenKey returns the key in Open Key notation, such as "1m" for A minor and "1d" for C major.
+func (k MusicalKey) OpenKey() string {
+	n := (k.wheel()+4)%12 + 1
+	if k.Minor {
+		return strconv.Itoa(n) + "m"
+	}
+	return strconv.Itoa(n) + "d"
+}
+
+// wheel returns the number of the key on the Camelot wheel, from 1 to 12.
+func (k MusicalKey) wheel() int {
+	major := k.Tonic
+	if k.Minor {
+		major = (k.Tonic + 3) % 12 // the relative major
+	}
+	return (major*7+7)%12 + 1
+}
+
+// musicalKeyFromWheel returns the key with number wheel on the Camelot wheel.
+func musicalKeyFromWheel(wheel int, minor bool) MusicalKey {
+	major := (wheel + 4) * 7 % 12
+	if minor {
+		return MusicalKey{Tonic: (major + 9) % 12, Minor: true}
+	}
+	return MusicalKey{Tonic: major}
+}
+
+// ParseMusicalKey parses a key in standard notation, such as "Am", "A minor", "F#", "Ebmaj", or "B♭m", in Camelot
+// notation, such as "8A", or in Open Key notation, such as "1m". Case and surrounding space are ignored.
+func ParseMusicalKey(s string) (MusicalKey, error) {
+	v := strings.ToLower(strings.TrimSpace(s))
+	if n, letter := strings.TrimRight(v, "abdm"), strings.TrimLeft(v, "0123456789"); n != "" && len(letter) == 1 {
+		if wheel, err := strconv.Atoi(n); err == nil && n[0] != '0' && wheel <= 12 {
+			switch letter {
+			case "a", "b": // Camelot
+				return musicalKeyFromWheel(wheel, letter == "a"), nil
+			case "m", "d": // Open Key
+				return musicalKeyFromWheel((wheel+6)%12+1, letter == "m"), nil
+			}
+		}
+	}
+
+	if v == "" || v[0] == '_' {
+		return MusicalKey{}, fmt.Errorf("invalid musical key %q", s)
+	}
+	tonic := strings.IndexByte("c_d_ef_g_a_b", v[0])
+	if tonic < 0 {
+		return MusicalKey{}, fmt.Errorf("invalid musical key %q", s)
+	}
+	rest := v[1:]
+	switch r, size := utf8.DecodeRuneInString(rest); r {
+	case '#', '♯':
+		tonic, rest = tonic+1, rest[size:]
+	case 'b', '♭':
+		tonic, rest = tonic+11, rest[size:]
+	}
+	switch strings.TrimSpace(rest) {
+	case "", "maj", "major":
+		return MusicalKey{Tonic: tonic % 12}, nil
+	case "m", "min", "minor":
+		return MusicalKey{Tonic: tonic % 12, Minor: true}, nil
+	}
+	return MusicalKey{}, fmt.Errorf("invalid musical key %q", s)
+}
+
+// Lyrics returns the unsynchronised lyrics of the file, regardless of format.
+// This reads [Lyrics], which TagLib maps from ID3v2 USLT, MP4 ©lyr, Vorbis LYRICS, and ASF WM/Lyrics.
+// If there are only lyrics with a description (such as ID3v2 USLT frames in other languages), the first of those is used.
+// Returns an empty string if the file has no lyrics.
+func (f *File) Lyrics() (string, error) {
+	tags, err := f.readTags()
+	if err != nil {
+		return "", err
+	}
+	return firstLyrics(tags, Lyrics), nil
+}
+
+// SyncedLyrics returns the synchronised lyrics of the file in LRC format, regardless of format.
+// ID3v2 SYLT frames are converted to LRC. For other formats, the unsynchronised lyrics are returned if they
+// are already LRC formatted, as is common in Vorbis comments and MP4 atoms.
+// Returns an empty string if the file has no synchronised lyrics.
+func (f *File) SyncedLyrics() (string, error) {
+	raw, err := f.readRawTags()
+	if err != nil {
+		return "", err
+	}
+	if lrc := firstLyrics(raw, "SYLT"); lrc != "" {
+		return lrc, nil
+	}
+
+	lyrics, err := f.Lyrics()
+	if err != nil {
+		return "", err
+	}
+	if isLRC(lyrics) {
+		return lyrics, nil
+	}
+	return "", nil
+}
+
+// firstLyrics returns the value of key, falling back to the first of "key:description" in key order.
+func firstLyrics(tags map[string][]string, key string) string {
+	if vs := tags[key]; len(vs) > 0 {
+		return vs[0]
+	}
+	var keys []string
+	for k := range tags {
+		if strings.HasPrefix(k, key+":") && len(tags[k]) > 0 {
+			keys = append(keys, k)
+		}
+	}
+	if len(keys) == 0 {
+		return ""
+	}
+	slices.Sort(keys)
+	return tags[keys[0]][0]
+}
+
+// isLRC reports whether text looks like LRC lyrics, with a "[mm:ss.xx]" timestamp starting each line.
+func isLRC(text string) bool {
+	var timed bool
+	for line := range strings.Lines(text) {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		mins, rest, ok := strings.Cut(strings.TrimPrefix(line, "["), ":")
+		if !strings.HasPrefix(line, "[") || !ok {
+			return false
+		}
+		secs, _, ok := strings.Cut(rest, "]")
+		if !ok {
+			return false
+		}
+		if _, err := strconv.Atoi(mins); err != nil {
+			// Metadata tags such as [ar:Artist] are allowed alongside timestamps
+			continue
+		}
+		if _, err := strconv.ParseFloat(secs, 64); err != nil {
+			return false
+		}
+		timed = true
+	}
+	return timed
+}
+
+// firstTag returns the first value of the first of keys that has a non-empty one in tags, or "" if none has.
+func firstTag(tags map[string][]string, keys ...string) string {
+	for _, key := range keys {
+		if vs := tags[key]; len(vs) > 0 && vs[0] != "" {
+			return vs[0]
+		}
+	}
+	return ""
+}
+
+// tagValue returns v as the values of a tag, or none if it is empty, which removes the tag when written.
+func tagValue(v string) []string {
+	if v == "" {
+		return nil
+	}
+	return []string{v}
+}
+
+// parseTagBool parses boolean tag values such as "1", "true", or "yes".
+func parseTagBool(s string) bool {
+	s = strings.TrimSpace(s)
+	if n, err := strconv.Atoi(s); err == nil {
+		return n != 0
+	}
+	switch strings.ToLower(s) {
+	case "true", "yes":
+		return true
+	}
+	return false
+}
+
+// RawTags reads format-specific tags from the file.
+// For MP3/WAV/AIFF: returns ID3v2 frames
+// For MP4: returns MP4 atoms
+// For ASF: returns ASF attributes
+// For other formats (FLAC, OGG, etc.): returns same as Tags() (Vorbis Comments)
+func (f *File) RawTags() map[string][]string {
+	tags, _ := f.readRawTags()
+	return tags
+}
 
+func (f *File) readRawTags() (map[string][]string, error) {
 	var raw wasmStrings
-	if err := mod.call("taglib_file_mp4_atoms", &raw, wasmString(wasmPath(path))); err != nil {
+	if err := f.mod.call("taglib_handle_raw_tags", &raw, wasmUint32(f.handle)); err != nil {
+		return nil, fmt.Errorf("call: %w", err)
+	}
+	if raw == nil {
+		return nil, ErrInvalidFile
+	}
+
+	tags := map[string][]string{}
+	for _, row := range raw {
+		k, v, ok := strings.Cut(row, "\t")
+		if !ok {
+			continue
+		}
+		tags[k] = append(tags[k], v)
+	}
+	return tags, nil
+}
+
+// RawEntry is a single format-specific tag, such as one ID3v2 frame, with the key [File.RawTags] lists it under.
+type RawEntry struct {
+	Key   string
+	Value string
+}
+
+// RawEntries reads format-specific tags from the file like [File.RawTags], but as a flat list that keeps
+// entries with the same key separate and in order.
+// For MP3 files, entries are in the order of the frames in the ID3v2 tag. Frames that TagLib converts
+// when reading, such as those of ID3v2.2 tags, are listed after the others.
+// For other formats, entries are in the order TagLib reads them, which groups them by key.
+func (f *File) RawEntries() []RawEntry {
+	entries, _ := f.readRawEntries()
+	return entries
+}
+
+func (f *File) readRawEntries() ([]RawEntry, error) {
+	var raw wasmStrings
+	if err := f.mod.call("taglib_handle_raw_tags", &raw, wasmUint32(f.handle)); err != nil {
 		return nil, fmt.Errorf("call: %w", err)
 	}
 	if raw == nil {
 		return nil, ErrInvalidFile
 	}
 
-	// If raw is empty, the file has no MP4 atoms
-	var atoms = map[string][]string{}
-	for _, row := range raw {
-		parts := strings.SplitN(row, "\t", 2)
-		if len(parts) != 2 {
-			continue
+	var entries []RawEntry
+	for _, row := range raw {
+		k, v, ok := strings.Cut(row, "\t")
+		if !ok {
+			continue
+		}
+		entries = append(entries, RawEntry{Key: k, Value: v})
+	}
+
+	if f.format == FormatMPEG {
+		f.readRaw(func(r io.ReaderAt) {
+			id3, _ := readID3v2Tag(r)
+			sortID3v2Entries(entries, id3v2FrameIDs(id3))
+		})
+	}
+	return entries, nil
+}
+
+// RawTagsUniform reads format-specific tags from the file like [File.RawTags], but handles their values the same way
+// across formats, without the normalization of [File.Tags]. Each value of a multi-valued field is separate, including
+// those of ID3v2 text frames, which [File.RawTags] joins with spaces, and the values of TXXX frames after the first.
+// Fields that hold binary data, such as ID3v2 APIC and PRIV frames, MP4 covr atoms, and ASF byte attributes, are left
+// out, as are empty values.
+func (f *File) RawTagsUniform() map[string][]string {
+	tags, _ := f.readRawTagsUniform()
+	return tags
+}
+
+func (f *File) readRawTagsUniform() (map[string][]string, error) {
+	entries, err := f.readRawEntries()
+	if err != nil {
+		return nil, err
+	}
+
+	// TagLib joins the values of text frames, so split them from the tag itself where it can be read
+	var split map[string][]string
+	if f.format == FormatMPEG {
+		f.readRaw(func(r io.ReaderAt) {
+			tag, _ := readID3v2Tag(r)
+			split = id3v2TextValues(tag)
+		})
+	}
+
+	tags := map[string][]string{}
+	for _, e := range entries {
+		// Binary MP4 atoms and ASF attributes have empty values
+		if e.Value == "" || hasID3v2Tags(f.format) && !isTextualID3v2Frame(e.Key) {
+			continue
+		}
+		if vs, ok := split[e.Key]; ok {
+			tags[e.Key] = vs
+			continue
+		}
+		tags[e.Key] = append(tags[e.Key], e.Value)
+	}
+	return tags, nil
+}
+
+// isTextualID3v2Frame reports whether the frame listed under key by [File.RawTags] holds text or a number,
+// rather than binary data such as an image.
+func isTextualID3v2Frame(key string) bool {
+	id, _, _ := strings.Cut(key, ":")
+	switch id {
+	case "COMM", "USLT", "SYLT", "POPM", "PCNT":
+		return true
+	}
+	return strings.HasPrefix(id, "T") || strings.HasPrefix(id, "W")
+}
+
+// id3v2TextValues returns the separate values of the text frames of tag by the key [File.RawTags] lists them under,
+// such as "TPE1" or "TXXX:description". Frames that can't be decoded are left out.
+func id3v2TextValues(tag []byte) map[string][]string {
+	values := map[string][]string{}
+	for _, frame := range id3v2Frames(tag) {
+		if frame.id[0] != 'T' || !isID3v2TextFrame(frame) {
+			continue
+		}
+		vs, ok := decodeID3v2Strings(frame.body[0], frame.body[1:])
+		if !ok {
+			continue
+		}
+		key := frame.id
+		if frame.id == "TXXX" {
+			if len(vs) == 0 {
+				continue
+			}
+			key, vs = "TXXX:"+vs[0], vs[1:]
+		}
+		for _, v := range vs {
+			if v != "" {
+				values[key] = append(values[key], v)
+			}
+		}
+	}
+	return values
+}
+
+// ExplainResult describes where the values of a normalized tag key come from in a file.
+type ExplainResult struct {
+	Key    string     // The normalized key, such as ALBUMARTIST
+	Values []string   // The values of Key, as in [File.Tags]
+	Raw    []RawEntry // The format-specific tags that TagLib maps to Key, as in [File.RawEntries]
+}
+
+// Explain reports the format-specific tags of the file that TagLib maps to the normalized key, such as the ID3v2
+// TPE2 frame or MP4 aART atom for [AlbumArtist], along with their values. This is meant for debugging how tags are
+// mapped. For formats without a separate raw representation, such as FLAC and Ogg, the raw tags are the
+// normalized ones. Raw is empty if nothing in the file maps to key.
+func (f *File) Explain(key string) (ExplainResult, error) {
+	key = strings.ToUpper(key)
+	tags, err := f.readTags()
+	if err != nil {
+		return ExplainResult{}, err
+	}
+	entries, err := f.readRawEntries()
+	if err != nil {
+		return ExplainResult{}, err
+	}
+
+	result := ExplainResult{Key: key, Values: tags[key]}
+	for _, e := range entries {
+		if rawKeyMapsTo(f.format, e.Key, key) {
+			result.Raw = append(result.Raw, e)
+		}
+	}
+	return result, nil
+}
+
+// rawKeyMapsTo reports whether TagLib maps the raw key of a file of format, as listed by [File.RawTags], to the
+// normalized key.
+func rawKeyMapsTo(format FileFormat, rawKey, key string) bool {
+	switch format {
+	case FormatMPEG, FormatWAV, FormatAIFF:
+		id, desc, _ := strings.Cut(rawKey, ":")
+		switch id {
+		case "TXXX":
+			return freeformKey(desc) == key
+		case "COMM", "WXXX":
+			base := map[string]string{"COMM": Comment, "WXXX": URL}[id]
+			return key == base || key == base+":"+strings.ToUpper(desc)
+		case "USLT":
+			// Listed by language rather than description
+			return key == Lyrics || strings.HasPrefix(key, Lyrics+":")
+		case "TIPL":
+			return slices.Contains(involvedPeopleRoles, key)
+		case "TMCL":
+			return strings.HasPrefix(key, Performer+":")
+		}
+		return id3v2FrameKeys[id] == key
+	case FormatMP4:
+		if name, ok := strings.CutPrefix(rawKey, "----:"); ok {
+			// Free-form atoms are listed as "----:mean:name"
+			_, name, _ = strings.Cut(name, ":")
+			return freeformKey(name) == key
+		}
+		atom, _, _ := strings.Cut(rawKey, ":")
+		return mp4AtomKeys[atom] == key
+	case FormatASF:
+		if k, ok := asfAttributeKeys[rawKey]; ok {
+			return k == key
+		}
+		// Such as "MusicBrainz/Album Id", or custom attributes
+		return freeformKey(strings.Replace(strings.TrimPrefix(rawKey, "WM/"), "/", " ", 1)) == key
+	}
+	return strings.ToUpper(rawKey) == key
+}
+
+// freeformKey returns the normalized key of the description of an ID3v2 TXXX frame or name of an MP4 free-form
+// atom, such as "MusicBrainz Album Id".
+func freeformKey(desc string) string {
+	desc = strings.ToUpper(desc)
+	if k, ok := freeformDescriptionKeys[desc]; ok {
+		return k
+	}
+	return desc
+}
+
+// freeformDescriptionKeys are the normalized keys of free-form tags that TagLib names differently, by upper case
+// description.
+var freeformDescriptionKeys = map[string]string{
+	"ACOUSTID FINGERPRINT":              AcoustIDFingerprint,
+	"ACOUSTID ID":                       AcoustIDID,
+	"MUSICBRAINZ ALBUM ARTIST ID":       MusicBrainzAlbumArtistID,
+	"MUSICBRAINZ ALBUM ID":              MusicBrainzAlbumID,
+	"MUSICBRAINZ ALBUM RELEASE COUNTRY": ReleaseCountry,
+	"MUSICBRAINZ ALBUM STATUS":          ReleaseStatus,
+	"MUSICBRAINZ ALBUM TYPE":            ReleaseType,
+	"MUSICBRAINZ ARTIST ID":             MusicBrainzArtistID,
+	"MUSICBRAINZ RELEASE GROUP ID":      MusicBrainzReleaseGroupID,
+	"MUSICBRAINZ RELEASE TRACK ID":      MusicBrainzReleaseTrackID,
+	"MUSICBRAINZ TRACK ID":              MusicBrainzTrackID,
+	"MUSICBRAINZ WORK ID":               MusicBrainzWorkID,
+	"MUSICIP PUID":                      MusicIPPUID,
+}
+
+// id3v2FrameKeys are the normalized keys of ID3v2 frames, other than those with a description.
+var id3v2FrameKeys = map[string]string{
+	"GRP1": Grouping,
+	"MVIN": MovementNumber,
+	"MVNM": MovementName,
+	"PCST": Podcast,
+	"TALB": Album,
+	"TBPM": BPM,
+	"TCAT": PodcastCategory,
+	"TCMP": Compilation,
+	"TCOM": Composer,
+	"TCON": Genre,
+	"TCOP": Copyright,
+	"TDEN": EncodingTime,
+	"TDES": PodcastDesc,
+	"TDLY": PlaylistDelay,
+	"TDOR": OriginalDate,
+	"TDRC": Date,
+	"TDRL": ReleaseDate,
+	"TDTG": TaggingDate,
+	"TENC": EncodedBy,
+	"TEXT": Lyricist,
+	"TFLT": FileType,
+	"TGID": PodcastID,
+	"TIT1": Work,
+	"TIT2": Title,
+	"TIT3": Subtitle,
+	"TKEY": InitialKey,
+	"TLAN": Language,
+	"TLEN": Length,
+	"TMED": Media,
+	"TMOO": Mood,
+	"TOAL": OriginalAlbum,
+	"TOFN": OriginalFilename,
+	"TOLY": OriginalLyricist,
+	"TOPE": OriginalArtist,
+	"TOWN": Owner,
+	"TPE1": Artist,
+	"TPE2": AlbumArtist,
+	"TPE3": Conductor,
+	"TPE4": Remixer,
+	"TPOS": DiscNumber,
+	"TPRO": ProducedNotice,
+	"TPUB": Label,
+	"TRCK": TrackNumber,
+	"TRSN": RadioStation,
+	"TRSO": RadioStationOwner,
+	"TSO2": AlbumArtistSort,
+	"TSOA": AlbumSort,
+	"TSOC": ComposerSort,
+	"TSOP": ArtistSort,
+	"TSOT": TitleSort,
+	"TSRC": ISRC,
+	"TSSE": Encoding,
+	"TSST": DiscSubtitle,
+	"TYER": Date,
+	"UFID": MusicBrainzTrackID,
+	"WCOP": CopyrightURL,
+	"WFED": PodcastURL,
+	"WOAF": FileWebpage,
+	"WOAR": ArtistWebpage,
+	"WOAS": AudioSourceWebpage,
+	"WORS": RadioStationWebpage,
+	"WPAY": PaymentWebpage,
+	"WPUB": PublisherWebpage,
+}
+
+// mp4AtomKeys are the normalized keys of MP4 atoms, other than free-form ones.
+var mp4AtomKeys = map[string]string{
+	"aART": AlbumArtist,
+	"catg": PodcastCategory,
+	"cpil": Compilation,
+	"cprt": Copyright,
+	"desc": PodcastDesc,
+	"disk": DiscNumber,
+	"egid": PodcastID,
+	"ownr": Owner,
+	"pcst": Podcast,
+	"pgap": GaplessPlayback,
+	"purl": PodcastURL,
+	"shwm": ShowWorkMovement,
+	"soaa": AlbumArtistSort,
+	"soal": AlbumSort,
+	"soar": ArtistSort,
+	"soco": ComposerSort,
+	"sonm": TitleSort,
+	"sosn": ShowSort,
+	"tmpo": BPM,
+	"trkn": TrackNumber,
+	"tven": TVEpisodeID,
+	"tves": TVEpisode,
+	"tvnn": TVNetwork,
+	"tvsh": TVShow,
+	"tvsn": TVSeason,
+	"©ART": Artist,
+	"©alb": Album,
+	"©cmt": Comment,
+	"©day": Date,
+	"©enc": EncodedBy,
+	"©gen": Genre,
+	"©grp": Grouping,
+	"©lyr": Lyrics,
+	"©mvc": MovementCount,
+	"©mvi": MovementNumber,
+	"©mvn": MovementName,
+	"©nam": Title,
+	"©too": Encoding,
+	"©wrk": Work,
+	"©wrt": Composer,
+}
+
+// asfAttributeKeys are the normalized keys of ASF attributes that aren't named after them.
+var asfAttributeKeys = map[string]string{
+	"Author":                     Artist,
+	"Copyright":                  Copyright,
+	"Description":                Comment,
+	"Title":                      Title,
+	"WM/AlbumArtist":             AlbumArtist,
+	"WM/AlbumArtistSortOrder":    AlbumArtistSort,
+	"WM/AlbumSortOrder":          AlbumSort,
+	"WM/AlbumTitle":              Album,
+	"WM/ArtistSortOrder":         ArtistSort,
+	"WM/AudioFileURL":            FileWebpage,
+	"WM/AuthorURL":               ArtistWebpage,
+	"WM/CatalogNo":               CatalogNumber,
+	"WM/ContentGroupDescription": Work,
+	"WM/EncodingSettings":        Encoding,
+	"WM/ModifiedBy":              Remixer,
+	"WM/OriginalAlbumTitle":      OriginalAlbum,
+	"WM/OriginalReleaseYear":     OriginalDate,
+	"WM/PartOfSet":               DiscNumber,
+	"WM/Publisher":               Label,
+	"WM/SetSubTitle":             DiscSubtitle,
+	"WM/SubTitle":                Subtitle,
+	"WM/TitleSortOrder":          TitleSort,
+	"WM/Writer":                  Lyricist,
+	"WM/Year":                    Date,
+}
+
+// sortID3v2Entries sorts entries of ID3v2 frames by the position of their frame in ids, the frame IDs of the
+// tag in order. TagLib lists frames with the same ID in order, so the nth entry of an ID is the nth frame with it.
+// Entries without a matching frame are moved to the end.
+func sortID3v2Entries(entries []RawEntry, ids []string) {
+	positions := map[string][]int{}
+	for i, id := range ids {
+		positions[id] = append(positions[id], i)
+	}
+
+	type positioned struct {
+		pos   int
+		entry RawEntry
+	}
+	sorted := make([]positioned, len(entries))
+	for i, e := range entries {
+		sorted[i] = positioned{pos: len(ids) + i, entry: e}
+		id, _, _ := strings.Cut(e.Key, ":")
+		if ps := positions[id]; len(ps) > 0 {
+			sorted[i].pos = ps[0]
+			positions[id] = ps[1:]
+		}
+	}
+	slices.SortStableFunc(sorted, func(a, b positioned) int { return a.pos - b.pos })
+
+	for i := range sorted {
+		entries[i] = sorted[i].entry
+	}
+}
+
+// AllTags contains both normalized and format-specific tags.
+type AllTags struct {
+	// Tags contains normalized tag keys (TITLE, ARTIST, etc.)
+	Tags map[string][]string
+	// Raw contains format-specific tags (ID3v2 frames, MP4 atoms, etc.)
+	Raw map[string][]string
+	// Format is the detected audio file format
+	Format FileFormat
+}
+
+// AllTags reads both normalized and format-specific tags in a single struct.
+func (f *File) AllTags() AllTags {
+	return AllTags{
+		Tags:   f.Tags(),
+		Raw:    f.RawTags(),
+		Format: f.format,
+	}
+}
+
+// Properties reads the audio properties from the file.
+func (f *File) Properties() Properties {
+	var raw wasmFileProperties
+	if err := f.mod.call("taglib_handle_properties", &raw, wasmUint32(f.handle)); err != nil {
+		return Properties{}
+	}
+
+	images := parseImageDescs(raw.imageDescs)
+
+	props := Properties{
+		Length:        time.Duration(raw.lengthInMilliseconds) * time.Millisecond,
+		Channels:      uint(raw.channels),
+		SampleRate:    uint(raw.sampleRate),
+		Bitrate:       uint(raw.bitrate),
+		BitsPerSample: uint(raw.bitsPerSample),
+		Codec:         raw.codec,
+		ImageCount:    len(images),
+		Images:        images,
+	}
+
+	// Reading these may take more requests of remote streams, so it's done once until the file changes
+	if f.raw == nil {
+		f.raw = &Properties{}
+		f.readRaw(func(r io.ReaderAt) {
+			f.raw.CompressionMode = compressionMode(r, f.format)
+			f.raw.ChapterCount, f.raw.CueTrackCount = embeddedCounts(r, f.format)
+			f.raw.ChannelLayout = channelLayout(r, f.format, props.Channels)
+			f.raw.HasVideo = f.format == FormatMP4 && mp4HasVideo(r)
+		})
+	}
+	props.CompressionMode = f.raw.CompressionMode
+	props.ChapterCount, props.CueTrackCount = f.raw.ChapterCount, f.raw.CueTrackCount
+	props.ChannelLayout = f.raw.ChannelLayout
+	props.HasVideo = f.raw.HasVideo
+	return props
+}
+
+// readRaw calls fn with the raw bytes of the file or stream. fn isn't called if they can't be read. The position of a
+// stream is left to TagLib, which reads from it, so streams are only read if they are an [io.ReaderAt] too.
+func (f *File) readRaw(fn func(io.ReaderAt)) {
+	if f.streamId != 0 {
+		r := getStream(f.streamId)
+		if s, ok := r.(*ctxStream); ok {
+			if s.ctx.Err() != nil {
+				return
+			}
+			r = s.r
+		}
+		if r, ok := r.(io.ReaderAt); ok {
+			fn(r)
+		}
+		return
+	}
+
+	file, err := os.Open(f.path)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	fn(file)
+}
+
+// Image reads the embedded image at the specified index from the file.
+// Index 0 is the first image, and index i is the image described by [Properties.Images][i].
+// Returns empty byte slice if index is out of range.
+func (f *File) Image(index int) ([]byte, error) {
+	var img wasmBytes
+	if err := f.mod.call("taglib_handle_image", &img, wasmUint32(f.handle), wasmInt(index)); err != nil {
+		return nil, fmt.Errorf("call: %w", err)
+	}
+	return img, nil
+}
+
+// ImageTo writes the embedded image at the specified index to w, returning the number of bytes written.
+// This avoids holding a copy of the image, such as when serving it with an [net/http.ResponseWriter].
+// Index 0 is the first image. Nothing is written if index is out of range.
+func (f *File) ImageTo(w io.Writer, index int) (int64, error) {
+	img := wasmBytesTo{w: w}
+	if err := f.mod.call("taglib_handle_image", &img, wasmUint32(f.handle), wasmInt(index)); err != nil {
+		return img.n, fmt.Errorf("call: %w", err)
+	}
+	return img.n, nil
+}
+
+// ImageAt reads the embedded image that is the nth occurrence of picture type t, where 0 is the first.
+// Returns empty byte slice if there are not that many images of the type, like [File.Image].
+func (f *File) ImageAt(t PictureType, occurrence int) ([]byte, error) {
+	var raw wasmFileProperties
+	if err := f.mod.call("taglib_handle_properties", &raw, wasmUint32(f.handle)); err != nil {
+		return nil, fmt.Errorf("call: %w", err)
+	}
+
+	n := 0
+	for i, row := range raw.imageDescs {
+		imageType, _, _ := strings.Cut(row, "\t")
+		if PictureType(imageType) != t {
+			continue
+		}
+		if n == occurrence {
+			return f.Image(i)
+		}
+		n++
+	}
+	return []byte{}, nil
+}
+
+// ImageCountsByType returns the number of embedded images of each picture type, from the same data as
+// [Properties.Images]. A count above 1, such as for [PictureFrontCover], points at duplicate or misfiled artwork.
+// Types without images are left out.
+func (f *File) ImageCountsByType() (map[PictureType]int, error) {
+	var raw wasmFileProperties
+	if err := f.mod.call("taglib_handle_properties", &raw, wasmUint32(f.handle)); err != nil {
+		return nil, fmt.Errorf("call: %w", err)
+	}
+
+	counts := map[PictureType]int{}
+	for _, row := range raw.imageDescs {
+		imageType, _, _ := strings.Cut(row, "\t")
+		counts[PictureType(imageType)]++
+	}
+	return counts, nil
+}
+
+// WriteTags writes the metadata key-values pairs to the file.
+// The behavior can be controlled with [WriteOption].
+func (f *File) WriteTags(tags map[string][]string, opts WriteOption) error {
+	var truncated []string
+	if opts&(TruncateWarn|TruncateError) != 0 {
+		truncated = truncatedKeys(f.format, tags)
+		if len(truncated) > 0 && opts&TruncateError != 0 {
+			return &TruncationError{Keys: truncated}
+		}
+	}
+
+	restoreModTime, err := keepModTime(f.path, opts)
+	if err != nil {
+		return err
+	}
+
+	var raw []string
+	for k, vs := range tags {
+		raw = append(raw, fmt.Sprintf("%s\t%s", k, strings.Join(vs, "\v")))
+	}
+
+	var out wasmBool
+	if err := f.mod.call("taglib_handle_write_tags", &out, wasmUint32(f.handle), wasmStrings(raw), wasmUint8(opts)); err != nil {
+		return fmt.Errorf("call: %w", err)
+	}
+	if !out {
+		return ErrSavingFile
+	}
+	// The tags can change the cached properties, such as the cue tracks of a CUESHEET comment
+	f.raw = nil
+	if err := restoreModTime(); err != nil {
+		return err
+	}
+	if len(truncated) > 0 {
+		return &TruncationError{Keys: truncated}
+	}
+	return nil
+}
+
+// WriteImage writes an image with custom metadata.
+// Index specifies which image slot to write to (0 = first image).
+// Set image to nil to clear the image at that index.
+// If mimeType is empty it is detected from the image data, and [ErrInvalidImage] is returned if that fails.
+func (f *File) WriteImage(image []byte, index int, imageType, description, mimeType string) error {
+	mimeType, err := imageMIME(image, mimeType)
+	if err != nil {
+		return err
+	}
+
+	var out wasmBool
+	if err := f.mod.call("taglib_handle_write_image", &out, wasmUint32(f.handle), wasmBytes(image), wasmUint32(uint32(len(image))), wasmInt(index), wasmString(imageType), wasmString(description), wasmString(mimeType)); err != nil {
+		return fmt.Errorf("call: %w", err)
+	}
+	if !out {
+		return ErrSavingFile
+	}
+	return nil
+}
+
+// SetPictures replaces all the embedded images of the file with pics, in order. The images are written to a copy of
+// the file that replaces it once they are all written, so the file is never left with only some of them. This suits
+// files with several images of different types, such as a FLAC file with exactly one front and one back cover, better
+// than replacing images by index with [File.WriteImage]. Empty pics removes all images.
+// Returns [ErrInvalidImage] if a picture has no data, or no MIME type and one can't be detected.
+func (f *File) SetPictures(pics []Picture) error {
+	mimeTypes := make([]string, len(pics))
+	for i, pic := range pics {
+		if len(pic.Data) == 0 {
+			return fmt.Errorf("picture %d: %w: no data", i, ErrInvalidImage)
+		}
+		mimeType, err := imageMIME(pic.Data, pic.MIMEType)
+		if err != nil {
+			return fmt.Errorf("picture %d: %w", i, err)
+		}
+		mimeTypes[i] = mimeType
+	}
+	if f.readOnly || f.path == "" {
+		return ErrSavingFile
+	}
+	count := f.Properties().ImageCount
+
+	tmp, err := copyToTemp(f.path)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+
+	// The copy gets its own module, as the module of the File only has the file itself
+	mod, err := newModule(tmp)
+	if err != nil {
+		return fmt.Errorf("init module: %w", err)
+	}
+	defer mod.close()
+
+	write := func(image []byte, index int, imageType PictureType, description, mimeType string) error {
+		var out wasmBool
+		if err := mod.call("taglib_file_write_image", &out, wasmString(wasmPath(tmp)), wasmBytes(image), wasmUint32(uint32(len(image))), wasmInt(index), wasmString(string(imageType)), wasmString(description), wasmString(mimeType)); err != nil {
+			return fmt.Errorf("call: %w", err)
+		}
+		if !out {
+			return ErrSavingFile
+		}
+		return nil
+	}
+	for range count {
+		if err := write(nil, 0, "", "", ""); err != nil {
+			return err
+		}
+	}
+	for i, pic := range pics {
+		if err := write(pic.Data, i, pic.Type, pic.Description, mimeTypes[i]); err != nil {
+			return err
+		}
+	}
+
+	return f.replaceFile(func() error {
+		if err := os.Rename(tmp, f.path); err != nil {
+			return fmt.Errorf("%w: %w", ErrSavingFile, err)
+		}
+		return nil
+	})
+}
+
+// These constants define normalized tag keys used by TagLib's [property mapping].
+// When using [ReadTags], the library will map format-specific metadata to these standardized keys.
+// Similarly, [WriteTags] will map these keys back to the appropriate format-specific fields.
+//
+// While these constants provide a consistent interface across different audio formats,
+// you can also use custom tag keys if the underlying format supports arbitrary tags.
+//
+// [property mapping]: https://taglib.org/api/p_propertymapping.html
+const (
+	AcoustIDFingerprint       = "ACOUSTID_FINGERPRINT"
+	AcoustIDID                = "ACOUSTID_ID"
+	Album                     = "ALBUM"
+	AlbumArtist               = "ALBUMARTIST"
+	AlbumArtistSort           = "ALBUMARTISTSORT"
+	AlbumSort                 = "ALBUMSORT"
+	Arranger                  = "ARRANGER"
+	Artist                    = "ARTIST"
+	Artists                   = "ARTISTS"
+	ArtistSort                = "ARTISTSORT"
+	ArtistWebpage             = "ARTISTWEBPAGE"
+	ASIN                      = "ASIN"
+	AudioSourceWebpage        = "AUDIOSOURCEWEBPAGE"
+	Barcode                   = "BARCODE"
+	BPM                       = "BPM"
+	CatalogNumber             = "CATALOGNUMBER"
+	Comment                   = "COMMENT"
+	Compilation               = "COMPILATION"
+	Composer                  = "COMPOSER"
+	ComposerSort              = "COMPOSERSORT"
+	Conductor                 = "CONDUCTOR"
+	Copyright                 = "COPYRIGHT"
+	CopyrightURL              = "COPYRIGHTURL"
+	Date                      = "DATE"
+	DiscNumber                = "DISCNUMBER"
+	DiscSubtitle              = "DISCSUBTITLE"
+	DJMixer                   = "DJMIXER"
+	EncodedBy                 = "ENCODEDBY"
+	Encoding                  = "ENCODING"
+	EncodingTime              = "ENCODINGTIME"
+	Engineer                  = "ENGINEER"
+	FileType                  = "FILETYPE"
+	FileWebpage               = "FILEWEBPAGE"
+	GaplessPlayback           = "GAPLESSPLAYBACK"
+	Genre                     = "GENRE"
+	Grouping                  = "GROUPING"
+	InitialKey                = "INITIALKEY"
+	InvolvedPeople            = "INVOLVEDPEOPLE"
+	ISRC                      = "ISRC"
+	Label                     = "LABEL"
+	Language                  = "LANGUAGE"
+	Length                    = "LENGTH"
+	License                   = "LICENSE"
+	Lyricist                  = "LYRICIST"
+	Lyrics                    = "LYRICS"
+	Media                     = "MEDIA"
+	Mixer                     = "MIXER"
+	Mood                      = "MOOD"
+	MovementCount             = "MOVEMENTCOUNT"
+	MovementName              = "MOVEMENTNAME"
+	MovementNumber            = "MOVEMENTNUMBER"
+	MusicBrainzAlbumID        = "MUSICBRAINZ_ALBUMID"
+	MusicBrainzAlbumArtistID  = "MUSICBRAINZ_ALBUMARTISTID"
+	MusicBrainzArtistID       = "MUSICBRAINZ_ARTISTID"
+	MusicBrainzReleaseGroupID = "MUSICBRAINZ_RELEASEGROUPID"
+	MusicBrainzReleaseTrackID = "MUSICBRAINZ_RELEASETRACKID"
+	MusicBrainzTrackID        = "MUSICBRAINZ_TRACKID"
+	MusicBrainzWorkID         = "MUSICBRAINZ_WORKID"
+	MusicianCredits           = "MUSICIANCREDITS"
+	MusicIPPUID               = "MUSICIP_PUID"
+	OriginalAlbum             = "ORIGINALALBUM"
+	OriginalArtist            = "ORIGINALARTIST"
+	OriginalDate              = "ORIGINALDATE"
+	OriginalFilename          = "ORIGINALFILENAME"
+	OriginalLyricist          = "ORIGINALLYRICIST"
+	Owner                     = "OWNER"
+	PaymentWebpage            = "PAYMENTWEBPAGE"
+	Performer                 = "PERFORMER"
+	PlaylistDelay             = "PLAYLISTDELAY"
+	Podcast                   = "PODCAST"
+	PodcastCategory           = "PODCASTCATEGORY"
+	PodcastDesc               = "PODCASTDESC"
+	PodcastID                 = "PODCASTID"
+	PodcastURL                = "PODCASTURL"
+	ProducedNotice            = "PRODUCEDNOTICE"
+	Producer                  = "PRODUCER"
+	PublisherWebpage          = "PUBLISHERWEBPAGE"
+	RadioStation              = "RADIOSTATION"
+	RadioStationOwner         = "RADIOSTATIONOWNER"
+	RadioStationWebpage       = "RADIOSTATIONWEBPAGE"
+	ReleaseCountry            = "RELEASECOUNTRY"
+	ReleaseDate               = "RELEASEDATE"
+	ReleaseStatus             = "RELEASESTATUS"
+	ReleaseType               = "RELEASETYPE"
+	Remixer                   = "REMIXER"
+	Script                    = "SCRIPT"
+	ShowSort                  = "SHOWSORT"
+	ShowWorkMovement          = "SHOWWORKMOVEMENT"
+	Subtitle                  = "SUBTITLE"
+	TaggingDate               = "TAGGINGDATE"
+	Title                     = "TITLE"
+	TitleSort                 = "TITLESORT"
+	TrackNumber               = "TRACKNUMBER"
+	TVEpisode                 = "TVEPISODE"
+	TVEpisodeID               = "TVEPISODEID"
+	TVNetwork                 = "TVNETWORK"
+	TVSeason                  = "TVSEASON"
+	TVShow                    = "TVSHOW"
+	URL                       = "URL"
+	Work                      = "WORK"
+)
+
+// normalizedKeys are all the normalized tag keys declared above, in order.
+var normalizedKeys = [...]string{
+	AcoustIDFingerprint,
+	AcoustIDID,
+	Album,
+	AlbumArtist,
+	AlbumArtistSort,
+	AlbumSort,
+	Arranger,
+	Artist,
+	Artists,
+	ArtistSort,
+	ArtistWebpage,
+	ASIN,
+	AudioSourceWebpage,
+	Barcode,
+	BPM,
+	CatalogNumber,
+	Comment,
+	Compilation,
+	Composer,
+	ComposerSort,
+	Conductor,
+	Copyright,
+	CopyrightURL,
+	Date,
+	DiscNumber,
+	DiscSubtitle,
+	DJMixer,
+	EncodedBy,
+	Encoding,
+	EncodingTime,
+	Engineer,
+	FileType,
+	FileWebpage,
+	GaplessPlayback,
+	Genre,
+	Grouping,
+	InitialKey,
+	InvolvedPeople,
+	ISRC,
+	Label,
+	Language,
+	Length,
+	License,
+	Lyricist,
+	Lyrics,
+	Media,
+	Mixer,
+	Mood,
+	MovementCount,
+	MovementName,
+	MovementNumber,
+	MusicBrainzAlbumID,
+	MusicBrainzAlbumArtistID,
+	MusicBrainzArtistID,
+	MusicBrainzReleaseGroupID,
+	MusicBrainzReleaseTrackID,
+	MusicBrainzTrackID,
+	MusicBrainzWorkID,
+	MusicianCredits,
+	MusicIPPUID,
+	OriginalAlbum,
+	OriginalArtist,
+	OriginalDate,
+	OriginalFilename,
+	OriginalLyricist,
+	Owner,
+	PaymentWebpage,
+	Performer,
+	PlaylistDelay,
+	Podcast,
+	PodcastCategory,
+	PodcastDesc,
+	PodcastID,
+	PodcastURL,
+	ProducedNotice,
+	Producer,
+	PublisherWebpage,
+	RadioStation,
+	RadioStationOwner,
+	RadioStationWebpage,
+	ReleaseCountry,
+	ReleaseDate,
+	ReleaseStatus,
+	ReleaseType,
+	Remixer,
+	Script,
+	ShowSort,
+	ShowWorkMovement,
+	Subtitle,
+	TaggingDate,
+	Title,
+	TitleSort,
+	TrackNumber,
+	TVEpisode,
+	TVEpisodeID,
+	TVNetwork,
+	TVSeason,
+	TVShow,
+	URL,
+	Work,
+}
+
+// asfUnsupportedKeys are the normalized keys that TagLib has no ASF attribute for, and drops when writing ASF files.
+var asfUnsupportedKeys = map[string]bool{
+	Arranger:            true,
+	AudioSourceWebpage:  true,
+	Compilation:         true,
+	ComposerSort:        true,
+	CopyrightURL:        true,
+	DJMixer:             true,
+	Engineer:            true,
+	FileType:            true,
+	GaplessPlayback:     true,
+	Grouping:            true,
+	InvolvedPeople:      true,
+	Length:              true,
+	License:             true,
+	Mixer:               true,
+	MovementCount:       true,
+	MovementName:        true,
+	MovementNumber:      true,
+	MusicianCredits:     true,
+	Owner:               true,
+	PaymentWebpage:      true,
+	Performer:           true,
+	PlaylistDelay:       true,
+	Podcast:             true,
+	PodcastCategory:     true,
+	PodcastDesc:         true,
+	PodcastID:           true,
+	PodcastURL:          true,
+	ProducedNotice:      true,
+	PublisherWebpage:    true,
+	RadioStation:        true,
+	RadioStationOwner:   true,
+	RadioStationWebpage: true,
+	ReleaseDate:         true,
+	ShowSort:            true,
+	ShowWorkMovement:    true,
+	TaggingDate:         true,
+	TVEpisode:           true,
+	TVEpisodeID:         true,
+	TVNetwork:           true,
+	TVSeason:            true,
+	TVShow:              true,
+	URL:                 true,
+}
+
+// SupportedKeys returns the normalized keys declared by this package, such as [Album], that files of format can
+// store and read back, for example to only offer the fields of a tag editor that a file can keep. Most formats store
+// any key, using free-form tags such as ID3v2 TXXX frames for keys without a dedicated field. ASF only stores the
+// keys it has attributes for, and ID3v2 can't store a value for [Podcast], which is a flag frame.
+// Returns nil for [FormatShorten], which TagLib can't write tags to, and [FormatUnknown].
+func SupportedKeys(format FileFormat) []string {
+	var keys []string
+	for _, key := range normalizedKeys {
+		if keySupported(format, key) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// keySupported reports whether files of format can store and read back the tag key, as described by
+// [SupportedKeys]. ASF doesn't store any keys other than the normalized ones it has attributes for.
+func keySupported(format FileFormat, key string) bool {
+	switch {
+	case format == FormatUnknown || format == FormatShorten:
+		return false
+	case format == FormatASF:
+		return !asfUnsupportedKeys[key] && slices.Contains(normalizedKeys[:], key)
+	case hasID3v2Tags(format):
+		return key != Podcast
+	}
+	return true
+}
+
+// ReadTags reads all metadata tags from an audio file at the given path. A file that parses but carries no tags
+// yields an empty, non-nil map and a nil error, while a file that can't be parsed as audio yields [ErrInvalidFile].
+func ReadTags(path string) (map[string][]string, error) {
+	var err error
+	path, err = filepath.Abs(path)
+	if err != nil {
+		return nil, fmt.Errorf("make path abs %w", err)
+	}
+
+	mod, err := newModuleRO(path)
+	if err != nil {
+		return nil, fmt.Errorf("init module: %w", err)
+	}
+	defer mod.close()
+
+	// Opening a handle rather than reading the tags by path gives the format, so that only MP4 files are parsed
+	// again for their genres
+	var result wasmOpenResult
+	if err := mod.call("taglib_file_open", &result, wasmString(wasmPath(path)), wasmUint8(ReadStyleFast)); err != nil {
+		return nil, fmt.Errorf("call: %w", err)
+	}
+	if result.handle == 0 {
+		return nil, ErrInvalidFile
+	}
+	var raw wasmStrings
+	err = mod.call("taglib_handle_tags", &raw, wasmUint32(result.handle))
+	var out wasmBool
+	_ = mod.call("taglib_file_close", &out, wasmUint32(result.handle))
+	if err != nil {
+		return nil, fmt.Errorf("call: %w", err)
+	}
+	if raw == nil {
+		return nil, ErrInvalidFile
+	}
+
+	var tags = map[string][]string{}
+	for _, row := range raw {
+		k, v, ok := strings.Cut(row, "\t")
+		if !ok {
+			continue
+		}
+		tags[k] = append(tags[k], v)
+	}
+	if _, ok := tags[Genre]; ok && FileFormat(result.format) == FormatMP4 {
+		if file, err := os.Open(path); err == nil {
+			if genres, ok := mp4Genres(file); ok {
+				tags[Genre] = genres
+			}
+			file.Close()
+		}
+	}
+	return tags, nil
+}
+
+// TagSystem is a tag format that a file can carry alongside others, such as the ID3v2, APE, and ID3v1 tags of an MP3.
+type TagSystem string
+
+const (
+	TagSystemID3v2    TagSystem = "ID3v2"    // MP3, FLAC, WAV, AIFF, and TrueAudio
+	TagSystemID3v1    TagSystem = "ID3v1"    // MP3, FLAC, APE, WavPack, Musepack, and TrueAudio
+	TagSystemAPE      TagSystem = "APE"      // MP3, APE, WavPack, and Musepack
+	TagSystemXiph     TagSystem = "Xiph"     // FLAC and Ogg Vorbis comments
+	TagSystemRIFFInfo TagSystem = "RIFFInfo" // WAV INFO chunk
+	TagSystemMP4      TagSystem = "MP4"      // MP4 metadata, reported by [TagSizes]
+)
+
+// ReadTagsMerged reads the tags of each tag system of the file at path and merges them field by field, taking each
+// key from the first system in precedence that has it. For example, with [TagSystemID3v2] before [TagSystemID3v1],
+// the ID3v2 tag of an MP3 wins but a title only the ID3v1 tag has is kept. This is finer than [ReadTags], which
+// reads a single tag system, preferring them in a fixed order. Systems the file doesn't carry are skipped.
+func ReadTagsMerged(path string, precedence []TagSystem) (map[string][]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open file: %w", err)
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("stat: %w", err)
+	}
+
+	// TagLib reads the tags of a single system of each file, so the tags of the others are hidden from it by reading
+	// the file through a view with them edited out
+	fsys := &editFS{name: filepath.Base(path), r: file, size: info.Size()}
+	mod, err := newModuleEdit(fsys)
+	if err != nil {
+		return nil, fmt.Errorf("init module: %w", err)
+	}
+	defer mod.close()
+
+	format, err := readFormat(&mod, fsys.path())
+	if err != nil {
+		return nil, err
+	}
+	edits := tagSystemEdits(file, info.Size(), format)
+
+	var tags = map[string][]string{}
+	for _, system := range precedence {
+		if !slices.Contains(tagSystems[format], system) {
+			continue
+		}
+		fsys.edits = nil
+		for other, es := range edits {
+			if other != system {
+				fsys.edits = append(fsys.edits, es...)
+			}
+		}
+
+		var raw wasmStrings
+		if err := mod.call("taglib_file_tags", &raw, wasmString(fsys.path())); err != nil {
+			return nil, fmt.Errorf("call: %w", err)
+		}
+		if raw == nil {
+			return nil, ErrInvalidFile
+		}
+
+		systemTags := map[string][]string{}
+		for _, row := range raw {
+			k, v, ok := strings.Cut(row, "\t")
+			if !ok {
+				continue
+			}
+			systemTags[k] = append(systemTags[k], v)
+		}
+		for k, vs := range systemTags {
+			if _, ok := tags[k]; !ok {
+				tags[k] = vs
+			}
+		}
+	}
+	return tags, nil
+}
+
+// tagSystems are the tag systems TagLib reads for each format.
+var tagSystems = map[FileFormat][]TagSystem{
+	FormatMPEG:      {TagSystemID3v2, TagSystemAPE, TagSystemID3v1},
+	FormatFLAC:      {TagSystemXiph, TagSystemID3v2, TagSystemID3v1},
+	FormatWAV:       {TagSystemID3v2, TagSystemRIFFInfo},
+	FormatAIFF:      {TagSystemID3v2},
+	FormatAPE:       {TagSystemAPE, TagSystemID3v1},
+	FormatWavPack:   {TagSystemAPE, TagSystemID3v1},
+	FormatMPC:       {TagSystemAPE, TagSystemID3v1},
+	FormatTrueAudio: {TagSystemID3v2, TagSystemID3v1},
+	FormatOggVorbis: {TagSystemXiph},
+	FormatOggOpus:   {TagSystemXiph},
+	FormatOggFLAC:   {TagSystemXiph},
+	FormatOggSpeex:  {TagSystemXiph},
+}
+
+// tagEdit replaces the bytes of a file from start up to but not including end with data, cutting them if data is
+// empty.
+type tagEdit struct {
+	start, end int64
+	data       []byte
+}
+
+// tagSystemEdits returns the edits that hide each tag of the file of format in r from TagLib, by tag system. Tags at
+// the start and end of the file are cut, while FLAC Vorbis comment blocks are turned into padding and the tag chunks
+// of WAV files into JUNK chunks, so that the rest of the file keeps its layout.
+func tagSystemEdits(r io.ReaderAt, size int64, format FileFormat) map[TagSystem][]tagEdit {
+	edits := map[TagSystem][]tagEdit{}
+	switch format {
+	case FormatWAV:
+		riffTagEdits(r, 12, size, edits)
+		return edits
+	case FormatMPEG, FormatFLAC, FormatAPE, FormatWavPack, FormatMPC, FormatTrueAudio:
+	default:
+		return edits
+	}
+
+	for _, loc := range tagLocations(r, size) {
+		switch loc.Kind {
+		case TagID3v2:
+			edits[TagSystemID3v2] = append(edits[TagSystemID3v2], tagEdit{start: loc.Start, end: loc.End})
+		case TagID3v1:
+			edits[TagSystemID3v1] = append(edits[TagSystemID3v1], tagEdit{start: loc.Start, end: loc.End})
+		case TagAPE:
+			edits[TagSystemAPE] = append(edits[TagSystemAPE], tagEdit{start: loc.Start, end: loc.End})
+		}
+	}
+
+	if format == FormatFLAC {
+		const (
+			typePadding       = 1
+			typeVorbisComment = 4
+		)
+
+		offset := id3v2TagSize(r)
+		marker := make([]byte, 4)
+		if _, err := r.ReadAt(marker, offset); err != nil || string(marker) != "fLaC" {
+			return edits
+		}
+		header := make([]byte, 4)
+		for pos := offset + 4; ; {
+			if _, err := r.ReadAt(header, pos); err != nil {
+				break
+			}
+			if header[0]&0x7F == typeVorbisComment {
+				// Keep the last block flag
+				padding := []byte{header[0]&0x80 | typePadding}
+				edits[TagSystemXiph] = append(edits[TagSystemXiph], tagEdit{start: pos, end: pos + 1, data: padding})
+			}
+			if header[0]&0x80 != 0 {
+				break
+			}
+			pos += 4 + (int64(header[1])<<16 | int64(header[2])<<8 | int64(header[3]))
+		}
+	}
+	return edits
+}
+
+// riffTagEdits adds the edits that turn the ID3v2 chunks and INFO list chunk of the WAV file with its chunks from pos
+// to end into JUNK chunks to edits.
+func riffTagEdits(r io.ReaderAt, pos, end int64, edits map[TagSystem][]tagEdit) {
+	header := make([]byte, 12)
+	for pos+8 <= end {
+		if _, err := r.ReadAt(header[:8], pos); err != nil {
+			return
+		}
+		junk := tagEdit{start: pos, end: pos + 4, data: []byte("JUNK")}
+		switch string(header[:4]) {
+		case "id3 ", "ID3 ":
+			edits[TagSystemID3v2] = append(edits[TagSystemID3v2], junk)
+		case "LIST":
+			if _, err := r.ReadAt(header[8:], pos+8); err == nil && string(header[8:]) == "INFO" {
+				edits[TagSystemRIFFInfo] = append(edits[TagSystemRIFFInfo], junk)
+			}
+		}
+		// Chunks are padded to an even size
+		n := int64(le32(header[4:8]))
+		pos += 8 + n + n&1
+	}
+}
+
+// searchableKeys are the tag keys that hold human-readable text worth indexing for search, rather than identifiers,
+// numbers, dates, or URLs.
+var searchableKeys = []string{
+	Title, Subtitle, Artist, Artists, AlbumArtist, Album, DiscSubtitle, Composer, Conductor, Lyricist, Arranger,
+	Remixer, Producer, Genre, Mood, Grouping, Work, MovementName, Label, Comment, OriginalAlbum, OriginalArtist,
+	TVShow, PodcastDesc,
+}
+
+// SearchableText returns the human-readable text of the tags of the file at path for full-text search indexing, by
+// tag key, such as the title, artists, album, genres, and comment, along with performer credits such as
+// "PERFORMER:GUITAR". Identifiers such as MusicBrainz IDs, numbers, dates, URLs, and binary data are left out.
+// Whitespace is collapsed and trimmed, and the values of keys with several are joined by newlines. Lyrics aren't
+// included. Use [SearchableTextWithLyrics] to include them as well.
+func SearchableText(path string) (map[string]string, error) {
+	return searchableText(path, false)
+}
+
+// SearchableTextWithLyrics returns the human-readable text of the tags of the file at path like [SearchableText],
+// including the lyrics under the [Lyrics] key.
+func SearchableTextWithLyrics(path string) (map[string]string, error) {
+	return searchableText(path, true)
+}
+
+func searchableText(path string, lyrics bool) (map[string]string, error) {
+	tags, err := ReadTags(path)
+	if err != nil {
+		return nil, err
+	}
+
+	text := map[string]string{}
+	for key, vs := range tags {
+		searchable := slices.Contains(searchableKeys, key) || strings.HasPrefix(key, Performer+":") ||
+			(lyrics && key == Lyrics)
+		if !searchable {
+			continue
+		}
+		var values []string
+		for _, v := range vs {
+			v = strings.Join(strings.Fields(v), " ")
+			if v != "" && !isUUID(v) && !slices.Contains(values, v) {
+				values = append(values, v)
+			}
+		}
+		if len(values) > 0 {
+			text[key] = strings.Join(values, "\n")
+		}
+	}
+	return text, nil
+}
+
+// isUUID reports whether s is a UUID such as "f4a31f0a-51dd-4fa7-986d-3095c40c5ed9", which some taggers write to
+// text fields.
+func isUUID(s string) bool {
+	if len(s) != 36 {
+		return false
+	}
+	for i, c := range s {
+		switch i {
+		case 8, 13, 18, 23:
+			if c != '-' {
+				return false
+			}
+		default:
+			if !strings.ContainsRune("0123456789abcdefABCDEF", c) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// DetectTagCharset guesses the character set the tag text of the file at path was originally encoded in.
+// Text in legacy tags is often really Windows-1251 or UTF-8, which shows up as mojibake. Only text stored without
+// a Unicode encoding is inspected: ID3v2 frames declared as Latin-1, ID3v1 tags, and Vorbis comments that aren't
+// valid UTF-8 as they should be. Text declared as UTF-8 or UTF-16 is taken at its word.
+// Returns "UTF-8", "windows-1252", or "windows-1251" along with a confidence between 0 and 1.
+// Files without any such non-ASCII text are reported as "UTF-8" with full confidence.
+func DetectTagCharset(path string) (charset string, confidence float64, err error) {
+	format, err := DetectFormat(path)
+	if err != nil {
+		return "", 0, err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return "", 0, err
+	}
+
+	charset, confidence = detectCharset(legacyTagText(file, info.Size(), format))
+	return charset, confidence, nil
+}
+
+// legacyTagText returns the raw bytes of the tag text of the file of format without a Unicode encoding, one
+// value per line. See [DetectTagCharset].
+func legacyTagText(r io.ReaderAt, size int64, format FileFormat) []byte {
+	var text []byte
+	add := func(b []byte) {
+		text = append(text, bytes.ReplaceAll(b, []byte{0}, []byte{'\n'})...)
+		text = append(text, '\n')
+	}
+
+	id3, _ := readID3v2Tag(r)
+	for _, frame := range id3v2Frames(id3) {
+		if !isID3v2TextFrame(frame) || frame.body[0] != 0 {
+			continue
+		}
+		body := frame.body[1:]
+		if frame.id == "COMM" || frame.id == "USLT" {
+			// The language follows the encoding
+			if len(body) < 3 {
+				continue
+			}
+			body = body[3:]
+		}
+		add(body)
+	}
+
+	if size >= 128 && tagID3v1At(r, size-128) {
+		// The title, artist, album, year, and comment, leaving out the ID3v1.1 track and the genre
+		v1 := make([]byte, 122)
+		if _, err := r.ReadAt(v1, size-125); err == nil {
+			add(v1)
+		}
+	}
+
+	for _, field := range vorbisCommentFields(vorbisCommentBlock(r, int64(len(id3)), format)) {
+		if _, value, _ := bytes.Cut(field, []byte("=")); !utf8.Valid(value) {
+			add(value)
+		}
+	}
+	return text
+}
+
+// detectCharset guesses the original encoding of raw, text stored as bytes without a declared encoding.
+func detectCharset(raw []byte) (string, float64) {
+	var high, upper, letters int
+	for line := range bytes.Lines(raw) {
+		// ASCII-only values say nothing about the encoding, and would drown out the letters of the others
+		if !slices.ContainsFunc(line, func(b byte) bool { return b >= 0x80 }) {
+			continue
+		}
+		for _, b := range line {
+			switch {
+			case b >= 0xC0:
+				upper++
+				letters++
+				high++
+			case b >= 0x80:
+				high++
+			case 'a' <= b && b <= 'z', 'A' <= b && b <= 'Z':
+				letters++
+			}
+		}
+	}
+	if high == 0 {
+		return "UTF-8", 1
+	}
+
+	// Legacy encodings very rarely form valid multi-byte UTF-8 sequences by chance
+	if utf8.Valid(raw) {
+		return "UTF-8", 0.95
+	}
+
+	// Windows-1251 puts the Cyrillic alphabet in 0xC0-0xFF so it dominates the letters of Cyrillic text,
+	// whereas Windows-1252 only uses that range for the occasional accented Latin letter
+	ratio := float64(upper) / float64(letters)
+	if ratio >= 0.5 {
+		return "windows-1251", ratio
+	}
+	return "windows-1252", 1 - ratio
+}
+
+// ReadID3v2Frames reads all ID3v2 frames from an audio file at the given path.
+// Supported formats: MP3, WAV, and AIFF.
+// This provides direct access to the raw ID3v2 frames, including custom frames like TXXX.
+// The returned map has frame IDs as keys (like "TIT2", "TPE1", "TXXX") and frame data as values.
+// For TXXX frames, the description is included in the key as "TXXX:description".
+func ReadID3v2Frames(path string) (map[string][]string, error) {
+	var err error
+	path, err = filepath.Abs(path)
+	if err != nil {
+		return nil, fmt.Errorf("make path abs %w", err)
+	}
+
+	mod, err := newModuleRO(path)
+	if err != nil {
+		return nil, fmt.Errorf("init module: %w", err)
+	}
+	defer mod.close()
+
+	var raw wasmStrings
+	if err := mod.call("taglib_file_id3v2_frames", &raw, wasmString(wasmPath(path))); err != nil {
+		return nil, fmt.Errorf("call: %w", err)
+	}
+	if raw == nil {
+		return nil, ErrInvalidFile
+	}
+
+	// If raw is empty, the file has no ID3v2 frames
+	var frames = map[string][]string{}
+	for _, row := range raw {
+		parts := strings.SplitN(row, "\t", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		frames[parts[0]] = append(frames[parts[0]], parts[1])
+	}
+	return frames, nil
+}
+
+// Dates holds the individual ID3v2.4 timestamp frames, which the normalised DATE tag collapses into one value.
+// Each field holds the frame's ISO 8601 timestamp (e.g. "2004", "2004-05-06", or "2004-05-06T12:30"),
+// or an empty string if the frame is not present.
+type Dates struct {
+	RecordingTime       string // TDRC
+	ReleaseTime         string // TDRL
+	OriginalReleaseTime string // TDOR
+	TaggingTime         string // TDTG
+}
+
+// ReadDates reads the recording, release, original release, and tagging times from the ID3v2 tag of the file at path.
+// Supported formats: MP3, WAV, and AIFF. ID3v2.3 year and date frames are upgraded to their ID3v2.4 equivalents by TagLib.
+func ReadDates(path string) (Dates, error) {
+	frames, err := ReadID3v2Frames(path)
+	if err != nil {
+		return Dates{}, err
+	}
+
+	return Dates{
+		RecordingTime:       firstTag(frames, "TDRC"),
+		ReleaseTime:         firstTag(frames, "TDRL"),
+		OriginalReleaseTime: firstTag(frames, "TDOR"),
+		TaggingTime:         firstTag(frames, "TDTG"),
+	}, nil
+}
+
+// WriteDates writes the recording, release, original release, and tagging times to the ID3v2 tag of the MP3 file at path.
+// Empty fields remove the corresponding frame. Other frames are left untouched.
+func WriteDates(path string, dates Dates) error {
+	return WriteID3v2Frames(path, map[string][]string{
+		"TDRC": {dates.RecordingTime},
+		"TDRL": {dates.ReleaseTime},
+		"TDOR": {dates.OriginalReleaseTime},
+		"TDTG": {dates.TaggingTime},
+	}, 0)
+}
+
+// ExportFormat is the text format used by [ExportMetadata] and [ImportMetadata].
+type ExportFormat uint8
+
+const (
+	// ExportVorbisComment is a KEY=value line per tag value, like metaflac --export-tags-to. Backslashes and line
+	// breaks in values are escaped as \\, \n, and \r. Raw tags and image descriptions follow as comment lines
+	// starting with "#", which are ignored on import.
+	ExportVorbisComment ExportFormat = iota
+	// ExportJSON is a JSON object with "format", "tags", "raw", and "images" fields.
+	ExportJSON
+)
+
+type exportedMetadata struct {
+	Format string              `json:"format"`
+	Tags   map[string][]string `json:"tags"`
+	Raw    map[string][]string `json:"raw"`
+	Images []exportedImage     `json:"images"`
+}
+
+type exportedImage struct {
+	Type        string `json:"type"`
+	Description string `json:"description"`
+	MIMEType    string `json:"mimeType"`
+}
+
+var (
+	exportEscaper   = strings.NewReplacer("\\", `\\`, "\n", `\n`, "\r", `\r`)
+	exportUnescaper = strings.NewReplacer(`\\`, "\\", `\n`, "\n", `\r`, "\r")
+)
+
+// ExportMetadata writes the metadata of the file at path to w in format, for backing it up or moving it between
+// files of any format. This includes the normalized tags, the raw tags, and the descriptions of embedded images,
+// but not the image data.
+func ExportMetadata(path string, w io.Writer, format ExportFormat) error {
+	f, err := OpenReadOnly(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	tags, err := f.readTags()
+	if err != nil {
+		return err
+	}
+	raw, err := f.readRawTags()
+	if err != nil {
+		return err
+	}
+	m := exportedMetadata{Format: f.format.String(), Tags: tags, Raw: raw, Images: []exportedImage{}}
+	for _, img := range f.Properties().Images {
+		m.Images = append(m.Images, exportedImage(img))
+	}
+
+	switch format {
+	case ExportJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(m)
+	case ExportVorbisComment:
+		var b strings.Builder
+		writeComments := func(prefix string, tags map[string][]string) {
+			for _, k := range slices.Sorted(maps.Keys(tags)) {
+				for _, v := range tags[k] {
+					fmt.Fprintf(&b, "%s%s=%s\n", prefix, k, exportEscaper.Replace(v))
+				}
+			}
+		}
+		writeComments("", m.Tags)
+		fmt.Fprintf(&b, "# format %s\n", m.Format)
+		writeComments("# raw ", m.Raw)
+		for _, img := range m.Images {
+			fmt.Fprintf(&b, "# image %s\t%s\t%s\n", img.Type, exportEscaper.Replace(img.Description), img.MIMEType)
+		}
+		_, err := io.WriteString(w, b.String())
+		return err
+	}
+	return fmt.Errorf("unknown export format %d", format)
+}
+
+// ImportMetadata replaces the tags of the file at path with the normalized tags read from r in format, as written
+// by [ExportMetadata]. Raw tags and image descriptions are only informational, and aren't applied.
+func ImportMetadata(path string, r io.Reader, format ExportFormat) error {
+	tags := map[string][]string{}
+	switch format {
+	case ExportJSON:
+		var m exportedMetadata
+		if err := json.NewDecoder(r).Decode(&m); err != nil {
+			return fmt.Errorf("decode json: %w", err)
+		}
+		tags = m.Tags
+	case ExportVorbisComment:
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return fmt.Errorf("read: %w", err)
+		}
+		for line := range strings.Lines(string(data)) {
+			line = strings.TrimRight(line, "\r\n")
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			k, v, ok := strings.Cut(line, "=")
+			if !ok {
+				return fmt.Errorf("invalid line %q", line)
+			}
+			k = strings.ToUpper(k)
+			tags[k] = append(tags[k], exportUnescaper.Replace(v))
+		}
+	default:
+		return fmt.Errorf("unknown export format %d", format)
+	}
+	return WriteTags(path, tags, Clear)
+}
+
+// Credit is a person credited with a role on a recording, such as a guitarist or the producer.
+type Credit struct {
+	Role string // lowercase, like "guitar" or "producer". Empty if the role isn't known.
+	Name string
+}
+
+// involvedPeopleRoles are the roles of the ID3v2 TIPL frame, which TagLib maps to their own keys in all formats.
+var involvedPeopleRoles = []string{Arranger, DJMixer, Engineer, Mixer, Producer}
+
+// ReadCredits reads the role and name pairs of the musician and involved people credits from the file at path.
+// Performers are read from PERFORMER:ROLE keys, which TagLib maps from the ID3v2 TMCL frame, as well as
+// "name (role)" values of PERFORMER, the convention of Vorbis comments. Involved people, from the ID3v2 TIPL frame,
+// are read from the ARRANGER, DJMIXER, ENGINEER, MIXER, and PRODUCER keys.
+func ReadCredits(path string) ([]Credit, error) {
+	tags, err := ReadTags(path)
+	if err != nil {
+		return nil, err
+	}
+	return readCredits(tags), nil
+}
+
+func readCredits(tags map[string][]string) []Credit {
+	var credits []Credit
+	for _, key := range slices.Sorted(maps.Keys(tags)) {
+		role, ok := strings.CutPrefix(key, Performer+":")
+		if ok || slices.Contains(involvedPeopleRoles, key) {
+			if !ok {
+				role = key
+			}
+			for _, name := range tags[key] {
+				credits = append(credits, Credit{Role: strings.ToLower(role), Name: name})
+			}
+			continue
+		}
+		if key == Performer {
+			for _, v := range tags[key] {
+				credits = append(credits, parseCredit(v))
+			}
+		}
+	}
+	return credits
+}
+
+// parseCredit parses a "name (role)" credit.
+func parseCredit(v string) Credit {
+	if strings.HasSuffix(v, ")") {
+		if i := strings.LastIndex(v, " ("); i > 0 {
+			return Credit{Role: strings.ToLower(v[i+2 : len(v)-1]), Name: v[:i]}
+		}
+	}
+	return Credit{Name: v}
+}
+
+// WriteCredits replaces the musician and involved people credits of the file at path, as read by [ReadCredits].
+// Involved people roles are written to their own keys. Other roles are written to PERFORMER:ROLE keys, and to
+// PERFORMER as "name (role)" for FLAC, Ogg, and MP4 files, whose tags conventionally have no roles in keys.
+// Credits without a role are written to PERFORMER.
+func WriteCredits(path string, credits []Credit) error {
+	f, err := Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	current, err := f.readTags()
+	if err != nil {
+		return err
+	}
+
+	// Remove all the current credits, unless they're replaced
+	tags := map[string][]string{}
+	for key := range current {
+		if key == Performer || strings.HasPrefix(key, Performer+":") || slices.Contains(involvedPeopleRoles, key) {
+			tags[key] = nil
+		}
+	}
+
+	inValue := f.format == FormatFLAC || f.format == FormatMP4 || f.format.IsOgg()
+	for _, c := range credits {
+		role := strings.ToUpper(c.Role)
+		switch {
+		case slices.Contains(involvedPeopleRoles, role):
+			tags[role] = append(tags[role], c.Name)
+		case role == "":
+			tags[Performer] = append(tags[Performer], c.Name)
+		case inValue:
+			tags[Performer] = append(tags[Performer], fmt.Sprintf("%s (%s)", c.Name, strings.ToLower(c.Role)))
+		default:
+			tags[Performer+":"+role] = append(tags[Performer+":"+role], c.Name)
+		}
+	}
+	return f.WriteTags(tags, 0)
+}
+
+// RenameFromTemplate renames the file at path based on its tags, returning the new path.
+// Fields in template are written as {name}, where name is a tag key such as {title} or {albumartist}, matched
+// case-insensitively. {track} and {disc} give the number without any total, {year} the first four characters of
+// the date, and {ext} the file's extension without the dot. A width pads numeric values with zeros, as in {track:2}.
+// Missing fields expand to an empty string, and multiple values are joined with ", ".
+//
+// The template is relative to the file's directory, may include "/" to move the file into subdirectories
+// (which are created), and should include the extension, for example "{disc}-{track:2} {title}.{ext}".
+// Path separators and characters not allowed in file names on the current OS are replaced with "_" in field values.
+// If the new path is already taken by another file, a counter is added before the extension, as in "Title (1).flac".
+func RenameFromTemplate(path string, template string) (newPath string, err error) {
+	tags, err := ReadTags(path)
+	if err != nil {
+		return "", err
+	}
+
+	name, err := expandTemplate(template, tags, strings.TrimPrefix(filepath.Ext(path), "."))
+	if err != nil {
+		return "", err
+	}
+	path = filepath.Clean(path)
+	newPath = filepath.Join(filepath.Dir(path), filepath.FromSlash(name))
+	if newPath == path {
+		return path, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(newPath), 0o755); err != nil {
+		return "", fmt.Errorf("create dir: %w", err)
+	}
+	newPath, err = availablePath(newPath)
+	if err != nil {
+		return "", err
+	}
+	if err := os.Rename(path, newPath); err != nil {
+		return "", fmt.Errorf("rename: %w", err)
+	}
+	return newPath, nil
+}
+
+// expandTemplate expands the fields of a [RenameFromTemplate] template.
+func expandTemplate(template string, tags map[string][]string, ext string) (string, error) {
+	var out strings.Builder
+	rest := template
+	for {
+		start := strings.IndexByte(rest, '{')
+		if start < 0 {
+			out.WriteString(rest)
+			break
+		}
+		end := strings.IndexByte(rest[start:], '}')
+		if end < 0 {
+			return "", fmt.Errorf("unclosed field in template at %q", rest[start:])
+		}
+		out.WriteString(rest[:start])
+
+		field, width, _ := strings.Cut(rest[start+1:start+end], ":")
+		value := templateField(strings.ToLower(field), tags, ext)
+		if width != "" {
+			n, err := strconv.Atoi(width)
+			if err != nil {
+				return "", fmt.Errorf("invalid width in template field %q", rest[start:start+end+1])
+			}
+			if _, err := strconv.Atoi(value); err == nil && len(value) < n {
+				value = strings.Repeat("0", n-len(value)) + value
+			}
+		}
+		out.WriteString(sanitizeFileName(value))
+		rest = rest[start+end+1:]
+	}
+
+	name := out.String()
+	for part := range strings.SplitSeq(name, "/") {
+		if strings.TrimSpace(part) == "" {
+			return "", fmt.Errorf("template %q expands to an empty path element", template)
+		}
+	}
+	return name, nil
+}
+
+func templateField(field string, tags map[string][]string, ext string) string {
+	switch field {
+	case "ext":
+		return ext
+	case "track":
+		n, _, _ := strings.Cut(firstTag(tags, TrackNumber), "/")
+		return n
+	case "disc":
+		n, _, _ := strings.Cut(firstTag(tags, DiscNumber), "/")
+		return n
+	case "year":
+		date := firstTag(tags, Date)
+		return date[:min(4, len(date))]
+	}
+	return strings.Join(tags[strings.ToUpper(field)], ", ")
+}
+
+// TagValue reads the first value of the normalized tag key from the file at path, cleaned up for use in a file
+// name: surrounding whitespace is trimmed, and path separators and characters not allowed in file names on the
+// current OS are replaced with "_". key is matched case-insensitively. Returns an empty string if the tag is missing.
+func TagValue(path string, key string) (string, error) {
+	tags, err := ReadTags(path)
+	if err != nil {
+		return "", err
+	}
+	vs := tags[strings.ToUpper(key)]
+	if len(vs) == 0 {
+		return "", nil
+	}
+	return sanitizeFileName(strings.TrimSpace(vs[0])), nil
+}
+
+// TemplateFields reads the tags of the file at path as template fields, keyed by lowercase names like "title" or
+// "albumartist". Each field holds the first value of the tag, cleaned up like [TagValue]. As with [RenameFromTemplate],
+// "track" and "disc" hold the number without any total, "year" the first four characters of the date, and "ext" the
+// file's extension without the dot. Missing tags have no field.
+func TemplateFields(path string) (map[string]string, error) {
+	tags, err := ReadTags(path)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := map[string]string{}
+	for k, vs := range tags {
+		if len(vs) > 0 {
+			fields[strings.ToLower(k)] = sanitizeFileName(strings.TrimSpace(vs[0]))
+		}
+	}
+	ext := strings.TrimPrefix(filepath.Ext(path), ".")
+	for _, name := range []string{"track", "disc", "year", "ext"} {
+		if v := strings.TrimSpace(templateField(name, tags, ext)); v != "" {
+			fields[name] = sanitizeFileName(v)
+		}
+	}
+	return fields, nil
+}
+
+// sanitizeFileName makes a tag value safe to use in a file name, replacing path separators and characters
+// that aren't allowed on the current OS.
+func sanitizeFileName(s string) string {
+	s = strings.Map(func(r rune) rune {
+		switch {
+		case r == '/' || r == '\\' || r < 0x20 || r == 0x7F:
+			return '_'
+		case runtime.GOOS == "windows" && strings.ContainsRune(`<>:"|?*`, r):
+			return '_'
+		}
+		return r
+	}, s)
+	if strings.Trim(s, ".") == "" {
+		// Don't let values like ".." refer to other directories
+		s = strings.Repeat("_", len(s))
+	}
+	if runtime.GOOS == "windows" {
+		// Windows silently drops trailing dots and spaces
+		s = strings.TrimRight(s, ". ")
+	}
+	return s
+}
+
+// availablePath returns path, or if it already exists, path with the first free counter added before the extension.
+func availablePath(path string) (string, error) {
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(path, ext)
+	for i := 0; ; i++ {
+		candidate := path
+		if i > 0 {
+			candidate = fmt.Sprintf("%s (%d)%s", base, i, ext)
+		}
+		_, err := os.Lstat(candidate)
+		if errors.Is(err, os.ErrNotExist) {
+			return candidate, nil
+		}
+		if err != nil {
+			return "", fmt.Errorf("stat: %w", err)
+		}
+	}
+}
+
+// ReadUFID reads the ID3v2 unique file identifier (UFID) frames from the file at path, keyed by owner.
+// For example, MusicBrainz Picard stores the recording ID under the owner "http://musicbrainz.org".
+// Supported formats: MP3, WAV, and AIFF. Other formats have no identifiers.
+func ReadUFID(path string) (map[string][]byte, error) {
+	format, err := DetectFormat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open file: %w", err)
+	}
+	defer f.Close()
+
+	ids := map[string][]byte{}
+	for _, frame := range id3v2Frames(formatID3v2Tag(f, format)) {
+		if frame.id != "UFID" || frame.flags[1] != 0 {
+			continue
+		}
+		if owner, id, ok := parseUFID(frame.body); ok {
+			ids[owner] = id
+		}
+	}
+	return ids, nil
+}
+
+// WriteUFID writes ID3v2 unique file identifier (UFID) frames to the MP3 file at path, keyed by owner.
+// Existing frames for the same owners are replaced, and a nil or empty identifier removes the owner's frame.
+// Frames of other owners are left untouched. Owners must be ISO-8859-1, as the frame stores them.
+func WriteUFID(path string, ids map[string][]byte) error {
+	format, err := DetectFormat(path)
+	if err != nil {
+		return err
+	}
+	if format != FormatMPEG {
+		return fmt.Errorf("%w: %s", ErrUnsupportedFormat, format)
+	}
+
+	// Owners are unique, so any existing frame is replaced
+	var add []id3v2Frame
+	for _, owner := range slices.Sorted(maps.Keys(ids)) {
+		if len(ids[owner]) == 0 {
+			continue
+		}
+		body, ok := encodeID3v2Strings(0, []string{owner})
+		if !ok {
+			return fmt.Errorf("%w: owner %q isn't ISO-8859-1", ErrSavingFile, owner)
+		}
+		body = append(body, 0)
+		add = append(add, id3v2Frame{id: "UFID", body: append(body, ids[owner]...)})
+	}
+	_, err = editID3v2Frames(path, func(frame id3v2Frame) bool {
+		if frame.id != "UFID" || frame.flags[1] != 0 {
+			return false
+		}
+		owner, _, ok := parseUFID(frame.body)
+		_, replaced := ids[owner]
+		return ok && replaced
+	}, add)
+	return err
+}
+
+// parseUFID parses the body of a UFID frame: the ISO-8859-1 owner terminated by a null byte, and the identifier.
+func parseUFID(body []byte) (owner string, id []byte, ok bool) {
+	b, id, ok := bytes.Cut(body, []byte{0})
+	if !ok {
+		return "", nil, false
+	}
+	values, _ := decodeID3v2Strings(0, b)
+	return values[0], bytes.Clone(id), true
+}
+
+// Ownership is the purchase information of an ID3v2 ownership (OWNE) frame, as stored by music stores.
+// It is separate from [Owner], the name of the owner or licensee of the file.
+type Ownership struct {
+	// PricePaid is the currency code followed by the price, such as "USD9.99"
+	PricePaid string
+	// DatePurchased is the date of purchase as YYYYMMDD, such as "20240131", or empty if unknown
+	DatePurchased string
+	// Seller is the name of the seller
+	Seller string
+}
+
+// ReadOwnership reads the ID3v2 ownership (OWNE) frame of the MP3 file at path.
+// Returns [ErrNoOwnership] if the file has none.
+func ReadOwnership(path string) (Ownership, error) {
+	format, err := DetectFormat(path)
+	if err != nil {
+		return Ownership{}, err
+	}
+	if format != FormatMPEG {
+		return Ownership{}, fmt.Errorf("%w: %s", ErrUnsupportedFormat, format)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return Ownership{}, fmt.Errorf("open file: %w", err)
+	}
+	defer f.Close()
+	tag, err := readID3v2Tag(f)
+	if err != nil {
+		return Ownership{}, err
+	}
+
+	for _, frame := range id3v2Frames(tag) {
+		if frame.id == "OWNE" && frame.flags[1] == 0 && len(frame.body) > 0 {
+			return parseOwnership(frame.body), nil
+		}
+	}
+	return Ownership{}, ErrNoOwnership
+}
+
+// WriteOwnership writes the ID3v2 ownership (OWNE) frame of the MP3 file at path, replacing any existing one.
+// A zero Ownership removes the ownership frame and any commercial (COMR) frames, clearing the purchase information
+// of the file. Other frames are left untouched.
+func WriteOwnership(path string, o Ownership) error {
+	format, err := DetectFormat(path)
+	if err != nil {
+		return err
+	}
+	if format != FormatMPEG {
+		return fmt.Errorf("%w: %s", ErrUnsupportedFormat, format)
+	}
+
+	if o == (Ownership{}) {
+		return replaceID3v2Frames(path, []string{"OWNE", "COMR"}, nil)
+	}
+	return replaceID3v2Frames(path, []string{"OWNE"}, map[string][]byte{"OWNE": renderOwnership(o)})
+}
+
+// parseOwnership parses the body of an OWNE frame: the text encoding, the ISO-8859-1 price terminated by a null byte,
+// the 8 character date, and the seller. A date of all zeros, which is written for unknown dates, is read as empty.
+func parseOwnership(body []byte) Ownership {
+	latin1 := func(b []byte) string {
+		values, _ := decodeID3v2Strings(0, b)
+		return values[0]
+	}
+	enc, rest := body[0], body[1:]
+	price, rest, _ := bytes.Cut(rest, []byte{0})
+	date := rest[:min(8, len(rest))]
+
+	o := Ownership{PricePaid: latin1(price), DatePurchased: latin1(date)}
+	if o.DatePurchased == "00000000" {
+		o.DatePurchased = ""
+	}
+	if values, ok := decodeID3v2Strings(enc, rest[len(date):]); ok {
+		o.Seller = values[0]
+	}
+	return o
+}
+
+// renderOwnership renders o as the body of an OWNE frame. The seller is written in ISO-8859-1 if it fits, or UTF-16
+// otherwise. The price and date are always ISO-8859-1, so other characters are dropped from them.
+func renderOwnership(o Ownership) []byte {
+	latin1 := func(s string) []byte {
+		b, _ := encodeID3v2Strings(0, []string{strings.Map(func(r rune) rune {
+			if r > 0xFF {
+				return -1
+			}
+			return r
+		}, s)})
+		return b
+	}
+	date := append(latin1(o.DatePurchased), "00000000"...)[:8]
+
+	enc := byte(0)
+	seller, ok := encodeID3v2Strings(enc, []string{o.Seller})
+	if !ok {
+		enc = 1
+		seller, _ = encodeID3v2Strings(enc, []string{o.Seller})
+	}
+	body := append([]byte{enc}, latin1(o.PricePaid)...)
+	body = append(body, 0)
+	body = append(body, date...)
+	return append(body, seller...)
+}
+
+// ReadPlayCount reads the play count of the MP3 file at path from its ID3v2 play counter (PCNT) frame, falling back to
+// the highest counter of its popularimeter (POPM) frames, which players such as Windows Media Player keep alongside
+// the rating. Returns 0 if the file has neither.
+func ReadPlayCount(path string) (uint64, error) {
+	format, err := DetectFormat(path)
+	if err != nil {
+		return 0, err
+	}
+	if format != FormatMPEG {
+		return 0, fmt.Errorf("%w: %s", ErrUnsupportedFormat, format)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, fmt.Errorf("open file: %w", err)
+	}
+	defer f.Close()
+	tag, err := readID3v2Tag(f)
+	if err != nil {
+		return 0, err
+	}
+
+	var popm uint64
+	for _, frame := range id3v2Frames(tag) {
+		if frame.flags[1] != 0 {
+			continue
+		}
+		switch frame.id {
+		case "PCNT":
+			return id3v2Counter(frame.body), nil
+		case "POPM":
+			// After the null terminated email and the rating
+			if i := bytes.IndexByte(frame.body, 0); i >= 0 && i+2 <= len(frame.body) {
+				popm = max(popm, id3v2Counter(frame.body[i+2:]))
+			}
+		}
+	}
+	return popm, nil
+}
+
+// WritePlayCount writes count to the ID3v2 play counter (PCNT) frame of the MP3 file at path, replacing any existing
+// one. A count of 0 removes the frame. The counters of popularimeter (POPM) frames are left untouched.
+func WritePlayCount(path string, count uint64) error {
+	format, err := DetectFormat(path)
+	if err != nil {
+		return err
+	}
+	if format != FormatMPEG {
+		return fmt.Errorf("%w: %s", ErrUnsupportedFormat, format)
+	}
+
+	if count == 0 {
+		return replaceID3v2Frames(path, []string{"PCNT"}, nil)
+	}
+	return replaceID3v2Frames(path, []string{"PCNT"}, map[string][]byte{"PCNT": renderID3v2Counter(count)})
+}
+
+// id3v2Counter parses the big-endian counter of a PCNT or POPM frame, which is as long as it needs to be. Counters
+// that don't fit in 64 bits are read as the largest value that does.
+func id3v2Counter(b []byte) uint64 {
+	var n uint64
+	for _, c := range b {
+		if n > math.MaxUint64>>8 {
+			return math.MaxUint64
+		}
+		n = n<<8 | uint64(c)
+	}
+	return n
+}
+
+// renderID3v2Counter renders n as the counter of a PCNT frame, which is at least 4 bytes long.
+func renderID3v2Counter(n uint64) []byte {
+	b := make([]byte, 8)
+	for i := range b {
+		b[i] = byte(n >> (56 - 8*i))
+	}
+	for len(b) > 4 && b[0] == 0 {
+		b = b[1:]
+	}
+	return b
+}
+
+// Chapter is an ID3v2 chapter (CHAP frame), as used by podcasts and audiobooks.
+type Chapter struct {
+	// ID is the chapter's element ID
+	ID string
+	// Start and End are the time range of the chapter
+	Start, End time.Duration
+	// Title is the chapter's title, from its embedded TIT2 frame
+	Title string
+	// ImageMIME is the MIME type of the chapter's embedded image (APIC frame), or empty if it has none
+	ImageMIME string
+	// Image is the chapter's embedded image. Only populated by [ReadChaptersWithImages].
+	Image []byte
+}
+
+// ReadChapters reads the ID3v2 chapters from the file at path, in the order they are stored.
+// Chapter images aren't read, but [Chapter.ImageMIME] reports whether a chapter has one.
+// Use [ReadChaptersWithImages] to read them as well.
+// Supported formats: MP3, WAV, and AIFF.
+func ReadChapters(path string) ([]Chapter, error) {
+	return readChapters(path, false)
+}
+
+// ReadChaptersWithImages reads the ID3v2 chapters from the file at path like [ReadChapters], including the
+// image embedded in each chapter, as used by "enhanced" podcasts for artwork that changes with the chapters.
+func ReadChaptersWithImages(path string) ([]Chapter, error) {
+	return readChapters(path, true)
+}
+
+func readChapters(path string, withImages bool) ([]Chapter, error) {
+	format, err := DetectFormat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open file: %w", err)
+	}
+	defer f.Close()
+
+	chapters := []Chapter{}
+	tag := formatID3v2Tag(f, format)
+	for _, frame := range id3v2Frames(tag) {
+		if frame.id != "CHAP" || frame.flags[1] != 0 {
+			continue
+		}
+		if chapter, ok := parseChapter(frame.body, tag[3], withImages); ok {
+			chapters = append(chapters, chapter)
+		}
+	}
+	return chapters, nil
+}
+
+// parseChapter parses the body of an ID3v2 CHAP frame of a tag of version, with the title and image from its
+// embedded TIT2 and APIC frames.
+func parseChapter(body []byte, version byte, withImage bool) (Chapter, bool) {
+	// The element ID is followed by the start and end times in milliseconds, and the start and end byte offsets
+	id, rest, ok := bytes.Cut(body, []byte{0})
+	if !ok || len(rest) < 16 {
+		return Chapter{}, false
+	}
+	ids, _ := decodeID3v2Strings(0, id)
+	chapter := Chapter{
+		ID:    ids[0],
+		Start: time.Duration(be32(rest)) * time.Millisecond,
+		End:   time.Duration(be32(rest[4:])) * time.Millisecond,
+	}
+
+	var titled, pictured bool
+	for _, frame := range id3v2FrameList(rest[16:], 0, version) {
+		switch {
+		case frame.id == "TIT2" && !titled && isID3v2TextFrame(frame):
+			values, ok := decodeID3v2Strings(frame.body[0], frame.body[1:])
+			if ok {
+				chapter.Title = strings.Join(slices.DeleteFunc(values, func(v string) bool { return v == "" }), " ")
+			}
+			titled = true
+		case frame.id == "APIC" && !pictured && frame.flags[1] == 0:
+			mimeType, image, ok := id3v2Picture(frame.body)
+			if ok {
+				chapter.ImageMIME = mimeType
+				if withImage && len(image) > 0 {
+					chapter.Image = bytes.Clone(image)
+				}
+			}
+			pictured = true
+		}
+	}
+	return chapter, true
+}
+
+// id3v2Picture returns the MIME type and image data of the body of an ID3v2 APIC frame.
+func id3v2Picture(body []byte) (mimeType string, image []byte, ok bool) {
+	if len(body) < 1 {
+		return "", nil, false
+	}
+	enc := body[0]
+	mime, rest, ok := bytes.Cut(body[1:], []byte{0})
+	if !ok || len(rest) < 1 {
+		return "", nil, false
+	}
+	// The picture type is followed by the description, terminated in its text encoding
+	rest = rest[1:]
+	if enc == 0 || enc == 3 {
+		i := bytes.IndexByte(rest, 0)
+		if i < 0 {
+			return "", nil, false
+		}
+		return string(mime), rest[i+1:], true
+	}
+	for i := 0; i+1 < len(rest); i += 2 {
+		if rest[i] == 0 && rest[i+1] == 0 {
+			return string(mime), rest[i+2:], true
+		}
+	}
+	return "", nil, false
+}
+
+// formatID3v2Tag returns the ID3v2 tag of a file of format from r: the one at the start of MP3 files, or the one in
+// the ID3 chunk of WAV and AIFF files. Returns nil if there is none.
+func formatID3v2Tag(r io.ReaderAt, format FileFormat) []byte {
+	var size func([]byte) uint32
+	switch format {
+	case FormatMPEG:
+		tag, _ := readID3v2Tag(r)
+		return tag
+	case FormatWAV:
+		size = le32
+	case FormatAIFF:
+		size = be32
+	default:
+		return nil
+	}
+	for _, id := range []string{"ID3 ", "id3 "} {
+		if pos, err := chunkOffset(r, 12, id, size); err == nil {
+			tag, _ := readID3v2Tag(io.NewSectionReader(r, pos, math.MaxInt64-pos))
+			return tag
+		}
+	}
+	return nil
+}
+
+// GaplessInfo describes the encoder delay and padding added around the audio by lossy encoders,
+// which players need to trim to play consecutive tracks without gaps.
+type GaplessInfo struct {
+	// EncoderDelay is the number of priming samples at the start of the audio
+	EncoderDelay uint32
+	// Padding is the number of padding samples at the end of the audio
+	Padding uint32
+	// SampleCount is the number of samples of the original audio, without delay and padding
+	SampleCount uint64
+}
+
+// iTunSMPBAtom is the MP4 freeform atom iTunes stores gapless info in.
+const iTunSMPBAtom = "----:com.apple.iTunes:iTunSMPB"
+
+// ReadGaplessInfo reads the gapless playback info of the file at path.
+// For MP4 this is the iTunSMPB freeform atom. For MP3 it is the LAME tag of the first frame, with the sample count
+// derived from the frame count, falling back to an iTunSMPB comment frame.
+// Returns a zero GaplessInfo if the file has none.
+func ReadGaplessInfo(path string) (GaplessInfo, error) {
+	format, err := DetectFormat(path)
+	if err != nil {
+		return GaplessInfo{}, err
+	}
+
+	switch format {
+	case FormatMP4:
+		atoms, err := ReadMP4Atoms(path)
+		if err != nil {
+			return GaplessInfo{}, err
+		}
+		info, _ := parseITunSMPB(findFold(atoms, iTunSMPBAtom))
+		return info, nil
+	case FormatMPEG:
+		info, ok, err := readLAMEGaplessInfo(path)
+		if err != nil || ok {
+			return info, err
+		}
+		frames, err := ReadID3v2Frames(path)
+		if err != nil {
+			return GaplessInfo{}, err
+		}
+		info, _ = parseITunSMPB(findFold(frames, "COMM:iTunSMPB"))
+		return info, nil
+	default:
+		return GaplessInfo{}, nil
+	}
+}
+
+// WriteGaplessInfo writes the gapless playback info of the file at path.
+// For MP4 the iTunSMPB freeform atom is written, as used by Apple devices.
+// For MP3 the encoder delay and padding are written to the LAME tag of the first frame, which must already exist.
+// The sample count of MP3 files is derived from the frame count and can't be written, and the delay and padding are
+// limited to 4095 samples each.
+// Other formats return [ErrUnsupportedFormat].
+func WriteGaplessInfo(path string, info GaplessInfo) error {
+	format, err := DetectFormat(path)
+	if err != nil {
+		return err
+	}
+
+	switch format {
+	case FormatMP4:
+		atoms, err := ReadMP4Atoms(path)
+		if err != nil {
+			return err
+		}
+		write := map[string][]string{}
+		// Atom names are case sensitive, so remove existing atoms with other cases first
+		for name := range atoms {
+			if strings.EqualFold(name, iTunSMPBAtom) {
+				write[name] = nil
+			}
+		}
+		write[iTunSMPBAtom] = []string{formatITunSMPB(info)}
+		return writeMP4Atoms(path, write)
+	case FormatMPEG:
+		return writeLAMEGaplessInfo(path, info)
+	default:
+		return fmt.Errorf("%w: %s", ErrUnsupportedFormat, format)
+	}
+}
+
+// findFold returns the first value of key in m, matching the key case-insensitively.
+func findFold(m map[string][]string, key string) string {
+	for k, vs := range m {
+		if strings.EqualFold(k, key) && len(vs) > 0 {
+			return vs[0]
+		}
+	}
+	return ""
+}
+
+// parseITunSMPB parses an iTunSMPB value such as " 00000000 00000840 000001CC 0000000000046E00 ...",
+// where the second to fourth fields are the hex encoder delay, padding, and sample count.
+func parseITunSMPB(s string) (GaplessInfo, bool) {
+	fields := strings.Fields(s)
+	if len(fields) < 4 {
+		return GaplessInfo{}, false
+	}
+	delay, err1 := strconv.ParseUint(fields[1], 16, 32)
+	padding, err2 := strconv.ParseUint(fields[2], 16, 32)
+	count, err3 := strconv.ParseUint(fields[3], 16, 64)
+	if err := errors.Join(err1, err2, err3); err != nil {
+		return GaplessInfo{}, false
+	}
+	return GaplessInfo{EncoderDelay: uint32(delay), Padding: uint32(padding), SampleCount: count}, true
+}
+
+// formatITunSMPB formats info the way iTunes writes it, with the unused fields zeroed.
+func formatITunSMPB(info GaplessInfo) string {
+	return fmt.Sprintf(" 00000000 %08X %08X %016X 00000000 00000000 00000000 00000000 00000000 00000000 00000000 00000000",
+		info.EncoderDelay, info.Padding, info.SampleCount)
+}
+
+// ReplayGain holds the ReplayGain loudness normalisation values of a file. Gains are in dB, and peaks are
+// linear sample amplitudes where 1 is full scale. Zero fields aren't set.
+type ReplayGain struct {
+	TrackGain float64 // REPLAYGAIN_TRACK_GAIN
+	TrackPeak float64 // REPLAYGAIN_TRACK_PEAK
+	AlbumGain float64 // REPLAYGAIN_ALBUM_GAIN
+	AlbumPeak float64 // REPLAYGAIN_ALBUM_PEAK
+}
+
+// replayGainKeys are the tag keys of the ReplayGain fields, in the order of the [ReplayGain] fields.
+var replayGainKeys = [...]string{
+	"REPLAYGAIN_TRACK_GAIN",
+	"REPLAYGAIN_TRACK_PEAK",
+	"REPLAYGAIN_ALBUM_GAIN",
+	"REPLAYGAIN_ALBUM_PEAK",
+}
+
+// mp4FreeformPrefix is the prefix of the names of MP4 freeform atoms written by iTunes and most other software.
+const mp4FreeformPrefix = "----:com.apple.iTunes:"
+
+// ReadReplayGain reads the ReplayGain values of the file at path. These are read from the REPLAYGAIN_* tags,
+// which TagLib maps from ID3v2 TXXX frames, MP4 freeform atoms such as "----:com.apple.iTunes:replaygain_track_gain",
+// Vorbis comments, and APE items, matching the names case-insensitively.
+// Values that aren't numbers, optionally followed by "dB", are ignored.
+func ReadReplayGain(path string) (ReplayGain, error) {
+	tags, err := ReadTags(path)
+	if err != nil {
+		return ReplayGain{}, err
+	}
+	var vs [len(replayGainKeys)]float64
+	for i, key := range replayGainKeys {
+		vs[i] = parseReplayGain(findFold(tags, key))
+	}
+	return ReplayGain{TrackGain: vs[0], TrackPeak: vs[1], AlbumGain: vs[2], AlbumPeak: vs[3]}, nil
+}
+
+// WriteReplayGain writes the ReplayGain values of the file at path, removing the tags of zero fields.
+// Gains are written as "-6.50 dB" and peaks as "0.988553", the way ReplayGain scanners write them.
+// For MP4 the values are written to lowercase "----:com.apple.iTunes:replaygain_*" freeform atoms as
+// iTunes-compatible software expects, replacing atoms of any other case.
+func WriteReplayGain(path string, rg ReplayGain) error {
+	format, err := DetectFormat(path)
+	if err != nil {
+		return err
+	}
+
+	values := map[string]string{}
+	for i, v := range [...]float64{rg.TrackGain, rg.TrackPeak, rg.AlbumGain, rg.AlbumPeak} {
+		switch {
+		case v == 0:
+			values[replayGainKeys[i]] = ""
+		case i%2 == 0:
+			values[replayGainKeys[i]] = fmt.Sprintf("%.2f dB", v)
+		default:
+			values[replayGainKeys[i]] = fmt.Sprintf("%.6f", v)
+		}
+	}
+
+	if format == FormatMP4 {
+		atoms, err := ReadMP4Atoms(path)
+		if err != nil {
+			return err
+		}
+		write := map[string][]string{}
+		for key, v := range values {
+			// Atom names are case sensitive, so remove existing atoms with other cases first
+			for name := range atoms {
+				if strings.EqualFold(name, mp4FreeformPrefix+key) {
+					write[name] = nil
+				}
+			}
+			write[mp4FreeformPrefix+strings.ToLower(key)] = []string{v}
+		}
+		return writeMP4Atoms(path, write)
+	}
+
+	tags := map[string][]string{}
+	for key, v := range values {
+		tags[key] = nil
+		if v != "" {
+			tags[key] = []string{v}
+		}
+	}
+	return WriteTags(path, tags, 0)
+}
+
+// parseReplayGain parses a ReplayGain gain or peak value such as "-6.50 dB" or "0.988553".
+// Returns 0 if s isn't a number.
+func parseReplayGain(s string) float64 {
+	s = strings.TrimSpace(s)
+	if len(s) >= 2 && strings.EqualFold(s[len(s)-2:], "dB") {
+		s = strings.TrimSpace(s[:len(s)-2])
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsInf(v, 0) || math.IsNaN(v) {
+		return 0
+	}
+	return v
+}
+
+// Loudness holds the measured EBU R128 loudness of a file. Zero fields aren't present.
+type Loudness struct {
+	IntegratedLUFS float64 // integrated loudness in LUFS
+	TruePeak       float64 // true peak as a linear amplitude where 1 is full scale
+	LoudnessRange  float64 // loudness range (LRA) in LU
+}
+
+// r128Reference is the loudness in LUFS that the Opus R128_TRACK_GAIN tag is relative to, as given by RFC 7845.
+const r128Reference = -23
+
+// ReadLoudness reads the loudness of the file at path from its tags, without measuring the audio.
+// The integrated loudness is read from the R128_TRACK_GAIN tag of Opus files, or from REPLAYGAIN_TRACK_GAIN
+// together with the REPLAYGAIN_REFERENCE_LOUDNESS it's relative to, given in LUFS such as "-18.00 LUFS" or as a
+// ReplayGain 1 sound pressure level such as "89.0 dB". The true peak is read from REPLAYGAIN_TRACK_PEAK only when the
+// reference loudness is given in LUFS, as by the EBU R128 scanners that measure true peaks, and the loudness range
+// from REPLAYGAIN_TRACK_RANGE. A ReplayGain gain without a reference loudness isn't used.
+func ReadLoudness(path string) (Loudness, error) {
+	tags, err := ReadTags(path)
+	if err != nil {
+		return Loudness{}, err
+	}
+	var l Loudness
+	ref, refLUFS := parseReferenceLoudness(findFold(tags, "REPLAYGAIN_REFERENCE_LOUDNESS"))
+	if gain, err := strconv.Atoi(strings.TrimSpace(findFold(tags, "R128_TRACK_GAIN"))); err == nil {
+		l.IntegratedLUFS = r128Reference - float64(gain)/256 // Q7.8 fixed point
+	} else if gain := findFold(tags, "REPLAYGAIN_TRACK_GAIN"); ref != 0 && strings.TrimSpace(gain) != "" {
+		l.IntegratedLUFS = ref - parseReplayGain(gain)
+	}
+	if refLUFS {
+		l.TruePeak = parseReplayGain(findFold(tags, "REPLAYGAIN_TRACK_PEAK"))
+	}
+	l.LoudnessRange = parseReplayGain(findFold(tags, "REPLAYGAIN_TRACK_RANGE"))
+	return l, nil
+}
+
+// parseReferenceLoudness parses a REPLAYGAIN_REFERENCE_LOUDNESS value to LUFS, and reports whether it was given
+// in LUFS. ReplayGain 1 sound pressure levels such as "89.0 dB" are converted with 89 dB being -18 LUFS, as in
+// ReplayGain 2.0. Returns 0 if s isn't a loudness.
+func parseReferenceLoudness(s string) (float64, bool) {
+	s = strings.TrimSpace(s)
+	if len(s) >= 4 && strings.EqualFold(s[len(s)-4:], "LUFS") {
+		return parseReplayGain(s[:len(s)-4]), true
+	}
+	v := parseReplayGain(s)
+	if v > 0 {
+		return v - 107, false
+	}
+	return v, v != 0
+}
+
+// SameRecording reports whether the files at paths a and b are likely the same recording, along with a confidence
+// from 0 to 1, by comparing their [MusicBrainzTrackID], [AcoustIDID], and [AcoustIDFingerprint] tags.
+// The confidence is 1 if the MusicBrainz recording IDs match, at least 0.9 if the AcoustIDs match, and otherwise how
+// closely the fingerprints match, from 0 for unrelated audio to 1 for identical fingerprints. Different MusicBrainz
+// recording IDs cap it at 0.3, since MusicBrainz then considers them different recordings however alike they sound.
+// IDs and fingerprints missing from either file can't be compared, so they only lower the confidence, which is 0 if
+// nothing can be compared. The files are reported as the same recording if the confidence is at least 0.5.
+func SameRecording(a, b string) (bool, float64, error) {
+	tagsA, err := ReadTags(a)
+	if err != nil {
+		return false, 0, err
+	}
+	tagsB, err := ReadTags(b)
+	if err != nil {
+		return false, 0, err
+	}
+	both := func(key string) (string, string, bool) {
+		if len(tagsA[key]) == 0 || len(tagsB[key]) == 0 {
+			return "", "", false
+		}
+		va, vb := strings.TrimSpace(tagsA[key][0]), strings.TrimSpace(tagsB[key][0])
+		return va, vb, va != "" && vb != ""
+	}
+
+	var confidence float64
+	if fa, fb, ok := both(AcoustIDFingerprint); ok {
+		confidence = fingerprintSimilarity(fa, fb)
+	}
+	if ida, idb, ok := both(AcoustIDID); ok && strings.EqualFold(ida, idb) {
+		confidence = max(confidence, 0.9)
+	}
+	if ida, idb, ok := both(MusicBrainzTrackID); ok {
+		if strings.EqualFold(ida, idb) {
+			return true, 1, nil
+		}
+		confidence = min(confidence, 0.3)
+	}
+	return confidence >= 0.5, confidence, nil
+}
+
+// fingerprintSimilarity returns how closely the compressed Chromaprint fingerprints a and b match at their best
+// alignment, from 0 when half the bits differ, as for unrelated audio, to 1 when they're identical.
+// Returns 0 if either can't be decoded.
+func fingerprintSimilarity(a, b string) float64 {
+	if a == b {
+		return 1
+	}
+	fa, fb := decodeFingerprint(a), decodeFingerprint(b)
+	if len(fa) == 0 || len(fb) == 0 {
+		return 0
+	}
+
+	// Items are about 0.12s apart, so this allows for about 10s of offset
+	const maxOffset = 80
+	minOverlap := max(min(len(fa), len(fb))/2, 1)
+	best := 0.0
+	for offset := -maxOffset; offset <= maxOffset; offset++ {
+		var errs, n int
+		for i := max(0, -offset); i < len(fa) && i+offset < len(fb); i++ {
+			errs += bits.OnesCount32(fa[i] ^ fb[i+offset])
+			n++
+		}
+		if n < minOverlap {
+			continue
+		}
+		best = max(best, 1-float64(errs)/float64(32*n))
+	}
+	return max(0, 2*best-1)
+}
+
+// decodeFingerprint decodes a compressed, base64 encoded Chromaprint fingerprint, as stored in
+// ACOUSTID_FINGERPRINT tags, to its items. Returns nil if it can't be decoded.
+func decodeFingerprint(s string) []uint32 {
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil || len(data) < 4 {
+		return nil
+	}
+	// The algorithm, then the number of items
+	count := int(data[1])<<16 | int(data[2])<<8 | int(data[3])
+	data = data[4:]
+
+	// Each item is the XOR with the previous item, stored as the gaps between its set bits ending with a 0.
+	// The gaps are packed as 3 bit values, followed by 5 bit values for the excess of those that are 7 or more.
+	bitAt := func(pos int) uint32 { return uint32(data[pos/8]>>(pos%8)) & 1 }
+	read := func(pos, width int) (uint32, bool) {
+		if pos+width > len(data)*8 {
+			return 0, false
+		}
+		var v uint32
+		for i := range width {
+			v |= bitAt(pos+i) << i
+		}
+		return v, true
+	}
+
+	var gaps []uint32
+	for pos, items := 0, 0; items < count; pos += 3 {
+		gap, ok := read(pos, 3)
+		if !ok {
+			return nil
+		}
+		if gap == 0 {
+			items++
+		}
+		gaps = append(gaps, gap)
+	}
+	pos := (len(gaps)*3 + 7) / 8 * 8
+	for i, gap := range gaps {
+		if gap == 7 {
+			excess, ok := read(pos, 5)
+			if !ok {
+				return nil
+			}
+			gaps[i] += excess
+			pos += 5
+		}
+	}
+
+	items := make([]uint32, 0, count)
+	var item uint32
+	var bit uint32
+	for _, gap := range gaps {
+		if gap == 0 {
+			if len(items) > 0 {
+				item ^= items[len(items)-1]
+			}
+			items = append(items, item)
+			item, bit = 0, 0
+			continue
+		}
+		if bit += gap; bit > 32 {
+			return nil
+		}
+		item |= 1 << (bit - 1)
+	}
+	return items
+}
+
+// lameFrame is the first MPEG audio frame of a file, holding a Xing/Info header with a LAME tag.
+type lameFrame struct {
+	offset int64  // offset of the frame in the file
+	data   []byte // frame data up to the end of the LAME tag
+	lame   int    // offset of the LAME tag in data
+	frames uint32 // frame count from the Xing/Info header, or 0 if absent
+	spf    uint32 // samples per frame
+}
+
+// readLAMEFrame finds the LAME tag in the first frame after any ID3v2 tag. Returns nil if there is none.
+func readLAMEFrame(r io.ReaderAt) (*lameFrame, error) {
+	var start int64
+	id3, err := readID3v2Tag(r)
+	if err != nil {
+		return nil, err
+	}
+	start = int64(len(id3))
+
+	buf := make([]byte, 4096)
+	n, err := r.ReadAt(buf, start)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("read frame: %w", err)
+	}
+	buf = buf[:n]
+
+	// Skip any junk before the first Layer III frame header
+	pos := 0
+	for ; pos+4 <= len(buf); pos++ {
+		if buf[pos] == 0xFF && buf[pos+1]&0xE0 == 0xE0 && buf[pos+1]>>1&3 == 1 && buf[pos+1]>>3&3 != 1 {
+			break
+		}
+	}
+	if pos+4 > len(buf) {
+		return nil, nil
+	}
+	data := buf[pos:]
+
+	mpeg1, mono := data[1]>>3&3 == 3, data[3]>>6 == 3
+	xing, spf := 4+17, uint32(1152)
+	switch {
+	case mpeg1 && !mono:
+		xing = 4 + 32
+	case !mpeg1 && mono:
+		xing, spf = 4+9, 576
+	case !mpeg1:
+		spf = 576
+	}
+	if xing+8 > len(data) || (string(data[xing:xing+4]) != "Xing" && string(data[xing:xing+4]) != "Info") {
+		return nil, nil
+	}
+
+	frame := &lameFrame{offset: start + int64(pos), spf: spf}
+	flags := be32(data[xing+4:])
+	lame := xing + 8
+	if flags&1 != 0 && lame+4 <= len(data) {
+		frame.frames = be32(data[lame:])
+		lame += 4
+	}
+	if flags&2 != 0 {
+		lame += 4 // Byte count
+	}
+	if flags&4 != 0 {
+		lame += 100 // Seek table
+	}
+	if flags&8 != 0 {
+		lame += 4 // Quality
+	}
+	// The LAME tag starts with an encoder version string such as "LAME3.100" or "Lavc61.19"
+	if lame+36 > len(data) || !isASCIILetters(data[lame:lame+4]) {
+		return nil, nil
+	}
+	frame.data, frame.lame = data[:lame+36], lame
+	return frame, nil
+}
+
+func readLAMEGaplessInfo(path string) (GaplessInfo, bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return GaplessInfo{}, false, fmt.Errorf("open file: %w", err)
+	}
+	defer f.Close()
+
+	frame, err := readLAMEFrame(f)
+	if err != nil || frame == nil {
+		return GaplessInfo{}, false, err
+	}
+
+	b := frame.data[frame.lame+21:]
+	info := GaplessInfo{
+		EncoderDelay: uint32(b[0])<<4 | uint32(b[1])>>4,
+		Padding:      uint32(b[1]&0x0F)<<8 | uint32(b[2]),
+	}
+	if total := uint64(frame.frames) * uint64(frame.spf); frame.frames > 0 && total >= uint64(info.EncoderDelay+info.Padding) {
+		info.SampleCount = total - uint64(info.EncoderDelay) - uint64(info.Padding)
+	}
+	return info, true, nil
+}
+
+func writeLAMEGaplessInfo(path string, info GaplessInfo) error {
+	if info.EncoderDelay > 0xFFF || info.Padding > 0xFFF {
+		return fmt.Errorf("delay %d or padding %d too large for LAME tag", info.EncoderDelay, info.Padding)
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return fmt.Errorf("open file: %w", err)
+	}
+	defer f.Close()
+
+	frame, err := readLAMEFrame(f)
+	if err != nil {
+		return err
+	}
+	if frame == nil {
+		return fmt.Errorf("%w: no LAME tag", ErrUnsupportedFormat)
+	}
+
+	b := frame.data[frame.lame+21:]
+	b[0] = byte(info.EncoderDelay >> 4)
+	b[1] = byte(info.EncoderDelay&0x0F)<<4 | byte(info.Padding>>8)
+	b[2] = byte(info.Padding)
+
+	// The tag ends with a CRC of the frame up to that point
+	crc := crc16(frame.data[:frame.lame+34])
+	frame.data[frame.lame+34], frame.data[frame.lame+35] = byte(crc>>8), byte(crc)
+
+	if _, err := f.WriteAt(frame.data, frame.offset); err != nil {
+		return fmt.Errorf("%w: %w", ErrSavingFile, err)
+	}
+	return f.Close()
+}
+
+// crc16 computes the CRC-16/ARC checksum used by LAME tags.
+func crc16(data []byte) uint16 {
+	var crc uint16
+	for _, b := range data {
+		crc ^= uint16(b)
+		for range 8 {
+			if crc&1 != 0 {
+				crc = crc>>1 ^ 0xA001
+			} else {
+				crc >>= 1
+			}
+		}
+	}
+	return crc
+}
+
+// be16 decodes a big-endian uint16.
+func be16(b []byte) uint16 {
+	return uint16(b[0])<<8 | uint16(b[1])
+}
+
+// be32 decodes a big-endian uint32.
+func be32(b []byte) uint32 {
+	return uint32(b[0])<<24 | uint32(b[1])<<16 | uint32(b[2])<<8 | uint32(b[3])
+}
+
+// putBE32 encodes v into b as a big-endian uint32.
+func putBE32(b []byte, v uint32) {
+	b[0], b[1], b[2], b[3] = byte(v>>24), byte(v>>16), byte(v>>8), byte(v)
+}
+
+func isASCIILetters(b []byte) bool {
+	for _, c := range b {
+		if ('a' > c || c > 'z') && ('A' > c || c > 'Z') {
+			return false
+		}
+	}
+	return true
+}
+
+// ReadID3v1Frames reads all ID3v1 tags from an MP3 file at the given path.
+// This provides access to the standard ID3v1 fields: title, artist, album, year, comment, track, and genre.
+// The returned map has standardized keys (like "TITLE", "ARTIST", "ALBUM") and values.
+// Note that ID3v1 is a much simpler format than ID3v2 with a fixed set of fields.
+func ReadID3v1Frames(path string) (map[string][]string, error) {
+	var err error
+	path, err = filepath.Abs(path)
+	if err != nil {
+		return nil, fmt.Errorf("make path abs %w", err)
+	}
+
+	mod, err := newModuleRO(path)
+	if err != nil {
+		return nil, fmt.Errorf("init module: %w", err)
+	}
+	defer mod.close()
+
+	var raw wasmStrings
+	if err := mod.call("taglib_file_id3v1_tags", &raw, wasmString(wasmPath(path))); err != nil {
+		return nil, fmt.Errorf("call: %w", err)
+	}
+	if raw == nil {
+		return nil, ErrInvalidFile
+	}
+
+	// If raw is empty, the file has no ID3v1 tags
+	var frames = map[string][]string{}
+	for _, row := range raw {
+		parts := strings.SplitN(row, "\t", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		frames[parts[0]] = append(frames[parts[0]], parts[1])
+	}
+	return frames, nil
+}
+
+// ReadMP4Atoms reads all MP4 atoms from an M4A/MP4 file at the given path.
+// This provides direct access to the raw MP4 atoms/items, including standard atoms like ©ART (artist),
+// ©alb (album), trkn (track number), etc.
+// The returned map has atom names as keys and atom data as values.
+// For IntPair atoms (like trkn, disk), values are split into separate keys with :num and :total suffixes.
+// For example, track 3 of 12 returns as "trkn:num" -> ["3"] and "trkn:total" -> ["12"].
+func ReadMP4Atoms(path string) (map[string][]string, error) {
+	var err error
+	path, err = filepath.Abs(path)
+	if err != nil {
+		return nil, fmt.Errorf("make path abs %w", err)
+	}
+
+	mod, err := newModuleRO(path)
+	if err != nil {
+		return nil, fmt.Errorf("init module: %w", err)
+	}
+	defer mod.close()
+
+	var raw wasmStrings
+	if err := mod.call("taglib_file_mp4_atoms", &raw, wasmString(wasmPath(path))); err != nil {
+		return nil, fmt.Errorf("call: %w", err)
+	}
+	if raw == nil {
+		return nil, ErrInvalidFile
+	}
+
+	// If raw is empty, the file has no MP4 atoms
+	var atoms = map[string][]string{}
+	for _, row := range raw {
+		parts := strings.SplitN(row, "\t", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		atoms[parts[0]] = append(atoms[parts[0]], parts[1])
+	}
+	return atoms, nil
+}
+
+// ReadMP4AtomRaw reads the raw values of the MP4 atom atomName from an M4A/MP4 file at the given path, one for each
+// data atom of the item, without the type and locale that precede them. Unlike [ReadMP4Atoms], binary values such as
+// covr images and binary freeform items are returned intact.
+// atomName is as returned by [ReadMP4Atoms], such as "©ART", "covr", or "----:com.apple.iTunes:iTunSMPB".
+// Returns nil if the file has no such atom, and [ErrInvalidFile] if it isn't an MP4 file.
+func ReadMP4AtomRaw(path, atomName string) ([][]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open: %w", err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("stat: %w", err)
+	}
+
+	moov, moovEnd, ok := mp4Box(f, 0, info.Size(), "moov")
+	if !ok {
+		return nil, ErrInvalidFile
+	}
+	meta, metaEnd, ok := mp4Path(f, moov, moovEnd, "udta", "meta")
+	if !ok {
+		return nil, nil
+	}
+	// After the version and flags of the meta box
+	ilst, ilstEnd, ok := mp4Box(f, meta+4, metaEnd, "ilst")
+	if !ok {
+		return nil, nil
+	}
+
+	var values [][]byte
+	for pos := ilst; ; {
+		typ, item, itemEnd, ok := mp4NextBox(f, pos, ilstEnd)
+		if !ok {
+			break
+		}
+		pos = itemEnd
+		if mp4ItemName(f, typ, item, itemEnd) != atomName {
+			continue
+		}
+		for pos := item; ; {
+			typ, data, dataEnd, ok := mp4NextBox(f, pos, itemEnd)
+			if !ok {
+				break
+			}
+			pos = dataEnd
+			// After the type and locale of the data atom
+			if typ != "data" || dataEnd-data < 8 {
+				continue
+			}
+			value := make([]byte, dataEnd-data-8)
+			if _, err := f.ReadAt(value, data+8); err != nil {
+				return nil, fmt.Errorf("read atom: %w", err)
+			}
+			values = append(values, value)
+		}
+	}
+	return values, nil
+}
+
+// ReadASFAttributes reads all ASF attributes from a WMA/ASF file at the given path.
+// This provides direct access to the raw ASF attributes, including standard attributes like
+// WM/AlbumTitle, WM/AlbumArtist, WM/TrackNumber, etc.
+// The returned map has attribute names as keys and attribute data as values.
+// Multi-valued attributes are returned as slices with multiple values.
+func ReadASFAttributes(path string) (map[string][]string, error) {
+	var err error
+	path, err = filepath.Abs(path)
+	if err != nil {
+		return nil, fmt.Errorf("make path abs %w", err)
+	}
+
+	mod, err := newModuleRO(path)
+	if err != nil {
+		return nil, fmt.Errorf("init module: %w", err)
+	}
+	defer mod.close()
+
+	var raw wasmStrings
+	if err := mod.call("taglib_file_asf_attributes", &raw, wasmString(wasmPath(path))); err != nil {
+		return nil, fmt.Errorf("call: %w", err)
+	}
+	if raw == nil {
+		return nil, ErrInvalidFile
+	}
+
+	// If raw is empty, the file has no ASF attributes
+	var attrs = map[string][]string{}
+	for _, row := range raw {
+		parts := strings.SplitN(row, "\t", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		attrs[parts[0]] = append(attrs[parts[0]], parts[1])
+	}
+	return attrs, nil
+}
+
+// DetectFormat detects the format of the audio file at the given path.
+// Returns [ErrInvalidFile] if the file is not a supported audio file.
+func DetectFormat(path string) (FileFormat, error) {
+	var err error
+	path, err = filepath.Abs(path)
+	if err != nil {
+		return FormatUnknown, fmt.Errorf("make path abs %w", err)
+	}
+
+	mod, err := newModuleRO(path)
+	if err != nil {
+		return FormatUnknown, fmt.Errorf("init module: %w", err)
+	}
+	defer mod.close()
+
+	return readFormat(&mod, path)
+}
+
+// Identify detects the format of the audio in r, such as an upload of unknown type, along with a confidence between
+// 0 and 1. The signatures of common containers are checked first, after any ID3v2 tag, which is fast and reliable:
+// an unambiguous signature has a confidence of at least 0.9, and MPEG audio, which has no signature but a frame
+// sync, less. Otherwise TagLib parses r to detect its format, with a confidence of 0.5. Returns [ErrInvalidFile]
+// if neither recognises r. r is read from the start, and left at an unspecified position.
+func Identify(r io.ReadSeeker) (FileFormat, float64, error) {
+	if format, confidence := sniffFormat(seekerReaderAt{r}); format != FormatUnknown {
+		return format, confidence, nil
+	}
+
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return FormatUnknown, 0, fmt.Errorf("seek: %w", err)
+	}
+	f, err := OpenStream(r, WithReadStyle(ReadStyleFast))
+	if err != nil {
+		return FormatUnknown, 0, err
+	}
+	defer f.Close()
+	return f.Format(), 0.5, nil
+}
+
+// asfHeaderGUID is the GUID of the header object that ASF files start with.
+var asfHeaderGUID = []byte{0x30, 0x26, 0xB2, 0x75, 0x8E, 0x66, 0xCF, 0x11, 0xA6, 0xD9, 0x00, 0xAA, 0x00, 0x62, 0xCE, 0x6C}
+
+// sniffFormat detects the format of r from the signature after any ID3v2 tag, with a confidence as described by
+// [Identify]. Returns [FormatUnknown] if there is no signature it knows.
+func sniffFormat(r io.ReaderAt) (FileFormat, float64) {
+	start := id3v2TagSize(r)
+	head := make([]byte, 64)
+	n, _ := r.ReadAt(head, start)
+	head = head[:n]
+	if len(head) < 4 {
+		return FormatUnknown, 0
+	}
+
+	switch magic := string(head[:4]); {
+	case magic == "fLaC":
+		return FormatFLAC, 0.99
+	case magic == "RIFF" && len(head) >= 12 && string(head[8:12]) == "WAVE":
+		return FormatWAV, 0.99
+	case magic == "FORM" && len(head) >= 12 && (string(head[8:12]) == "AIFF" || string(head[8:12]) == "AIFC"):
+		return FormatAIFF, 0.99
+	case len(head) >= 8 && string(head[4:8]) == "ftyp":
+		// Also used by video files
+		return FormatMP4, 0.95
+	case magic == "OggS":
+		// The codec is identified by the first packet, after the page header and segment table
+		if len(head) < 27 || 27+int(head[26]) >= len(head) {
+			return FormatUnknown, 0
+		}
+		packet := head[27+int(head[26]):]
+		switch {
+		case bytes.HasPrefix(packet, []byte("\x01vorbis")):
+			return FormatOggVorbis, 0.99
+		case bytes.HasPrefix(packet, []byte("OpusHead")):
+			return FormatOggOpus, 0.99
+		case bytes.HasPrefix(packet, []byte("Speex   ")):
+			return FormatOggSpeex, 0.99
+		case bytes.HasPrefix(packet, []byte("\x7fFLAC")):
+			return FormatOggFLAC, 0.99
+		}
+		return FormatUnknown, 0
+	case magic == "MAC ":
+		return FormatAPE, 0.99
+	case magic == "wvpk":
+		return FormatWavPack, 0.99
+	case magic == "TTA1":
+		return FormatTrueAudio, 0.99
+	case magic == "MPCK" || magic[:3] == "MP+":
+		return FormatMPC, 0.99
+	case magic == "ajkg":
+		return FormatShorten, 0.99
+	case magic == "DSD ":
+		return FormatDSF, 0.99
+	case magic == "FRM8":
+		return FormatDSDIFF, 0.99
+	case bytes.HasPrefix(head, asfHeaderGUID):
+		return FormatASF, 0.99
+	case bytes.HasPrefix(head, []byte{0x1A, 0x45, 0xDF, 0xA3}):
+		// The EBML header of Matroska and WebM, also used by video files
+		return FormatMatroska, 0.9
+	case isMPEGFrameHeader(head):
+		// A frame sync is only a few bits, so it's more convincing right after a tag
+		if start > 0 {
+			return FormatMPEG, 0.9
+		}
+		return FormatMPEG, 0.6
+	}
+	return FormatUnknown, 0
+}
+
+// Verify checks that the file at path can be fully parsed by TagLib, without reading its tags or writing anything.
+// Returns [ErrUnsupportedFormat] if the file isn't recognised as audio at all, or [ErrInvalidFile] if it looks like a
+// supported format by extension but can't be opened, or has no readable audio stream. Other errors, such as the file
+// not existing, are returned as-is.
+func Verify(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("%w: not a regular file", ErrInvalidFile)
+	}
+
+	f, err := OpenReadOnly(path)
+	if errors.Is(err, ErrInvalidFile) {
+		if format := FileFormatFromExtension(filepath.Ext(path)); format != FormatUnknown {
+			return fmt.Errorf("%w: can't parse as %s", ErrInvalidFile, format)
+		}
+		return ErrUnsupportedFormat
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	props := f.Properties()
+	if props.SampleRate == 0 || props.Channels == 0 {
+		return fmt.Errorf("%w: no audio stream in %s file", ErrInvalidFile, f.Format())
+	}
+	return nil
+}
+
+// CheckExtension compares the format implied by the extension of the file at path with the format detected from
+// its content, for finding files such as an AAC named ".mp3" or an Ogg named ".flac". Unlike [DetectFormat], which
+// lets TagLib trust the extension, the content is sniffed without it. Since ".ogg" and ".oga" are used for all
+// kinds of Ogg streams, any Ogg format matches them. expected is [FormatUnknown] for unrecognised extensions, which
+// never match. Returns [ErrInvalidFile] if the content isn't recognised as a supported format.
+func CheckExtension(path string) (expected, actual FileFormat, match bool, err error) {
+	ext := strings.ToLower(filepath.Ext(path))
+	expected = FileFormatFromExtension(ext)
+
+	f, err := os.Open(path)
+	if err != nil {
+		return expected, FormatUnknown, false, err
+	}
+	defer f.Close()
+
+	file, err := OpenStream(f)
+	if err != nil {
+		return expected, FormatUnknown, false, err
+	}
+	actual = file.Format()
+	_ = file.Close()
+
+	switch {
+	case expected == FormatUnknown:
+		match = false
+	case ext == ".ogg" || ext == ".oga":
+		match = actual.IsOgg()
+	default:
+		match = expected == actual
+	}
+	return expected, actual, match, nil
+}
+
+// FormatHistogram walks the directory tree rooted at root and counts the audio files of each format.
+// Files that are not supported audio files are skipped. A single module is reused for all files in a directory.
+// The walk stops early with the context's error if ctx is cancelled.
+func FormatHistogram(ctx context.Context, root string) (map[FileFormat]int, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, fmt.Errorf("make path abs %w", err)
+	}
+
+	var dirs []string
+	files := map[string][]string{}
+	err = filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		dir := filepath.Dir(path)
+		if _, ok := files[dir]; !ok {
+			dirs = append(dirs, dir)
+		}
+		files[dir] = append(files[dir], path)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("walk: %w", err)
+	}
+
+	counts := map[FileFormat]int{}
+	for _, dir := range dirs {
+		if err := countFormats(ctx, dir, files[dir], counts); err != nil {
+			return nil, err
+		}
+	}
+	return counts, nil
+}
+
+func countFormats(ctx context.Context, dir string, paths []string, counts map[FileFormat]int) error {
+	mod, err := newModuleOpt(dir, paths, true)
+	if err != nil {
+		return fmt.Errorf("init module: %w", err)
+	}
+	defer mod.close()
+
+	for _, path := range paths {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		format, err := readFormat(&mod, path)
+		if errors.Is(err, ErrInvalidFile) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		counts[format]++
+	}
+	return nil
+}
+
+// PathErrors maps paths to the errors encountered reading them, for batch functions that carry on past
+// failing files.
+type PathErrors map[string]error
+
+func (e PathErrors) Error() string {
+	paths := make([]string, 0, len(e))
+	for path := range e {
+		paths = append(paths, path)
+	}
+	slices.Sort(paths)
+
+	msgs := make([]string, 0, len(paths))
+	for _, path := range paths {
+		msgs = append(msgs, fmt.Sprintf("%s: %v", path, e[path]))
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// durationsPerModule bounds how many files [ReadDurations] reads with one module, since memory
+// returned from each call is only reclaimed when the module is closed.
+const durationsPerModule = 256
+
+// ReadDurations reads the duration of each of paths using [ReadStyleFast], for example to total the runtime of a
+// playlist. Modules are reused across files in the same directory. Files that can't be read are left out of the
+// returned map and reported in a [PathErrors] error, while the others are still returned.
+func ReadDurations(paths []string) (map[string]time.Duration, error) {
+	durations := make(map[string]time.Duration, len(paths))
+	errs := PathErrors{}
+
+	var dirs []string
+	files := map[string][]string{}
+	abs := map[string]string{}
+	for _, path := range paths {
+		absPath, err := filepath.Abs(path)
+		if err != nil {
+			errs[path] = fmt.Errorf("make path abs %w", err)
+			continue
+		}
+		abs[absPath] = path
+		dir := filepath.Dir(absPath)
+		if _, ok := files[dir]; !ok {
+			dirs = append(dirs, dir)
+		}
+		files[dir] = append(files[dir], absPath)
+	}
+
+	for _, dir := range dirs {
+		for chunk := range slices.Chunk(files[dir], durationsPerModule) {
+			readDurations(dir, chunk, func(path string, d time.Duration, err error) {
+				if err != nil {
+					errs[abs[path]] = err
+					return
+				}
+				durations[abs[path]] = d
+			})
+		}
+	}
+
+	if len(errs) > 0 {
+		return durations, errs
+	}
+	return durations, nil
+}
+
+func readDurations(dir string, paths []string, fn func(path string, d time.Duration, err error)) {
+	mod, err := newModuleOpt(dir, paths, true)
+	if err != nil {
+		for _, path := range paths {
+			fn(path, 0, fmt.Errorf("init module: %w", err))
+		}
+		return
+	}
+	defer mod.close()
+
+	for _, path := range paths {
+		var result wasmOpenResult
+		if err := mod.call("taglib_file_open", &result, wasmString(wasmPath(path)), wasmUint8(ReadStyleFast)); err != nil {
+			fn(path, 0, fmt.Errorf("call: %w", err))
+			continue
+		}
+		if result.handle == 0 {
+			fn(path, 0, ErrInvalidFile)
+			continue
+		}
+
+		var raw wasmFileProperties
+		err := mod.call("taglib_handle_properties", &raw, wasmUint32(result.handle))
+		var out wasmBool
+		_ = mod.call("taglib_file_close", &out, wasmUint32(result.handle))
+		if err != nil {
+			fn(path, 0, fmt.Errorf("call: %w", err))
+			continue
+		}
+		fn(path, time.Duration(raw.lengthInMilliseconds)*time.Millisecond, nil)
+	}
+}
+
+// Properties contains the audio properties of a media file.
+type Properties struct {
+	// Length is the duration of the audio
+	Length time.Duration
+	// Channels is the number of audio channels
+	Channels uint
+	// SampleRate in Hz
+	SampleRate uint
+	// Bitrate in kbit/s
+	Bitrate uint
+	// BitsPerSample is the bit depth (e.g., 16, 24, 32). May be 0 for formats that don't support this.
+	BitsPerSample uint
+	// Codec is the audio codec (e.g., "MP3", "AAC", "ALAC"). May be empty for formats without codec variants.
+	Codec string
+	// ChannelLayout is the speaker layout of the channels, such as "mono", "stereo", "5.1", or "7.1". It is read from
+	// the channel mask of WAV, FLAC, and MP4 files where present, or otherwise is the default layout of the format for
+	// the number of channels. It is "N channels" if the layout is unknown or the channels are discrete.
+	ChannelLayout string
+	// HasVideo reports whether an MP4 file has a video track, as music videos and some files with cover art stored
+	// as a video track do. Always false for other formats.
+	HasVideo bool
+	// CompressionMode is the encoder compression level of APE ("fast", "normal", "high", "extra high", or "insane")
+	// and WavPack ("fast", "normal", "high", or "very high", followed by "lossless" or "hybrid") files.
+	// Empty for other formats.
+	CompressionMode string
+	// ImageCount is the number of embedded images, the same as len(Images)
+	ImageCount int
+	// ChapterCount is the number of ID3v2 chapter frames of MP3 files. 0 for other formats.
+	ChapterCount int
+	// CueTrackCount is the number of tracks in the embedded cue sheet of FLAC files, either from the
+	// CUESHEET metadata block or a CUESHEET comment. 0 for other formats.
+	CueTrackCount int
+	// Images contains metadata about all embedded images, in the order [File.Image] reads them by index, so that
+	// Images[i] describes the image at index i for all formats
+	Images []ImageDesc
+}
+
+// ImageDesc contains metadata about an embedded image without the actual image data.
+type ImageDesc struct {
+	// Type is the picture type (e.g., "Front Cover", "Back Cover")
+	Type string
+	// Description is a textual description of the image
+	Description string
+	// MIMEType is the MIME type of the image (e.g., "image/jpeg")
+	MIMEType string
+}
+
+// parseImageDescs parses the type, description, and MIME type rows of the images of a file. Every row yields an
+// image, even a malformed one, so that the index of each matches the index [File.Image] reads it by.
+func parseImageDescs(rows []string) []ImageDesc {
+	var images []ImageDesc
+	for _, row := range rows {
+		// Descriptions may contain tabs themselves
+		imageType, rest, _ := strings.Cut(row, "\t")
+		desc, mime := rest, ""
+		if i := strings.LastIndexByte(rest, '\t'); i >= 0 {
+			desc, mime = rest[:i], rest[i+1:]
+		}
+		images = append(images, ImageDesc{
+			Type:        imageType,
+			Description: desc,
+			MIMEType:    mime,
+		})
+	}
+	return images
+}
+
+// Picture is an embedded image with its metadata, as written by [File.SetPictures].
+type Picture struct {
+	// Data is the image data
+	Data []byte
+	// Type is the picture type. MP4 files have no picture types, so it isn't written to them.
+	Type PictureType
+	// Description is a textual description of the image
+	Description string
+	// MIMEType is the MIME type of the image, detected from Data if empty
+	MIMEType string
+}
+
+// PictureType is the type of an embedded picture, as in [ImageDesc.Type]. These are the ID3v2 APIC picture types,
+// which FLAC, Ogg, and ASF share. MP4 has no picture types, so its images have an empty type.
+type PictureType string
+
+// Picture types, named as TagLib names them.
+const (
+	PictureOther              PictureType = "Other"
+	PictureFileIcon           PictureType = "File Icon"
+	PictureOtherFileIcon      PictureType = "Other File Icon"
+	PictureFrontCover         PictureType = "Front Cover"
+	PictureBackCover          PictureType = "Back Cover"
+	PictureLeafletPage        PictureType = "Leaflet Page"
+	PictureMedia              PictureType = "Media"
+	PictureLeadArtist         PictureType = "Lead Artist"
+	PictureArtist             PictureType = "Artist"
+	PictureConductor          PictureType = "Conductor"
+	PictureBand               PictureType = "Band"
+	PictureComposer           PictureType = "Composer"
+	PictureLyricist           PictureType = "Lyricist"
+	PictureRecordingLocation  PictureType = "Recording Location"
+	PictureDuringRecording    PictureType = "During Recording"
+	PictureDuringPerformance  PictureType = "During Performance"
+	PictureMovieScreenCapture PictureType = "Movie Screen Capture"
+	PictureColouredFish       PictureType = "Coloured Fish"
+	PictureIllustration       PictureType = "Illustration"
+	PictureBandLogo           PictureType = "Band Logo"
+	PicturePublisherLogo      PictureType = "Publisher Logo"
+)
+
+// ReadProperties reads the audio properties from a file at the given path.
+func ReadProperties(path string) (Properties, error) {
+	var err error
+	path, err = filepath.Abs(path)
+	if err != nil {
+		return Properties{}, fmt.Errorf("make path abs %w", err)
+	}
+
+	mod, err := newModuleRO(path)
+	if err != nil {
+		return Properties{}, fmt.Errorf("init module: %w", err)
+	}
+	defer mod.close()
+
+	// Opening a handle rather than reading the properties by path also gives the format TagLib detected
+	var result wasmOpenResult
+	if err := mod.call("taglib_file_open", &result, wasmString(wasmPath(path)), wasmUint8(ReadStyleAverage)); err != nil {
+		return Properties{}, fmt.Errorf("call: %w", err)
+	}
+	if result.handle == 0 {
+		return Properties{}, nil
+	}
+	format := FileFormat(result.format)
+
+	var raw wasmFileProperties
+	err = mod.call("taglib_handle_properties", &raw, wasmUint32(result.handle))
+	var out wasmBool
+	_ = mod.call("taglib_file_close", &out, wasmUint32(result.handle))
+	if err != nil {
+		return Properties{}, fmt.Errorf("call: %w", err)
+	}
+
+	images := parseImageDescs(raw.imageDescs)
+
+	props := Properties{
+		Length:        time.Duration(raw.lengthInMilliseconds) * time.Millisecond,
+		Channels:      uint(raw.channels),
+		SampleRate:    uint(raw.sampleRate),
+		Bitrate:       uint(raw.bitrate),
+		BitsPerSample: uint(raw.bitsPerSample),
+		Codec:         raw.codec,
+		ImageCount:    len(images),
+		Images:        images,
+	}
+
+	if f, err := os.Open(path); err == nil {
+		props.CompressionMode = compressionMode(f, format)
+		props.ChapterCount, props.CueTrackCount = embeddedCounts(f, format)
+		props.ChannelLayout = channelLayout(f, format, props.Channels)
+		props.HasVideo = format == FormatMP4 && mp4HasVideo(f)
+		_ = f.Close()
+	}
+	return props, nil
+}
+
+// embeddedCounts counts the ID3v2 chapters of MP3 files and the embedded cue sheet tracks of FLAC files
+// from r, without parsing the rest of the tags. Other formats have neither.
+func embeddedCounts(r io.ReaderAt, format FileFormat) (chapters, cueTracks int) {
+	switch format {
+	case FormatMPEG:
+		id3, _ := readID3v2Tag(r)
+		for _, id := range id3v2FrameIDs(id3) {
+			if id == "CHAP" {
+				chapters++
+			}
+		}
+	case FormatFLAC:
+		cueTracks = flacCueTrackCount(r, id3v2TagSize(r))
+	}
+	return chapters, cueTracks
+}
+
+// id3v2FrameIDs returns the IDs of the frames of an ID3v2.3 or ID3v2.4 tag in order.
+func id3v2FrameIDs(tag []byte) []string {
+	var ids []string
+	for _, frame := range id3v2Frames(tag) {
+		ids = append(ids, frame.id)
+	}
+	return ids
+}
+
+// id3v2Frame is a frame of an ID3v2 tag, as found by [id3v2Frames].
+type id3v2Frame struct {
+	id    string
+	flags [2]byte
+	start int // offset of the frame header in the tag
+	body  []byte
+}
+
+// id3v2Frames returns the frames of an ID3v2.3 or ID3v2.4 tag in order.
+func id3v2Frames(tag []byte) []id3v2Frame {
+	if len(tag) < 10 {
+		return nil
+	}
+	version, flags := tag[3], tag[5]
+	// Unsynchronised tags would need decoding first
+	if (version != 3 && version != 4) || flags&0x80 != 0 {
+		return nil
+	}
+
+	pos := 10
+	if flags&0x40 != 0 && len(tag) >= 14 {
+		// The extended header size includes itself in v2.4 but not in v2.3
+		if version == 3 {
+			pos += 4 + int(be32(tag[10:14]))
+		} else {
+			pos += syncsafe(tag[10:14])
+		}
+	}
+	return id3v2FrameList(tag, pos, version)
+}
+
+// id3v2FrameList returns the frames of an ID3v2.3 or ID3v2.4 tag of version in b from pos, up to the end of b or
+// the padding. Frames embedded in others, such as in CHAP frames, are laid out the same way.
+func id3v2FrameList(b []byte, pos int, version byte) []id3v2Frame {
+	var frames []id3v2Frame
+	for pos >= 0 && pos+10 <= len(b) && b[pos] != 0 {
+		size := int(be32(b[pos+4 : pos+8]))
+		if version == 4 {
+			size = syncsafe(b[pos+4 : pos+8])
+		}
+		frame := id3v2Frame{id: string(b[pos : pos+4]), flags: [2]byte{b[pos+8], b[pos+9]}, start: pos}
+		if size >= 0 && pos+10+size <= len(b) {
+			frame.body = b[pos+10 : pos+10+size]
+		}
+		frames = append(frames, frame)
+		pos += 10 + size
+	}
+	return frames
+}
+
+// flacCueTrackCount returns the number of tracks in the cue sheet of the FLAC stream at offset, not counting
+// the lead-out. The CUESHEET metadata block is preferred over a CUESHEET Vorbis comment.
+func flacCueTrackCount(r io.ReaderAt, offset int64) int {
+	const (
+		typeVorbisComment = 4
+		typeCueSheet      = 5
+		maxCommentSize    = 1 << 20
+	)
+
+	marker := make([]byte, 4)
+	if _, err := r.ReadAt(marker, offset); err != nil || string(marker) != "fLaC" {
+		return 0
+	}
+
+	var comment int
+	for pos := offset + 4; ; {
+		header := make([]byte, 4)
+		if _, err := r.ReadAt(header, pos); err != nil {
+			return comment
+		}
+		size := int64(header[1])<<16 | int64(header[2])<<8 | int64(header[3])
+
+		switch header[0] & 0x7F {
+		case typeCueSheet:
+			// The track count follows the catalog number, lead-in, and flags
+			count := make([]byte, 1)
+			if _, err := r.ReadAt(count, pos+4+395); err == nil && count[0] > 0 {
+				return int(count[0]) - 1
+			}
+		case typeVorbisComment:
+			if size > maxCommentSize {
+				break
+			}
+			body := make([]byte, size)
+			if _, err := r.ReadAt(body, pos+4); err == nil {
+				comment = cueSheetCommentTracks(body)
+			}
+		}
+
+		if header[0]&0x80 != 0 {
+			return comment
+		}
+		pos += 4 + size
+	}
+}
+
+// vendorString reads the vendor string of the Vorbis comment block of the FLAC or Ogg stream in r, which TagLib
+// doesn't expose. Reports false if there is none, or for other formats.
+func vendorString(r io.ReaderAt, format FileFormat) (string, bool) {
+	id3, _ := readID3v2Tag(r)
+	comment := vorbisCommentBlock(r, int64(len(id3)), format)
+	if len(comment) < 4 {
+		return "", false
+	}
+	n := le32(comment[:4])
+	if uint64(n) > uint64(len(comment)-4) {
+		return "", false
+	}
+	return string(comment[4 : 4+n]), true
+}
+
+// vorbisCommentBlock returns the start of the Vorbis comment block of the FLAC or Ogg stream of format at start,
+// from the vendor string length on, or nil if there is none.
+func vorbisCommentBlock(r io.ReaderAt, start int64, format FileFormat) []byte {
+	var comment []byte
+	switch format {
+	case FormatFLAC:
+		comment = flacVorbisComment(r, start)
+	case FormatOggVorbis:
+		comment, _ = bytes.CutPrefix(oggPacket(r, start, 1), []byte("\x03vorbis"))
+	case FormatOggOpus:
+		comment, _ = bytes.CutPrefix(oggPacket(r, start, 1), []byte("OpusTags"))
+	case FormatOggSpeex:
+		comment = oggPacket(r, start, 1)
+	case FormatOggFLAC:
+		// The comment packet is a metadata block, header included
+		if comment = oggPacket(r, start, 1); len(comment) >= 4 && comment[0]&0x7F == 4 {
+			comment = comment[4:]
+		} else {
+			comment = nil
+		}
+	}
+	return comment
+}
+
+// maxVendorRead bounds how much of a Vorbis comment block is read to find its vendor string, which comes first.
+const maxVendorRead = 1 << 16
+
+// flacVorbisComment returns the start of the body of the VORBIS_COMMENT block of the FLAC stream at offset.
+func flacVorbisComment(r io.ReaderAt, offset int64) []byte {
+	const typeVorbisComment = 4
+
+	marker := make([]byte, 4)
+	if _, err := r.ReadAt(marker, offset); err != nil || string(marker) != "fLaC" {
+		return nil
+	}
+	header := make([]byte, 4)
+	for pos := offset + 4; ; {
+		if _, err := r.ReadAt(header, pos); err != nil {
+			return nil
+		}
+		size := int64(header[1])<<16 | int64(header[2])<<8 | int64(header[3])
+		if header[0]&0x7F == typeVorbisComment {
+			body := make([]byte, min(size, maxVendorRead))
+			if _, err := r.ReadAt(body, pos+4); err != nil {
+				return nil
+			}
+			return body
+		}
+		if header[0]&0x80 != 0 {
+			return nil
+		}
+		pos += 4 + size
+	}
+}
+
+// OggStreamInfo holds information about the logical streams of an Ogg file.
+type OggStreamInfo struct {
+	SerialNumber   uint32 // The serial number of the first logical stream
+	LastGranulePos int64  // The granule position of the last page of the first logical stream, or -1 if none has one
+	Chained        bool   // Whether another logical stream follows the first, as in chained Ogg files
+}
+
+// ReadOggStreamInfo reads information about the logical streams of the Ogg file at path from its page headers.
+// The granule position of the last page is the number of samples, or for Opus 48kHz samples, up to the end of the
+// stream. Returns [ErrUnsupportedFormat] if the file isn't an Ogg file.
+func ReadOggStreamInfo(path string) (OggStreamInfo, error) {
+	format, err := DetectFormat(path)
+	if err != nil {
+		return OggStreamInfo{}, err
+	}
+	if !format.IsOgg() {
+		return OggStreamInfo{}, fmt.Errorf("%w: %s", ErrUnsupportedFormat, format)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return OggStreamInfo{}, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return OggStreamInfo{}, fmt.Errorf("stat: %w", err)
+	}
+	return oggStreamInfo(f, info.Size())
+}
+
+func oggStreamInfo(r io.ReaderAt, size int64) (OggStreamInfo, error) {
+	const (
+		flagBOS = 0x02 // beginning of stream
+		flagEOS = 0x04 // end of stream
+	)
+
+	id3, err := readID3v2Tag(r)
+	if err != nil {
+		return OggStreamInfo{}, err
+	}
+
+	info := OggStreamInfo{LastGranulePos: -1}
+	// Multiplexed streams all begin on the first pages, so a stream beginning after those is chained
+	var started, ended, pastBOS bool
+	header := make([]byte, 27+255)
+	for pos := int64(len(id3)); pos+27 <= size; {
+		if _, err := r.ReadAt(header[:27], pos); err != nil || string(header[:4]) != "OggS" {
+			if !started {
+				return OggStreamInfo{}, fmt.Errorf("read ogg page: %w", ErrInvalidFile)
+			}
+			break // Ignore anything trailing the pages
+		}
+		segments := int(header[26])
+		if _, err := r.ReadAt(header[27:27+segments], pos+27); err != nil {
+			break
+		}
+
+		flags, serial := header[5], le32(header[14:18])
+		switch {
+		case !started:
+			info.SerialNumber = serial
+		case flags&flagBOS == 0:
+			pastBOS = true
+		case pastBOS:
+			info.Chained = true
+		}
+		if serial == info.SerialNumber && !ended {
+			// -1 for pages where no packet ends
+			if granule := int64(uint64(le32(header[6:10])) | uint64(le32(header[10:14]))<<32); granule != -1 {
+				info.LastGranulePos = granule
+			}
+			ended = flags&flagEOS != 0
+		}
+		started = true
+
+		pos += 27 + int64(segments)
+		for _, seg := range header[27 : 27+segments] {
+			pos += int64(seg)
+		}
+	}
+	return info, nil
+}
+
+// oggPacket returns the start of the packet at index of the first logical stream of the Ogg file at offset,
+// reassembled from its pages. Returns nil if it can't be read.
+func oggPacket(r io.ReaderAt, offset int64, index int) []byte {
+	const maxPages = 64
+
+	var (
+		packet  []byte
+		serial  uint32
+		packets int
+	)
+	header := make([]byte, 27)
+	for page, pos := 0, offset; page < maxPages; page++ {
+		if _, err := r.ReadAt(header, pos); err != nil || string(header[:4]) != "OggS" {
+			return nil
+		}
+		lacing := make([]byte, header[26])
+		if _, err := r.ReadAt(lacing, pos+27); err != nil {
+			return nil
+		}
+		data := pos + 27 + int64(len(lacing))
+		if page == 0 {
+			serial = le32(header[14:18])
+		}
+
+		var size int64
+		for _, n := range lacing {
+			size += int64(n)
+		}
+		if le32(header[14:18]) != serial {
+			pos = data + size
+			continue
+		}
+
+		for _, n := range lacing {
+			if packets == index && len(packet) < maxVendorRead {
+				segment := make([]byte, n)
+				if _, err := r.ReadAt(segment, data); err != nil {
+					return nil
+				}
+				packet = append(packet, segment...)
+			}
+			data += int64(n)
+			if n < 255 {
+				if packets == index {
+					return packet
+				}
+				packets++
+			}
+		}
+		if packets == index && len(packet) >= maxVendorRead {
+			return packet
+		}
+		pos = data
+	}
+	return nil
+}
+
+// cueSheetCommentTracks returns the number of tracks in the CUESHEET comment of a Vorbis comment block.
+func cueSheetCommentTracks(block []byte) int {
+	if len(block) < 8 {
+		return 0
+	}
+	pos := 4 + int(le32(block))
+	if pos+4 > len(block) {
+		return 0
+	}
+	n := int(le32(block[pos:]))
+	pos += 4
+
+	for range n {
+		if pos+4 > len(block) {
+			return 0
+		}
+		size := int(le32(block[pos:]))
+		pos += 4
+		if size < 0 || pos+size > len(block) {
+			return 0
+		}
+		key, value, _ := strings.Cut(string(block[pos:pos+size]), "=")
+		pos += size
+		if !strings.EqualFold(key, "CUESHEET") {
+			continue
+		}
+
+		var tracks int
+		for line := range strings.Lines(value) {
+			if strings.HasPrefix(strings.ToUpper(strings.TrimSpace(line)), "TRACK ") {
+				tracks++
+			}
+		}
+		return tracks
+	}
+	return 0
+}
+
+// channelLayout describes the layout of the channels of the file of format in r, which has the given number of
+// channels. Returns an empty string if there are no channels.
+func channelLayout(r io.ReaderAt, format FileFormat, channels uint) string {
+	if channels == 0 {
+		return ""
+	}
+	if mask, ok := channelMask(r, format); ok {
+		// A zero mask means the channels are discrete, and a mask for other channels doesn't describe them
+		if mask != 0 && uint(bits.OnesCount32(mask)) == channels {
+			return maskLayout(mask)
+		}
+		return fmt.Sprintf("%d channels", channels)
+	}
+
+	switch {
+	case channels == 1:
+		return "mono"
+	case channels == 2:
+		return "stereo"
+	case channels <= 8 && hasDefaultLayouts(format):
+		return [...]string{3: "3.0", 4: "4.0", 5: "5.0", 6: "5.1", 7: "6.1", 8: "7.1"}[channels]
+	}
+	return fmt.Sprintf("%d channels", channels)
+}
+
+// hasDefaultLayouts reports whether files of format have a defined channel order for up to 8 channels, as FLAC,
+// Vorbis, Opus, and AAC do.
+func hasDefaultLayouts(format FileFormat) bool {
+	switch format {
+	case FormatFLAC, FormatMP4, FormatOggVorbis, FormatOggOpus, FormatOggFLAC:
+		return true
+	}
+	return false
+}
+
+// speakerLFE is the LFE speaker position of WAVE_FORMAT_EXTENSIBLE channel masks, which CoreAudio channel bitmaps share.
+const speakerLFE = 0x8
+
+// maskLayout describes a WAVE_FORMAT_EXTENSIBLE channel mask, as the number of full range channels and LFE channels
+// such as "5.1", or "mono" and "stereo".
+func maskLayout(mask uint32) string {
+	lfe := mask & speakerLFE
+	full := bits.OnesCount32(mask &^ speakerLFE)
+	switch {
+	case lfe == 0 && full == 1:
+		return "mono"
+	case lfe == 0 && full == 2:
+		return "stereo"
+	}
+	return fmt.Sprintf("%d.%d", full, bits.OnesCount32(lfe))
+}
+
+// channelMask reads the WAVE_FORMAT_EXTENSIBLE channel mask of the file of format in r, which TagLib doesn't expose.
+// This is the channel mask of the fmt chunk of WAV files, the WAVEFORMATEXTENSIBLE_CHANNEL_MASK Vorbis comment of FLAC
+// files, and the channel bitmap or layout of the chan box of MP4 files. Reports false if there is none.
+func channelMask(r io.ReaderAt, format FileFormat) (uint32, bool) {
+	start := id3v2TagSize(r)
+
+	switch format {
+	case FormatWAV:
+		pos, err := chunkOffset(r, start+12, "fmt ", le32)
+		if err != nil {
+			return 0, false
+		}
+		// WAVEFORMATEXTENSIBLE has the format tag 0xFFFE and the mask after the extension size and valid bits
+		header := make([]byte, 24)
+		if _, err := r.ReadAt(header, pos); err != nil || le16(header) != 0xFFFE {
+			return 0, false
+		}
+		return le32(header[20:]), true
+	case FormatFLAC:
+		comment := flacVorbisComment(r, start)
+		value, ok := vorbisCommentValue(comment, "WAVEFORMATEXTENSIBLE_CHANNEL_MASK")
+		if !ok {
+			return 0, false
+		}
+		mask, err := strconv.ParseUint(strings.TrimPrefix(strings.ToLower(value), "0x"), 16, 32)
+		return uint32(mask), err == nil
+	case FormatMP4:
+		return mp4ChannelMask(r, start)
+	}
+	return 0, false
+}
+
+// vorbisCommentValue returns the value of the first comment with key of the Vorbis comment block, matching the key
+// case-insensitively. Reports false if there is none.
+func vorbisCommentValue(block []byte, key string) (string, bool) {
+	for _, field := range vorbisCommentFields(block) {
+		if k, value, _ := strings.Cut(string(field), "="); strings.EqualFold(k, key) {
+			return value, true
+		}
+	}
+	return "", false
+}
+
+// vorbisCommentFields returns the "KEY=value" fields of the Vorbis comment block, from the vendor string length
+// on. Fields cut off by the end of block are left out.
+func vorbisCommentFields(block []byte) [][]byte {
+	if len(block) < 8 {
+		return nil
+	}
+	pos := 4 + int(le32(block))
+	if pos < 0 || pos+4 > len(block) {
+		return nil
+	}
+	n := int(le32(block[pos:]))
+	pos += 4
+
+	var fields [][]byte
+	for range n {
+		if pos+4 > len(block) {
+			break
+		}
+		size := int(le32(block[pos:]))
+		pos += 4
+		if size < 0 || pos+size > len(block) {
+			break
+		}
+		fields = append(fields, block[pos:pos+size])
+		pos += size
+	}
+	return fields
+}
+
+// mp4ChannelMask reads the channel layout of the chan box of the first audio sample entry that has one of the MP4
+// stream at pos, as a WAVE_FORMAT_EXTENSIBLE channel mask. Layouts described by channel descriptions, and layout tags
+// other than the common MPEG ones, aren't supported.
+func mp4ChannelMask(r io.ReaderAt, pos int64) (uint32, bool) {
+	const (
+		layoutUseBitmap = 1 << 16
+		maxSampleEntry  = 1 << 16
+	)
+
+	moov, moovEnd, ok := mp4Box(r, pos, math.MaxInt64, "moov")
+	if !ok {
+		return 0, false
+	}
+	for trak, trakEnd := moov, moov; ; trak = trakEnd {
+		if trak, trakEnd, ok = mp4Box(r, trak, moovEnd, "trak"); !ok {
+			return 0, false
+		}
+		stsd, stsdEnd, ok := mp4Path(r, trak, trakEnd, "mdia", "minf", "stbl", "stsd")
+		if !ok || stsdEnd-stsd < 16 {
+			continue
+		}
+
+		// The first sample entry follows the version, flags, and entry count
+		entry := make([]byte, min(stsdEnd-stsd-8, maxSampleEntry))
+		if _, err := r.ReadAt(entry, stsd+8); err != nil || len(entry) < 36 {
+			continue
+		}
+		entry = entry[:min(int64(be32(entry)), int64(len(entry)))]
+
+		// The child boxes follow the audio sample entry fields, which QuickTime extends in versions 1 and 2
+		children := 36
+		switch be16(entry[16:]) {
+		case 1:
+			children += 16
+		case 2:
+			children += 36
+		}
+		for children+8 <= len(entry) {
+			size := int(be32(entry[children:]))
+			if size < 8 || children+size > len(entry) {
+				break
+			}
+			if body := entry[children+8 : children+size]; string(entry[children+4:children+8]) == "chan" && len(body) >= 12 {
+				// After the version and flags, the layout tag and bitmap
+				switch tag := be32(body[4:]); {
+				case tag == layoutUseBitmap:
+					return be32(body[8:]), true
+				case tag>>16 != 0:
+					mask, ok := coreAudioLayoutMasks[tag>>16]
+					return mask, ok
+				}
+				return 0, false
+			}
+			children += size
+		}
+	}
+}
+
+// mp4HasVideo reports whether the MP4 file in r has a track with the video handler type.
+func mp4HasVideo(r io.ReaderAt) bool {
+	moov, moovEnd, ok := mp4Box(r, 0, math.MaxInt64, "moov")
+	if !ok {
+		return false
+	}
+	for trak, trakEnd := moov, moov; ; trak = trakEnd {
+		if trak, trakEnd, ok = mp4Box(r, trak, moovEnd, "trak"); !ok {
+			return false
+		}
+		// After the version, flags, and predefined field
+		hdlr, hdlrEnd, ok := mp4Path(r, trak, trakEnd, "mdia", "hdlr")
+		handler := make([]byte, 4)
+		if ok && hdlrEnd-hdlr >= 12 {
+			if _, err := r.ReadAt(handler, hdlr+8); err == nil && string(handler) == "vide" {
+				return true
+			}
+		}
+	}
+}
+
+// coreAudioLayoutMasks are the channel masks of common CoreAudio channel layout tags, by the upper 16 bits.
+var coreAudioLayoutMasks = map[uint32]uint32{
+	100: 0x4,   // Mono
+	101: 0x3,   // Stereo
+	113: 0x7,   // MPEG 3.0 A
+	114: 0x7,   // MPEG 3.0 B
+	115: 0x107, // MPEG 4.0 A
+	116: 0x107, // MPEG 4.0 B
+	117: 0x37,  // MPEG 5.0 A
+	118: 0x37,  // MPEG 5.0 B
+	119: 0x37,  // MPEG 5.0 C
+	120: 0x37,  // MPEG 5.0 D
+	121: 0x3F,  // MPEG 5.1 A
+	122: 0x3F,  // MPEG 5.1 B
+	123: 0x3F,  // MPEG 5.1 C
+	124: 0x3F,  // MPEG 5.1 D
+	125: 0x13F, // MPEG 6.1 A
+	126: 0xFF,  // MPEG 7.1 A
+	127: 0x63F, // MPEG 7.1 B
+	128: 0x63F, // MPEG 7.1 C
+}
+
+// mp4Path returns the content of the box found by following path from the boxes between pos and end.
+func mp4Path(r io.ReaderAt, pos, end int64, path ...string) (int64, int64, bool) {
+	for _, typ := range path {
+		var ok bool
+		if pos, end, ok = mp4Box(r, pos, end, typ); !ok {
+			return 0, 0, false
+		}
+	}
+	return pos, end, true
+}
+
+// mp4Genres returns the values of the free-form ©gen item of the MP4 file. TagLib maps a legacy numeric gnre item
+// to GENRE too, and keeps whichever of the two comes first, so the ©gen text is lost behind a gnre before it.
+// Reports false if the file isn't an MP4 file or has no ©gen item.
+func mp4Genres(r io.ReaderAt) ([]string, bool) {
+	head := make([]byte, 8)
+	if _, err := r.ReadAt(head, 0); err != nil || string(head[4:]) != "ftyp" {
+		return nil, false
+	}
+	meta, metaEnd, ok := mp4Path(r, 0, math.MaxInt64, "moov", "udta", "meta")
+	if !ok {
+		return nil, false
+	}
+	// After the version and flags of the meta box
+	pos, end, ok := mp4Path(r, meta+4, metaEnd, "ilst", "\xa9gen")
+	if !ok {
+		return nil, false
+	}
+
+	var genres []string
+	for {
+		typ, start, next, ok := mp4NextBox(r, pos, end)
+		if !ok {
+			break
+		}
+		// After the type and locale of the data atom
+		if typ == "data" && next-start >= 8 && next-start <= 1<<20 {
+			value := make([]byte, next-start-8)
+			if _, err := r.ReadAt(value, start+8); err == nil {
+				genres = append(genres, string(value))
+			}
+		}
+		pos = next
+	}
+	return genres, len(genres) > 0
+}
+
+// mp4ItemData returns the value of the data atom of the iTunes metadata item name, such as "rtng".
+func mp4ItemData(r io.ReaderAt, name string) ([]byte, bool) {
+	meta, metaEnd, ok := mp4Path(r, 0, math.MaxInt64, "moov", "udta", "meta")
+	if !ok {
+		return nil, false
+	}
+	// After the version and flags of the meta box, and the type and locale of the data atom
+	data, dataEnd, ok := mp4Path(r, meta+4, metaEnd, "ilst", name, "data")
+	if !ok || dataEnd-data < 8 || dataEnd-data > 1<<20 {
+		return nil, false
+	}
+	value := make([]byte, dataEnd-data-8)
+	if _, err := r.ReadAt(value, data+8); err != nil {
+		return nil, false
+	}
+	return value, true
+}
+
+// mp4Box returns the start and end of the content of the first MP4 box of typ between pos and end.
+func mp4Box(r io.ReaderAt, pos, end int64, typ string) (int64, int64, bool) {
+	for {
+		boxTyp, start, next, ok := mp4NextBox(r, pos, end)
+		if !ok {
+			return 0, 0, false
+		}
+		if boxTyp == typ {
+			return start, next, true
+		}
+		pos = next
+	}
+}
+
+// mp4NextBox returns the type of the MP4 box at pos, and the start and end of its content, if it starts before end.
+func mp4NextBox(r io.ReaderAt, pos, end int64) (string, int64, int64, bool) {
+	if pos+8 > end {
+		return "", 0, 0, false
+	}
+	header := make([]byte, 16)
+	if _, err := r.ReadAt(header[:8], pos); err != nil {
+		return "", 0, 0, false
+	}
+	size, headerLen := int64(be32(header)), int64(8)
+	if size == 1 {
+		// 64 bit size
+		if _, err := r.ReadAt(header[8:], pos+8); err != nil {
+			return "", 0, 0, false
+		}
+		size, headerLen = int64(be32(header[8:]))<<32|int64(be32(header[12:])), 16
+	}
+	if size < headerLen {
+		return "", 0, 0, false
+	}
+	return string(header[4:8]), pos + headerLen, min(pos+size, end), true
+}
+
+// mp4ItemName returns the name of the iTunes metadata item box of typ between pos and end as TagLib reports it, with
+// the type read as Latin-1 like "©ART", and freeform items as "----:" followed by their mean and name.
+func mp4ItemName(r io.ReaderAt, typ string, pos, end int64) string {
+	if typ != "----" {
+		runes := make([]rune, len(typ))
+		for i := range len(typ) {
+			runes[i] = rune(typ[i])
+		}
+		return string(runes)
+	}
+	field := func(typ string) string {
+		start, end, ok := mp4Box(r, pos, end, typ)
+		// After the version and flags
+		if !ok || end-start < 4 || end-start > 1<<10 {
+			return ""
+		}
+		b := make([]byte, end-start-4)
+		if _, err := r.ReadAt(b, start+4); err != nil {
+			return ""
+		}
+		return string(b)
+	}
+	return "----:" + field("mean") + ":" + field("name")
+}
+
+// ReadAudioOffset returns the byte offset of the audio data of the file at path, after any leading tags and metadata,
+// for example to skip them when serving a range of the audio. For MP3 this is the first frame after the ID3v2 tag,
+// for FLAC the first frame after the metadata blocks, for WAV and AIFF the samples of the data chunk, and for MP4 the
+// content of the mdat box. For formats that interleave metadata with the audio, such as Ogg, and formats not listed
+// here, this is where the stream starts after any ID3v2 tag, usually 0.
+func ReadAudioOffset(path string) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return audioOffset(f)
+}
+
+func audioOffset(r io.ReaderAt) (int64, error) {
+	id3, err := readID3v2Tag(r)
+	if err != nil {
+		return 0, err
+	}
+	start := int64(len(id3))
+
+	head := make([]byte, 12)
+	n, err := r.ReadAt(head, start)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return 0, fmt.Errorf("read header: %w", err)
+	}
+	head = head[:n]
+
+	switch {
+	case bytes.HasPrefix(head, []byte("fLaC")):
+		pos := start + 4
+		header := make([]byte, 4)
+		for {
+			if _, err := r.ReadAt(header, pos); err != nil {
+				return 0, fmt.Errorf("read flac metadata: %w", err)
+			}
+			pos += 4 + (int64(header[1])<<16 | int64(header[2])<<8 | int64(header[3]))
+			if header[0]&0x80 != 0 {
+				return pos, nil
+			}
+		}
+	case len(head) == 12 && string(head[:4]) == "RIFF" && string(head[8:]) == "WAVE":
+		return chunkOffset(r, start+12, "data", le32)
+	case len(head) == 12 && string(head[:4]) == "FORM" && (string(head[8:]) == "AIFF" || string(head[8:]) == "AIFC"):
+		pos, err := chunkOffset(r, start+12, "SSND", be32)
+		if err != nil {
+			return 0, err
+		}
+		// The samples follow the offset and block size fields, skipping the number of bytes given by the offset
+		offset := make([]byte, 4)
+		if _, err := r.ReadAt(offset, pos); err != nil {
+			return 0, fmt.Errorf("read ssnd chunk: %w", err)
+		}
+		return pos + 8 + int64(be32(offset)), nil
+	case len(head) >= 8 && string(head[4:8]) == "ftyp":
+		return mdatOffset(r, start)
+	case len(head) >= 4 && slices.Contains([]string{"OggS", "MAC ", "wvpk", "TTA1", "MPCK"}, string(head[:4])):
+		return start, nil
+	}
+
+	// MP3 frames may follow some junk after the tag
+	buf := make([]byte, 1<<16)
+	n, err = r.ReadAt(buf, start)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return 0, fmt.Errorf("read frames: %w", err)
+	}
+	for pos := 0; pos+4 <= n; pos++ {
+		if isMPEGFrameHeader(buf[pos : pos+4]) {
+			if pos == 0 || len(id3) > 0 {
+				return start + int64(pos), nil
+			}
+			break
+		}
+	}
+	return start, nil
+}
+
+// chunkOffset returns the offset of the content of the first RIFF or IFF chunk with id, starting at pos.
+// size decodes the chunk sizes, which are little-endian for RIFF and big-endian for IFF.
+func chunkOffset(r io.ReaderAt, pos int64, id string, size func([]byte) uint32) (int64, error) {
+	header := make([]byte, 8)
+	for {
+		if _, err := r.ReadAt(header, pos); err != nil {
+			return 0, fmt.Errorf("find %s chunk: %w", id, err)
+		}
+		if string(header[:4]) == id {
+			return pos + 8, nil
+		}
+		// Chunks are padded to an even size
+		n := int64(size(header[4:]))
+		pos += 8 + n + n&1
+	}
+}
+
+// mdatOffset returns the offset of the content of the top-level MP4 mdat box, starting at pos.
+func mdatOffset(r io.ReaderAt, pos int64) (int64, error) {
+	header := make([]byte, 16)
+	for {
+		if _, err := r.ReadAt(header[:8], pos); err != nil {
+			return 0, fmt.Errorf("find mdat box: %w", err)
+		}
+		size, headerLen := int64(be32(header)), int64(8)
+		if size == 1 {
+			// 64 bit size
+			if _, err := r.ReadAt(header[8:], pos+8); err != nil {
+				return 0, fmt.Errorf("find mdat box: %w", err)
+			}
+			size, headerLen = int64(be32(header[8:]))<<32|int64(be32(header[12:])), 16
+		}
+		if string(header[4:8]) == "mdat" {
+			return pos + headerLen, nil
+		}
+		if size < headerLen {
+			return 0, fmt.Errorf("find mdat box: %w", ErrInvalidFile)
+		}
+		pos += size
+	}
+}
+
+// AudioHash returns a SHA-256 hash of the audio data of the file at path, leaving out tags and other metadata, so
+// that files that differ only in their tags or images have the same hash. The audio data runs from
+// [ReadAudioOffset] up to any tags at the end of the file, such as ID3v1 and APE tags, or to the end of the data
+// chunk for WAV and AIFF and of the mdat box for MP4. For Ogg, the pages after the header packets are hashed without
+// their page headers, which change when the tags do. ASF, DSF, DSDIFF, Matroska, and Shorten files aren't supported.
+func AudioHash(path string) ([]byte, error) {
+	format, err := DetectFormat(path)
+	if err != nil {
+		return nil, err
+	}
+	switch format {
+	case FormatUnknown, FormatASF, FormatDSF, FormatDSDIFF, FormatMatroska, FormatShorten:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedFormat, format)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("stat: %w", err)
+	}
+
+	h := sha256.New()
+	if format.IsOgg() {
+		if err := hashOggAudio(h, f, info.Size()); err != nil {
+			return nil, err
+		}
+		return h.Sum(nil), nil
+	}
+
+	start, end, err := audioRange(f, format, info.Size())
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(h, io.NewSectionReader(f, start, max(end-start, 0))); err != nil {
+		return nil, fmt.Errorf("read audio: %w", err)
+	}
+	return h.Sum(nil), nil
+}
+
+// MetadataHash returns a SHA-256 hash of everything in the file at path other than the audio data hashed by
+// [AudioHash], such as the tags, images, and container headers, so that it changes whenever the metadata does.
+// Together with AudioHash this tells whether the audio, the metadata, or both of a file changed. For Ogg, the content
+// of the pages of the header packets is hashed, without the page headers. The same formats as for AudioHash are
+// supported. Note that container headers can change along with the audio, such as the sizes in a WAV header.
+func MetadataHash(path string) ([]byte, error) {
+	format, err := DetectFormat(path)
+	if err != nil {
+		return nil, err
+	}
+	switch format {
+	case FormatUnknown, FormatASF, FormatDSF, FormatDSDIFF, FormatMatroska, FormatShorten:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedFormat, format)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("stat: %w", err)
+	}
+
+	h := sha256.New()
+	if format.IsOgg() {
+		if err := hashOggMetadata(h, f, info.Size()); err != nil {
+			return nil, err
+		}
+		return h.Sum(nil), nil
+	}
+
+	start, end, err := audioRange(f, format, info.Size())
+	if err != nil {
+		return nil, err
+	}
+	end = max(start, end)
+	if _, err := io.Copy(h, io.NewSectionReader(f, 0, start)); err != nil {
+		return nil, fmt.Errorf("read metadata: %w", err)
+	}
+	if _, err := io.Copy(h, io.NewSectionReader(f, end, info.Size()-end)); err != nil {
+		return nil, fmt.Errorf("read metadata: %w", err)
+	}
+	return h.Sum(nil), nil
+}
+
+// audioRange returns the start and end of the audio data of a file of format and size, as described by [AudioHash].
+func audioRange(r io.ReaderAt, format FileFormat, size int64) (int64, int64, error) {
+	id3, err := readID3v2Tag(r)
+	if err != nil {
+		return 0, 0, err
+	}
+	base := int64(len(id3))
+	chunkSize := make([]byte, 4)
+
+	switch format {
+	case FormatWAV:
+		pos, err := chunkOffset(r, base+12, "data", le32)
+		if err != nil {
+			return 0, 0, err
+		}
+		if _, err := r.ReadAt(chunkSize, pos-4); err != nil {
+			return 0, 0, fmt.Errorf("read data chunk: %w", err)
+		}
+		return pos, min(pos+int64(le32(chunkSize)), size), nil
+	case FormatAIFF:
+		pos, err := chunkOffset(r, base+12, "SSND", be32)
+		if err != nil {
+			return 0, 0, err
+		}
+		// The samples follow the offset and block size fields, skipping the number of bytes given by the offset
+		header := make([]byte, 8)
+		if _, err := r.ReadAt(header, pos-4); err != nil {
+			return 0, 0, fmt.Errorf("read ssnd chunk: %w", err)
+		}
+		return pos + 8 + int64(be32(header[4:])), min(pos+int64(be32(header)), size), nil
+	case FormatMP4:
+		start, end, ok := mp4Box(r, base, size, "mdat")
+		if !ok {
+			return 0, 0, fmt.Errorf("find mdat box: %w", ErrInvalidFile)
+		}
+		return start, end, nil
+	}
+
+	start, err := audioOffset(r)
+	if err != nil {
+		return 0, 0, err
+	}
+	end := size
+	for _, loc := range tagLocations(r, size) {
+		if loc.Start >= start {
+			end = min(end, loc.Start)
+		}
+	}
+	return start, end, nil
+}
+
+// hashOggAudio writes the content of the audio pages of r to h, in order, leaving out the pages of the header packets
+// of each logical stream. Header pages have a granule position of 0, or of -1 for those of a comment packet
+// continuing onto further pages, so a stream's audio starts after its last page with a granule position of 0 before
+// one with any other. Each link of a chained file starts new logical streams, so the audio of every link is hashed.
+func hashOggAudio(h hash.Hash, r io.ReaderAt, size int64) error {
+	id3, err := readID3v2Tag(r)
+	if err != nil {
+		return err
+	}
+
+	type page struct{ pos, n int64 }
+	type oggStream struct {
+		audio   bool
+		pending []page // Pages with a granule position of -1 that may start the audio
+	}
+	streams := map[uint32]*oggStream{}
+	write := func(p page) error {
+		if _, err := io.Copy(h, io.NewSectionReader(r, p.pos, p.n)); err != nil {
+			return fmt.Errorf("read ogg page: %w", err)
+		}
+		return nil
+	}
+
+	header := make([]byte, 27+255)
+	for pos := int64(len(id3)); pos+27 <= size; {
+		if _, err := r.ReadAt(header[:27], pos); err != nil {
+			return fmt.Errorf("read ogg page: %w", err)
+		}
+		if string(header[:4]) != "OggS" {
+			return fmt.Errorf("read ogg page: %w", ErrInvalidFile)
+		}
+		segments := int(header[26])
+		if _, err := r.ReadAt(header[27:27+segments], pos+27); err != nil {
+			return fmt.Errorf("read ogg page: %w", err)
+		}
+		var n int64
+		for _, seg := range header[27 : 27+segments] {
+			n += int64(seg)
+		}
+		p := page{pos + 27 + int64(segments), n}
+		pos = p.pos + n
+
+		// A beginning of stream page starts a new logical stream, even if it reuses the serial number of one before
+		serial := le32(header[14:18])
+		stream := streams[serial]
+		if stream == nil || header[5]&0x02 != 0 {
+			stream = &oggStream{}
+			streams[serial] = stream
+		}
+
+		granuleLow, granuleHigh := le32(header[6:10]), le32(header[10:14])
+		switch {
+		case stream.audio:
+			if err := write(p); err != nil {
+				return err
+			}
+		case granuleLow == 0 && granuleHigh == 0:
+			stream.pending = nil
+		case granuleLow == math.MaxUint32 && granuleHigh == math.MaxUint32:
+			stream.pending = append(stream.pending, p)
+		default:
+			stream.audio = true
+			for _, p := range append(stream.pending, p) {
+				if err := write(p); err != nil {
+					return err
+				}
+			}
+			stream.pending = nil
+		}
+	}
+	return nil
+}
+
+// hashOggMetadata writes any ID3v2 tag of r and the content of the Ogg pages that [hashOggAudio] leaves out to h:
+// those up to the last page with a granule position of 0.
+func hashOggMetadata(h hash.Hash, r io.ReaderAt, size int64) error {
+	id3, err := readID3v2Tag(r)
+	if err != nil {
+		return err
+	}
+	h.Write(id3)
+
+	type page struct{ body, n int64 }
+	var pages []page
+	headers := 0
+	header := make([]byte, 27+255)
+	for pos := int64(len(id3)); pos+27 <= size; {
+		if _, err := r.ReadAt(header[:27], pos); err != nil {
+			return fmt.Errorf("read ogg page: %w", err)
+		}
+		if string(header[:4]) != "OggS" {
+			return fmt.Errorf("read ogg page: %w", ErrInvalidFile)
+		}
+		segments := int(header[26])
+		if _, err := r.ReadAt(header[27:27+segments], pos+27); err != nil {
+			return fmt.Errorf("read ogg page: %w", err)
+		}
+		var n int64
+		for _, seg := range header[27 : 27+segments] {
+			n += int64(seg)
+		}
+
+		body := pos + 27 + int64(segments)
+		pages = append(pages, page{body, n})
+		if le32(header[6:10]) == 0 && le32(header[10:14]) == 0 { // granule position
+			headers = len(pages)
+		}
+		pos = body + n
+	}
+
+	for _, p := range pages[:headers] {
+		if _, err := io.Copy(h, io.NewSectionReader(r, p.body, p.n)); err != nil {
+			return fmt.Errorf("read ogg page: %w", err)
+		}
+	}
+	return nil
+}
+
+// isMPEGFrameHeader reports whether b starts with a valid MPEG audio frame header.
+func isMPEGFrameHeader(b []byte) bool {
+	return b[0] == 0xFF && b[1]&0xE0 == 0xE0 &&
+		b[1]>>3&3 != 1 && // version
+		b[1]>>1&3 != 0 && // layer
+		b[2]>>4 != 0xF && // bitrate
+		b[2]>>2&3 != 3 // sample rate
+}
+
+// TagKind is the kind of a tag block found by [TagLocations].
+type TagKind string
+
+const (
+	TagID3v2   TagKind = "ID3v2"
+	TagID3v1   TagKind = "ID3v1"
+	TagAPE     TagKind = "APE"
+	TagLyrics3 TagKind = "Lyrics3v2"
+)
+
+// TagLocation is the position of a tag block in a file, from Start up to but not including End.
+type TagLocation struct {
+	Kind  TagKind
+	Start int64
+	End   int64
+}
+
+// TagLocations returns the tag blocks found at the start and end of the file at path, in file order.
+// It looks for ID3v2 tags at the start, including repeated ones, and for ID3v1, APE, Lyrics3v2, and ID3v2 tags
+// with a footer at the end, in any order. This is meant for diagnosing files with tags in unusual places and
+// doesn't depend on the format of the file. Tags embedded in the audio container, such as FLAC Vorbis comments or
+// MP4 atoms, aren't reported.
+func TagLocations(path string) ([]TagLocation, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("stat: %w", err)
+	}
+	return tagLocations(f, info.Size()), nil
+}
+
+func tagLocations(r io.ReaderAt, size int64) []TagLocation {
+	var locs []TagLocation
+
+	start := int64(0)
+	header := make([]byte, 10)
+	for start+10 <= size {
+		if _, err := r.ReadAt(header, start); err != nil || !isID3v2Header(header, "ID3") {
+			break
+		}
+		n := 10 + int64(syncsafe(header[6:10]))
+		if header[5]&0x10 != 0 {
+			n += 10 // Footer
+		}
+		locs = append(locs, TagLocation{Kind: TagID3v2, Start: start, End: min(start+n, size)})
+		start += n
+	}
+
+	// Trailing tags can come in any order, so keep peeling them off the end
+	end := size
+	for end > start {
+		loc, ok := trailingTag(r, start, end)
+		if !ok {
+			break
+		}
+		locs = append(locs, loc)
+		end = loc.Start
+	}
+
+	slices.SortFunc(locs, func(a, b TagLocation) int { return cmp.Compare(a.Start, b.Start) })
+	return locs
+}
+
+// trailingTag returns the tag ending at end, which doesn't extend before start.
+func trailingTag(r io.ReaderAt, start, end int64) (TagLocation, bool) {
+	tail := make([]byte, min(end-start, 32))
+	if _, err := r.ReadAt(tail, end-int64(len(tail))); err != nil {
+		return TagLocation{}, false
+	}
+
+	var loc TagLocation
+	switch {
+	case end-start >= 128 && tagID3v1At(r, end-128):
+		loc = TagLocation{Kind: TagID3v1, Start: end - 128}
+	case len(tail) == 32 && string(tail[:8]) == "APETAGEX":
+		// The size counts the items and footer, and the header if the flags say there is one
+		n := int64(le32(tail[12:16]))
+		if le32(tail[20:24])&(1<<31) != 0 {
+			n += 32
+		}
+		loc = TagLocation{Kind: TagAPE, Start: end - n}
+	case len(tail) >= 10 && isID3v2Header(tail[len(tail)-10:], "3DI"):
+		loc = TagLocation{Kind: TagID3v2, Start: end - 20 - int64(syncsafe(tail[len(tail)-4:]))}
+	case len(tail) >= 15 && string(tail[len(tail)-9:]) == "LYRICS200":
+		// The size before the end marker counts everything from LYRICSBEGIN, but not itself or the marker
+		n, err := strconv.Atoi(string(tail[len(tail)-15 : len(tail)-9]))
+		if err != nil {
+			return TagLocation{}, false
+		}
+		loc = TagLocation{Kind: TagLyrics3, Start: end - 15 - int64(n)}
+	default:
+		return TagLocation{}, false
+	}
+
+	if loc.Start < start {
+		return TagLocation{}, false
+	}
+	loc.End = end
+	return loc, true
+}
+
+// tagID3v1At reports whether there is an ID3v1 tag at pos.
+func tagID3v1At(r io.ReaderAt, pos int64) bool {
+	magic := make([]byte, 3)
+	_, err := r.ReadAt(magic, pos)
+	return err == nil && string(magic) == "TAG"
+}
+
+// TagSizes returns the size in bytes of each tag system present in the file at path, as serialized in the file,
+// such as for finding files that waste space on padding. ID3v2 tags count their header and padding, and are found
+// at the start and end of the file as with [TagLocations], and in the chunks of WAV and AIFF files. For FLAC,
+// [TagSystemXiph] counts the Vorbis comment, picture, and padding metadata blocks, and for MP4, [TagSystemMP4] counts
+// the udta box. Sizes of tags from more than one block, such as repeated ID3v2 tags, are added up. Tags inside Ogg
+// packets and other containers aren't reported.
+func TagSizes(path string) (map[TagSystem]int64, error) {
+	format, err := DetectFormat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("stat: %w", err)
+	}
+	size := info.Size()
+
+	sizes := map[TagSystem]int64{}
+	for _, loc := range tagLocations(f, size) {
+		switch loc.Kind {
+		case TagID3v2:
+			sizes[TagSystemID3v2] += loc.End - loc.Start
+		case TagID3v1:
+			sizes[TagSystemID3v1] += loc.End - loc.Start
+		case TagAPE:
+			sizes[TagSystemAPE] += loc.End - loc.Start
+		}
+	}
+
+	id3, err := readID3v2Tag(f)
+	if err != nil {
+		return nil, err
+	}
+	start := int64(len(id3))
+
+	switch format {
+	case FormatFLAC:
+		if n := flacTagBlocksSize(f, start); n > 0 {
+			sizes[TagSystemXiph] += n
+		}
+	case FormatMP4:
+		if moov, moovEnd, ok := mp4Box(f, start, size, "moov"); ok {
+			for pos := moov; ; {
+				typ, _, next, ok := mp4NextBox(f, pos, moovEnd)
+				if !ok {
+					break
+				}
+				if typ == "udta" {
+					sizes[TagSystemMP4] += next - pos
+				}
+				pos = next
+			}
+		}
+	case FormatWAV:
+		riffTagSizes(f, start+12, size, le32, sizes)
+	case FormatAIFF:
+		riffTagSizes(f, start+12, size, be32, sizes)
+	}
+	return sizes, nil
+}
+
+// flacTagBlocksSize returns the size of the Vorbis comment, picture, and padding blocks of the FLAC stream at offset,
+// block headers included.
+func flacTagBlocksSize(r io.ReaderAt, offset int64) int64 {
+	const (
+		typePadding       = 1
+		typeVorbisComment = 4
+		typePicture       = 6
+	)
+
+	marker := make([]byte, 4)
+	if _, err := r.ReadAt(marker, offset); err != nil || string(marker) != "fLaC" {
+		return 0
+	}
+	var total int64
+	header := make([]byte, 4)
+	for pos := offset + 4; ; {
+		if _, err := r.ReadAt(header, pos); err != nil {
+			return total
+		}
+		size := int64(header[1])<<16 | int64(header[2])<<8 | int64(header[3])
+		switch header[0] & 0x7F {
+		case typePadding, typeVorbisComment, typePicture:
+			total += 4 + size
+		}
+		if header[0]&0x80 != 0 {
+			return total
+		}
+		pos += 4 + size
+	}
+}
+
+// riffTagSizes adds the sizes of the ID3v2 chunks and INFO list chunk of the WAV or AIFF file with its chunks from
+// pos to end to sizes, chunk headers included.
+func riffTagSizes(r io.ReaderAt, pos, end int64, size func([]byte) uint32, sizes map[TagSystem]int64) {
+	header := make([]byte, 12)
+	for pos+8 <= end {
+		if _, err := r.ReadAt(header[:8], pos); err != nil {
+			return
+		}
+		// Chunks are padded to an even size
+		n := int64(size(header[4:8]))
+		n += n & 1
+		switch string(header[:4]) {
+		case "id3 ", "ID3 ":
+			sizes[TagSystemID3v2] += 8 + n
+		case "LIST":
+			if _, err := r.ReadAt(header[8:], pos+8); err == nil && string(header[8:]) == "INFO" {
+				sizes[TagSystemRIFFInfo] += 8 + n
+			}
+		}
+		pos += 8 + n
+	}
+}
+
+// isID3v2Header reports whether b is a plausible ID3v2 header or footer starting with magic.
+func isID3v2Header(b []byte, magic string) bool {
+	return string(b[:3]) == magic && b[3] < 0xFF && b[4] < 0xFF &&
+		b[6]&0x80 == 0 && b[7]&0x80 == 0 && b[8]&0x80 == 0 && b[9]&0x80 == 0
+}
+
+// compressionMode reads the compression mode of APE and WavPack files from r, which TagLib doesn't expose.
+// Returns an empty string for other formats.
+func compressionMode(r io.ReaderAt, format FileFormat) string {
+	if format != FormatAPE && format != FormatWavPack {
+		return ""
+	}
+	start := id3v2TagSize(r)
+
+	magic := make([]byte, 4)
+	if _, err := r.ReadAt(magic, start); err != nil {
+		return ""
+	}
+	switch string(magic) {
+	case "MAC ":
+		return apeCompressionMode(r, start)
+	case "wvpk":
+		return wavPackCompressionMode(r, start)
+	}
+	return ""
+}
+
+func apeCompressionMode(r io.ReaderAt, start int64) string {
+	desc := make([]byte, 12)
+	if _, err := r.ReadAt(desc, start); err != nil {
+		return ""
+	}
+
+	// Since 3.98 the header follows a descriptor of variable length, before it follows the version
+	levelAt := start + 6
+	if version := le16(desc[4:]); version >= 3980 {
+		levelAt = start + int64(le32(desc[8:]))
+	}
+	level := make([]byte, 2)
+	if _, err := r.ReadAt(level, levelAt); err != nil {
+		return ""
+	}
+
+	switch le16(level) {
+	case 1000:
+		return "fast"
+	case 2000:
+		return "normal"
+	case 3000:
+		return "high"
+	case 4000:
+		return "extra high"
+	case 5000:
+		return "insane"
+	}
+	return ""
+}
+
+func wavPackCompressionMode(r io.ReaderAt, start int64) string {
+	const (
+		hybridFlag      = 0x8
+		idUnique        = 0x3F
+		idLarge         = 0x80
+		idConfigBlock   = 0x25
+		configFast      = 0x200
+		configHigh      = 0x800
+		configVeryHigh  = 0x1000
+		maxMetadataSize = 1 << 20
+	)
+
+	header := make([]byte, 32)
+	if _, err := r.ReadAt(header, start); err != nil {
+		return ""
+	}
+	blockEnd := start + 8 + int64(le32(header[4:]))
+	flags := le32(header[24:])
+
+	// The encoder settings are in the config metadata sub-block of the first block
+	var level string
+	for pos := start + 32; pos < blockEnd && pos-start < maxMetadataSize; {
+		id := make([]byte, 4)
+		if _, err := r.ReadAt(id, pos); err != nil {
+			return ""
+		}
+		size, headerLen := int64(id[1])*2, int64(2)
+		if id[0]&idLarge != 0 {
+			size, headerLen = (int64(id[1])|int64(id[2])<<8|int64(id[3])<<16)*2, 4
+		}
+		if id[0]&idUnique == idConfigBlock && size >= 3 {
+			config := make([]byte, 3)
+			if _, err := r.ReadAt(config, pos+headerLen); err != nil {
+				return ""
+			}
+			// The lowest byte of the flags isn't stored
+			cfg := uint32(config[0])<<8 | uint32(config[1])<<16 | uint32(config[2])<<24
+			switch {
+			case cfg&configVeryHigh != 0:
+				level = "very high"
+			case cfg&configHigh != 0:
+				level = "high"
+			case cfg&configFast != 0:
+				level = "fast"
+			default:
+				level = "normal"
+			}
+			break
+		}
+		pos += headerLen + size
+	}
+	if level == "" {
+		return ""
+	}
+
+	if flags&hybridFlag != 0 {
+		return level + " hybrid"
+	}
+	return level + " lossless"
+}
+
+// le16 decodes a little-endian uint16.
+func le16(b []byte) uint16 {
+	return uint16(b[0]) | uint16(b[1])<<8
+}
+
+// le32 decodes a little-endian uint32.
+func le32(b []byte) uint32 {
+	return uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16 | uint32(b[3])<<24
+}
+
+// WriteOption configures the behavior of write operations. The can be passed to [WriteTags] and combined with the bitwise OR operator.
+type WriteOption uint8
+
+const (
+	// Clear indicates that all existing tags not present in the new map should be removed.
+	Clear WriteOption = 1 << iota
+	// TruncateWarn indicates that tags should be written even if some values are too long for the
+	// fixed-size fields of the file (such as ID3v1), but the affected keys are reported with a [*TruncationError].
+	TruncateWarn
+	// TruncateError indicates that nothing should be written if some values are too long for the
+	// fixed-size fields of the file. The affected keys are reported with a [*TruncationError].
+	TruncateError
+	// PreserveUnmapped indicates that raw ID3v2 frames and MP4 atoms that TagLib doesn't map to any normalized key
+	// should be restored if the write removed them. The file is only replaced once both are done. Only text frames of
+	// MP3 files and text atoms of MP4 files can be restored. Only supported by [WriteTags].
+	PreserveUnmapped
+	// PreserveModTime indicates that the modification time of the file should be restored after writing, so tools
+	// that detect changes by modification time, like rsync, don't see one. Has no effect on files opened with
+	// [OpenStream].
+	PreserveModTime
+	// PreserveEncoding indicates that ID3v2 text and comment frames should be written in the text encoding the tag
+	// already used for them, such as ISO-8859-1, where the new values can be represented in it, rather than TagLib's
+	// default of UTF-8. Frames new to the tag use the encoding shared by all the existing text frames, if any. The
+	// frames are re-encoded after TagLib's save, on a copy that only replaces the file once both are done. Only
+	// supported by [WriteTags], for ID3v2 tags at the start of the file as in MP3 files.
+	PreserveEncoding
+)
+
+// keepModTime returns a function that restores the modification time of path to the current one if opts has
+// [PreserveModTime], or does nothing otherwise.
+func keepModTime(path string, opts WriteOption) (restore func() error, err error) {
+	if opts&PreserveModTime == 0 || path == "" {
+		return func() error { return nil }, nil
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("stat: %w", err)
+	}
+	return func() error {
+		// Files replaced by renaming are a new file at path, so this applies to them too
+		if err := os.Chtimes(path, time.Time{}, info.ModTime()); err != nil {
+			return fmt.Errorf("restore mod time: %w", err)
+		}
+		return nil
+	}, nil
+}
+
+// fieldLimits are the maximum value lengths of fixed-size fields per format. For MPEG these come from
+// the ID3v1 tag which TagLib writes alongside ID3v2. The comment is 28 rather than 30 since TagLib
+// always writes ID3v1.1, which uses the last two bytes of the comment for the track number.
+var fieldLimits = map[FileFormat]map[string]int{
+	FormatMPEG: {
+		Title:   30,
+		Artist:  30,
+		Album:   30,
+		Comment: 28,
+	},
+}
+
+// truncatedKeys returns the keys of tags with values that won't fit the fixed-size fields of format.
+func truncatedKeys(format FileFormat, tags map[string][]string) []string {
+	limits := fieldLimits[format]
+	var keys []string
+	for k, vs := range tags {
+		limit, ok := limits[k]
+		if !ok || len(vs) == 0 {
+			continue
+		}
+		if utf8.RuneCountInString(vs[0]) > limit {
+			keys = append(keys, k)
+		}
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// unmappedRawTags are the raw tags of a file that [PreserveUnmapped] restores after a write.
+type unmappedRawTags struct {
+	format FileFormat
+	tags   map[string][]string
+}
+
+// readUnmappedRawTags reads the raw tags of the file at path that aren't represented by normalized tags and
+// can be written back.
+func readUnmappedRawTags(m *module, path string) (unmappedRawTags, error) {
+	format, err := readFormat(m, path)
+	if err != nil {
+		return unmappedRawTags{}, err
+	}
+
+	var rawFn string
+	var restorable func(key string) bool
+	switch format {
+	case FormatMPEG:
+		rawFn = "taglib_file_id3v2_frames"
+		restorable = func(key string) bool {
+			return len(key) == 4 && key[0] == 'T' && key != "TXXX"
+		}
+	case FormatMP4:
+		rawFn = "taglib_file_mp4_atoms"
+		restorable = func(key string) bool {
+			return strings.HasPrefix(key, "----:") || (strings.HasPrefix(key, "©") && !strings.Contains(key, ":"))
+		}
+	default:
+		return unmappedRawTags{}, nil
+	}
+
+	var raw, normalized wasmStrings
+	if err := m.call(rawFn, &raw, wasmString(wasmPath(path))); err != nil {
+		return unmappedRawTags{}, fmt.Errorf("call: %w", err)
+	}
+	if err := m.call("taglib_file_tags", &normalized, wasmString(wasmPath(path))); err != nil {
+		return unmappedRawTags{}, fmt.Errorf("call: %w", err)
+	}
+
+	var keys []string
+	for _, row := range normalized {
+		if k, _, ok := strings.Cut(row, "\t"); ok {
+			keys = append(keys, k)
+		}
+	}
+	// A raw key is mapped if TagLib maps it to one of the normalized keys of the file
+	mapped := func(rawKey string) bool {
+		return slices.ContainsFunc(keys, func(k string) bool { return rawKeyMapsTo(format, rawKey, k) })
+	}
+
+	tags := map[string][]string{}
+	for _, row := range raw {
+		k, v, ok := strings.Cut(row, "\t")
+		if !ok || !restorable(k) || mapped(k) {
+			continue
+		}
+		tags[k] = append(tags[k], v)
+	}
+	return unmappedRawTags{format: format, tags: tags}, nil
+}
+
+// restore writes back the tags that are no longer in the file at path.
+func (u unmappedRawTags) restore(m *module, path string) error {
+	rawFn := "taglib_file_id3v2_frames"
+	if u.format == FormatMP4 {
+		rawFn = "taglib_file_mp4_atoms"
+	}
+	var raw wasmStrings
+	if err := m.call(rawFn, &raw, wasmString(wasmPath(path))); err != nil {
+		return fmt.Errorf("call: %w", err)
+	}
+
+	missing := maps.Clone(u.tags)
+	for _, row := range raw {
+		k, _, _ := strings.Cut(row, "\t")
+		delete(missing, k)
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
+	if u.format == FormatMP4 {
+		return writeMP4Atoms(path, missing)
+	}
+	var rows []string
+	for k, vs := range missing {
+		rows = append(rows, fmt.Sprintf("%s\t%s", k, strings.Join(vs, "\v")))
+	}
+	var out wasmBool
+	if err := m.call("taglib_file_write_id3v2_frames", &out, wasmString(wasmPath(path)), wasmStrings(rows), wasmUint8(0)); err != nil {
+		return fmt.Errorf("call: %w", err)
+	}
+	if !out {
+		return ErrSavingFile
+	}
+	return nil
+}
+
+// readFormat detects the format of the file at path using an existing module.
+func readFormat(m *module, path string) (FileFormat, error) {
+	var result wasmOpenResult
+	if err := m.call("taglib_file_open", &result, wasmString(wasmPath(path)), wasmUint8(ReadStyleFast)); err != nil {
+		return FormatUnknown, fmt.Errorf("call: %w", err)
+	}
+	if result.handle == 0 {
+		return FormatUnknown, ErrInvalidFile
+	}
+	var out wasmBool
+	_ = m.call("taglib_file_close", &out, wasmUint32(result.handle))
+	return FileFormat(result.format), nil
+}
+
+// WriteTags writes the metadata key-values pairs to path. The behavior can be controlled with [WriteOption].
+func WriteTags(path string, tags map[string][]string, opts WriteOption) error {
+	var err error
+	path, err = filepath.Abs(path)
+	if err != nil {
+		return fmt.Errorf("make path abs %w", err)
+	}
+
+	// Restoring unmapped tags or text encodings is a second save, so both are done on a copy that only replaces the
+	// file once both succeeded
+	target := path
+	paths := []string{path}
+	if opts&(PreserveUnmapped|PreserveEncoding) != 0 {
+		target, err = copyToTemp(path)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(target)
+		paths = append(paths, target)
+	}
+
+	mod, err := newModuleOpt(filepath.Dir(path), paths, false)
+	if err != nil {
+		return fmt.Errorf("init module: %w", err)
+	}
+	defer mod.close()
+
+	var truncated []string
+	if opts&(TruncateWarn|TruncateError) != 0 {
+		format, err := readFormat(&mod, path)
+		if err != nil {
+			return err
+		}
+		truncated = truncatedKeys(format, tags)
+		if len(truncated) > 0 && opts&TruncateError != 0 {
+			return &TruncationError{Keys: truncated}
+		}
+	}
+
+	var unmapped unmappedRawTags
+	if opts&PreserveUnmapped != 0 {
+		unmapped, err = readUnmappedRawTags(&mod, path)
+		if err != nil {
+			return err
+		}
+	}
+
+	var encodings map[string]byte
+	if opts&PreserveEncoding != 0 {
+		encodings, err = readID3v2TextEncodings(path)
+		if err != nil {
+			return err
+		}
+	}
+
+	restoreModTime, err := keepModTime(path, opts)
+	if err != nil {
+		return err
+	}
+
+	var raw []string
+	for k, vs := range tags {
+		raw = append(raw, fmt.Sprintf("%s\t%s", k, strings.Join(vs, "\v")))
+	}
+
+	var out wasmBool
+	if err := mod.call("taglib_file_write_tags", &out, wasmString(wasmPath(target)), wasmStrings(raw), wasmUint8(opts)); err != nil {
+		return fmt.Errorf("call: %w", err)
+	}
+	if !out {
+		return ErrSavingFile
+	}
+	if len(unmapped.tags) > 0 {
+		if err := unmapped.restore(&mod, target); err != nil {
+			return err
+		}
+	}
+	if len(encodings) > 0 {
+		if err := restoreID3v2TextEncodings(target, encodings); err != nil {
+			return err
+		}
+	}
+	if target != path {
+		if err := os.Rename(target, path); err != nil {
+			return fmt.Errorf("%w: %w", ErrSavingFile, err)
+		}
+	}
+	if err := restoreModTime(); err != nil {
+		return err
+	}
+	if len(truncated) > 0 {
+		return &TruncationError{Keys: truncated}
+	}
+	return nil
+}
+
+// TagSet is the complete metadata of a file, independent of its format, for writing the same metadata to files of
+// different formats with [TagSet.WriteTo].
+type TagSet struct {
+	// Tags are the normalized tags, such as [Title]. The track and disc totals may be given either as part of a
+	// "number/total" [TrackNumber] and [DiscNumber], or as TRACKTOTAL and DISCTOTAL.
+	Tags map[string][]string
+	// Images are the embedded images, in order. If nil, the images of the file are kept.
+	Images []Picture
+}
+
+// UnsupportedKeysError is returned by [TagSet.WriteTo] when some tags can't be stored in the format of the file
+// and were dropped. The other tags and the images are still written.
+type UnsupportedKeysError struct {
+	// Format is the format of the file
+	Format FileFormat
+	// Keys are the tag keys that were dropped
+	Keys []string
+}
+
+func (e *UnsupportedKeysError) Error() string {
+	return fmt.Sprintf("keys not supported by %s: %s", e.Format, strings.Join(e.Keys, ", "))
+}
+
+// WriteTo replaces the tags and images of the file at path with the tag set, adapting the tags to the idioms of the
+// format of the file. The track and disc totals are written as separate TRACKTOTAL and DISCTOTAL tags for FLAC, Ogg,
+// APE, and WavPack files and as part of a "number/total" value otherwise, and [BPM] is rounded for ID3v2 and MP4 tags
+// that only store integers. Tags the format can't store, as reported by [SupportedKeys], are dropped and reported
+// with an [*UnsupportedKeysError].
+func (ts TagSet) WriteTo(path string) error {
+	format, err := DetectFormat(path)
+	if err != nil {
+		return err
+	}
+	if format == FormatUnknown || format == FormatShorten {
+		return fmt.Errorf("%w: %s", ErrUnsupportedFormat, format)
+	}
+
+	tags, dropped := adaptTags(format, ts.Tags)
+	if err := WriteTags(path, tags, Clear); err != nil {
+		return err
+	}
+
+	if ts.Images != nil {
+		props, err := ReadProperties(path)
+		if err != nil {
+			return err
+		}
+		for i, img := range ts.Images {
+			if err := WriteImageOptions(path, img.Data, i, string(img.Type), img.Description, img.MIMEType); err != nil {
+				return fmt.Errorf("write image %d: %w", i, err)
+			}
+		}
+		for i := props.ImageCount - 1; i >= len(ts.Images); i-- {
+			if err := WriteImageOptions(path, nil, i, "", "", ""); err != nil {
+				return fmt.Errorf("remove image %d: %w", i, err)
+			}
+		}
+	}
+
+	if len(dropped) > 0 {
+		return &UnsupportedKeysError{Format: format, Keys: dropped}
+	}
+	return nil
+}
+
+// adaptTags returns tags adapted to the idioms of format as described by [TagSet.WriteTo], and the sorted keys that
+// were dropped since format can't store them.
+func adaptTags(format FileFormat, tags map[string][]string) (map[string][]string, []string) {
+	out := map[string][]string{}
+	for k, vs := range tags {
+		if len(vs) > 0 {
+			out[strings.ToUpper(k)] = vs
+		}
+	}
+
+	for _, keys := range [][3]string{{TrackNumber, "TRACKTOTAL", "TOTALTRACKS"}, {DiscNumber, "DISCTOTAL", "TOTALDISCS"}} {
+		number, totalKey, altTotalKey := keys[0], keys[1], keys[2]
+		var n, total string
+		if vs := out[number]; len(vs) > 0 {
+			n, total, _ = strings.Cut(vs[0], "/")
+		}
+		if total == "" {
+			total = cmp.Or(slices.Concat(out[totalKey], out[altTotalKey], []string{""})[0])
+		}
+		delete(out, altTotalKey)
+		delete(out, totalKey)
+
+		switch {
+		case hasSeparateTotals(format):
+			if n != "" {
+				out[number] = []string{n}
+			}
+			if total != "" {
+				out[totalKey] = []string{total}
+			}
+		case n != "" && total != "":
+			out[number] = []string{n + "/" + total}
+		}
+	}
+
+	if vs := out[BPM]; len(vs) > 0 && (hasID3v2Tags(format) || format == FormatMP4) {
+		if bpm, err := strconv.ParseFloat(strings.TrimSpace(vs[0]), 64); err == nil {
+			out[BPM] = []string{strconv.FormatFloat(math.Round(bpm), 'f', 0, 64)}
+		}
+	}
+
+	var dropped []string
+	for k := range out {
+		if !keySupported(format, k) {
+			dropped = append(dropped, k)
+			delete(out, k)
+		}
+	}
+	slices.Sort(dropped)
+	return out, dropped
+}
+
+// BatchWriter writes tags to several files so that either all of them are changed or none are, for example to
+// retag an album without leaving it half edited. Changes are staged with [BatchWriter.Stage] and written with
+// [BatchWriter.Commit], which writes each file to a temporary copy next to it and only moves the copies into place
+// once every write has succeeded. A BatchWriter isn't safe for concurrent use.
+type BatchWriter struct {
+	writes []batchWrite
+}
+
+type batchWrite struct {
+	path string
+	tags map[string][]string
+	opts WriteOption
+}
+
+// NewBatchWriter returns a BatchWriter with no writes staged.
+func NewBatchWriter() *BatchWriter {
+	return &BatchWriter{}
+}
+
+// Stage adds a write of tags to path to the batch, as [WriteTags] would do it. Nothing is written until
+// [BatchWriter.Commit]. Writes staged for the same file are applied in order.
+func (b *BatchWriter) Stage(path string, tags map[string][]string, opts WriteOption) error {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return fmt.Errorf("make path abs %w", err)
+	}
+	b.writes = append(b.writes, batchWrite{path: path, tags: maps.Clone(tags), opts: opts})
+	return nil
+}
+
+// Commit writes all the staged changes and ends the batch. If any write fails, including with a [TruncationError],
+// no file is changed. If moving the written copies into place fails part way, the files already replaced are
+// restored on a best-effort basis.
+func (b *BatchWriter) Commit() error {
+	writes := b.writes
+	b.writes = nil
+
+	var paths []string
+	temps := map[string]string{}
+	defer func() {
+		for _, tmp := range temps {
+			_ = os.Remove(tmp)
+		}
+	}()
+
+	for _, w := range writes {
+		tmp, ok := temps[w.path]
+		if !ok {
+			var err error
+			if tmp, err = copyToTemp(w.path); err != nil {
+				return fmt.Errorf("copy %q: %w", w.path, err)
+			}
+			temps[w.path] = tmp
+			paths = append(paths, w.path)
+		}
+		if err := WriteTags(tmp, w.tags, w.opts); err != nil {
+			return fmt.Errorf("write %q: %w", w.path, err)
+		}
+	}
+
+	// Move the originals aside rather than overwriting them, so they can be put back if a later rename fails
+	backups := map[string]string{}
+	defer func() {
+		for _, backup := range backups {
+			_ = os.Remove(backup)
+		}
+	}()
+	restore := func() {
+		for path, backup := range backups {
+			_ = os.Rename(backup, path)
+		}
+	}
+	for _, path := range paths {
+		backup, err := reserveTemp(path)
+		if err != nil {
+			restore()
+			return err
+		}
+		if err := os.Rename(path, backup); err != nil {
+			_ = os.Remove(backup)
+			restore()
+			return fmt.Errorf("%w: %w", ErrSavingFile, err)
+		}
+		backups[path] = backup
+		if err := os.Rename(temps[path], path); err != nil {
+			restore()
+			return fmt.Errorf("%w: %w", ErrSavingFile, err)
+		}
+		delete(temps, path)
+	}
+	return nil
+}
+
+// Rollback discards the staged changes and ends the batch. No file is changed.
+func (b *BatchWriter) Rollback() {
+	b.writes = nil
+}
+
+// copyToTemp copies the file at path to a new hidden file in the same directory with the same extension, so TagLib
+// detects its format the same way. The copy keeps the mode and modification time of the original, so that
+// [PreserveModTime] works on it.
+func copyToTemp(path string) (string, error) {
+	src, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("open file: %w", err)
+	}
+	defer src.Close()
+	info, err := src.Stat()
+	if err != nil {
+		return "", fmt.Errorf("stat file: %w", err)
+	}
+
+	tmp, err := reserveTemp(path)
+	if err != nil {
+		return "", err
+	}
+	dst, err := os.OpenFile(tmp, os.O_WRONLY|os.O_TRUNC, 0)
+	if err != nil {
+		_ = os.Remove(tmp)
+		return "", fmt.Errorf("open temp file: %w", err)
+	}
+	_, err = io.Copy(dst, src)
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmp, info.Mode())
+	}
+	if err == nil {
+		err = os.Chtimes(tmp, time.Time{}, info.ModTime())
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return "", fmt.Errorf("write temp file: %w", err)
+	}
+	return tmp, nil
+}
+
+// reserveTemp creates an empty hidden file next to path with the same extension, and returns its path.
+func reserveTemp(path string) (string, error) {
+	ext := filepath.Ext(path)
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+strings.TrimSuffix(filepath.Base(path), ext)+".*"+ext)
+	if err != nil {
+		return "", fmt.Errorf("create temp file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return "", fmt.Errorf("close temp file: %w", err)
+	}
+	return tmp.Name(), nil
+}
+
+// WriteTagsIfChanged writes the metadata key-value pairs to path like [WriteTags], but only if they differ from
+// the tags already in the file, leaving the file untouched otherwise. Keys are compared case-insensitively and values
+// in order. Keys with no values (or a single empty value) count as changed only if the file has them. With [Clear],
+// any existing key not in tags also counts as a change. Reports whether the file was written.
+func WriteTagsIfChanged(path string, tags map[string][]string, opts WriteOption) (changed bool, err error) {
+	current, err := ReadTags(path)
+	if err != nil {
+		return false, err
+	}
+	if !tagsChanged(current, tags, opts&Clear != 0) {
+		return false, nil
+	}
+	return true, WriteTags(path, tags, opts)
+}
+
+// tagsChanged reports whether writing want over current would change the tags, with TagLib's normalisation of
+// upper case keys and empty values meaning removal.
+func tagsChanged(current, want map[string][]string, clear bool) bool {
+	wanted := map[string]bool{}
+	for k, vs := range want {
+		k = strings.ToUpper(k)
+		if len(vs) == 0 || (len(vs) == 1 && vs[0] == "") {
+			if len(current[k]) > 0 {
+				return true
+			}
+			continue
+		}
+		wanted[k] = true
+		if !slices.Equal(current[k], vs) {
+			return true
+		}
+	}
+	if clear {
+		for k := range current {
+			if !wanted[k] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// TagDiff is the difference between two sets of tags, as returned by [DiffTags].
+type TagDiff struct {
+	Added   map[string][]string    // keys only in b, with their values
+	Removed map[string][]string    // keys only in a, with their values
+	Changed map[string]ValueChange // keys in both, with different values
+}
+
+// ValueChange is a change to the values of a tag.
+type ValueChange struct {
+	Old, New []string
+	// Reordered reports whether the values are the same, but in a different order. Order matters for
+	// multi-valued tags like ARTISTS, which are usually shown in their stored order.
+	Reordered bool
+}
+
+// Empty reports whether there are no differences.
+func (d TagDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// DiffTags compares tags a to tags b. Keys are compared case-insensitively, and reported upper case like
+// [ReadTags] keys. Keys without values are treated as missing.
+func DiffTags(a, b map[string][]string) TagDiff {
+	normalize := func(tags map[string][]string) map[string][]string {
+		out := map[string][]string{}
+		for k, vs := range tags {
+			if len(vs) > 0 {
+				k = strings.ToUpper(k)
+				out[k] = append(out[k], vs...)
+			}
+		}
+		return out
+	}
+	a, b = normalize(a), normalize(b)
+
+	d := TagDiff{Added: map[string][]string{}, Removed: map[string][]string{}, Changed: map[string]ValueChange{}}
+	for k, before := range a {
+		after, ok := b[k]
+		switch {
+		case !ok:
+			d.Removed[k] = before
+		case !slices.Equal(before, after):
+			reordered := slices.Equal(slices.Sorted(slices.Values(before)), slices.Sorted(slices.Values(after)))
+			d.Changed[k] = ValueChange{Old: before, New: after, Reordered: reordered}
+		}
+	}
+	for k, after := range b {
+		if _, ok := a[k]; !ok {
+			d.Added[k] = after
+		}
+	}
+	return d
+}
+
+// DiffFiles compares the tags of the file at pathA to the tags of the file at pathB. See [DiffTags].
+func DiffFiles(pathA, pathB string) (TagDiff, error) {
+	a, err := ReadTags(pathA)
+	if err != nil {
+		return TagDiff{}, fmt.Errorf("read %q: %w", pathA, err)
+	}
+	b, err := ReadTags(pathB)
+	if err != nil {
+		return TagDiff{}, fmt.Errorf("read %q: %w", pathB, err)
+	}
+	return DiffTags(a, b), nil
+}
+
+// WriteTagsBytes writes the metadata key-values pairs to an in-memory copy of data and returns the
+// resulting file bytes. The input slice is not modified. The behavior can be controlled with [WriteOption].
+// This is useful for pipelines where the file never touches the disk, such as tagging an HTTP upload.
+func WriteTagsBytes(data []byte, tags map[string][]string, opts WriteOption) ([]byte, error) {
+	fsys := &memFS{name: "file", data: bytes.Clone(data)}
+	mod, err := newModuleMem(fsys)
+	if err != nil {
+		return nil, fmt.Errorf("init module: %w", err)
+	}
+	defer mod.close()
+
+	var raw []string
+	for k, vs := range tags {
+		raw = append(raw, fmt.Sprintf("%s\t%s", k, strings.Join(vs, "\v")))
+	}
+
+	var out wasmBool
+	if err := mod.call("taglib_file_write_tags", &out, wasmString(fsys.path()), wasmStrings(raw), wasmUint8(opts)); err != nil {
+		return nil, fmt.Errorf("call: %w", err)
+	}
+	if !out {
+		return nil, ErrSavingFile
+	}
+	return fsys.data, nil
+}
+
+// WriteID3v2Frames writes ID3v2 frames to an MP3 file at the given path.
+// This provides direct access to modify raw ID3v2 frames, including custom frames like TXXX.
+// The map should have frame IDs as keys (like "TIT2", "TPE1", "TXXX") and frame data as values.
+// The opts parameter can include taglib.Clear to remove all existing frames not in the new map, and
+// taglib.PreserveModTime to keep the modification time of the file.
+func WriteID3v2Frames(path string, frames map[string][]string, opts WriteOption) error {
+	var err error
+	path, err = filepath.Abs(path)
+	if err != nil {
+		return fmt.Errorf("make path abs %w", err)
+	}
+
+	mod, err := newModule(path)
+	if err != nil {
+		return fmt.Errorf("init module: %w", err)
+	}
+	defer mod.close()
+
+	restoreModTime, err := keepModTime(path, opts)
+	if err != nil {
+		return err
+	}
+
+	// Convert the frames map to a slice of strings
+	var framesList []string
+	for k, vs := range frames {
+		framesList = append(framesList, fmt.Sprintf("%s\t%s", k, strings.Join(vs, "\v")))
+	}
+
+	var out wasmBool
+	if err := mod.call("taglib_file_write_id3v2_frames", &out, wasmString(wasmPath(path)), wasmStrings(framesList), wasmUint8(opts)); err != nil {
+		return fmt.Errorf("call: %w", err)
+	}
+	if !out {
+		return ErrSavingFile
+	}
+
+	return restoreModTime()
+}
+
+// RemoveID3v2Frame removes every ID3v2 frame with the given frame ID from the MP3 file at path, and returns the
+// number of frames removed. Described frames can be targeted individually using the same keys as
+// [ReadID3v2Frames], such as "TXXX:REPLAYGAIN_TRACK_GAIN" or "COMM:", leaving other frames with the same ID
+// in place. The file is only saved if a frame was removed.
+func RemoveID3v2Frame(path string, frameID string) (int, error) {
+	format, err := DetectFormat(path)
+	if err != nil {
+		return 0, err
+	}
+	if format != FormatMPEG {
+		return 0, fmt.Errorf("%w: %s", ErrUnsupportedFormat, format)
+	}
+
+	// A key without a description matches every frame with that ID
+	described := strings.Contains(frameID, ":")
+	return editID3v2Frames(path, func(frame id3v2Frame) bool {
+		if described {
+			return id3v2FrameKey(frame) == frameID
+		}
+		return frame.id == frameID
+	}, nil)
+}
+
+// id3v2FrameKey returns the key [ReadID3v2Frames] lists frame under, including the description of described frames,
+// such as "TXXX:REPLAYGAIN_TRACK_GAIN" or "USLT:eng". Frames that can't be decoded are keyed by their ID.
+func id3v2FrameKey(frame id3v2Frame) string {
+	body := frame.body
+	if len(body) == 0 || frame.flags[1] != 0 {
+		return frame.id
+	}
+	switch frame.id {
+	case "TXXX", "COMM":
+		if frame.id == "COMM" {
+			// The language follows the encoding
+			if len(body) < 4 {
+				return frame.id
+			}
+			body = append([]byte{body[0]}, body[4:]...)
+		}
+		if values, ok := decodeID3v2Strings(body[0], body[1:]); ok {
+			return frame.id + ":" + values[0]
+		}
+	case "USLT", "SYLT":
+		if len(body) >= 4 {
+			langs, _ := decodeID3v2Strings(0, body[1:4])
+			return frame.id + ":" + langs[0]
+		}
+	case "POPM":
+		email, _, _ := bytes.Cut(body, []byte{0})
+		emails, _ := decodeID3v2Strings(0, email)
+		return frame.id + ":" + emails[0]
+	}
+	return frame.id
+}
+
+// writeMP4Atoms writes MP4 string atoms to the file at path, keeping the exact atom names.
+// The rtng content rating atom is written as a byte from its decimal value. Empty values remove the atom.
+func writeMP4Atoms(path string, atoms map[string][]string) error {
+	items := map[string][]mp4Data{}
+	for name, vs := range atoms {
+		items[name] = nil
+		if len(vs) == 0 || (len(vs) == 1 && vs[0] == "") {
+			continue
+		}
+		if name == "rtng" {
+			n, err := strconv.Atoi(vs[0])
+			if err != nil || n < 0 || n > math.MaxUint8 {
+				return fmt.Errorf("invalid rtng value %q", vs[0])
+			}
+			items[name] = []mp4Data{{class: mp4ClassInteger, value: []byte{byte(n)}}}
+			continue
+		}
+		for _, v := range vs {
+			items[name] = append(items[name], mp4Data{class: mp4ClassUTF8, value: []byte(v)})
+		}
+	}
+	return writeMP4Items(path, items)
+}
+
+// Well-known types of MP4 data atoms.
+const (
+	mp4ClassImplicit = 0
+	mp4ClassUTF8     = 1
+	mp4ClassInteger  = 21 // big-endian signed integer
+)
+
+// mp4Data is the value of an MP4 data atom, with its well-known type.
+type mp4Data struct {
+	class uint32
+	value []byte
+}
+
+// maxMP4MoovSize bounds the size of the moov box [writeMP4Items] reads into memory.
+const maxMP4MoovSize = 64 << 20
+
+// writeMP4Items sets the iTunes metadata items of the MP4 file at path, by their names as [ReadMP4Atoms] lists them,
+// such as "rtng" or "----:com.apple.iTunes:iTunSMPB". Items with no data are removed. Other items are kept as they are
+// and in order, and new items are added after them. Any change in size is taken from or given to a free box after
+// the item list if possible, and otherwise the chunk offsets of the tracks are shifted to match. The file is written
+// alongside the original and renamed over it.
+func writeMP4Items(path string, items map[string][]mp4Data) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("open file: %w", err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("stat file: %w", err)
+	}
+
+	var moovStart, moovEnd int64 = -1, 0
+	var fragmented bool
+	for pos := int64(0); ; {
+		typ, _, next, ok := mp4NextBox(f, pos, info.Size())
+		if !ok {
+			break
+		}
+		switch typ {
+		case "moov":
+			moovStart, moovEnd = pos, next
+		case "moof":
+			fragmented = true
+		}
+		pos = next
+	}
+	if moovStart < 0 || moovEnd-moovStart > maxMP4MoovSize {
+		return ErrInvalidFile
+	}
+
+	moov := make([]byte, moovEnd-moovStart)
+	if _, err := f.ReadAt(moov, moovStart); err != nil {
+		return fmt.Errorf("read moov: %w", err)
+	}
+	newMoov, err := setMP4Items(moov, items)
+	if err != nil {
+		return err
+	}
+	if delta := int64(len(newMoov) - len(moov)); delta != 0 {
+		if fragmented {
+			return fmt.Errorf("%w: can't resize the moov box of a fragmented MP4 file", ErrSavingFile)
+		}
+		if err := shiftMP4ChunkOffsets(newMoov, moovEnd, delta); err != nil {
+			return err
+		}
+	}
+	return replaceRange(f, path, moovStart, moovEnd, newMoov)
+}
+
+// mp4Boxes returns the boxes of b as the offsets of their headers, their content, and their ends. A box with a
+// size of 0 extends to the end of b. Reports false if a box doesn't fit in b.
+func mp4Boxes(b []byte) ([]mp4BoxSpan, bool) {
+	var boxes []mp4BoxSpan
+	for pos := 0; pos < len(b); {
+		if pos+8 > len(b) {
+			return nil, false
+		}
+		size, headerLen := uint64(be32(b[pos:])), 8
+		switch size {
+		case 0:
+			size = uint64(len(b) - pos)
+		case 1:
+			if pos+16 > len(b) {
+				return nil, false
+			}
+			size, headerLen = uint64(be32(b[pos+8:]))<<32|uint64(be32(b[pos+12:])), 16
+		}
+		if size < uint64(headerLen) || size > uint64(len(b)-pos) {
+			return nil, false
+		}
+		boxes = append(boxes, mp4BoxSpan{typ: string(b[pos+4 : pos+8]), start: pos, content: pos + headerLen, end: pos + int(size)})
+		pos += int(size)
+	}
+	return boxes, true
+}
+
+// mp4BoxSpan is a box found by [mp4Boxes].
+type mp4BoxSpan struct {
+	typ                 string
+	start, content, end int
+}
+
+// resizeMP4Box adds delta to the size in the header of the box at start of b.
+func resizeMP4Box(b []byte, start int, delta int) {
+	if size := be32(b[start:]); size != 1 {
+		putBE32(b[start:], uint32(int(size)+delta))
+		return
+	}
+	size := int64(be32(b[start+8:]))<<32 | int64(be32(b[start+12:])) + int64(delta)
+	putBE32(b[start+8:], uint32(size>>32))
+	putBE32(b[start+12:], uint32(size))
+}
+
+// setMP4Items returns moov, a whole moov box, with the items of its iTunes metadata set as with [writeMP4Items],
+// adding the udta, meta, and ilst boxes it needs.
+func setMP4Items(moov []byte, items map[string][]mp4Data) ([]byte, error) {
+	// Find the ilst box, or where to add the boxes missing on the way to it, along with the boxes that hold it
+	var parents []int
+	var list []byte
+	at, end := -1, -1
+	wrap := func(ilst []byte) []byte { return ilst }
+	pos, limit := 0, len(moov)
+	for _, step := range []struct {
+		typ  string
+		skip int // bytes before the child boxes, such as the version and flags of meta
+	}{{"moov", 0}, {"udta", 0}, {"meta", 4}, {"ilst", 0}} {
+		boxes, _ := mp4Boxes(moov[pos:limit])
+		i := slices.IndexFunc(boxes, func(box mp4BoxSpan) bool { return box.typ == step.typ })
+		if i < 0 {
+			switch step.typ {
+			case "moov":
+				return nil, ErrInvalidFile
+			case "udta":
+				wrap = func(ilst []byte) []byte { return mp4BoxBytes("udta", mp4MetaBox(ilst)) }
+			case "meta":
+				wrap = mp4MetaBox
+			}
+			at, end = limit, limit
+			break
+		}
+		box := boxes[i]
+		if step.typ == "ilst" {
+			at, end = pos+box.start, pos+box.end
+			list = moov[pos+box.content : end]
+			break
+		}
+		parents = append(parents, pos+box.start)
+		pos, limit = pos+box.content+step.skip, pos+box.end
+		if pos > limit {
+			return nil, ErrInvalidFile
+		}
+	}
+
+	newList, err := setMP4ItemList(list, items)
+	if err != nil {
+		return nil, err
+	}
+	if at == end && len(newList) == 0 {
+		// Nothing to add to a file without items
+		return moov, nil
+	}
+	insert := wrap(mp4BoxBytes("ilst", newList))
+	growth := len(insert) - (end - at)
+
+	// A free box straight after an existing ilst box can absorb the change in size
+	if boxes, _ := mp4Boxes(moov[end:limit]); at != end && len(boxes) > 0 && boxes[0].typ == "free" && be32(moov[end:]) >= 8 {
+		if freeSize := boxes[0].end - growth; freeSize >= 8 || freeSize == 0 {
+			var free []byte
+			if freeSize > 0 {
+				free = mp4BoxBytes("free", make([]byte, freeSize-8))
+			}
+			return slices.Concat(moov[:at], insert, free, moov[end+boxes[0].end:]), nil
+		}
+	}
+
+	out := slices.Concat(moov[:at], insert, moov[end:])
+	for _, parent := range parents {
+		resizeMP4Box(out, parent, growth)
+	}
+	return out, nil
+}
+
+// mp4MetaBox returns a meta box holding the handler the iTunes metadata needs and the ilst box.
+func mp4MetaBox(ilst []byte) []byte {
+	hdlr := mp4BoxBytes("hdlr", slices.Concat(make([]byte, 8), []byte("mdirappl"), make([]byte, 9)))
+	return mp4BoxBytes("meta", slices.Concat(make([]byte, 4), hdlr, ilst))
+}
+
+// mp4BoxBytes returns a box of typ holding content.
+func mp4BoxBytes(typ string, content []byte) []byte {
+	b := make([]byte, 4, 8+len(content))
+	putBE32(b, uint32(8+len(content)))
+	return append(append(b, typ...), content...)
+}
+
+// setMP4ItemList returns the content of an ilst box with the items set as with [writeMP4Items].
+func setMP4ItemList(list []byte, items map[string][]mp4Data) ([]byte, error) {
+	boxes, ok := mp4Boxes(list)
+	if !ok {
+		return nil, ErrInvalidFile
+	}
+	var out []byte
+	for _, box := range boxes {
+		name := mp4ItemName(bytes.NewReader(list), box.typ, int64(box.content), int64(box.end))
+		if _, ok := items[name]; !ok {
+			out = append(out, list[box.start:box.end]...)
+		}
+	}
+
+	names := slices.Sorted(maps.Keys(items))
+	for _, name := range names {
+		if len(items[name]) == 0 {
+			continue
+		}
+		item, err := mp4ItemBytes(name, items[name])
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, item...)
+	}
+	return out, nil
+}
+
+// mp4ItemBytes returns the item box for the item with name and its data atoms.
+func mp4ItemBytes(name string, data []mp4Data) ([]byte, error) {
+	var content []byte
+	typ := name
+	if rest, ok := strings.CutPrefix(name, "----:"); ok {
+		mean, key, ok := strings.Cut(rest, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid free-form MP4 item name %q", name)
+		}
+		typ = "----"
+		content = append(content, mp4BoxBytes("mean", append(make([]byte, 4), mean...))...)
+		content = append(content, mp4BoxBytes("name", append(make([]byte, 4), key...))...)
+	}
+
+	// Names such as "©nam" are Latin-1
+	var typBytes []byte
+	for _, r := range typ {
+		if r > 0xFF {
+			return nil, fmt.Errorf("invalid MP4 item name %q", name)
+		}
+		typBytes = append(typBytes, byte(r))
+	}
+	if len(typBytes) != 4 {
+		return nil, fmt.Errorf("invalid MP4 item name %q", name)
+	}
+
+	for _, d := range data {
+		// The type, then the locale
+		atom := make([]byte, 8, 8+len(d.value))
+		putBE32(atom, d.class)
+		content = append(content, mp4BoxBytes("data", append(atom, d.value...))...)
+	}
+	return mp4BoxBytes(string(typBytes), content), nil
+}
+
+// shiftMP4ChunkOffsets adds delta to the chunk offsets of the tracks of moov, a whole moov box, that point at or
+// after the end of the moov box, at end in the file, before it changed size.
+func shiftMP4ChunkOffsets(moov []byte, end int64, delta int64) error {
+	var walk func(b []byte) error
+	walk = func(b []byte) error {
+		boxes, _ := mp4Boxes(b)
+		for _, box := range boxes {
+			content := b[box.content:box.end]
+			switch box.typ {
+			case "moov", "trak", "mdia", "minf", "stbl":
+				if err := walk(content); err != nil {
+					return err
+				}
+			case "stco", "co64":
+				if len(content) < 8 {
+					return ErrInvalidFile
+				}
+				entrySize := 4
+				if box.typ == "co64" {
+					entrySize = 8
+				}
+				n := int(be32(content[4:]))
+				if n < 0 || n > (len(content)-8)/entrySize {
+					return ErrInvalidFile
+				}
+				for i := range n {
+					entry := content[8+i*entrySize:]
+					if entrySize == 8 {
+						if offset := int64(be32(entry))<<32 | int64(be32(entry[4:])); offset >= end {
+							putBE32(entry, uint32((offset+delta)>>32))
+							putBE32(entry[4:], uint32(offset+delta))
+						}
+						continue
+					}
+					if offset := int64(be32(entry)); offset >= end {
+						if offset+delta > math.MaxUint32 {
+							return fmt.Errorf("%w: chunk offset doesn't fit in stco", ErrSavingFile)
+						}
+						putBE32(entry, uint32(offset+delta))
+					}
+				}
+			}
+		}
+		return nil
+	}
+	return walk(moov)
+}
+
+// maxTagPadding is the largest padding [OptimizeTags] accepts, bounded by the 24-bit length of a FLAC metadata block.
+const maxTagPadding = 1<<24 - 1
+
+// OptimizeTags rewrites the tag container of the file at path so that it is followed by exactly padding bytes of
+// padding, discarding any excess accumulated over repeated edits. Tag values and audio data are not changed.
+// Supported formats: MP3 (leading ID3v2 tag) and FLAC (metadata blocks, and any leading ID3v2 tag, which is
+// left without padding).
+// Returns the number of bytes saved, which is negative if the file grew. Files that are already optimal are not rewritten.
+// ID3v2 tags using unsynchronisation, an extended header, or a footer are left as they are.
+func OptimizeTags(path string, padding int) (saved int64, err error) {
+	if padding < 0 || padding > maxTagPadding {
+		return 0, fmt.Errorf("padding %d out of range", padding)
+	}
+
+	format, err := DetectFormat(path)
+	if err != nil {
+		return 0, err
+	}
+	if format != FormatMPEG && format != FormatFLAC {
+		return 0, fmt.Errorf("%w: %s", ErrUnsupportedFormat, format)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, fmt.Errorf("open file: %w", err)
+	}
+	defer f.Close()
+
+	var (
+		oldLen int64
+		newTag []byte
+	)
+
+	id3, err := readID3v2Tag(f)
+	if err != nil {
+		return 0, err
+	}
+	if id3 != nil {
+		oldLen += int64(len(id3))
+		// The FLAC metadata that follows holds the padding, so the ID3v2 tag doesn't need any
+		id3Padding := padding
+		if format == FormatFLAC {
+			id3Padding = 0
+		}
+		newTag = append(newTag, padID3v2Tag(id3, id3Padding)...)
+	}
+
+	if format == FormatFLAC {
+		blocks, err := readFLACMetadata(f, oldLen)
+		if err != nil {
+			return 0, err
+		}
+		oldLen += int64(len(blocks))
+		newTag = append(newTag, padFLACMetadata(blocks, padding)...)
+	}
+
+	if int64(len(newTag)) == oldLen {
+		return 0, nil
+	}
+	if err := replacePrefix(f, path, oldLen, newTag); err != nil {
+		return 0, err
+	}
+	return oldLen - int64(len(newTag)), nil
+}
+
+// readID3v2Tag reads the ID3v2 tag at the start of r, including its header. Returns nil if there is none.
+func readID3v2Tag(r io.ReaderAt) ([]byte, error) {
+	header := make([]byte, 10)
+	if _, err := r.ReadAt(header, 0); err != nil || string(header[:3]) != "ID3" {
+		return nil, nil
+	}
+
+	size := 10 + syncsafe(header[6:10])
+	if header[5]&0x10 != 0 {
+		size += 10 // Footer
+	}
+	tag := make([]byte, size)
+	if _, err := r.ReadAt(tag, 0); err != nil {
+		return nil, fmt.Errorf("read id3v2 tag: %w", err)
+	}
+	return tag, nil
+}
+
+// id3v2TagSize returns the size of the ID3v2 tag at the start of r from its header, or 0 if there is none.
+func id3v2TagSize(r io.ReaderAt) int64 {
+	header := make([]byte, 10)
+	if _, err := r.ReadAt(header, 0); err != nil || !isID3v2Header(header, "ID3") {
+		return 0
+	}
+	size := 10 + int64(syncsafe(header[6:10]))
+	if header[5]&0x10 != 0 {
+		size += 10 // Footer
+	}
+	return size
+}
+
+// padID3v2Tag returns tag with its padding replaced by padding zero bytes. Tags that can't be safely
+// rewritten are returned unchanged.
+func padID3v2Tag(tag []byte, padding int) []byte {
+	version, flags := tag[3], tag[5]
+	// Unsynchronisation and extended headers affect how frames are laid out, and padding isn't allowed with a footer
+	if flags&0xD0 != 0 {
+		return tag
+	}
+
+	idLen, headerLen := 4, 10
+	if version == 2 {
+		idLen, headerLen = 3, 6
+	}
+
+	end := 10
+	for end+headerLen <= len(tag) && tag[end] != 0 {
+		var size int
+		switch version {
+		case 2:
+			size = int(tag[end+3])<<16 | int(tag[end+4])<<8 | int(tag[end+5])
+		case 3:
+			size = int(tag[end+idLen])<<24 | int(tag[end+idLen+1])<<16 | int(tag[end+idLen+2])<<8 | int(tag[end+idLen+3])
+		default:
+			size = syncsafe(tag[end+idLen : end+idLen+4])
+		}
+		if size < 0 || end+headerLen+size > len(tag) {
+			return tag
+		}
+		end += headerLen + size
+	}
+	// Anything after the last frame should be padding
+	if slices.ContainsFunc(tag[end:], func(b byte) bool { return b != 0 }) {
+		return tag
+	}
+
+	out := make([]byte, end+padding)
+	copy(out, tag[:end])
+	size := end - 10 + padding
+	out[6], out[7], out[8], out[9] = byte(size>>21&0x7F), byte(size>>14&0x7F), byte(size>>7&0x7F), byte(size&0x7F)
+	return out
+}
+
+// readID3v2TextEncodings reads the text encodings of the text and comment frames of the ID3v2 tag at the start of
+// the file at path, by frame ID. If all the frames share an encoding, it is also stored with an empty ID.
+func readID3v2TextEncodings(path string) (map[string]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open file: %w", err)
+	}
+	defer f.Close()
+	tag, err := readID3v2Tag(f)
+	if err != nil {
+		return nil, err
+	}
+
+	encodings := map[string]byte{}
+	uniform := true
+	for _, frame := range id3v2Frames(tag) {
+		if !isID3v2TextFrame(frame) {
+			continue
+		}
+		enc := frame.body[0]
+		if _, ok := encodings[frame.id]; !ok {
+			encodings[frame.id] = enc
+		}
+		if shared, ok := encodings[""]; ok && shared != enc {
+			uniform = false
+		}
+		encodings[""] = enc
+	}
+	if !uniform {
+		delete(encodings, "")
+	}
+	return encodings, nil
+}
+
+// restoreID3v2TextEncodings re-encodes the text and comment frames of the ID3v2 tag at the start of the file at path
+// to the encodings of [readID3v2TextEncodings], where their values can be represented in them.
+func restoreID3v2TextEncodings(path string, encodings map[string]byte) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("open file: %w", err)
+	}
+	defer f.Close()
+	tag, err := readID3v2Tag(f)
+	if err != nil {
+		return err
+	}
+	newTag := reencodeID3v2Tag(tag, encodings)
+	if newTag == nil {
+		return nil
+	}
+	return replaceID3v2Tag(f, path, tag, newTag)
+}
+
+// replaceID3v2Tag replaces the ID3v2 tag at the start of the file f at path, which may be nil, with newTag, which has
+// no padding. The tag is rewritten in place if the new one fits, or the file is rewritten with some padding otherwise.
+func replaceID3v2Tag(f *os.File, path string, tag, newTag []byte) error {
+	if len(newTag) > len(tag) {
+		return replacePrefix(f, path, int64(len(tag)), padID3v2Tag(newTag, id3v2Padding))
+	}
+	newTag = padID3v2Tag(newTag, len(tag)-len(newTag))
+	w, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return fmt.Errorf("open file: %w", err)
+	}
+	if _, err := w.WriteAt(newTag, 0); err != nil {
+		_ = w.Close()
+		return fmt.Errorf("%w: %w", ErrSavingFile, err)
+	}
+	return w.Close()
+}
+
+// reencodeID3v2Tag returns the ID3v2 tag with its text and comment frames re-encoded as in
+// [restoreID3v2TextEncodings], without padding. Returns nil if no frame changed, or the tag can't be safely rewritten.
+func reencodeID3v2Tag(tag []byte, encodings map[string]byte) []byte {
+	if len(tag) < 10 {
+		return nil
+	}
+	version := tag[3]
+
+	var changed bool
+	out := rewriteID3v2Tag(tag, func(frame id3v2Frame) []byte {
+		enc, ok := encodings[frame.id]
+		if !ok {
+			enc, ok = encodings[""]
+		}
+		// UTF-16BE and UTF-8 were added in ID3v2.4
+		if ok && isID3v2TextFrame(frame) && enc != frame.body[0] && (version == 4 || enc < 2) {
+			if body, ok := reencodeID3v2Text(frame.id, frame.body, enc); ok {
+				changed = true
+				return body
+			}
+		}
+		return frame.body
+	})
+	if !changed {
+		return nil
+	}
+	return out
+}
+
+// rewriteID3v2Tag returns the ID3v2.3 or ID3v2.4 tag with the body of each frame replaced by what edit returns for
+// it, leaving out the frames it returns nil for. Returns nil for tags that can't be rewritten frame by frame: those
+// using unsynchronisation, an extended header, or a footer, or with frames past the end of the tag.
+func rewriteID3v2Tag(tag []byte, edit func(id3v2Frame) []byte) []byte {
+	version := tag[3]
+	// Unsynchronisation and extended headers affect how frames are laid out, and padding isn't allowed with a footer
+	if (version != 3 && version != 4) || tag[5]&0xD0 != 0 {
+		return nil
+	}
+
+	out := slices.Clone(tag[:10])
+	for _, frame := range id3v2Frames(tag) {
+		if frame.body == nil {
+			return nil
+		}
+		if body := edit(frame); body != nil {
+			out = appendID3v2Frame(out, version, frame.id, frame.flags, body)
+		}
+	}
+	setID3v2TagSize(out)
+	return out
+}
+
+// appendID3v2Frame appends a frame of an ID3v2 tag of version to out.
+func appendID3v2Frame(out []byte, version byte, id string, flags [2]byte, body []byte) []byte {
+	size := len(body)
+	sizeBytes := []byte{byte(size >> 24), byte(size >> 16), byte(size >> 8), byte(size)}
+	if version == 4 {
+		sizeBytes = []byte{byte(size >> 21 & 0x7F), byte(size >> 14 & 0x7F), byte(size >> 7 & 0x7F), byte(size & 0x7F)}
+	}
+	out = append(out, id...)
+	out = append(out, sizeBytes...)
+	out = append(out, flags[:]...)
+	return append(out, body...)
+}
+
+// setID3v2TagSize sets the size in the header of the ID3v2 tag to the length of the rest of it.
+func setID3v2TagSize(tag []byte) {
+	size := len(tag) - 10
+	tag[6], tag[7], tag[8], tag[9] = byte(size>>21&0x7F), byte(size>>14&0x7F), byte(size>>7&0x7F), byte(size&0x7F)
+}
+
+// replaceID3v2Frames removes the frames with IDs in remove from the ID3v2 tag at the start of the file at path and
+// adds frames, by ID, creating an ID3v2.4 tag if there is none. Tags using unsynchronisation, an extended header, or
+// a footer aren't supported.
+func replaceID3v2Frames(path string, remove []string, frames map[string][]byte) error {
+	var add []id3v2Frame
+	for _, id := range slices.Sorted(maps.Keys(frames)) {
+		add = append(add, id3v2Frame{id: id, body: frames[id]})
+	}
+	_, err := editID3v2Frames(path, func(frame id3v2Frame) bool { return slices.Contains(remove, frame.id) }, add)
+	return err
+}
+
+// editID3v2Frames rewrites the ID3v2 tag at the start of the file at path without the frames that remove reports,
+// adding the frames of add after the others, as in [replaceID3v2Frames]. Returns the number of frames removed. The
+// file is left untouched if there is nothing to remove or add.
+func editID3v2Frames(path string, remove func(id3v2Frame) bool, add []id3v2Frame) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, fmt.Errorf("open file: %w", err)
+	}
+	defer f.Close()
+	tag, err := readID3v2Tag(f)
+	if err != nil {
+		return 0, err
+	}
+
+	var removed int
+	out := []byte{'I', 'D', '3', 4, 0, 0, 0, 0, 0, 0}
+	if tag != nil {
+		out = rewriteID3v2Tag(tag, func(frame id3v2Frame) []byte {
+			if remove(frame) {
+				removed++
+				return nil
+			}
+			return frame.body
+		})
+		if out == nil {
+			return 0, fmt.Errorf("%w: unsupported ID3v2 tag", ErrSavingFile)
+		}
+	}
+	if removed == 0 && len(add) == 0 {
+		return 0, nil
+	}
+	for _, frame := range add {
+		out = appendID3v2Frame(out, out[3], frame.id, frame.flags, frame.body)
+	}
+	setID3v2TagSize(out)
+	return removed, replaceID3v2Tag(f, path, tag, out)
+}
+
+// id3v2Padding is the padding of ID3v2 tags that have to grow, as TagLib adds.
+const id3v2Padding = 1024
+
+// isID3v2TextFrame reports whether frame is a text, user text, comment, or unsynchronised lyrics frame that is
+// stored as is, so that its text can be re-encoded.
+func isID3v2TextFrame(frame id3v2Frame) bool {
+	// Compression, encryption, and the ID3v2.4 unsynchronisation and data length flags change the body
+	if len(frame.body) == 0 || frame.flags[1] != 0 || frame.body[0] > 3 {
+		return false
+	}
+	return frame.id[0] == 'T' || frame.id == "COMM" || frame.id == "USLT"
+}
+
+// reencodeID3v2Text re-encodes the body of the text frame with id to the text encoding enc. Reports false if the
+// text can't be decoded or represented in enc.
+func reencodeID3v2Text(id string, body []byte, enc byte) ([]byte, bool) {
+	prefix := 1
+	if id == "COMM" || id == "USLT" {
+		// The language follows the encoding
+		prefix = 4
+	}
+	if len(body) < prefix {
+		return nil, false
+	}
+
+	values, ok := decodeID3v2Strings(body[0], body[prefix:])
+	if !ok {
+		return nil, false
+	}
+	text, ok := encodeID3v2Strings(enc, values)
+	if !ok {
+		return nil, false
+	}
+	out := append([]byte{enc}, body[1:prefix]...)
+	return append(out, text...), true
+}
+
+// decodeID3v2Strings decodes the terminated strings of an ID3v2 frame in the text encoding enc.
+// The last string may be unterminated.
+func decodeID3v2Strings(enc byte, b []byte) ([]string, bool) {
+	if enc == 0 || enc == 3 {
+		var values []string
+		for _, v := range bytes.Split(b, []byte{0}) {
+			if enc == 3 && !utf8.Valid(v) {
+				return nil, false
+			}
+			if enc == 0 {
+				runes := make([]rune, len(v))
+				for i, c := range v {
+					runes[i] = rune(c)
+				}
+				values = append(values, string(runes))
+			} else {
+				values = append(values, string(v))
+			}
+		}
+		return values, true
+	}
+
+	if len(b)%2 != 0 {
+		return nil, false
+	}
+	var values []string
+	var units []uint16
+	bigEndian := enc == 2
+	for i := 0; i <= len(b); i += 2 {
+		if i < len(b) && (b[i] != 0 || b[i+1] != 0) {
+			unit := uint16(b[i]) | uint16(b[i+1])<<8
+			if bigEndian {
+				unit = uint16(b[i])<<8 | uint16(b[i+1])
+			}
+			units = append(units, unit)
+			continue
+		}
+		// Each UTF-16 string starts with its own byte order mark
+		if enc == 1 && len(units) > 0 {
+			switch units[0] {
+			case 0xFEFF:
+				units = units[1:]
+			case 0xFFFE:
+				for j := range units {
+					units[j] = units[j]<<8 | units[j]>>8
+				}
+				units = units[1:]
+			default:
+				return nil, false
+			}
+		}
+		values = append(values, string(utf16.Decode(units)))
+		units = units[:0]
+	}
+	return values, true
+}
+
+// encodeID3v2Strings encodes the strings of an ID3v2 frame in the text encoding enc, separated by terminators.
+// Reports false if they can't be represented in enc.
+func encodeID3v2Strings(enc byte, values []string) ([]byte, bool) {
+	var out []byte
+	for i, v := range values {
+		if i > 0 {
+			out = append(out, 0)
+			if enc == 1 || enc == 2 {
+				out = append(out, 0)
+			}
+		}
+		switch enc {
+		case 0:
+			for _, r := range v {
+				if r > 0xFF {
+					return nil, false
+				}
+				out = append(out, byte(r))
+			}
+		case 1:
+			if v == "" {
+				continue
+			}
+			out = append(out, 0xFF, 0xFE)
+			for _, u := range utf16.Encode([]rune(v)) {
+				out = append(out, byte(u), byte(u>>8))
+			}
+		case 2:
+			for _, u := range utf16.Encode([]rune(v)) {
+				out = append(out, byte(u>>8), byte(u))
+			}
+		case 3:
+			out = append(out, v...)
+		}
+	}
+	return out, true
+}
+
+// syncsafe decodes a 4 byte ID3v2 syncsafe integer.
+func syncsafe(b []byte) int {
+	return int(b[0]&0x7F)<<21 | int(b[1]&0x7F)<<14 | int(b[2]&0x7F)<<7 | int(b[3]&0x7F)
+}
+
+// readFLACMetadata reads the "fLaC" marker and all metadata blocks starting at offset.
+func readFLACMetadata(r io.ReaderAt, offset int64) ([]byte, error) {
+	marker := make([]byte, 4)
+	if _, err := r.ReadAt(marker, offset); err != nil || string(marker) != "fLaC" {
+		return nil, ErrInvalidFile
+	}
+
+	blocks := marker
+	for {
+		header := make([]byte, 4)
+		if _, err := r.ReadAt(header, offset+int64(len(blocks))); err != nil {
+			return nil, fmt.Errorf("read flac metadata: %w", err)
+		}
+		body := make([]byte, int(header[1])<<16|int(header[2])<<8|int(header[3]))
+		if _, err := r.ReadAt(body, offset+int64(len(blocks))+4); err != nil {
+			return nil, fmt.Errorf("read flac metadata: %w", err)
+		}
+		blocks = append(blocks, header...)
+		blocks = append(blocks, body...)
+		if header[0]&0x80 != 0 {
+			return blocks, nil
+		}
+	}
+}
+
+// padFLACMetadata returns the metadata blocks with all PADDING blocks replaced by a single one of padding bytes.
+// No PADDING block is written if padding is 0.
+func padFLACMetadata(blocks []byte, padding int) []byte {
+	const typePadding = 1
+
+	out := []byte("fLaC")
+	last := 0
+	for pos := 4; pos < len(blocks); {
+		size := int(blocks[pos+1])<<16 | int(blocks[pos+2])<<8 | int(blocks[pos+3])
+		if blocks[pos]&0x7F != typePadding {
+			last = len(out)
+			out = append(out, blocks[pos]&0x7F)
+			out = append(out, blocks[pos+1:pos+4+size]...)
+		}
+		pos += 4 + size
+	}
+
+	if padding == 0 {
+		out[last] |= 0x80
+		return out
+	}
+	out = append(out, 0x80|typePadding, byte(padding>>16), byte(padding>>8), byte(padding))
+	return append(out, make([]byte, padding)...)
+}
+
+// replacePrefix replaces the first n bytes of the file at path with prefix, keeping the rest of the file.
+// The new file is written alongside the original and renamed over it.
+func replacePrefix(f *os.File, path string, n int64, prefix []byte) error {
+	return replaceRange(f, path, 0, n, prefix)
+}
+
+// replaceRange replaces the bytes from start to end of the file at path with data, keeping the rest of the file.
+// The new file is written alongside the original and renamed over it.
+func replaceRange(f *os.File, path string, start, end int64, data []byte) error {
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("stat file: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("create temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	if _, err := io.Copy(tmp, io.NewSectionReader(f, 0, start)); err != nil {
+		return fmt.Errorf("write temp file: %w", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		return fmt.Errorf("write temp file: %w", err)
+	}
+	if _, err := io.Copy(tmp, io.NewSectionReader(f, end, info.Size()-end)); err != nil {
+		return fmt.Errorf("write temp file: %w", err)
+	}
+	if err := tmp.Chmod(info.Mode()); err != nil {
+		return fmt.Errorf("chmod temp file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("close temp file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("%w: %w", ErrSavingFile, err)
+	}
+	return nil
+}
+
+// ReadImage reads the first embedded image from path. Returns empty byte slice if no images exist.
+func ReadImage(path string) ([]byte, error) {
+	return ReadImageOptions(path, 0)
+}
+
+// ReadImageDataURI reads the first embedded image from path as a data URI such as "data:image/jpeg;base64,...",
+// for embedding it directly in HTML or JSON. The MIME type is detected from the image data, falling back to
+// "application/octet-stream" if it isn't recognised. Returns an empty string if no images exist.
+func ReadImageDataURI(path string) (string, error) {
+	data, err := ReadImage(path)
+	if err != nil {
+		return "", err
+	}
+	if len(data) == 0 {
+		return "", nil
+	}
+	mimeType := cmp.Or(detectImageMIME(data), "application/octet-stream")
+	return "data:" + mimeType + ";base64," + base64.StdEncoding.EncodeToString(data), nil
+}
+
+// ReadImageDecoded reads and decodes the first embedded image from path.
+// JPEG, PNG, and GIF images are supported. Returns [ErrNoImage] if there is no embedded image,
+// and [ErrInvalidImage] if it can't be decoded.
+func ReadImageDecoded(path string) (image.Image, error) {
+	data, err := ReadImage(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, ErrNoImage
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidImage, err)
+	}
+	return img, nil
+}
+
+// ReadImageColor returns the average color of the first embedded image from path, for example to theme a UI around
+// the cover. Returns [ErrNoImage] if there is no embedded image. See [ReadImageDecoded] for supported formats.
+func ReadImageColor(path string) (color.RGBA, error) {
+	img, err := ReadImageDecoded(path)
+	if err != nil {
+		return color.RGBA{}, err
+	}
+
+	var r, g, b, a, n uint64
+	sampleImage(img, func(c color.Color) {
+		cr, cg, cb, ca := c.RGBA()
+		r, g, b, a, n = r+uint64(cr), g+uint64(cg), b+uint64(cb), a+uint64(ca), n+1
+	})
+	if n == 0 {
+		return color.RGBA{}, nil
+	}
+	return color.RGBA{R: uint8(r / n >> 8), G: uint8(g / n >> 8), B: uint8(b / n >> 8), A: uint8(a / n >> 8)}, nil
+}
+
+// ReadImagePalette returns up to n dominant colors of the first embedded image from path, most common first.
+// Similar colors are grouped together and averaged, and fully transparent pixels are ignored.
+// Returns [ErrNoImage] if there is no embedded image. See [ReadImageDecoded] for supported formats.
+func ReadImagePalette(path string, n int) ([]color.RGBA, error) {
+	img, err := ReadImageDecoded(path)
+	if err != nil {
+		return nil, err
+	}
+	n = max(n, 0)
+
+	type bucket struct {
+		r, g, b, a, count uint64
+	}
+	buckets := map[uint32]*bucket{}
+	sampleImage(img, func(c color.Color) {
+		cr, cg, cb, ca := c.RGBA()
+		if ca == 0 {
+			return
+		}
+		// 4 bits per channel is coarse enough to group shades of the same color
+		key := cr>>12<<8 | cg>>12<<4 | cb>>12
+		bk := buckets[key]
+		if bk == nil {
+			bk = &bucket{}
+			buckets[key] = bk
+		}
+		bk.r, bk.g, bk.b, bk.a, bk.count = bk.r+uint64(cr), bk.g+uint64(cg), bk.b+uint64(cb), bk.a+uint64(ca), bk.count+1
+	})
+
+	sorted := make([]*bucket, 0, len(buckets))
+	for _, bk := range buckets {
+		sorted = append(sorted, bk)
+	}
+	slices.SortFunc(sorted, func(x, y *bucket) int {
+		if x.count != y.count {
+			return int(y.count) - int(x.count)
+		}
+		// Break ties by brightness so the result is deterministic
+		return int(y.r+y.g+y.b)/int(y.count) - int(x.r+x.g+x.b)/int(x.count)
+	})
+
+	palette := make([]color.RGBA, 0, min(n, len(sorted)))
+	for _, bk := range sorted[:min(n, len(sorted))] {
+		palette = append(palette, color.RGBA{
+			R: uint8(bk.r / bk.count >> 8),
+			G: uint8(bk.g / bk.count >> 8),
+			B: uint8(bk.b / bk.count >> 8),
+			A: uint8(bk.a / bk.count >> 8),
+		})
+	}
+	return palette, nil
+}
+
+// ReadImageThumbnail reads and decodes the first embedded image from path, and scales it down so that its longest
+// side is maxDim pixels, keeping its aspect ratio, for example for list views. Each pixel of the thumbnail is the
+// average of the pixels it covers. Images that already fit are returned as they are. Returns [ErrNoImage] if there
+// is no embedded image. See [ReadImageDecoded] for supported formats.
+func ReadImageThumbnail(path string, maxDim int) (image.Image, error) {
+	if maxDim <= 0 {
+		return nil, fmt.Errorf("invalid thumbnail size %d", maxDim)
+	}
+	img, err := ReadImageDecoded(path)
+	if err != nil {
+		return nil, err
+	}
+	return thumbnail(img, maxDim), nil
+}
+
+// thumbnail scales img down with a box filter so that its longest side is at most maxDim.
+func thumbnail(img image.Image, maxDim int) image.Image {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	if max(w, h) <= maxDim {
+		return img
+	}
+	tw, th := maxDim, maxDim
+	if w >= h {
+		th = max(1, (h*maxDim+w/2)/w)
+	} else {
+		tw = max(1, (w*maxDim+h/2)/h)
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, tw, th))
+	for ty := range th {
+		y0, y1 := bounds.Min.Y+ty*h/th, bounds.Min.Y+(ty+1)*h/th
+		for tx := range tw {
+			x0, x1 := bounds.Min.X+tx*w/tw, bounds.Min.X+(tx+1)*w/tw
+			var r, g, b, a, n uint64
+			for y := y0; y < y1; y++ {
+				for x := x0; x < x1; x++ {
+					cr, cg, cb, ca := img.At(x, y).RGBA()
+					r, g, b, a, n = r+uint64(cr), g+uint64(cg), b+uint64(cb), a+uint64(ca), n+1
+				}
+			}
+			dst.SetRGBA(tx, ty, color.RGBA{R: uint8(r / n >> 8), G: uint8(g / n >> 8), B: uint8(b / n >> 8), A: uint8(a / n >> 8)})
+		}
+	}
+	return dst
+}
+
+// sampleImage calls fn for the pixels of a grid of at most 64x64 points spread evenly over img,
+// which is plenty to find the colors of cover art without visiting every pixel.
+func sampleImage(img image.Image, fn func(color.Color)) {
+	const samples = 64
+
+	bounds := img.Bounds()
+	stepX, stepY := max(1, bounds.Dx()/samples), max(1, bounds.Dy()/samples)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y += stepY {
+		for x := bounds.Min.X; x < bounds.Max.X; x += stepX {
+			fn(img.At(x, y))
+		}
+	}
+}
+
+// WriteImage writes image as an embedded "Front Cover" at index 0 with auto-detected MIME type and no description.
+// Returns [ErrInvalidImage] if the MIME type can't be detected from the image data.
+// Set image to nil to clear the image at that index.
+func WriteImage(path string, image []byte) error {
+	return WriteImageOptions(path, image, 0, "Front Cover", "", "")
+}
+
+// WriteImageConvert encodes img as format ("jpeg" or "png") and writes it as an embedded "Front Cover" at index 0
+// with the matching MIME type, for example to embed a JPEG when the cover at hand is a PNG. quality is the JPEG
+// quality from 1 to 100, and is ignored for PNG.
+func WriteImageConvert(path string, img image.Image, format string, quality int) error {
+	var buf bytes.Buffer
+	var mimeType string
+	switch strings.ToLower(format) {
+	case "jpeg", "jpg":
+		if quality < 1 || quality > 100 {
+			return fmt.Errorf("jpeg quality %d out of range 1 to 100", quality)
+		}
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+			return fmt.Errorf("encode jpeg: %w", err)
+		}
+		mimeType = "image/jpeg"
+	case "png":
+		if err := png.Encode(&buf, img); err != nil {
+			return fmt.Errorf("encode png: %w", err)
+		}
+		mimeType = "image/png"
+	default:
+		return fmt.Errorf("%w: can't encode format %q", ErrInvalidImage, format)
+	}
+	return WriteImageOptions(path, buf.Bytes(), 0, "Front Cover", "", mimeType)
+}
+
+// ReplaceImage writes image at index like [WriteImageOptions], but keeps the picture type and description of the
+// image already at that index. If there is no image at index, it is written as a "Front Cover" with no description.
+// The MIME type is always detected from the new image data.
+func ReplaceImage(path string, image []byte, index int) error {
+	properties, err := ReadProperties(path)
+	if err != nil {
+		return err
+	}
+
+	imageType, description := "Front Cover", ""
+	if index >= 0 && index < len(properties.Images) {
+		imageType, description = properties.Images[index].Type, properties.Images[index].Description
+	}
+	return WriteImageOptions(path, image, index, imageType, description, "")
+}
+
+// Common names and extensions of image files stored alongside audio files, in order of preference.
+var (
+	sidecarImageNames = []string{"cover", "folder", "front"}
+	sidecarImageExts  = []string{".jpg", ".jpeg", ".png", ".webp", ".gif", ".bmp"}
+)
+
+// ReadImageWithFallback reads the first embedded image from path. If there is no embedded image, it looks for a
+// sidecar image (cover.*, folder.*, or front.*) in the same directory, matching names case-insensitively.
+// The returned source is "embedded" or "sidecar:" followed by the sidecar's file name, like "sidecar:cover.jpg".
+// Returns empty byte slice and source if no image is found.
+func ReadImageWithFallback(path string) ([]byte, string, error) {
+	img, err := ReadImage(path)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(img) > 0 {
+		return img, "embedded", nil
+	}
+
+	dir := filepath.Dir(path)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, "", fmt.Errorf("read dir: %w", err)
+	}
+
+	names := map[string]string{}
+	for _, e := range entries {
+		if e.Type().IsRegular() {
+			names[strings.ToLower(e.Name())] = e.Name()
+		}
+	}
+
+	for _, name := range sidecarImageNames {
+		for _, ext := range sidecarImageExts {
+			sidecar, ok := names[name+ext]
+			if !ok {
+				continue
+			}
+			img, err := os.ReadFile(filepath.Join(dir, sidecar))
+			if err != nil {
+				return nil, "", fmt.Errorf("read sidecar: %w", err)
+			}
+			return img, "sidecar:" + sidecar, nil
+		}
+	}
+	return nil, "", nil
+}
+
+// ReadImageTo writes the embedded image at the specified index from path to w, returning the number of bytes
+// written. This avoids holding a copy of the image, such as when serving it with an [net/http.ResponseWriter].
+// Index 0 is the first image. Nothing is written if index is out of range.
+func ReadImageTo(path string, index int, w io.Writer) (int64, error) {
+	var err error
+	path, err = filepath.Abs(path)
+	if err != nil {
+		return 0, fmt.Errorf("make path abs %w", err)
+	}
+
+	mod, err := newModuleRO(path)
+	if err != nil {
+		return 0, fmt.Errorf("init module: %w", err)
+	}
+	defer mod.close()
+
+	img := wasmBytesTo{w: w}
+	if err := mod.call("taglib_file_read_image", &img, wasmString(wasmPath(path)), wasmInt(index)); err != nil {
+		return img.n, fmt.Errorf("call: %w", err)
+	}
+	return img.n, nil
+}
+
+// pictureLinkMIME is the MIME type of ID3v2 APIC frames and FLAC pictures that hold the URL of an image rather than
+// the image itself.
+const pictureLinkMIME = "-->"
+
+// ReadImageReference returns the URL of the picture at the specified index from path if it's a linked picture,
+// an ID3v2 APIC frame or FLAC picture that references an external image by URL rather than embedding it. For these,
+// [ReadImageOptions] returns the URL as the image data. Returns an empty string if the picture is embedded, and
+// [ErrNoImage] if there is no picture at index. Index 0 is the first picture.
+func ReadImageReference(path string, index int) (string, error) {
+	f, err := OpenReadOnly(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	images := f.Properties().Images
+	if index < 0 || index >= len(images) {
+		return "", ErrNoImage
+	}
+	if images[index].MIMEType != pictureLinkMIME {
+		return "", nil
+	}
+	url, err := f.Image(index)
+	if err != nil {
+		return "", err
+	}
+	return string(url), nil
+}
+
+// ReadImageOptions reads the embedded image at the specified index from path.
+// Index 0 is the first image. Returns empty byte slice if index is out of range.
+func ReadImageOptions(path string, index int) ([]byte, error) {
+	var err error
+	path, err = filepath.Abs(path)
+	if err != nil {
+		return nil, fmt.Errorf("make path abs %w", err)
+	}
+
+	mod, err := newModuleRO(path)
+	if err != nil {
+		return nil, fmt.Errorf("init module: %w", err)
+	}
+	defer mod.close()
+
+	var img wasmBytes
+	if err := mod.call("taglib_file_read_image", &img, wasmString(wasmPath(path)), wasmInt(index)); err != nil {
+		return nil, fmt.Errorf("call: %w", err)
+	}
+
+	return img, nil
+}
+
+// ImageInfo describes an embedded image without its pixel data.
+type ImageInfo struct {
+	Width    int
+	Height   int
+	MIMEType string
+	Size     int // Size of the image data in bytes
+}
+
+// ReadImageInfo reads the dimensions of the embedded image at the specified index from path by parsing only
+// the image header, which is much cheaper than decoding it. Index 0 is the first image.
+// JPEG, PNG, GIF, and WebP images are supported. Returns [ErrNoImage] if there is no image at index,
+// and [ErrInvalidImage] if its format isn't supported or its header is malformed.
+func ReadImageInfo(path string, index int) (ImageInfo, error) {
+	data, err := ReadImageOptions(path, index)
+	if err != nil {
+		return ImageInfo{}, err
+	}
+	if len(data) == 0 {
+		return ImageInfo{}, ErrNoImage
+	}
+	return imageInfo(data)
+}
+
+// imageInfo parses the header of image data.
+func imageInfo(data []byte) (ImageInfo, error) {
+	info := ImageInfo{MIMEType: detectImageMIME(data), Size: len(data)}
+	switch info.MIMEType {
+	case "image/jpeg", "image/png", "image/gif":
+		// The standard decoders stop after the header (JPEG SOF, PNG IHDR, GIF screen descriptor) when only reading the config
+		cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			return ImageInfo{}, fmt.Errorf("%w: %w", ErrInvalidImage, err)
+		}
+		info.Width, info.Height = cfg.Width, cfg.Height
+	case "image/webp":
+		var ok bool
+		if info.Width, info.Height, ok = webpSize(data); !ok {
+			return ImageInfo{}, fmt.Errorf("%w: malformed webp header", ErrInvalidImage)
+		}
+	default:
+		return ImageInfo{}, fmt.Errorf("%w: unsupported image format %q", ErrInvalidImage, info.MIMEType)
+	}
+	return info, nil
+}
+
+// webpSize returns the dimensions of a WebP image from the header of its first chunk.
+func webpSize(data []byte) (width, height int, ok bool) {
+	if len(data) < 30 {
+		return 0, 0, false
+	}
+	switch string(data[12:16]) {
+	case "VP8 ":
+		// Lossy, a keyframe header with a start code and 14-bit dimensions
+		if data[23] != 0x9D || data[24] != 0x01 || data[25] != 0x2A {
+			return 0, 0, false
+		}
+		return int(le16(data[26:28]) & 0x3FFF), int(le16(data[28:30]) & 0x3FFF), true
+	case "VP8L":
+		// Lossless, a signature byte then 14-bit dimensions minus one
+		if data[20] != 0x2F {
+			return 0, 0, false
+		}
+		bits := le32(data[21:25])
+		return int(bits&0x3FFF) + 1, int(bits>>14&0x3FFF) + 1, true
+	case "VP8X":
+		// Extended, flags then 24-bit canvas dimensions minus one
+		width = int(data[24]) | int(data[25])<<8 | int(data[26])<<16
+		height = int(data[27]) | int(data[28])<<8 | int(data[29])<<16
+		return width + 1, height + 1, true
+	}
+	return 0, 0, false
+}
+
+// WriteImageOptions writes an image with custom metadata.
+// Index specifies which image slot to write to (0 = first image).
+// Set image to nil to clear the image at that index.
+// If mimeType is empty it is detected from the image data, and [ErrInvalidImage] is returned if that fails.
+func WriteImageOptions(path string, image []byte, index int, imageType, description, mimeType string) error {
+	mimeType, err := imageMIME(image, mimeType)
+	if err != nil {
+		return err
+	}
+
+	path, err = filepath.Abs(path)
+	if err != nil {
+		return fmt.Errorf("make path abs %w", err)
+	}
+
+	mod, err := newModule(path)
+	if err != nil {
+		return fmt.Errorf("init module: %w", err)
+	}
+	defer mod.close()
+
+	var out wasmBool
+	if err := mod.call("taglib_file_write_image", &out, wasmString(wasmPath(path)), wasmBytes(image), wasmInt(len(image)), wasmInt(index), wasmString(imageType), wasmString(description), wasmString(mimeType)); err != nil {
+		return fmt.Errorf("call: %w", err)
+	}
+	if !out {
+		return ErrSavingFile
+	}
+	return nil
+}
+
+type rc struct {
+	wazero.Runtime
+	wazero.CompiledModule
+}
+
+// Stream registry for io.ReadSeeker streams used by OpenStream
+var (
+	streamRegistry   = make(map[uint32]io.ReadSeeker)
+	streamRegistryMu sync.RWMutex
+	nextStreamId     uint32 = 1
+)
+
+// Buffer pool for stream reads - avoids allocation per read call
+var streamReadPool = sync.Pool{
+	New: func() any {
+		// Match C++ BUFFER_SIZE (32KB)
+		buf := make([]byte, 32*1024)
+		return &buf
+	},
+}
+
+// ctxStream wraps an io.ReadSeeker so reads and seeks give up once ctx is done, even if the underlying call is blocked.
+// After giving up the underlying reader is never used again, as the blocked call may still be running.
+// Contexts that can never be done, such as context.Background, skip the goroutine and call the reader directly.
+type ctxStream struct {
+	ctx       context.Context
+	r         io.ReadSeeker
+	buf       []byte // reused for reads, and never touched again once abandoned
+	abandoned bool
+}
+
+func (s *ctxStream) Read(p []byte) (int, error) {
+	if s.ctx.Done() == nil {
+		return s.r.Read(p)
+	}
+	// Read into a private buffer since p may be reused by the caller if we give up
+	if cap(s.buf) < len(p) {
+		s.buf = make([]byte, len(p))
+	}
+	buf := s.buf[:len(p)]
+	n, err := s.do(func() (int64, error) {
+		n, err := s.r.Read(buf)
+		return int64(n), err
+	})
+	copy(p, buf[:n])
+	return int(n), err
+}
+
+func (s *ctxStream) Seek(offset int64, whence int) (int64, error) {
+	if s.ctx.Done() == nil {
+		return s.r.Seek(offset, whence)
+	}
+	return s.do(func() (int64, error) {
+		return s.r.Seek(offset, whence)
+	})
+}
+
+func (s *ctxStream) do(fn func() (int64, error)) (int64, error) {
+	if s.abandoned {
+		return 0, s.ctx.Err()
+	}
+	if err := s.ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	type result struct {
+		n   int64
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		n, err := fn()
+		done <- result{n, err}
+	}()
+
+	select {
+	case res := <-done:
+		return res.n, res.err
+	case <-s.ctx.Done():
+		s.abandoned = true
+		return 0, s.ctx.Err()
+	}
+}
+
+// rangeReadAhead is the minimum number of bytes a [RangeReader] fetches at once.
+const rangeReadAhead = 64 << 10
+
+// RangeReader is an [io.ReadSeeker] and [io.ReaderAt] over a remote file, fetched with HTTP Range requests as it is
+// read. Each request fetches at least 64 KiB, and the last range fetched is cached, so the small reads TagLib makes
+// of headers and tags don't each need a request. Pass it to [OpenStream], or use [OpenURL].
+// It must not be used concurrently.
+type RangeReader struct {
+	ctx    context.Context
+	client *http.Client
+	url    string
+	size   int64
+	pos    int64
+	buf    []byte // cached bytes starting at bufOff
+	bufOff int64
+}
+
+// NewRangeReader returns a [RangeReader] for the file at url, using client to make the requests, or
+// [http.DefaultClient] if nil. The first range is fetched straight away, to find the size of the file and check
+// that the server supports range requests.
+func NewRangeReader(ctx context.Context, client *http.Client, url string) (*RangeReader, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	r := &RangeReader{ctx: ctx, client: client, url: url, size: -1}
+	if err := r.fetch(0, rangeReadAhead); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Size returns the size of the remote file.
+func (r *RangeReader) Size() int64 {
+	return r.size
+}
+
+func (r *RangeReader) Read(p []byte) (int, error) {
+	n, err := r.ReadAt(p, r.pos)
+	r.pos += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+func (r *RangeReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.pos
+	case io.SeekEnd:
+		offset += r.size
+	default:
+		return 0, fmt.Errorf("seek: invalid whence %d", whence)
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("seek: negative position %d", offset)
+	}
+	r.pos = offset
+	return offset, nil
+}
+
+func (r *RangeReader) ReadAt(p []byte, off int64) (int, error) {
+	var n int
+	for n < len(p) {
+		pos := off + int64(n)
+		if pos >= r.size {
+			return n, io.EOF
+		}
+		if pos < r.bufOff || pos >= r.bufOff+int64(len(r.buf)) {
+			if err := r.fetch(pos, max(rangeReadAhead, int64(len(p)-n))); err != nil {
+				return n, err
+			}
+		}
+		n += copy(p[n:], r.buf[pos-r.bufOff:])
+	}
+	return n, nil
+}
+
+// fetch replaces the cache with up to length bytes from off.
+func (r *RangeReader) fetch(off, length int64) error {
+	end := off + length - 1
+	if r.size >= 0 {
+		end = min(end, r.size-1)
+	}
+	req, err := http.NewRequestWithContext(r.ctx, http.MethodGet, r.url, nil)
+	if err != nil {
+		return fmt.Errorf("new request: %w", err)
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", off, end))
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("range request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusRequestedRangeNotSatisfiable:
+		// an empty file has no byte to start the first range at
+		if size, ok := strings.CutPrefix(resp.Header.Get("Content-Range"), "bytes */"); ok && off == 0 {
+			if r.size, err = strconv.ParseInt(size, 10, 64); err == nil {
+				r.buf, r.bufOff = nil, 0
+				return nil
+			}
+		}
+		return fmt.Errorf("range request: unexpected status %q", resp.Status)
+	default:
+		return fmt.Errorf("range request: unexpected status %q", resp.Status)
+	}
+
+	var start, last, size int64
+	if _, err := fmt.Sscanf(resp.Header.Get("Content-Range"), "bytes %d-%d/%d", &start, &last, &size); err != nil || start != off {
+		return fmt.Errorf("range request: invalid content range %q", resp.Header.Get("Content-Range"))
+	}
+	buf, err := io.ReadAll(io.LimitReader(resp.Body, last-start+1))
+	if err != nil {
+		return fmt.Errorf("read range: %w", err)
+	}
+	if len(buf) == 0 {
+		return io.ErrUnexpectedEOF
+	}
+	r.size, r.buf, r.bufOff = size, buf, off
+	return nil
+}
+
+// seekerReaderAt adapts an io.ReadSeeker to io.ReaderAt, restoring the position after each read.
+// It must not be used concurrently with other reads of the stream.
+type seekerReaderAt struct {
+	r io.ReadSeeker
+}
+
+func (s seekerReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	cur, err := s.r.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _, _ = s.r.Seek(cur, io.SeekStart) }()
+
+	if _, err := s.r.Seek(off, io.SeekStart); err != nil {
+		return 0, err
+	}
+	return io.ReadFull(s.r, p)
+}
+
+func registerStream(r io.ReadSeeker) uint32 {
+	streamRegistryMu.Lock()
+	defer streamRegistryMu.Unlock()
+	id := nextStreamId
+	nextStreamId++
+	streamRegistry[id] = r
+	return id
+}
+
+func unregisterStream(id uint32) {
+	streamRegistryMu.Lock()
+	defer streamRegistryMu.Unlock()
+	delete(streamRegistry, id)
+}
+
+func getStream(id uint32) io.ReadSeeker {
+	streamRegistryMu.RLock()
+	defer streamRegistryMu.RUnlock()
+	return streamRegistry[id]
+}
+
+// Host functions called by WASM for stream I/O
+func hostStreamRead(_ context.Context, m api.Module, streamId, bufPtr, length uint32) uint32 {
+	r := getStream(streamId)
+	if r == nil {
+		return 0
+	}
+
+	// Use pooled buffer to avoid allocation
+	bufp := streamReadPool.Get().(*[]byte)
+	buf := *bufp
+	defer streamReadPool.Put(bufp)
+
+	// Read up to buffer size
+	toRead := length
+	if toRead > uint32(len(buf)) {
+		toRead = uint32(len(buf))
+	}
+
+	n, err := r.Read(buf[:toRead])
+	if err != nil && n == 0 {
+		return 0
+	}
+	if n > 0 {
+		m.Memory().Write(bufPtr, buf[:n])
+	}
+	return uint32(n)
+}
+
+func hostStreamSeek(_ context.Context, streamId uint32, offset int64, whence int32) int32 {
+	r := getStream(streamId)
+	if r == nil {
+		return -1
+	}
+	_, err := r.Seek(offset, int(whence))
+	if err != nil {
+		return -1
+	}
+	return 0
+}
+
+func hostStreamTell(_ context.Context, streamId uint32) int64 {
+	r := getStream(streamId)
+	if r == nil {
+		return -1
+	}
+	pos, err := r.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return -1
+	}
+	return pos
+}
+
+func hostStreamLength(_ context.Context, streamId uint32) int64 {
+	r := getStream(streamId)
+	if r == nil {
+		return -1
+	}
+	// Save current position
+	cur, err := r.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return -1
+	}
+	// Seek to end to get length
+	end, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return -1
+	}
+	// Restore position
+	_, _ = r.Seek(cur, io.SeekStart)
+	return end
+}
+
+var getRuntimeOnce = sync.OnceValues(func() (rc, error) {
+	ctx := context.Background()
+
+	cacheDir := filepath.Join(os.TempDir(), "go-taglib-wasm")
+	compilationCache, err := wazero.NewCompilationCacheWithDir(cacheDir)
+	if err != nil {
+		return rc{}, err
+	}
+
+	runtime := wazero.NewRuntimeWithConfig(ctx,
+		wazero.NewRuntimeConfig().
+			WithCompilationCache(compilationCache),
+	)
+	wasi_snapshot_preview1.MustInstantiate(ctx, runtime)
+
+	_, err = runtime.
+		NewHostModuleBuilder("env").
+		NewFunctionBuilder().WithFunc(func(int32) int32 { panic("__cxa_allocate_exception") }).Export("__cxa_allocate_exception").
+		NewFunctionBuilder().WithFunc(func(int32, int32, int32) { panic("__cxa_throw") }).Export("__cxa_throw").
+		Instantiate(ctx)
+	if err != nil {
+		return rc{}, err
+	}
+
+	// Register stream I/O host functions for OpenStream support
+	_, err = runtime.
+		NewHostModuleBuilder("go_io").
+		NewFunctionBuilder().WithFunc(hostStreamRead).Export("stream_read").
+		NewFunctionBuilder().WithFunc(hostStreamSeek).Export("stream_seek").
+		NewFunctionBuilder().WithFunc(hostStreamTell).Export("stream_tell").
+		NewFunctionBuilder().WithFunc(hostStreamLength).Export("stream_length").
+		Instantiate(ctx)
+	if err != nil {
+		return rc{}, err
+	}
+
+	var bin = binary
+	if binaryPath != "" {
+		bin, err = os.ReadFile(binaryPath)
+		if err != nil {
+			return rc{}, fmt.Errorf("read custom binary path: %w", err)
 		}
-		atoms[parts[0]] = append(atoms[parts[0]], parts[1])
+		clear(binary)
 	}
-	return atoms, nil
-}
 
-// ReadASFAttributes reads all ASF attributes from a WMA/ASF file at the given path.
-// This provides direct access to the raw ASF attributes, including standard attributes like
-// WM/AlbumTitle, WM/AlbumArtist, WM/TrackNumber, etc.
-// The returned map has attribute names as keys and attribute data as values.
-// Multi-valued attributes are returned as slices with multiple values.
-func ReadASFAttributes(path string) (map[string][]string, error) {
-	var err error
-	path, err = filepath.Abs(path)
+	compiled, err := runtime.CompileModule(ctx, bin)
 	if err != nil {
-		return nil, fmt.Errorf("make path abs %w", err)
+		return rc{}, err
 	}
 
-	dir := filepath.Dir(path)
-	mod, err := newModuleRO(dir)
-	if err != nil {
-		return nil, fmt.Errorf("init module: %w", err)
-	}
-	defer mod.close()
+	return rc{
+		Runtime:        runtime,
+		CompiledModule: compiled,
+	}, nil
+})
 
-	var raw wasmStrings
-	if err := mod.call("taglib_file_asf_attributes", &raw, wasmString(wasmPath(path))); err != nil {
-		return nil, fmt.Errorf("call: %w", err)
-	}
-	if raw == nil {
-		return nil, ErrInvalidFile
-	}
+type module struct {
+	mod    api.Module
+	limits readLimits
+	files  *filesFS // The files mounted, or nil if none are
+}
 
-	// If raw is empty, the file has no ASF attributes
-	var attrs = map[string][]string{}
-	for _, row := range raw {
-		parts := strings.SplitN(row, "\t", 2)
-		if len(parts) != 2 {
-			continue
-		}
-		attrs[parts[0]] = append(attrs[parts[0]], parts[1])
-	}
-	return attrs, nil
+// readLimits caps how much data is copied out of the module's memory by a single call. 0 means no limit.
+type readLimits struct {
+	maxBytes       int // size of a byte result, such as an image
+	maxStringBytes int // combined size of a string or strings result, such as tags
+	maxValueBytes  int // size of each string, such as a single tag
 }
 
-// Properties contains the audio properties of a media file.
-type Properties struct {
-	// Length is the duration of the audio
-	Length time.Duration
-	// Channels is the number of audio channels
-	Channels uint
-	// SampleRate in Hz
-	SampleRate uint
-	// Bitrate in kbit/s
-	Bitrate uint
-	// BitsPerSample is the bit depth (e.g., 16, 24, 32). May be 0 for formats that don't support this.
-	BitsPerSample uint
-	// Codec is the audio codec (e.g., "MP3", "AAC", "ALAC"). May be empty for formats without codec variants.
-	Codec string
-	// Images contains metadata about all embedded images
-	Images []ImageDesc
+// stringLimit returns the limit for reading a single string, the smaller of maxStringBytes and maxValueBytes.
+func (l readLimits) stringLimit() int {
+	if l.maxStringBytes == 0 || (l.maxValueBytes > 0 && l.maxValueBytes < l.maxStringBytes) {
+		return l.maxValueBytes
+	}
+	return l.maxStringBytes
 }
 
-// ImageDesc contains metadata about an embedded image without the actual image data.
-type ImageDesc struct {
-	// Type is the picture type (e.g., "Front Cover", "Back Cover")
-	Type string
-	// Description is a textual description of the image
-	Description string
-	// MIMEType is the MIME type of the image (e.g., "image/jpeg")
-	MIMEType string
+// newModule returns a module that can read and write only the file at path, which must be absolute.
+func newModule(path string) (module, error) {
+	return newModuleOpt(filepath.Dir(path), []string{path}, false)
 }
 
-// ReadProperties reads the audio properties from a file at the given path.
-func ReadProperties(path string) (Properties, error) {
-	var err error
-	path, err = filepath.Abs(path)
-	if err != nil {
-		return Properties{}, fmt.Errorf("make path abs %w", err)
-	}
+// newModuleRO returns a module that can only read the file at path, which must be absolute.
+func newModuleRO(path string) (module, error) {
+	return newModuleOpt(filepath.Dir(path), []string{path}, true)
+}
 
-	dir := filepath.Dir(path)
-	mod, err := newModuleRO(dir)
-	if err != nil {
-		return Properties{}, fmt.Errorf("init module: %w", err)
-	}
-	defer mod.close()
+func newModuleForStream() (module, error) { return newModuleOpt("", nil, true) }
 
-	var raw wasmFileProperties
-	if err := mod.call("taglib_file_read_properties", &raw, wasmString(wasmPath(path))); err != nil {
-		return Properties{}, fmt.Errorf("call: %w", err)
+// newModuleOpt returns a module with access to the files at paths, which must all be in dir. Rather than mounting
+// all of dir, only those files are exposed, so a module mishandling a file can't read or change its neighbours.
+func newModuleOpt(dir string, paths []string, readOnly bool) (module, error) {
+	var fsys *filesFS
+	var mount experimentalsys.FS
+	if dir != "" {
+		fsys = &filesFS{dir: sysfs.DirFS(dir), names: map[string]bool{}}
+		if readOnly {
+			fsys.dir = &sysfs.ReadFS{FS: fsys.dir}
+		}
+		for _, path := range paths {
+			fsys.names[filepath.Base(path)] = true
+		}
+		mount = fsys
 	}
 
-	var images []ImageDesc
-	for _, row := range raw.imageDescs {
-		parts := strings.SplitN(row, "\t", 3)
-		if len(parts) != 3 {
-			continue
-		}
-		images = append(images, ImageDesc{
-			Type:        parts[0],
-			Description: parts[1],
-			MIMEType:    parts[2],
-		})
+	mod, err := instantiateModule(mount, wasmPath(dir))
+	if err != nil {
+		return module{}, err
 	}
 
-	return Properties{
-		Length:        time.Duration(raw.lengthInMilliseconds) * time.Millisecond,
-		Channels:      uint(raw.channels),
-		SampleRate:    uint(raw.sampleRate),
-		Bitrate:       uint(raw.bitrate),
-		BitsPerSample: uint(raw.bitsPerSample),
-		Codec:         raw.codec,
-		Images:        images,
+	return module{
+		mod:   mod,
+		files: fsys,
 	}, nil
 }
 
-// WriteOption configures the behavior of write operations. The can be passed to [WriteTags] and combined with the bitwise OR operator.
-type WriteOption uint8
-
-const (
-	// Clear indicates that all existing tags not present in the new map should be removed.
-	Clear WriteOption = 1 << iota
-)
-
-// WriteTags writes the metadata key-values pairs to path. The behavior can be controlled with [WriteOption].
-func WriteTags(path string, tags map[string][]string, opts WriteOption) error {
-	var err error
-	path, err = filepath.Abs(path)
+// newModuleMem returns a module that can read and write only the in-memory file of fsys.
+func newModuleMem(fsys *memFS) (module, error) {
+	mod, err := instantiateModule(fsys.sysFS(), memFSDir)
 	if err != nil {
-		return fmt.Errorf("make path abs %w", err)
+		return module{}, err
 	}
+	return module{mod: mod}, nil
+}
 
-	dir := filepath.Dir(path)
-	mod, err := newModule(dir)
+// instantiateModule instantiates the compiled module with fsys mounted at guestDir, or with no filesystem if fsys is nil.
+func instantiateModule(fsys experimentalsys.FS, guestDir string) (api.Module, error) {
+	rt, err := getRuntimeOnce()
 	if err != nil {
-		return fmt.Errorf("init module: %w", err)
+		return nil, fmt.Errorf("get runtime once: %w", err)
 	}
-	defer mod.close()
 
-	var raw []string
-	for k, vs := range tags {
-		raw = append(raw, fmt.Sprintf("%s\t%s", k, strings.Join(vs, "\v")))
+	cfg := wazero.
+		NewModuleConfig().
+		WithName("").
+		WithStartFunctions("_initialize")
+	if fsys != nil {
+		cfg = cfg.WithFSConfig(wazero.NewFSConfig().(sysfs.FSConfig).WithSysFSMount(fsys, guestDir))
 	}
 
-	var out wasmBool
-	if err := mod.call("taglib_file_write_tags", &out, wasmString(wasmPath(path)), wasmStrings(raw), wasmUint8(opts)); err != nil {
-		return fmt.Errorf("call: %w", err)
-	}
-	if !out {
-		return ErrSavingFile
-	}
-	return nil
+	ctx := context.Background()
+	return rt.InstantiateModule(ctx, rt.CompiledModule, cfg)
 }
 
-// WriteID3v2Frames writes ID3v2 frames to an MP3 file at the given path.
-// This provides direct access to modify raw ID3v2 frames, including custom frames like TXXX.
-// The map should have frame IDs as keys (like "TIT2", "TPE1", "TXXX") and frame data as values.
-// The opts parameter can include taglib.Clear to remove all existing frames not in the new map.
-func WriteID3v2Frames(path string, frames map[string][]string, opts WriteOption) error {
-	var err error
-	path, err = filepath.Abs(path)
-	if err != nil {
-		return fmt.Errorf("make path abs %w", err)
-	}
+// filesFS exposes only the named files at the top of a directory. The directory itself can be opened, as the
+// module does when it starts, but it lists as empty. Anything that would add, remove, or rename files isn't supported.
+type filesFS struct {
+	experimentalsys.UnimplementedFS
+	dir     experimentalsys.FS
+	names   map[string]bool
+	aliases map[string]string // other names of the files, such as to open them as another format
+}
 
-	dir := filepath.Dir(path)
-	mod, err := newModule(dir)
-	if err != nil {
-		return fmt.Errorf("init module: %w", err)
-	}
-	defer mod.close()
+func (f *filesFS) visible(path string) bool {
+	return path == "." || f.names[path]
+}
 
-	// Convert the frames map to a slice of strings
-	var framesList []string
-	for k, vs := range frames {
-		framesList = append(framesList, fmt.Sprintf("%s\t%s", k, strings.Join(vs, "\v")))
+// resolve returns the name of the file path is an alias of, or path itself.
+func (f *filesFS) resolve(path string) string {
+	if name, ok := f.aliases[path]; ok && f.names[name] {
+		return name
 	}
+	return path
+}
 
-	var out wasmBool
-	if err := mod.call("taglib_file_write_id3v2_frames", &out, wasmString(wasmPath(path)), wasmStrings(framesList), wasmUint8(opts)); err != nil {
-		return fmt.Errorf("call: %w", err)
+func (f *filesFS) OpenFile(path string, flag experimentalsys.Oflag, perm fs.FileMode) (experimentalsys.File, experimentalsys.Errno) {
+	path = f.resolve(path)
+	if !f.visible(path) {
+		return nil, experimentalsys.ENOENT
 	}
-	if !out {
-		return ErrSavingFile
+	file, errno := f.dir.OpenFile(path, flag, perm)
+	if errno != 0 {
+		return nil, errno
 	}
-
-	return nil
+	if path == "." {
+		return emptyDir{file}, 0
+	}
+	return file, 0
 }
 
-// ReadImage reads the first embedded image from path. Returns empty byte slice if no images exist.
-func ReadImage(path string) ([]byte, error) {
-	return ReadImageOptions(path, 0)
+func (f *filesFS) Lstat(path string) (sys.Stat_t, experimentalsys.Errno) {
+	path = f.resolve(path)
+	if !f.visible(path) {
+		return sys.Stat_t{}, experimentalsys.ENOENT
+	}
+	return f.dir.Lstat(path)
 }
 
-// WriteImage writes image as an embedded "Front Cover" at index 0 with auto-detected MIME type.
-// Set image to nil to clear the image at that index.
-func WriteImage(path string, image []byte) error {
-	mimeType := ""
-	if image != nil {
-		mimeType = detectImageMIME(image)
+func (f *filesFS) Stat(path string) (sys.Stat_t, experimentalsys.Errno) {
+	path = f.resolve(path)
+	if !f.visible(path) {
+		return sys.Stat_t{}, experimentalsys.ENOENT
 	}
-	return WriteImageOptions(path, image, 0, "Front Cover", "Added by go-taglib", mimeType)
+	return f.dir.Stat(path)
 }
 
-// ReadImageOptions reads the embedded image at the specified index from path.
-// Index 0 is the first image. Returns empty byte slice if index is out of range.
-func ReadImageOptions(path string, index int) ([]byte, error) {
-	var err error
-	path, err = filepath.Abs(path)
-	if err != nil {
-		return nil, fmt.Errorf("make path abs %w", err)
+func (f *filesFS) Chmod(path string, perm fs.FileMode) experimentalsys.Errno {
+	path = f.resolve(path)
+	if !f.names[path] {
+		return experimentalsys.ENOENT
 	}
+	return f.dir.Chmod(path, perm)
+}
 
-	mod, err := newModuleRO(filepath.Dir(path))
-	if err != nil {
-		return nil, fmt.Errorf("init module: %w", err)
+func (f *filesFS) Utimens(path string, atim, mtim int64) experimentalsys.Errno {
+	path = f.resolve(path)
+	if !f.names[path] {
+		return experimentalsys.ENOENT
 	}
-	defer mod.close()
+	return f.dir.Utimens(path, atim, mtim)
+}
 
-	var img wasmBytes
-	if err := mod.call("taglib_file_read_image", &img, wasmString(wasmPath(path)), wasmInt(index)); err != nil {
-		return nil, fmt.Errorf("call: %w", err)
-	}
+// emptyDir is a directory that lists as empty.
+type emptyDir struct {
+	experimentalsys.File
+}
 
-	return img, nil
+func (emptyDir) Readdir(int) ([]experimentalsys.Dirent, experimentalsys.Errno) { return nil, 0 }
+
+// memFSDir is where a memFS is mounted in the module.
+const memFSDir = "/mem"
+
+// memFS is a filesystem holding a single in-memory file, so that the module can read and save a file that never
+// touches the disk. Like filesFS, the directory lists as empty and files can't be added, removed, or renamed.
+type memFS struct {
+	name string
+	data []byte
 }
 
-// WriteImageOptions writes an image with custom metadata.
-// Index specifies which image slot to write to (0 = first image).
-// Set image to nil to clear the image at that index.
-func WriteImageOptions(path string, image []byte, index int, imageType, description, mimeType string) error {
-	var err error
-	path, err = filepath.Abs(path)
-	if err != nil {
-		return fmt.Errorf("make path abs %w", err)
-	}
+// path returns the path of the file in the module.
+func (m *memFS) path() string { return memFSDir + "/" + m.name }
 
-	mod, err := newModule(filepath.Dir(path))
-	if err != nil {
-		return fmt.Errorf("init module: %w", err)
-	}
-	defer mod.close()
+// sysFS returns m as a filesystem the module can mount.
+func (m *memFS) sysFS() experimentalsys.FS {
+	return memSysFS{FS: &sysfs.AdaptFS{FS: m}, mem: m}
+}
 
-	var out wasmBool
-	if err := mod.call("taglib_file_write_image", &out, wasmString(wasmPath(path)), wasmBytes(image), wasmInt(len(image)), wasmInt(index), wasmString(imageType), wasmString(description), wasmString(mimeType)); err != nil {
-		return fmt.Errorf("call: %w", err)
-	}
-	if !out {
-		return ErrSavingFile
+func (m *memFS) Open(name string) (fs.File, error) {
+	switch name {
+	case ".":
+		return memDir{}, nil
+	case m.name:
+		return &memFile{mem: m}, nil
 	}
-	return nil
+	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
 }
 
-type rc struct {
-	wazero.Runtime
-	wazero.CompiledModule
+// memSysFS adds truncation to the adapted memFS, which fs.FS has no way to express.
+type memSysFS struct {
+	experimentalsys.FS
+	mem *memFS
 }
 
-// Stream registry for io.ReadSeeker streams used by OpenStream
-var (
-	streamRegistry   = make(map[uint32]io.ReadSeeker)
-	streamRegistryMu sync.RWMutex
-	nextStreamId     uint32 = 1
-)
+func (m memSysFS) OpenFile(path string, flag experimentalsys.Oflag, perm fs.FileMode) (experimentalsys.File, experimentalsys.Errno) {
+	file, errno := m.FS.OpenFile(path, flag, perm)
+	if errno != 0 || path != m.mem.name {
+		return file, errno
+	}
+	return memSysFile{File: file, mem: m.mem}, 0
+}
 
-// Buffer pool for stream reads - avoids allocation per read call
-var streamReadPool = sync.Pool{
-	New: func() any {
-		// Match C++ BUFFER_SIZE (32KB)
-		buf := make([]byte, 32*1024)
-		return &buf
-	},
+type memSysFile struct {
+	experimentalsys.File
+	mem *memFS
 }
 
-func registerStream(r io.ReadSeeker) uint32 {
-	streamRegistryMu.Lock()
-	defer streamRegistryMu.Unlock()
-	id := nextStreamId
-	nextStreamId++
-	streamRegistry[id] = r
-	return id
+func (f memSysFile) Truncate(size int64) experimentalsys.Errno {
+	if size < 0 {
+		return experimentalsys.EINVAL
+	}
+	f.mem.truncate(size)
+	return 0
+}
+
+func (m *memFS) truncate(size int64) {
+	if size <= int64(len(m.data)) {
+		m.data = m.data[:size]
+		return
+	}
+	m.data = append(m.data, make([]byte, size-int64(len(m.data)))...)
 }
 
-func unregisterStream(id uint32) {
-	streamRegistryMu.Lock()
-	defer streamRegistryMu.Unlock()
-	delete(streamRegistry, id)
+// memFile is an open handle to the file of a memFS. Writes past the end grow the file.
+type memFile struct {
+	mem *memFS
+	pos int64
 }
 
-func getStream(id uint32) io.ReadSeeker {
-	streamRegistryMu.RLock()
-	defer streamRegistryMu.RUnlock()
-	return streamRegistry[id]
+func (f *memFile) Stat() (fs.FileInfo, error) {
+	return memInfo{name: f.mem.name, size: int64(len(f.mem.data)), mode: 0o644}, nil
 }
 
-// Host functions called by WASM for stream I/O
-func hostStreamRead(_ context.Context, m api.Module, streamId, bufPtr, length uint32) uint32 {
-	r := getStream(streamId)
-	if r == nil {
-		return 0
-	}
-
-	// Use pooled buffer to avoid allocation
-	bufp := streamReadPool.Get().(*[]byte)
-	buf := *bufp
-	defer streamReadPool.Put(bufp)
+func (f *memFile) Read(p []byte) (int, error) {
+	n, err := f.ReadAt(p, f.pos)
+	f.pos += int64(n)
+	return n, err
+}
 
-	// Read up to buffer size
-	toRead := length
-	if toRead > uint32(len(buf)) {
-		toRead = uint32(len(buf))
+func (f *memFile) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fs.ErrInvalid
 	}
-
-	n, err := r.Read(buf[:toRead])
-	if err != nil && n == 0 {
-		return 0
+	if off >= int64(len(f.mem.data)) {
+		return 0, io.EOF
 	}
-	if n > 0 {
-		m.Memory().Write(bufPtr, buf[:n])
+	n := copy(p, f.mem.data[off:])
+	if n < len(p) {
+		return n, io.EOF
 	}
-	return uint32(n)
+	return n, nil
 }
 
-func hostStreamSeek(_ context.Context, streamId uint32, offset int64, whence int32) int32 {
-	r := getStream(streamId)
-	if r == nil {
-		return -1
+func (f *memFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.pos
+	case io.SeekEnd:
+		offset += int64(len(f.mem.data))
+	default:
+		return 0, fs.ErrInvalid
 	}
-	_, err := r.Seek(offset, int(whence))
-	if err != nil {
-		return -1
+	if offset < 0 {
+		return 0, fs.ErrInvalid
 	}
-	return 0
+	f.pos = offset
+	return offset, nil
 }
 
-func hostStreamTell(_ context.Context, streamId uint32) int64 {
-	r := getStream(streamId)
-	if r == nil {
-		return -1
-	}
-	pos, err := r.Seek(0, io.SeekCurrent)
-	if err != nil {
-		return -1
-	}
-	return pos
+func (f *memFile) Write(p []byte) (int, error) {
+	n, err := f.WriteAt(p, f.pos)
+	f.pos += int64(n)
+	return n, err
 }
 
-func hostStreamLength(_ context.Context, streamId uint32) int64 {
-	r := getStream(streamId)
-	if r == nil {
-		return -1
-	}
-	// Save current position
-	cur, err := r.Seek(0, io.SeekCurrent)
-	if err != nil {
-		return -1
+func (f *memFile) WriteAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fs.ErrInvalid
 	}
-	// Seek to end to get length
-	end, err := r.Seek(0, io.SeekEnd)
-	if err != nil {
-		return -1
+	if end := off + int64(len(p)); end > int64(len(f.mem.data)) {
+		f.mem.truncate(end)
 	}
-	// Restore position
-	_, _ = r.Seek(cur, io.SeekStart)
-	return end
+	return copy(f.mem.data[off:], p), nil
 }
 
-var getRuntimeOnce = sync.OnceValues(func() (rc, error) {
-	ctx := context.Background()
+func (f *memFile) Close() error { return nil }
 
-	cacheDir := filepath.Join(os.TempDir(), "go-taglib-wasm")
-	compilationCache, err := wazero.NewCompilationCacheWithDir(cacheDir)
-	if err != nil {
-		return rc{}, err
-	}
+// memDir is the root directory of a memFS, which lists as empty.
+type memDir struct{}
 
-	runtime := wazero.NewRuntimeWithConfig(ctx,
-		wazero.NewRuntimeConfig().
-			WithCompilationCache(compilationCache),
-	)
-	wasi_snapshot_preview1.MustInstantiate(ctx, runtime)
+func (memDir) Stat() (fs.FileInfo, error) {
+	return memInfo{name: ".", mode: fs.ModeDir | 0o755}, nil
+}
 
-	_, err = runtime.
-		NewHostModuleBuilder("env").
-		NewFunctionBuilder().WithFunc(func(int32) int32 { panic("__cxa_allocate_exception") }).Export("__cxa_allocate_exception").
-		NewFunctionBuilder().WithFunc(func(int32, int32, int32) { panic("__cxa_throw") }).Export("__cxa_throw").
-		Instantiate(ctx)
+func (memDir) Read([]byte) (int, error)           { return 0, fs.ErrInvalid }
+func (memDir) ReadDir(int) ([]fs.DirEntry, error) { return nil, nil }
+func (memDir) Close() error                       { return nil }
+
+// editFS is a read-only filesystem holding a single file, which reads as the file of r with edits applied without
+// copying it. The edits can be changed between opens of the file.
+type editFS struct {
+	name  string
+	r     io.ReaderAt
+	size  int64
+	edits []tagEdit
+}
+
+// newModuleEdit returns a module that can read only the file of fsys.
+func newModuleEdit(fsys *editFS) (module, error) {
+	mod, err := instantiateModule(&sysfs.AdaptFS{FS: fsys}, memFSDir)
 	if err != nil {
-		return rc{}, err
+		return module{}, err
 	}
+	return module{mod: mod}, nil
+}
 
-	// Register stream I/O host functions for OpenStream support
-	_, err = runtime.
-		NewHostModuleBuilder("go_io").
-		NewFunctionBuilder().WithFunc(hostStreamRead).Export("stream_read").
-		NewFunctionBuilder().WithFunc(hostStreamSeek).Export("stream_seek").
-		NewFunctionBuilder().WithFunc(hostStreamTell).Export("stream_tell").
-		NewFunctionBuilder().WithFunc(hostStreamLength).Export("stream_length").
-		Instantiate(ctx)
-	if err != nil {
-		return rc{}, err
+// path returns the path of the file in the module.
+func (e *editFS) path() string { return memFSDir + "/" + e.name }
+
+func (e *editFS) Open(name string) (fs.File, error) {
+	switch name {
+	case ".":
+		return memDir{}, nil
+	case e.name:
+		return e.open(), nil
 	}
+	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+}
 
-	var bin = binary
-	if binaryPath != "" {
-		bin, err = os.ReadFile(binaryPath)
-		if err != nil {
-			return rc{}, fmt.Errorf("read custom binary path: %w", err)
+// open returns a handle to the file with the current edits. Edits overlapping an earlier one are ignored.
+func (e *editFS) open() *editFile {
+	edits := slices.Clone(e.edits)
+	slices.SortFunc(edits, func(a, b tagEdit) int { return cmp.Compare(a.start, b.start) })
+
+	f := &editFile{name: e.name}
+	add := func(p editPiece) {
+		p.off = f.size
+		f.pieces = append(f.pieces, p)
+		f.size += p.n
+	}
+	var pos int64
+	for _, edit := range edits {
+		if edit.start < pos || edit.end > e.size {
+			continue
 		}
-		clear(binary)
+		if edit.start > pos {
+			add(editPiece{r: e.r, src: pos, n: edit.start - pos})
+		}
+		if len(edit.data) > 0 {
+			add(editPiece{data: edit.data, n: int64(len(edit.data))})
+		}
+		pos = edit.end
 	}
-
-	compiled, err := runtime.CompileModule(ctx, bin)
-	if err != nil {
-		return rc{}, err
+	if pos < e.size {
+		add(editPiece{r: e.r, src: pos, n: e.size - pos})
 	}
+	return f
+}
 
-	return rc{
-		Runtime:        runtime,
-		CompiledModule: compiled,
-	}, nil
-})
-
-type module struct {
-	mod api.Module
+// editFile is an open handle to the file of an editFS, made of pieces of the original file and of the edits.
+type editFile struct {
+	name   string
+	pieces []editPiece
+	size   int64
+	pos    int64
 }
 
-func newModule(dir string) (module, error)   { return newModuleOpt(dir, false) }
-func newModuleRO(dir string) (module, error) { return newModuleOpt(dir, true) }
-func newModuleForStream() (module, error)    { return newModuleOpt("", true) }
+// editPiece is the part of an editFile from off: data, or if it's nil, n bytes of r from src.
+type editPiece struct {
+	off, n int64
+	data   []byte
+	r      io.ReaderAt
+	src    int64
+}
 
-// newModuleOpt creates a module with optional directory mount. If dir is empty, no filesystem access is provided.
-func newModuleOpt(dir string, readOnly bool) (module, error) {
-	rt, err := getRuntimeOnce()
-	if err != nil {
-		return module{}, fmt.Errorf("get runtime once: %w", err)
-	}
+func (f *editFile) Stat() (fs.FileInfo, error) {
+	return memInfo{name: f.name, size: f.size, mode: 0o444}, nil
+}
 
-	cfg := wazero.
-		NewModuleConfig().
-		WithName("").
-		WithStartFunctions("_initialize")
+func (f *editFile) Read(p []byte) (int, error) {
+	n, err := f.ReadAt(p, f.pos)
+	f.pos += int64(n)
+	return n, err
+}
 
-	if dir != "" {
-		fsConfig := wazero.NewFSConfig()
-		if readOnly {
-			fsConfig = fsConfig.WithReadOnlyDirMount(dir, wasmPath(dir))
-		} else {
-			fsConfig = fsConfig.WithDirMount(dir, wasmPath(dir))
+func (f *editFile) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fs.ErrInvalid
+	}
+	var n int
+	for _, piece := range f.pieces {
+		if n == len(p) {
+			break
+		}
+		at := off + int64(n)
+		if at >= piece.off+piece.n {
+			continue
+		}
+		want := p[n:min(len(p), n+int(piece.off+piece.n-at))]
+		if piece.data != nil {
+			n += copy(want, piece.data[at-piece.off:])
+			continue
+		}
+		m, err := piece.r.ReadAt(want, piece.src+at-piece.off)
+		n += m
+		if m < len(want) {
+			return n, err
 		}
-		cfg = cfg.WithFSConfig(fsConfig)
 	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
 
-	ctx := context.Background()
-	mod, err := rt.InstantiateModule(ctx, rt.CompiledModule, cfg)
-	if err != nil {
-		return module{}, err
+func (f *editFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.pos
+	case io.SeekEnd:
+		offset += f.size
+	default:
+		return 0, fs.ErrInvalid
 	}
+	if offset < 0 {
+		return 0, fs.ErrInvalid
+	}
+	f.pos = offset
+	return offset, nil
+}
 
-	return module{
-		mod: mod,
-	}, nil
+func (f *editFile) Close() error { return nil }
+
+type memInfo struct {
+	name string
+	size int64
+	mode fs.FileMode
 }
 
+func (i memInfo) Name() string       { return i.name }
+func (i memInfo) Size() int64        { return i.size }
+func (i memInfo) Mode() fs.FileMode  { return i.mode }
+func (i memInfo) ModTime() time.Time { return time.Time{} }
+func (i memInfo) IsDir() bool        { return i.mode.IsDir() }
+func (i memInfo) Sys() any           { return nil }
+
 func (m *module) malloc(size uint32) uint32 {
 	var ptr wasmUint32
 	if err := m.call("malloc", &ptr, wasmUint32(size)); err != nil {
@@ -1151,7 +10229,7 @@ type wasmArg interface {
 }
 
 type wasmResult interface {
-	decode(*module, uint64)
+	decode(*module, uint64) error
 }
 
 type wasmBool bool
@@ -1163,15 +10241,17 @@ func (b wasmBool) encode(*module) uint64 {
 	return 0
 }
 
-func (b *wasmBool) decode(_ *module, val uint64) {
+func (b *wasmBool) decode(_ *module, val uint64) error {
 	*b = val == 1
+	return nil
 }
 
 type wasmInt int
 
 func (i wasmInt) encode(*module) uint64 { return uint64(i) }
-func (i *wasmInt) decode(_ *module, val uint64) {
-	*i = wasmInt(val)
+func (i *wasmInt) decode(_ *module, val uint64) error {
+	*i = wasmInt(int32(val))
+	return nil
 }
 
 type wasmUint8 uint8
@@ -1181,8 +10261,9 @@ func (u wasmUint8) encode(*module) uint64 { return uint64(u) }
 type wasmUint32 uint32
 
 func (u wasmUint32) encode(*module) uint64 { return uint64(u) }
-func (u *wasmUint32) decode(_ *module, val uint64) {
+func (u *wasmUint32) decode(_ *module, val uint64) error {
 	*u = wasmUint32(val)
+	return nil
 }
 
 type wasmString string
@@ -1195,10 +10276,16 @@ func (s wasmString) encode(m *module) uint64 {
 	}
 	return uint64(ptr)
 }
-func (s *wasmString) decode(m *module, val uint64) {
-	if val != 0 {
-		*s = wasmString(readString(m, uint32(val)))
+func (s *wasmString) decode(m *module, val uint64) error {
+	if val == 0 {
+		return nil
+	}
+	str, err := readString(m, uint32(val), m.limits.stringLimit())
+	if err != nil {
+		return err
 	}
+	*s = wasmString(str)
+	return nil
 }
 
 type wasmBytes []byte
@@ -1210,10 +10297,35 @@ func (b wasmBytes) encode(m *module) uint64 {
 	}
 	return uint64(ptr)
 }
-func (b *wasmBytes) decode(m *module, val uint64) {
-	if val != 0 {
-		*b = readBytes(m, uint32(val))
+func (b *wasmBytes) decode(m *module, val uint64) error {
+	if val == 0 {
+		return nil
+	}
+	bs, err := readBytes(m, uint32(val))
+	if err != nil {
+		return err
+	}
+	*b = bs
+	return nil
+}
+
+// wasmBytesTo decodes bytes by writing them to w straight from the module's memory, without copying them.
+type wasmBytesTo struct {
+	w io.Writer
+	n int64 // bytes written
+}
+
+func (b *wasmBytesTo) decode(m *module, val uint64) error {
+	if val == 0 {
+		return nil
 	}
+	bs, err := readBytesView(m, uint32(val))
+	if err != nil {
+		return err
+	}
+	n, err := b.w.Write(bs)
+	b.n = int64(n)
+	return err
 }
 
 type wasmStrings []string
@@ -1235,10 +10347,16 @@ func (s wasmStrings) encode(m *module) uint64 {
 	}
 	return uint64(arrayPtr)
 }
-func (s *wasmStrings) decode(m *module, val uint64) {
-	if val != 0 {
-		*s = readStrings(m, uint32(val))
+func (s *wasmStrings) decode(m *module, val uint64) error {
+	if val == 0 {
+		return nil
 	}
+	strs, err := readStrings(m, uint32(val))
+	if err != nil {
+		return err
+	}
+	*s = strs
+	return nil
 }
 
 type wasmFileProperties struct {
@@ -1251,9 +10369,9 @@ type wasmFileProperties struct {
 	codec                string
 }
 
-func (f *wasmFileProperties) decode(m *module, val uint64) {
+func (f *wasmFileProperties) decode(m *module, val uint64) error {
 	if val == 0 {
-		return
+		return nil
 	}
 	ptr := uint32(val)
 
@@ -1263,15 +10381,21 @@ func (f *wasmFileProperties) decode(m *module, val uint64) {
 	f.bitrate, _ = m.mod.Memory().ReadUint32Le(ptr + 12)
 	f.bitsPerSample, _ = m.mod.Memory().ReadUint32Le(ptr + 16)
 
+	var err error
 	imageMetadataPtr, _ := m.mod.Memory().ReadUint32Le(ptr + 20)
 	if imageMetadataPtr != 0 {
-		f.imageDescs = readStrings(m, imageMetadataPtr)
+		if f.imageDescs, err = readStrings(m, imageMetadataPtr); err != nil {
+			return err
+		}
 	}
 
 	codecPtr, _ := m.mod.Memory().ReadUint32Le(ptr + 24)
 	if codecPtr != 0 {
-		f.codec = readString(m, codecPtr)
+		if f.codec, err = readString(m, codecPtr, m.limits.stringLimit()); err != nil {
+			return err
+		}
 	}
+	return nil
 }
 
 type wasmOpenResult struct {
@@ -1279,24 +10403,77 @@ type wasmOpenResult struct {
 	format uint8
 }
 
-func (r *wasmOpenResult) decode(m *module, val uint64) {
+func (r *wasmOpenResult) decode(m *module, val uint64) error {
 	if val == 0 {
-		return
+		return nil
 	}
 	ptr := uint32(val)
 
 	r.handle, _ = m.mod.Memory().ReadUint32Le(ptr)
 	format, _ := m.mod.Memory().ReadByte(ptr + 4)
 	r.format = format
+	return nil
+}
+
+// openPath opens the file at path in the module, as format unless it is [FormatUnknown].
+//
+// TagLib tries the parser of the extension of a file before detecting its format from the content, so a file is
+// opened as format through an alias with the extension of format. A file that TagLib had to detect as another
+// format can't be parsed as format, and isn't opened.
+func (m *module) openPath(dest *wasmOpenResult, path string, style ReadStyle, format FileFormat) error {
+	if format == FormatUnknown {
+		return m.call("taglib_file_open", dest, wasmString(wasmPath(path)), wasmUint8(style))
+	}
+	alias := path + format.Extension()
+	if m.files.aliases == nil {
+		m.files.aliases = map[string]string{}
+	}
+	m.files.aliases[filepath.Base(alias)] = filepath.Base(path)
+	if err := m.call("taglib_file_open", dest, wasmString(wasmPath(alias)), wasmUint8(style)); err != nil {
+		return err
+	}
+	m.checkFormat(dest, format)
+	return nil
+}
+
+// openStream opens the registered stream in the module, as format unless it is [FormatUnknown]. As with
+// [module.openPath], the filename hint is given the extension of format.
+func (m *module) openStream(dest *wasmOpenResult, streamId uint32, filename string, style ReadStyle, format FileFormat) error {
+	if format == FormatUnknown {
+		return m.call("taglib_stream_open", dest, wasmUint32(streamId), wasmString(filename), wasmUint8(style))
+	}
+	if err := m.call("taglib_stream_open", dest, wasmUint32(streamId), wasmString(filename+format.Extension()), wasmUint8(style)); err != nil {
+		return err
+	}
+	m.checkFormat(dest, format)
+	return nil
+}
+
+// checkFormat closes the file opened into dest, and clears its handle, if it wasn't opened as format.
+func (m *module) checkFormat(dest *wasmOpenResult, format FileFormat) {
+	if dest.handle == 0 || FileFormat(dest.format) == format {
+		return
+	}
+	var out wasmBool
+	_ = m.call("taglib_file_close", &out, wasmUint32(dest.handle))
+	dest.handle = 0
 }
 
 func (m *module) call(name string, dest wasmResult, args ...wasmArg) error {
+	if m.mod == nil {
+		return fmt.Errorf("call %q: file is closed", name)
+	}
+	fn := m.mod.ExportedFunction(name)
+	if fn == nil {
+		return fmt.Errorf("call %q: function not exported", name)
+	}
+
 	params := make([]uint64, 0, len(args))
 	for _, a := range args {
 		params = append(params, a.encode(m))
 	}
 
-	results, err := m.mod.ExportedFunction(name).Call(context.Background(), params...)
+	results, err := fn.Call(context.Background(), params...)
 	if err != nil {
 		return fmt.Errorf("call %q: %w", name, err)
 	}
@@ -1304,7 +10481,9 @@ func (m *module) call(name string, dest wasmResult, args ...wasmArg) error {
 		return nil
 	}
 
-	dest.decode(m, results[0])
+	if err := dest.decode(m, results[0]); err != nil {
+		return fmt.Errorf("call %q: %w", name, err)
+	}
 	return nil
 }
 
@@ -1314,68 +10493,110 @@ func (m *module) close() {
 	}
 }
 
-func readStrings(m *module, ptr uint32) []string {
+// errMemoryBounds is returned when a result points outside of the module's memory, such as after TagLib
+// mishandles a corrupt file.
+var errMemoryBounds = fmt.Errorf("result out of memory bounds")
+
+func readStrings(m *module, ptr uint32) ([]string, error) {
 	strs := []string{} // non nil so call knows if it's just empty
+	limit, total := m.limits.maxStringBytes, 0
 	for {
 		stringPtr, ok := m.mod.Memory().ReadUint32Le(ptr)
 		if !ok {
-			panic("memory error")
+			return nil, fmt.Errorf("%w: string pointer at %#x", errMemoryBounds, ptr)
 		}
 		if stringPtr == 0 {
 			break
 		}
-		str := readString(m, stringPtr)
+		str, err := readString(m, stringPtr, m.limits.stringLimit())
+		if err != nil {
+			return nil, err
+		}
+		if total += len(str); limit > 0 && total > limit {
+			return nil, fmt.Errorf("%w: strings over %d bytes", ErrTooLarge, limit)
+		}
 		strs = append(strs, str)
 		ptr += 4
 	}
-	return strs
+	return strs, nil
 }
 
-func readString(m *module, ptr uint32) string {
-	size := uint32(64)
-	buf, ok := m.mod.Memory().Read(ptr, size)
-	if !ok {
-		panic("memory error")
-	}
-	if i := bytes.IndexByte(buf, 0); i >= 0 {
-		return string(buf[:i])
+// readString reads a NUL terminated string. If limit is above 0, strings longer than limit return [ErrTooLarge].
+func readString(m *module, ptr uint32, limit int) (string, error) {
+	mem := m.mod.Memory()
+	if ptr >= mem.Size() {
+		return "", fmt.Errorf("%w: string at %#x", errMemoryBounds, ptr)
 	}
 
-	for {
-		next, ok := m.mod.Memory().Read(ptr+size, size)
+	// Read in doubling chunks, stopping short at the end of memory
+	var buf []byte
+	for size := uint32(64); ; size += size {
+		at := ptr + uint32(len(buf))
+		if at >= mem.Size() {
+			return "", fmt.Errorf("%w: unterminated string at %#x", errMemoryBounds, ptr)
+		}
+		next, ok := mem.Read(at, min(size, mem.Size()-at))
 		if !ok {
-			panic("memory error")
+			return "", fmt.Errorf("%w: string at %#x", errMemoryBounds, ptr)
 		}
 		if i := bytes.IndexByte(next, 0); i >= 0 {
-			return string(append(buf, next[:i]...))
+			return checkStringLimit(string(append(buf, next[:i]...)), limit)
 		}
 		buf = append(buf, next...)
-		size += size
+		if limit > 0 && len(buf) > limit {
+			return "", fmt.Errorf("%w: string over %d bytes", ErrTooLarge, limit)
+		}
+	}
+}
+
+func checkStringLimit(s string, limit int) (string, error) {
+	if limit > 0 && len(s) > limit {
+		return "", fmt.Errorf("%w: string over %d bytes", ErrTooLarge, limit)
 	}
+	return s, nil
 }
 
-func readBytes(m *module, ptr uint32) []byte {
-	ret := []byte{} // non nil so call knows if it's just empty
+func readBytes(m *module, ptr uint32) ([]byte, error) {
+	b, err := readBytesView(m, ptr)
+	if err != nil {
+		return nil, err
+	}
+
+	// copy the data, "this returns a view of the underlying memory, not a copy" per api.Memory.Read docs
+	ret := make([]byte, len(b)) // non nil so call knows if it's just empty
+	copy(ret, b)
+
+	return ret, nil
+}
 
-	size, ok := m.mod.Memory().ReadUint32Le(ptr)
+// readBytesView is like readBytes but returns a view of the module's memory, which is only valid until the next call.
+func readBytesView(m *module, ptr uint32) ([]byte, error) {
+	mem := m.mod.Memory()
+	size, ok := mem.ReadUint32Le(ptr)
 	if !ok {
-		panic("memory error")
+		return nil, fmt.Errorf("%w: bytes header at %#x", errMemoryBounds, ptr)
 	}
 	if size == 0 {
-		return ret
+		return nil, nil
 	}
-
-	loc, _ := m.mod.Memory().ReadUint32Le(ptr + 4)
-	b, ok := m.mod.Memory().Read(loc, size)
+	loc, ok := mem.ReadUint32Le(ptr + 4)
 	if !ok {
-		panic("memory error")
+		return nil, fmt.Errorf("%w: bytes header at %#x", errMemoryBounds, ptr)
 	}
 
-	// copy the data, "this returns a view of the underlying memory, not a copy" per api.Memory.Read docs
-	ret = make([]byte, size)
-	copy(ret, b)
+	// Check the bounds before the limit, since nothing sensible could be over the memory size anyway
+	if uint64(loc)+uint64(size) > uint64(mem.Size()) {
+		return nil, fmt.Errorf("%w: %d bytes at %#x", errMemoryBounds, size, loc)
+	}
+	if limit := m.limits.maxBytes; limit > 0 && int64(size) > int64(limit) {
+		return nil, fmt.Errorf("%w: %d bytes over %d", ErrTooLarge, size, limit)
+	}
 
-	return ret
+	b, ok := mem.Read(loc, size)
+	if !ok {
+		return nil, fmt.Errorf("%w: %d bytes at %#x", errMemoryBounds, size, loc)
+	}
+	return b, nil
 }
 
 // WASI uses POSIXy paths, even on Windows
@@ -1383,6 +10604,18 @@ func wasmPath(p string) string {
 	return filepath.ToSlash(p)
 }
 
+// imageMIME returns mimeType if set, otherwise the MIME type detected from image.
+// Clearing an image (nil image) needs no MIME type.
+func imageMIME(image []byte, mimeType string) (string, error) {
+	if image == nil || mimeType != "" {
+		return mimeType, nil
+	}
+	if mimeType = detectImageMIME(image); mimeType == "" {
+		return "", fmt.Errorf("%w: unrecognised image data, provide a MIME type explicitly", ErrInvalidImage)
+	}
+	return mimeType, nil
+}
+
 // detectImageMIME detects image MIME type from magic bytes.
 // Adapted from Go's net/http package to avoid the dependency.
 func detectImageMIME(data []byte) string {
@@ -1406,7 +10639,50 @@ func detectImageMIME(data []byte) string {
 		return "image/jpeg"
 	case len(data) >= 14 && bytes.Equal(data[:4], []byte("RIFF")) && bytes.Equal(data[8:14], []byte("WEBPVP")):
 		return "image/webp"
+	case len(data) >= 4 && bytes.Equal(data[:4], []byte("II*\x00")):
+		return "image/tiff"
+	case len(data) >= 4 && bytes.Equal(data[:4], []byte("MM\x00*")):
+		return "image/tiff"
+	case bytes.HasPrefix(data, []byte("\xFF\x0A")):
+		return "image/jxl"
+	case len(data) >= 12 && bytes.Equal(data[:12], []byte("\x00\x00\x00\x0CJXL \x0D\x0A\x87\x0A")):
+		return "image/jxl"
+	case len(data) >= 12 && bytes.Equal(data[4:8], []byte("ftyp")):
+		return detectISOBMFFImageMIME(data)
 	default:
 		return ""
 	}
 }
+
+// detectISOBMFFImageMIME detects AVIF and HEIC images from the brands in an ISO BMFF ftyp box.
+func detectISOBMFFImageMIME(data []byte) string {
+	brandMIME := func(brand string) string {
+		switch brand {
+		case "avif", "avis":
+			return "image/avif"
+		case "heic", "heix", "heim", "heis", "hevc", "hevx":
+			return "image/heic"
+		}
+		return ""
+	}
+
+	if mime := brandMIME(string(data[8:12])); mime != "" {
+		return mime
+	}
+
+	// Generic "mif1"/"msf1" major brands list the specific format as a compatible brand
+	size := int(data[0])<<24 | int(data[1])<<16 | int(data[2])<<8 | int(data[3])
+	if size > len(data) {
+		size = len(data)
+	}
+	for i := 16; i+4 <= size; i += 4 {
+		if mime := brandMIME(string(data[i : i+4])); mime != "" {
+			return mime
+		}
+	}
+	switch string(data[8:12]) {
+	case "mif1", "msf1":
+		return "image/heif"
+	}
+	return ""
+}
@@ -39,6 +39,28 @@ func BenchmarkOpen(b *testing.B) {
 			}
 		})
 
+		b.Run("FileHandle/"+name, func(b *testing.B) {
+			// Warm up
+			file, _ := os.Open(path)
+			f, err := taglib.OpenFileHandle(file)
+			if err != nil {
+				b.Fatal(err)
+			}
+			_ = f.Close()
+			_ = file.Close()
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				file, _ := os.Open(path)
+				f, err := taglib.OpenFileHandle(file)
+				if err != nil {
+					b.Fatal(err)
+				}
+				_ = f.Close()
+				_ = file.Close()
+			}
+		})
+
 		b.Run("Stream/"+name, func(b *testing.B) {
 			// Warm up
 			file, _ := os.Open(path)
@@ -0,0 +1,305 @@
+package taglib
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestReadBytesBounds(t *testing.T) {
+	t.Parallel()
+
+	mod, err := newModuleForStream()
+	if err != nil {
+		t.Fatalf("init module: %v", err)
+	}
+	defer mod.close()
+
+	mem := mod.mod.Memory()
+	header := mod.malloc(8)
+	data := mod.malloc(4)
+	mem.Write(data, []byte("abcd"))
+
+	tests := []struct {
+		name      string
+		size, loc uint32
+		wantErr   bool
+	}{
+		{"valid", 4, data, false},
+		{"past end", 16, mem.Size() - 8, true},
+		{"huge size", 0xFFFFFFF0, data, true},
+		{"overflowing loc", 16, 0xFFFFFFF8, true},
+	}
+	for _, tt := range tests {
+		mem.WriteUint32Le(header, tt.size)
+		mem.WriteUint32Le(header+4, tt.loc)
+
+		b, err := readBytes(&mod, header)
+		if tt.wantErr {
+			if !errors.Is(err, errMemoryBounds) {
+				t.Errorf("%s: expected bounds error, got %v", tt.name, err)
+			}
+			continue
+		}
+		if err != nil || string(b) != "abcd" {
+			t.Errorf("%s: got %q, %v", tt.name, b, err)
+		}
+	}
+
+	// The header itself can be out of bounds too
+	if _, err := readBytes(&mod, mem.Size()-2); !errors.Is(err, errMemoryBounds) {
+		t.Errorf("expected bounds error for header, got %v", err)
+	}
+
+	// As can an unterminated string at the end of memory
+	end := mem.Size() - 3
+	mem.Write(end, []byte("abc"))
+	if _, err := readString(&mod, end, 0); !errors.Is(err, errMemoryBounds) {
+		t.Errorf("expected bounds error for string, got %v", err)
+	}
+}
+
+func TestModuleMountsOnlyFiles(t *testing.T) {
+	t.Parallel()
+
+	data, err := os.ReadFile("testdata/eg.mp3")
+	if err != nil {
+		t.Fatalf("read fixture: %v", err)
+	}
+	dir := t.TempDir()
+	target, sibling := filepath.Join(dir, "target.mp3"), filepath.Join(dir, "sibling.mp3")
+	for _, path := range []string{target, sibling} {
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			t.Fatalf("write file: %v", err)
+		}
+	}
+
+	mod, err := newModule(target)
+	if err != nil {
+		t.Fatalf("init module: %v", err)
+	}
+	defer mod.close()
+
+	open := func(path string) uint32 {
+		var result wasmOpenResult
+		if err := mod.call("taglib_file_open", &result, wasmString(wasmPath(path)), wasmUint8(ReadStyleFast)); err != nil {
+			t.Fatalf("call: %v", err)
+		}
+		return result.handle
+	}
+	if open(target) == 0 {
+		t.Errorf("target can't be opened")
+	}
+	if open(sibling) != 0 {
+		t.Errorf("sibling can be opened")
+	}
+
+	// The directory can be opened, but lists as empty
+	file, errno := mod.files.OpenFile(".", 0, 0)
+	if errno != 0 {
+		t.Fatalf("open dir: %v", errno)
+	}
+	defer file.Close()
+	if entries, errno := file.Readdir(-1); errno != 0 || len(entries) != 0 {
+		t.Errorf("dir lists %d entries, errno %v", len(entries), errno)
+	}
+	if errno := mod.files.Unlink("target.mp3"); errno == 0 {
+		t.Errorf("target can be removed")
+	}
+}
+
+func TestOpenFileHandleByPath(t *testing.T) {
+	t.Parallel()
+
+	path, err := filepath.Abs("testdata/eg.mp3")
+	if err != nil {
+		t.Fatalf("make path abs: %v", err)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("open file: %v", err)
+	}
+	defer file.Close()
+
+	f, err := OpenFileHandle(file)
+	if err != nil {
+		t.Fatalf("open file handle: %v", err)
+	}
+	defer f.Close()
+	if f.path != path || f.streamId != 0 || !f.readOnly {
+		t.Errorf("not opened read-only by path: path %q, stream %d", f.path, f.streamId)
+	}
+}
+
+func TestMP4ItemData(t *testing.T) {
+	t.Parallel()
+
+	box := func(typ string, content ...byte) []byte {
+		size := 8 + len(content)
+		return append([]byte{byte(size >> 24), byte(size >> 16), byte(size >> 8), byte(size), typ[0], typ[1], typ[2], typ[3]}, content...)
+	}
+	data := box("data", 0, 0, 0, 21, 0, 0, 0, 0, 4) // integer type, explicit
+	ilst := box("ilst", box("rtng", data...)...)
+	meta := box("meta", append([]byte{0, 0, 0, 0}, ilst...)...)
+	moov := box("moov", box("udta", meta...)...)
+
+	value, ok := mp4ItemData(bytes.NewReader(moov), "rtng")
+	if !ok || !bytes.Equal(value, []byte{4}) {
+		t.Fatalf("got %v, %v", value, ok)
+	}
+	if _, ok := mp4ItemData(bytes.NewReader(moov), "cpil"); ok {
+		t.Fatalf("found missing item")
+	}
+}
+
+func TestEditFS(t *testing.T) {
+	t.Parallel()
+
+	data := []byte("0123456789")
+	fsys := &editFS{name: "f", r: bytes.NewReader(data), size: int64(len(data))}
+	fsys.edits = []tagEdit{{start: 7, end: 9}, {start: 2, end: 3, data: []byte("ab")}, {start: 0, end: 1}}
+
+	f := fsys.open()
+	got, err := io.ReadAll(f)
+	if err != nil || string(got) != "1ab34569" {
+		t.Fatalf("got %q, %v", got, err)
+	}
+	buf := make([]byte, 4)
+	if n, err := f.ReadAt(buf, 1); n != 4 || err != nil || string(buf) != "ab34" {
+		t.Fatalf("got %q, %d, %v", buf[:n], n, err)
+	}
+	if n, err := f.ReadAt(buf, 6); n != 2 || err != io.EOF || string(buf[:n]) != "69" {
+		t.Fatalf("got %q, %d, %v", buf[:n], n, err)
+	}
+
+	// Without edits the file reads as it is
+	fsys.edits = nil
+	if got, _ := io.ReadAll(fsys.open()); !bytes.Equal(got, data) {
+		t.Fatalf("got %q", got)
+	}
+}
+
+func TestParseImageDescs(t *testing.T) {
+	t.Parallel()
+
+	images := parseImageDescs([]string{"Front Cover\tone\ttwo\timage/png", "Back Cover", "Other\t\timage/jpeg"})
+	want := []ImageDesc{
+		{Type: "Front Cover", Description: "one\ttwo", MIMEType: "image/png"},
+		{Type: "Back Cover"},
+		{Type: "Other", MIMEType: "image/jpeg"},
+	}
+	if !slices.Equal(images, want) {
+		t.Fatalf("got %v, want %v", images, want)
+	}
+}
+
+func TestWriteMP4Items(t *testing.T) {
+	t.Parallel()
+
+	data, err := os.ReadFile("testdata/eg.m4a")
+	if err != nil {
+		t.Fatalf("read fixture: %v", err)
+	}
+	// Move moov before mdat, as for streaming, so that growing it moves the audio
+	const mdatStart, moovStart = 36, 71
+	moov := bytes.Clone(data[moovStart:])
+	faststart := slices.Concat(data[:mdatStart], moov, data[mdatStart:moovStart])
+	if err := shiftMP4ChunkOffsets(faststart[mdatStart:mdatStart+len(moov)], 0, int64(len(moov))); err != nil {
+		t.Fatalf("shift offsets: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "eg.m4a")
+	if err := os.WriteFile(path, faststart, 0o644); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+
+	// Too big for the free box after the item list to absorb
+	long := bytes.Repeat([]byte("x"), 4000)
+	err = writeMP4Items(path, map[string][]mp4Data{
+		"----:com.apple.iTunes:LONG": {{class: mp4ClassUTF8, value: long}},
+		"©alb":                       nil,
+	})
+	if err != nil {
+		t.Fatalf("write items: %v", err)
+	}
+
+	out, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read file: %v", err)
+	}
+	r := bytes.NewReader(out)
+	mdat, _, ok := mp4Box(r, 0, int64(len(out)), "mdat")
+	if !ok {
+		t.Fatalf("no mdat")
+	}
+	stco, _, ok := mp4Path(r, 0, int64(len(out)), "moov", "trak", "mdia", "minf", "stbl", "stco")
+	if !ok {
+		t.Fatalf("no stco")
+	}
+	if offset := int64(be32(out[stco+8:])); offset != mdat {
+		t.Errorf("chunk offset %d, mdat content at %d", offset, mdat)
+	}
+	if value, ok := mp4ItemData(r, "\xa9ART"); !ok || string(value) != "example artist" {
+		t.Errorf("artist %q, %v", value, ok)
+	}
+	if _, ok := mp4ItemData(r, "\xa9alb"); ok {
+		t.Errorf("album not removed")
+	}
+	if tags, err := ReadTags(path); err != nil || len(tags["LONG"]) != 1 || tags[Artist][0] != "example artist" {
+		t.Errorf("TagLib reads %v, %v", tags, err)
+	}
+
+	// The boxes on the way to the item list are added when missing
+	box := func(typ string, content ...byte) []byte {
+		size := 8 + len(content)
+		return append([]byte{byte(size >> 24), byte(size >> 16), byte(size >> 8), byte(size), typ[0], typ[1], typ[2], typ[3]}, content...)
+	}
+	bare := box("moov", box("mvhd")...)
+	got, err := setMP4Items(bare, map[string][]mp4Data{"rtng": {{class: mp4ClassInteger, value: []byte{2}}}})
+	if err != nil {
+		t.Fatalf("set items: %v", err)
+	}
+	if value, ok := mp4ItemData(bytes.NewReader(got), "rtng"); !ok || !bytes.Equal(value, []byte{2}) {
+		t.Errorf("rtng %v, %v", value, ok)
+	}
+	if got, err := setMP4Items(bare, map[string][]mp4Data{"rtng": nil}); err != nil || !bytes.Equal(got, bare) {
+		t.Errorf("removing from a file without items changed it: %v", err)
+	}
+}
+
+func TestWriteTagsPreserveUnmappedMP4(t *testing.T) {
+	t.Parallel()
+
+	data, err := os.ReadFile("testdata/eg.m4a")
+	if err != nil {
+		t.Fatalf("read fixture: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "eg.m4a")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+	if err := writeMP4Atoms(path, map[string][]string{"©xyz": {"Shared"}, "©nam": {"Shared"}}); err != nil {
+		t.Fatalf("write atoms: %v", err)
+	}
+
+	err = WriteTags(path, map[string][]string{Title: {"New Title"}}, Clear|PreserveUnmapped)
+	if err != nil {
+		t.Fatalf("write tags: %v", err)
+	}
+	atoms, err := ReadMP4Atoms(path)
+	if err != nil {
+		t.Fatalf("read atoms: %v", err)
+	}
+	if !slices.Equal(atoms["©xyz"], []string{"Shared"}) {
+		t.Errorf("unmapped atom %q", atoms["©xyz"])
+	}
+	if !slices.Equal(atoms["©nam"], []string{"New Title"}) || len(atoms["©ART"]) != 0 {
+		t.Errorf("mapped atoms %q", atoms)
+	}
+	if matches, _ := filepath.Glob(filepath.Join(filepath.Dir(path), ".*")); len(matches) != 0 {
+		t.Errorf("temp files left behind: %q", matches)
+	}
+}
@@ -2,23 +2,33 @@ package taglib_test
 
 import (
 	"bytes"
+	"cmp"
+	"context"
 	_ "embed"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"image"
+	"image/color"
+	"io"
 	"maps"
+	"math"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"runtime"
 	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	_ "image/gif"
 	_ "image/jpeg"
-	_ "image/png"
+	"image/png"
 
 	"go.senan.xyz/taglib"
 )
@@ -45,6 +55,24 @@ func TestInvalid(t *testing.T) {
 	eq(t, err, taglib.ErrInvalidFile)
 }
 
+func TestReadTagsNoTags(t *testing.T) {
+	t.Parallel()
+
+	for _, path := range testPaths(t) {
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			err := taglib.WriteTags(path, nil, taglib.Clear)
+			nilErr(t, err)
+
+			// no tags is a valid state, so not an error and not a nil map
+			got, err := taglib.ReadTags(path)
+			nilErr(t, err)
+			if got == nil || len(got) > 0 {
+				t.Fatalf("exp empty non-nil map, got %#v", got)
+			}
+		})
+	}
+}
+
 func TestClear(t *testing.T) {
 	t.Parallel()
 
@@ -202,6 +230,65 @@ func TestMergeWrite(t *testing.T) {
 	}
 }
 
+func TestWriteTagsBytes(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name string
+		data []byte
+	}{
+		{"eg.mp3", egMP3},
+		{"eg.flac", egFLAC},
+		{"eg.m4a", egM4a},
+		{"eg.ogg", egOgg},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			orig := bytes.Clone(tc.data)
+
+			long := strings.Repeat("long title ", 1000)
+			grown, err := taglib.WriteTagsBytes(tc.data, map[string][]string{
+				taglib.Title:  {long},
+				taglib.Artist: {"bytes artist"},
+			}, taglib.Clear)
+			nilErr(t, err)
+			eq(t, bytes.Equal(tc.data, orig), true)
+
+			f, err := taglib.OpenStream(bytes.NewReader(grown))
+			nilErr(t, err)
+			tagEq(t, f.Tags(), map[string][]string{
+				taglib.Title:  {long},
+				taglib.Artist: {"bytes artist"},
+			})
+			nilErr(t, f.Close())
+
+			// Shrinking the tags must truncate the file rather than leave stale bytes at the end
+			shrunk, err := taglib.WriteTagsBytes(grown, map[string][]string{
+				taglib.Title: {"short"},
+			}, taglib.Clear)
+			nilErr(t, err)
+
+			f, err = taglib.OpenStream(bytes.NewReader(shrunk))
+			nilErr(t, err)
+			tagEq(t, f.Tags(), map[string][]string{
+				taglib.Title: {"short"},
+			})
+			nilErr(t, f.Close())
+
+			path := tmpf(t, shrunk, tc.name)
+			tags, err := taglib.ReadTags(path)
+			nilErr(t, err)
+			tagEq(t, tags, map[string][]string{
+				taglib.Title: {"short"},
+			})
+		})
+	}
+
+	_, err := taglib.WriteTagsBytes([]byte("not audio"), map[string][]string{taglib.Title: {"x"}}, 0)
+	if err == nil {
+		t.Fatalf("expected error for invalid data")
+	}
+}
+
 func TestReadExistingUnicode(t *testing.T) {
 	tags, err := taglib.ReadTags("testdata/normal.flac")
 	nilErr(t, err)
@@ -263,6 +350,42 @@ func TestOpenStream(t *testing.T) {
 	}
 }
 
+// seekCounter is an io.ReadSeeker that isn't an io.ReaderAt, counting its seeks.
+type seekCounter struct {
+	r     io.ReadSeeker
+	seeks int
+}
+
+func (s *seekCounter) Read(p []byte) (int, error) { return s.r.Read(p) }
+
+func (s *seekCounter) Seek(offset int64, whence int) (int64, error) {
+	s.seeks++
+	return s.r.Seek(offset, whence)
+}
+
+func TestOpenStreamNotMoved(t *testing.T) {
+	t.Parallel()
+
+	// Only TagLib moves a stream that can't be read at an offset
+	r := &seekCounter{r: bytes.NewReader(egFLAC)}
+	f, err := taglib.OpenStream(r)
+	nilErr(t, err)
+	defer func() { _ = f.Close() }()
+
+	r.seeks = 0
+	props := f.Properties()
+	eq(t, props.SampleRate > 0, true)
+	eq(t, props.CueTrackCount, 0)
+	_ = f.Tags()
+	eq(t, r.seeks, 0)
+
+	// Streams that can be are read for the values TagLib doesn't read
+	f, err = taglib.OpenStream(bytes.NewReader(egFLAC))
+	nilErr(t, err)
+	defer func() { _ = f.Close() }()
+	eq(t, f.Properties().ChannelLayout, "stereo")
+}
+
 func TestOpenStreamWithFilename(t *testing.T) {
 	t.Parallel()
 
@@ -280,6 +403,230 @@ func TestOpenStreamWithFilename(t *testing.T) {
 	eq(t, tags[taglib.Album][0], "Test Album")
 }
 
+func TestOpenWithFormat(t *testing.T) {
+	t.Parallel()
+
+	// An extension that doesn't match the content
+	path := tmpf(t, egMP3, "eg.flac")
+	f, err := taglib.OpenReadOnly(path, taglib.WithFormat(taglib.FormatMPEG))
+	nilErr(t, err)
+	eq(t, f.Format(), taglib.FormatMPEG)
+	eq(t, len(f.Tags()) > 0, true)
+	nilErr(t, f.SetReadStyle(taglib.ReadStyleAccurate))
+	eq(t, f.Format(), taglib.FormatMPEG)
+	nilErr(t, f.Close())
+
+	_, err = taglib.OpenReadOnly(path, taglib.WithFormat(taglib.FormatFLAC))
+	if !errors.Is(err, taglib.ErrInvalidFile) {
+		t.Fatalf("expected ErrInvalidFile, got %v", err)
+	}
+
+	// Files opened as a format can be written
+	f, err = taglib.Open(path, taglib.WithFormat(taglib.FormatMPEG))
+	nilErr(t, err)
+	nilErr(t, f.WriteTags(map[string][]string{taglib.Title: {"Forced"}}, 0))
+	nilErr(t, f.Close())
+	f, err = taglib.OpenReadOnly(path, taglib.WithFormat(taglib.FormatMPEG))
+	nilErr(t, err)
+	eq(t, f.Tags()[taglib.Title][0], "Forced")
+	nilErr(t, f.Close())
+
+	// Files opened by Reopen are opened as the format too
+	f, err = taglib.OpenReadOnly(tmpf(t, egFLAC, "eg.mp3"), taglib.WithFormat(taglib.FormatFLAC))
+	nilErr(t, err)
+	nilErr(t, f.Reopen(tmpf(t, egFLAC, "other.mp3")))
+	eq(t, f.Format(), taglib.FormatFLAC)
+	err = f.Reopen(tmpf(t, egMP3, "eg.mp3"))
+	if !errors.Is(err, taglib.ErrInvalidFile) {
+		t.Fatalf("expected ErrInvalidFile, got %v", err)
+	}
+
+	// A file only another parser accepts isn't opened, such as an Ogg Vorbis file as Ogg FLAC
+	_, err = taglib.OpenReadOnly(tmpf(t, egOgg, "eg.ogg"), taglib.WithFormat(taglib.FormatOggFLAC))
+	if !errors.Is(err, taglib.ErrInvalidFile) {
+		t.Fatalf("expected ErrInvalidFile, got %v", err)
+	}
+
+	f, err = taglib.OpenStream(bytes.NewReader(egM4a), taglib.WithFormat(taglib.FormatMP4))
+	nilErr(t, err)
+	eq(t, f.Format(), taglib.FormatMP4)
+	nilErr(t, f.Close())
+
+	_, err = taglib.OpenStream(bytes.NewReader(egM4a), taglib.WithFormat(taglib.FormatOggVorbis))
+	if !errors.Is(err, taglib.ErrInvalidFile) {
+		t.Fatalf("expected ErrInvalidFile, got %v", err)
+	}
+}
+
+func TestOpenFileHandle(t *testing.T) {
+	t.Parallel()
+
+	path := tmpf(t, egFLAC, "eg.flac")
+	file, err := os.Open(path)
+	nilErr(t, err)
+	t.Cleanup(func() { file.Close() })
+
+	f, err := taglib.OpenFileHandle(file)
+	nilErr(t, err)
+	eq(t, f.Format(), taglib.FormatFLAC)
+	tags, err := taglib.ReadTags(path)
+	nilErr(t, err)
+	eq(t, maps.EqualFunc(f.Tags(), tags, slices.Equal), true)
+	nilErr(t, f.Close())
+
+	// A removed file is read through the handle, with its name as the format hint
+	path = tmpf(t, egOpus, "eg.opus")
+	file, err = os.Open(path)
+	nilErr(t, err)
+	t.Cleanup(func() { file.Close() })
+	nilErr(t, os.Remove(path))
+
+	f, err = taglib.OpenFileHandle(file)
+	nilErr(t, err)
+	t.Cleanup(func() { f.Close() })
+	eq(t, f.Format(), taglib.FormatOggOpus)
+	eq(t, f.Tags()[taglib.Title][0], "Test")
+}
+
+func TestReadLimits(t *testing.T) {
+	t.Parallel()
+
+	// eg.flac has a PNG of almost 1 MiB as its first image
+	path := tmpf(t, egFLAC, "eg.flac")
+
+	f, err := taglib.Open(path, taglib.WithMaxImageBytes(1<<20))
+	nilErr(t, err)
+	img, err := f.Image(0)
+	nilErr(t, err)
+	eq(t, len(img) > 1<<10, true)
+	nilErr(t, f.Close())
+
+	f, err = taglib.Open(path, taglib.WithMaxImageBytes(1<<10))
+	nilErr(t, err)
+	_, err = f.Image(0)
+	eq(t, errors.Is(err, taglib.ErrTooLarge), true)
+	nilErr(t, f.Close())
+
+	s, err := taglib.OpenStream(bytes.NewReader(egFLAC), taglib.WithMaxImageBytes(1<<10))
+	nilErr(t, err)
+	_, err = s.Image(0)
+	eq(t, errors.Is(err, taglib.ErrTooLarge), true)
+	nilErr(t, s.Close())
+
+	err = taglib.WriteTags(path, map[string][]string{
+		taglib.Title:  {"Title"},
+		taglib.Lyrics: {strings.Repeat("la ", 1000)},
+	}, 0)
+	nilErr(t, err)
+
+	f, err = taglib.Open(path, taglib.WithMaxTagBytes(1<<10))
+	nilErr(t, err)
+	defer func() { _ = f.Close() }()
+	_, err = f.Lyrics()
+	eq(t, errors.Is(err, taglib.ErrTooLarge), true)
+
+	// The limit is for all the tags combined
+	err = f.Reopen(tmpf(t, egFLAC, "eg.flac"))
+	nilErr(t, err)
+	lyrics, err := f.Lyrics()
+	nilErr(t, err)
+	eq(t, lyrics, "")
+
+	many := map[string][]string{}
+	for i := range 100 {
+		many[fmt.Sprintf("KEY_%d", i)] = []string{"a value of some length"}
+	}
+	nilErr(t, f.WriteTags(many, taglib.Clear))
+	_, err = f.Lyrics()
+	eq(t, errors.Is(err, taglib.ErrTooLarge), true)
+}
+
+func TestReadValueLimit(t *testing.T) {
+	t.Parallel()
+
+	path := tmpf(t, egFLAC, "eg.flac")
+	err := taglib.WriteTags(path, map[string][]string{
+		taglib.Title:  {"Title"},
+		taglib.Lyrics: {strings.Repeat("la ", 1<<20)},
+	}, 0)
+	nilErr(t, err)
+
+	f, err := taglib.Open(path, taglib.WithMaxValueBytes(1<<16))
+	nilErr(t, err)
+	defer func() { _ = f.Close() }()
+	_, err = f.Lyrics()
+	eq(t, errors.Is(err, taglib.ErrTooLarge), true)
+
+	// The limit is for each value, so many small ones are fine
+	many := map[string][]string{}
+	for i := range 100 {
+		many[fmt.Sprintf("KEY_%d", i)] = []string{strings.Repeat("a", 1<<10)}
+	}
+	nilErr(t, f.WriteTags(many, taglib.Clear))
+	tags := f.Tags()
+	eq(t, len(tags), 100)
+
+	// The combined tag limit still applies alongside a larger value limit
+	path = tmpf(t, egFLAC, "eg.flac")
+	nilErr(t, taglib.WriteTags(path, map[string][]string{taglib.Lyrics: {strings.Repeat("la ", 1<<10)}}, 0))
+	g, err := taglib.Open(path, taglib.WithMaxValueBytes(1<<20), taglib.WithMaxTagBytes(1<<9))
+	nilErr(t, err)
+	defer func() { _ = g.Close() }()
+	_, err = g.Lyrics()
+	eq(t, errors.Is(err, taglib.ErrTooLarge), true)
+}
+
+func TestOpenStreamContext(t *testing.T) {
+	t.Parallel()
+
+	t.Run("ok", func(t *testing.T) {
+		f, err := taglib.OpenStream(bytes.NewReader(egFLAC), taglib.WithContext(context.Background()))
+		nilErr(t, err)
+		defer func() { _ = f.Close() }()
+
+		eq(t, f.Format(), taglib.FormatFLAC)
+		eq(t, f.Tags()[taglib.Artist][0], "example artist")
+	})
+
+	t.Run("cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := taglib.OpenStream(bytes.NewReader(egFLAC), taglib.WithContext(ctx))
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected context.Canceled, got %v", err)
+		}
+	})
+
+	t.Run("stalled reader", func(t *testing.T) {
+		unblock := make(chan struct{})
+		defer close(unblock)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		start := time.Now()
+		_, err := taglib.OpenStream(&stalledReader{ReadSeeker: bytes.NewReader(egFLAC), unblock: unblock}, taglib.WithContext(ctx))
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+		}
+		if elapsed := time.Since(start); elapsed > 5*time.Second {
+			t.Fatalf("open took too long: %v", elapsed)
+		}
+	})
+}
+
+// stalledReader blocks on every read until unblock is closed
+type stalledReader struct {
+	io.ReadSeeker
+	unblock chan struct{}
+}
+
+func (r *stalledReader) Read(p []byte) (int, error) {
+	<-r.unblock
+	return 0, io.EOF
+}
+
 func TestWAVLatin1InfoChunk(t *testing.T) {
 	t.Parallel()
 
@@ -343,6 +690,73 @@ func TestOpenStreamConcurrent(t *testing.T) {
 	nilErr(t, err)
 }
 
+func TestOpenURL(t *testing.T) {
+	t.Parallel()
+
+	// pad the audio so it's clear the whole file isn't fetched
+	data := append(slices.Clone(egFLAC), make([]byte, 8<<20)...)
+	path := tmpf(t, data, "eg.flac")
+
+	var served, requests atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		http.ServeContent(countingResponseWriter{w, &served}, r, "", time.Time{}, bytes.NewReader(data))
+	}))
+	defer srv.Close()
+
+	f, err := taglib.OpenURL(t.Context(), srv.URL+"/music/eg.flac")
+	nilErr(t, err)
+	defer f.Close()
+
+	want, err := taglib.ReadTags(path)
+	nilErr(t, err)
+	tags := f.Tags()
+	eq(t, len(tags), len(want))
+	for k, v := range want {
+		eq(t, strings.Join(tags[k], "\n"), strings.Join(v, "\n"))
+	}
+	eq(t, f.Properties().SampleRate, uint(48000))
+	// The properties read from the file itself are only read once
+	before := requests.Load()
+	eq(t, f.Properties().SampleRate, uint(48000))
+	eq(t, requests.Load(), before)
+	if n := served.Load(); n >= int64(len(data))/4 {
+		t.Fatalf("fetched %d of %d bytes", n, len(data))
+	}
+
+	r, err := taglib.NewRangeReader(t.Context(), srv.Client(), srv.URL)
+	nilErr(t, err)
+	eq(t, r.Size(), int64(len(data)))
+	_, err = r.Seek(-int64(len(egFLAC)), io.SeekEnd)
+	nilErr(t, err)
+	_, err = r.Seek(-int64(len(data)-len(egFLAC)), io.SeekCurrent)
+	nilErr(t, err)
+	got, err := io.ReadAll(io.LimitReader(r, int64(len(egFLAC))))
+	nilErr(t, err)
+	eq(t, bytes.Equal(got, egFLAC), true)
+
+	// servers that ignore the range header would have to send the whole file
+	full := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(data)
+	}))
+	defer full.Close()
+	_, err = taglib.OpenURL(t.Context(), full.URL+"/eg.flac")
+	if err == nil {
+		t.Fatalf("expected error for server without range support")
+	}
+}
+
+type countingResponseWriter struct {
+	http.ResponseWriter
+	n *atomic.Int64
+}
+
+func (w countingResponseWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	w.n.Add(int64(n))
+	return n, err
+}
+
 func TestConcurrent(t *testing.T) {
 	t.Parallel()
 
@@ -446,6 +860,110 @@ func TestPropertiesCodec(t *testing.T) {
 	}
 }
 
+func TestPropertiesChannelLayout(t *testing.T) {
+	t.Parallel()
+
+	// A 6 channel WAVE_FORMAT_EXTENSIBLE file with the channel mask
+	extensibleWAV := func(mask uint32) []byte {
+		le := func(v uint32, n int) []byte {
+			b := make([]byte, n)
+			for i := range b {
+				b[i] = byte(v >> (8 * i))
+			}
+			return b
+		}
+		var fmtChunk []byte
+		fmtChunk = append(fmtChunk, le(0xFFFE, 2)...)                                                      // Format tag
+		fmtChunk = append(fmtChunk, le(6, 2)...)                                                           // Channels
+		fmtChunk = append(fmtChunk, le(44100, 4)...)                                                       // Sample rate
+		fmtChunk = append(fmtChunk, le(44100*12, 4)...)                                                    // Byte rate
+		fmtChunk = append(fmtChunk, le(12, 2)...)                                                          // Block align
+		fmtChunk = append(fmtChunk, le(16, 2)...)                                                          // Bits per sample
+		fmtChunk = append(fmtChunk, le(22, 2)...)                                                          // Extension size
+		fmtChunk = append(fmtChunk, le(16, 2)...)                                                          // Valid bits per sample
+		fmtChunk = append(fmtChunk, le(mask, 4)...)                                                        // Channel mask
+		fmtChunk = append(fmtChunk, "\x01\x00\x00\x00\x00\x00\x10\x00\x80\x00\x00\xaa\x00\x38\x9b\x71"...) // PCM
+
+		var body []byte
+		body = append(body, "WAVE"...)
+		body = append(body, "fmt "...)
+		body = append(body, le(uint32(len(fmtChunk)), 4)...)
+		body = append(body, fmtChunk...)
+		body = append(body, "data"...)
+		body = append(body, le(12*100, 4)...)
+		body = append(body, make([]byte, 12*100)...)
+		return append(append([]byte("RIFF"), le(uint32(len(body)), 4)...), body...)
+	}
+
+	// The FLAC fixture with its STREAMINFO changed to 6 channels
+	sixChannelFLAC := slices.Clone(egFLAC)
+	sixChannelFLAC[20] = sixChannelFLAC[20]&^0x0E | 5<<1
+
+	tests := []struct {
+		name     string
+		data     []byte
+		filename string
+		tags     map[string][]string
+		layout   string
+	}{
+		{"MP3", egMP3, "eg.mp3", nil, "stereo"},
+		{"M4A", egM4a, "eg.m4a", nil, "stereo"},
+		{"FLAC", egFLAC, "eg.flac", nil, "stereo"},
+		{"FLAC default", sixChannelFLAC, "eg.flac", nil, "5.1"},
+		{"FLAC mask", sixChannelFLAC, "eg.flac", map[string][]string{"WAVEFORMATEXTENSIBLE_CHANNEL_MASK": {"0x60F"}}, "5.1"},
+		{"FLAC discrete", sixChannelFLAC, "eg.flac", map[string][]string{"WAVEFORMATEXTENSIBLE_CHANNEL_MASK": {"0x0"}}, "6 channels"},
+		{"WAV mask", extensibleWAV(0x3F), "eg.wav", nil, "5.1"},
+		{"WAV discrete", extensibleWAV(0), "eg.wav", nil, "6 channels"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := tmpf(t, tt.data, tt.filename)
+			if tt.tags != nil {
+				nilErr(t, taglib.WriteTags(path, tt.tags, 0))
+			}
+
+			properties, err := taglib.ReadProperties(path)
+			nilErr(t, err)
+			eq(t, tt.layout, properties.ChannelLayout)
+
+			f, err := taglib.OpenReadOnly(path)
+			nilErr(t, err)
+			t.Cleanup(func() { f.Close() })
+			eq(t, tt.layout, f.Properties().ChannelLayout)
+		})
+	}
+}
+
+func TestPropertiesHasVideo(t *testing.T) {
+	t.Parallel()
+
+	path := tmpf(t, egM4a, "eg.m4a")
+	properties, err := taglib.ReadProperties(path)
+	nilErr(t, err)
+	eq(t, properties.HasVideo, false)
+
+	// The fixture with its track changed to a video track
+	i := bytes.Index(egM4a, []byte("hdlr"))
+	video := slices.Clone(egM4a)
+	copy(video[i+12:], "vide")
+	path = tmpf(t, video, "eg.m4a")
+
+	properties, err = taglib.ReadProperties(path)
+	nilErr(t, err)
+	eq(t, properties.HasVideo, true)
+
+	f, err := taglib.OpenReadOnly(path)
+	nilErr(t, err)
+	t.Cleanup(func() { f.Close() })
+	eq(t, f.Properties().HasVideo, true)
+
+	path = tmpf(t, egFLAC, "eg.flac")
+	properties, err = taglib.ReadProperties(path)
+	nilErr(t, err)
+	eq(t, properties.HasVideo, false)
+}
+
 func TestMultiOpen(t *testing.T) {
 	t.Parallel()
 
@@ -502,34 +1020,213 @@ func TestWriteImage(t *testing.T) {
 	if b.Dx() != 700 || b.Dy() != 700 {
 		t.Fatalf("bad image dimensions: %d, %d != 700, 700", b.Dx(), b.Dy())
 	}
+
+	properties, err := taglib.ReadProperties(path)
+	nilErr(t, err)
+	eq(t, properties.Images[0].Type, "Front Cover")
+	eq(t, properties.Images[0].Description, "")
 }
 
-func TestClearImage(t *testing.T) {
+func TestWriteImageConvert(t *testing.T) {
+	t.Parallel()
+
+	// cover.jpg is really a PNG
+	img, _, err := image.Decode(bytes.NewReader(coverJPG))
+	nilErr(t, err)
+
+	for _, tt := range []struct {
+		format string
+		mime   string
+	}{
+		{"jpeg", "image/jpeg"},
+		{"PNG", "image/png"},
+	} {
+		path := tmpf(t, egFLAC, "eg.flac")
+		err := taglib.WriteImageConvert(path, img, tt.format, 80)
+		nilErr(t, err)
+
+		properties, err := taglib.ReadProperties(path)
+		nilErr(t, err)
+		eq(t, properties.Images[0].Type, "Front Cover")
+		eq(t, properties.Images[0].MIMEType, tt.mime)
+
+		decoded, err := taglib.ReadImageDecoded(path)
+		nilErr(t, err)
+		eq(t, decoded.Bounds(), img.Bounds())
+	}
+
 	path := tmpf(t, egFLAC, "eg.flac")
+	for _, quality := range []int{0, 101} {
+		if err := taglib.WriteImageConvert(path, img, "jpeg", quality); err == nil {
+			t.Errorf("expected error for quality %d", quality)
+		}
+	}
+	err = taglib.WriteImageConvert(path, img, "bmp", 80)
+	eq(t, errors.Is(err, taglib.ErrInvalidImage), true)
+}
 
-	properties, err := taglib.ReadProperties(path)
+func TestImageAt(t *testing.T) {
+	t.Parallel()
+
+	// eg.flac has a "Front Cover" then a "Lead Artist" image. Add another "Front Cover" after them.
+	path := tmpf(t, egFLAC, "eg.flac")
+	err := taglib.WriteImageOptions(path, coverJPG, 2, string(taglib.PictureFrontCover), "", "")
 	nilErr(t, err)
-	eq(t, len(properties.Images) == 2, true) // have two imaages
-	eq(t, properties.Images[0].Description, "The first image")
 
-	img, err := taglib.ReadImage(path)
+	f, err := taglib.Open(path)
 	nilErr(t, err)
-	eq(t, len(img) > 0, true)
+	defer func() { _ = f.Close() }()
 
-	nilErr(t, taglib.WriteImage(path, nil))
+	first, err := f.Image(0)
+	nilErr(t, err)
+	second, err := f.Image(1)
+	nilErr(t, err)
 
-	properties, err = taglib.ReadProperties(path)
+	img, err := f.ImageAt(taglib.PictureFrontCover, 0)
 	nilErr(t, err)
-	eq(t, len(properties.Images) == 1, true) // have one images
-	eq(t, properties.Images[0].Description, "The second image")
+	eq(t, bytes.Equal(img, first), true)
 
-	nilErr(t, taglib.WriteImage(path, nil))
+	img, err = f.ImageAt(taglib.PictureFrontCover, 1)
+	nilErr(t, err)
+	eq(t, bytes.Equal(img, coverJPG), true)
 
-	properties, err = taglib.ReadProperties(path)
+	img, err = f.ImageAt(taglib.PictureLeadArtist, 0)
 	nilErr(t, err)
-	eq(t, len(properties.Images) == 0, true) // have zero images
+	eq(t, bytes.Equal(img, second), true)
 
-	img, err = taglib.ReadImage(path)
+	img, err = f.ImageAt(taglib.PictureLeadArtist, 1)
+	nilErr(t, err)
+	eq(t, len(img), 0)
+
+	img, err = f.ImageAt(taglib.PictureBackCover, 0)
+	nilErr(t, err)
+	eq(t, len(img), 0)
+}
+
+func TestImageOrder(t *testing.T) {
+	t.Parallel()
+
+	jpeg := func(b byte) []byte { return []byte{0xFF, 0xD8, 0xFF, 0xE0, b, b, b, b} }
+	images := []struct {
+		data []byte
+		mime string
+	}{
+		{jpeg(1), "image/jpeg"},
+		{coverJPG, "image/png"}, // cover.jpg is really a PNG
+		{jpeg(2), "image/jpeg"},
+	}
+
+	for _, path := range []string{tmpf(t, egFLAC, "eg.flac"), tmpf(t, egM4a, "eg.m4a")} {
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			for i, img := range images {
+				err := taglib.WriteImageOptions(path, img.data, i, "Front Cover", fmt.Sprint(i), img.mime)
+				nilErr(t, err)
+			}
+
+			f, err := taglib.OpenReadOnly(path)
+			nilErr(t, err)
+			defer func() { _ = f.Close() }()
+
+			descs := f.Properties().Images
+			eq(t, len(descs), len(images))
+			for i, desc := range descs {
+				data, err := f.Image(i)
+				nilErr(t, err)
+				eq(t, bytes.Equal(data, images[i].data), true)
+				eq(t, desc.MIMEType, images[i].mime)
+				if f.Format() == taglib.FormatFLAC {
+					// MP4 doesn't store descriptions
+					eq(t, desc.Description, fmt.Sprint(i))
+				}
+			}
+		})
+	}
+}
+
+func TestImageCountsByType(t *testing.T) {
+	t.Parallel()
+
+	// eg.flac has a "Front Cover" then a "Lead Artist" image. Add another "Front Cover" after them.
+	path := tmpf(t, egFLAC, "eg.flac")
+	err := taglib.WriteImageOptions(path, coverJPG, 2, string(taglib.PictureFrontCover), "", "")
+	nilErr(t, err)
+
+	f, err := taglib.Open(path)
+	nilErr(t, err)
+	defer func() { _ = f.Close() }()
+
+	counts, err := f.ImageCountsByType()
+	nilErr(t, err)
+	eq(t, len(counts), 2)
+	eq(t, counts[taglib.PictureFrontCover], 2)
+	eq(t, counts[taglib.PictureLeadArtist], 1)
+
+	path = tmpf(t, egOgg, "eg.ogg")
+	g, err := taglib.OpenReadOnly(path)
+	nilErr(t, err)
+	defer func() { _ = g.Close() }()
+	counts, err = g.ImageCountsByType()
+	nilErr(t, err)
+	eq(t, len(counts), 0)
+}
+
+func TestReplaceImage(t *testing.T) {
+	t.Parallel()
+
+	path := tmpf(t, egFLAC, "eg.flac")
+
+	// The second image is a "Lead Artist" picture
+	err := taglib.ReplaceImage(path, coverJPG, 1)
+	nilErr(t, err)
+
+	properties, err := taglib.ReadProperties(path)
+	nilErr(t, err)
+	eq(t, len(properties.Images), 2)
+	eq(t, properties.Images[1].Type, "Lead Artist")
+	eq(t, properties.Images[1].Description, "The second image")
+	eq(t, properties.Images[1].MIMEType, "image/png") // cover.jpg is really a PNG
+
+	img, err := taglib.ReadImageOptions(path, 1)
+	nilErr(t, err)
+	eq(t, bytes.Equal(img, coverJPG), true)
+
+	// Appending has nothing to inherit
+	err = taglib.ReplaceImage(path, coverJPG, 2)
+	nilErr(t, err)
+
+	properties, err = taglib.ReadProperties(path)
+	nilErr(t, err)
+	eq(t, len(properties.Images), 3)
+	eq(t, properties.Images[2].Type, "Front Cover")
+	eq(t, properties.Images[2].Description, "")
+}
+
+func TestClearImage(t *testing.T) {
+	path := tmpf(t, egFLAC, "eg.flac")
+
+	properties, err := taglib.ReadProperties(path)
+	nilErr(t, err)
+	eq(t, len(properties.Images) == 2, true) // have two imaages
+	eq(t, properties.Images[0].Description, "The first image")
+
+	img, err := taglib.ReadImage(path)
+	nilErr(t, err)
+	eq(t, len(img) > 0, true)
+
+	nilErr(t, taglib.WriteImage(path, nil))
+
+	properties, err = taglib.ReadProperties(path)
+	nilErr(t, err)
+	eq(t, len(properties.Images) == 1, true) // have one images
+	eq(t, properties.Images[0].Description, "The second image")
+
+	nilErr(t, taglib.WriteImage(path, nil))
+
+	properties, err = taglib.ReadProperties(path)
+	nilErr(t, err)
+	eq(t, len(properties.Images) == 0, true) // have zero images
+
+	img, err = taglib.ReadImage(path)
 	nilErr(t, err)
 	eq(t, len(img) == 0, true)
 }
@@ -812,6 +1509,84 @@ func TestReadMP4AtomsInvalid(t *testing.T) {
 	}
 }
 
+func TestReadMP4AtomRaw(t *testing.T) {
+	t.Parallel()
+
+	path := tmpf(t, egM4a, "eg.m4a")
+	err := taglib.WriteTags(path, map[string][]string{
+		taglib.Artist: {"Artist"},
+		"MY_KEY":      {"a", "b"},
+	}, taglib.Clear)
+	nilErr(t, err)
+	nilErr(t, taglib.WriteImage(path, coverJPG))
+
+	values, err := taglib.ReadMP4AtomRaw(path, "©ART")
+	nilErr(t, err)
+	eq(t, len(values), 1)
+	eq(t, string(values[0]), "Artist")
+
+	values, err = taglib.ReadMP4AtomRaw(path, "covr")
+	nilErr(t, err)
+	eq(t, len(values), 1)
+	eq(t, bytes.Equal(values[0], coverJPG), true)
+
+	values, err = taglib.ReadMP4AtomRaw(path, "----:com.apple.iTunes:MY_KEY")
+	nilErr(t, err)
+	eq(t, len(values), 2)
+	eq(t, string(values[1]), "b")
+
+	values, err = taglib.ReadMP4AtomRaw(path, "©alb")
+	nilErr(t, err)
+	eq(t, len(values), 0)
+
+	_, err = taglib.ReadMP4AtomRaw(tmpf(t, egFLAC, "eg.flac"), "covr")
+	eq(t, err, taglib.ErrInvalidFile)
+}
+
+func TestMP4Movement(t *testing.T) {
+	t.Parallel()
+
+	path := tmpf(t, egM4a, "eg.m4a")
+
+	movement := map[string][]string{
+		taglib.Work:             {"Symphony No. 5"},
+		taglib.MovementName:     {"Allegro con brio"},
+		taglib.MovementNumber:   {"1"},
+		taglib.MovementCount:    {"4"},
+		taglib.ShowWorkMovement: {"1"},
+	}
+	err := taglib.WriteTags(path, movement, taglib.Clear)
+	nilErr(t, err)
+
+	tags, err := taglib.ReadTags(path)
+	nilErr(t, err)
+	tagEq(t, tags, movement)
+
+	atoms, err := taglib.ReadMP4Atoms(path)
+	nilErr(t, err)
+	eq(t, atoms["©wrk"][0], "Symphony No. 5")
+	eq(t, atoms["©mvn"][0], "Allegro con brio")
+	eq(t, atoms["©mvi"][0], "1")
+	eq(t, atoms["©mvc"][0], "4")
+	eq(t, atoms["shwm"][0], "1")
+
+	// Nothing is left behind in free-form atoms
+	for k := range atoms {
+		if strings.HasPrefix(k, "----:") {
+			t.Errorf("unexpected free-form atom %q", k)
+		}
+	}
+
+	err = taglib.WriteTags(path, map[string][]string{taglib.MovementName: nil, taglib.ShowWorkMovement: nil}, 0)
+	nilErr(t, err)
+
+	atoms, err = taglib.ReadMP4Atoms(path)
+	nilErr(t, err)
+	eq(t, len(atoms["©mvn"]), 0)
+	eq(t, len(atoms["shwm"]), 0)
+	eq(t, atoms["©mvi"][0], "1")
+}
+
 func TestReadMP4AtomsIntPair(t *testing.T) {
 	t.Parallel()
 
@@ -982,6 +1757,44 @@ func TestWriteID3v2Frames(t *testing.T) {
 	}
 }
 
+func TestRemoveID3v2Frame(t *testing.T) {
+	t.Parallel()
+
+	path := tmpf(t, egMP3, "eg.mp3")
+	err := taglib.WriteTags(path, map[string][]string{
+		taglib.Title: {"Title"},
+		"CUSTOM_ONE": {"one"},
+		"CUSTOM_TWO": {"two"},
+	}, 0)
+	nilErr(t, err)
+
+	n, err := taglib.RemoveID3v2Frame(path, "TXXX:CUSTOM_ONE")
+	nilErr(t, err)
+	eq(t, n, 1)
+
+	frames, err := taglib.ReadID3v2Frames(path)
+	nilErr(t, err)
+	eq(t, len(frames["TXXX:CUSTOM_ONE"]), 0)
+	eq(t, frames["TXXX:CUSTOM_TWO"][0], "two")
+
+	// Without a description every frame with the ID goes
+	n, err = taglib.RemoveID3v2Frame(path, "TXXX")
+	nilErr(t, err)
+	eq(t, n >= 1, true)
+
+	frames, err = taglib.ReadID3v2Frames(path)
+	nilErr(t, err)
+	eq(t, len(frames["TXXX:CUSTOM_TWO"]), 0)
+	eq(t, frames["TIT2"][0], "Title")
+
+	n, err = taglib.RemoveID3v2Frame(path, "TXXX")
+	nilErr(t, err)
+	eq(t, n, 0)
+
+	_, err = taglib.RemoveID3v2Frame(tmpf(t, egFLAC, "eg.flac"), "TXXX")
+	eq(t, errors.Is(err, taglib.ErrUnsupportedFormat), true)
+}
+
 func TestWriteID3v2FramesMerge(t *testing.T) {
 	t.Parallel()
 
@@ -1162,6 +1975,10 @@ var (
 	egMP3 []byte
 	//go:embed testdata/eg.m4a
 	egM4a []byte
+	//go:embed testdata/eg_gnre.m4a
+	egM4aGnre []byte
+	//go:embed testdata/eg_gnre_gen.m4a
+	egM4aGnreGen []byte
 	//go:embed testdata/eg.ogg
 	egOgg []byte
 	//go:embed testdata/eg.wav
@@ -1170,12 +1987,22 @@ var (
 	egAIFF []byte
 	//go:embed testdata/eg.opus
 	egOpus []byte
+	//go:embed testdata/eg.oga
+	egOggFLAC []byte
+	//go:embed testdata/eg.spx
+	egSpeex []byte
+	//go:embed testdata/eg.ape
+	egAPE []byte
+	//go:embed testdata/eg.wv
+	egWavPack []byte
 	//go:embed testdata/eg.wma
 	egWMA []byte
 	//go:embed testdata/eg-latin1-info.wav
 	egWAVLatin1 []byte
 	//go:embed testdata/eg_lyrics.mp3
 	egMP3Lyrics []byte
+	//go:embed testdata/eg_chapters.mp3
+	egMP3Chapters []byte
 	//go:embed testdata/eg.mka
 	egMKA []byte
 	//go:embed testdata/eg_track_tags.mka
@@ -1689,3 +2516,4028 @@ func TestFileFormatString(t *testing.T) {
 		eq(t, tt.format.String(), tt.want)
 	}
 }
+
+func TestWriteTagsTruncation(t *testing.T) {
+	t.Parallel()
+
+	long := strings.Repeat("x", 40)
+
+	t.Run("error", func(t *testing.T) {
+		path := tmpf(t, egMP3, "eg.mp3")
+		err := taglib.WriteTags(path, map[string][]string{
+			taglib.Title: {long},
+			taglib.Album: {"short"},
+		}, taglib.TruncateError)
+
+		var terr *taglib.TruncationError
+		if !errors.As(err, &terr) {
+			t.Fatalf("expected truncation error, got %v", err)
+		}
+		eq(t, strings.Join(terr.Keys, ","), taglib.Title)
+
+		// nothing should have been written
+		tags, err := taglib.ReadTags(path)
+		nilErr(t, err)
+		eq(t, tags[taglib.Album][0], "example album")
+	})
+
+	t.Run("warn", func(t *testing.T) {
+		path := tmpf(t, egMP3, "eg.mp3")
+		err := taglib.WriteTags(path, map[string][]string{
+			taglib.Title:   {long},
+			taglib.Comment: {long},
+		}, taglib.TruncateWarn)
+
+		var terr *taglib.TruncationError
+		if !errors.As(err, &terr) {
+			t.Fatalf("expected truncation error, got %v", err)
+		}
+		eq(t, strings.Join(terr.Keys, ","), taglib.Comment+","+taglib.Title)
+
+		// but the full value is still written to ID3v2
+		tags, err := taglib.ReadTags(path)
+		nilErr(t, err)
+		eq(t, tags[taglib.Title][0], long)
+	})
+
+	t.Run("silent", func(t *testing.T) {
+		path := tmpf(t, egMP3, "eg.mp3")
+		err := taglib.WriteTags(path, map[string][]string{
+			taglib.Title: {long},
+		}, 0)
+		nilErr(t, err)
+	})
+
+	t.Run("no fixed fields", func(t *testing.T) {
+		path := tmpf(t, egFLAC, "eg.flac")
+		err := taglib.WriteTags(path, map[string][]string{
+			taglib.Title: {long},
+		}, taglib.TruncateError)
+		nilErr(t, err)
+	})
+
+	t.Run("file", func(t *testing.T) {
+		path := tmpf(t, egMP3, "eg.mp3")
+		f, err := taglib.Open(path)
+		nilErr(t, err)
+		defer func() { _ = f.Close() }()
+
+		err = f.WriteTags(map[string][]string{
+			taglib.Artist: {long},
+		}, taglib.TruncateError)
+
+		var terr *taglib.TruncationError
+		if !errors.As(err, &terr) {
+			t.Fatalf("expected truncation error, got %v", err)
+		}
+	})
+}
+
+func TestReadImageWithFallback(t *testing.T) {
+	t.Parallel()
+
+	t.Run("embedded", func(t *testing.T) {
+		path := tmpf(t, egFLAC, "eg.flac")
+		img, source, err := taglib.ReadImageWithFallback(path)
+		nilErr(t, err)
+		eq(t, source, "embedded")
+		eq(t, len(img) > 0, true)
+	})
+
+	t.Run("sidecar", func(t *testing.T) {
+		path := tmpf(t, egMP3, "eg.mp3")
+		dir := filepath.Dir(path)
+		nilErr(t, os.WriteFile(filepath.Join(dir, "Folder.png"), []byte("folder"), os.ModePerm))
+		nilErr(t, os.WriteFile(filepath.Join(dir, "cover.jpg"), coverJPG, os.ModePerm))
+
+		img, source, err := taglib.ReadImageWithFallback(path)
+		nilErr(t, err)
+		eq(t, source, "sidecar:cover.jpg")
+		eq(t, bytes.Equal(img, coverJPG), true)
+	})
+
+	t.Run("none", func(t *testing.T) {
+		path := tmpf(t, egMP3, "eg.mp3")
+		img, source, err := taglib.ReadImageWithFallback(path)
+		nilErr(t, err)
+		eq(t, source, "")
+		eq(t, len(img), 0)
+	})
+}
+
+func TestDetectFormat(t *testing.T) {
+	t.Parallel()
+
+	format, err := taglib.DetectFormat(tmpf(t, egM4a, "eg.m4a"))
+	nilErr(t, err)
+	eq(t, format, taglib.FormatMP4)
+
+	_, err = taglib.DetectFormat(tmpf(t, []byte("not a file"), "eg.flac"))
+	eq(t, err, taglib.ErrInvalidFile)
+}
+
+func TestFormatHistogram(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	write := func(name string, b []byte) {
+		t.Helper()
+		p := filepath.Join(root, name)
+		nilErr(t, os.MkdirAll(filepath.Dir(p), os.ModePerm))
+		nilErr(t, os.WriteFile(p, b, os.ModePerm))
+	}
+	write("a.flac", egFLAC)
+	write("b.mp3", egMP3)
+	write("sub/c.mp3", egMP3)
+	write("sub/d.opus", egOpus)
+	write("sub/deeper/e.flac", egFLAC)
+	write("sub/cover.jpg", coverJPG)
+	write("notes.txt", []byte("not audio"))
+
+	counts, err := taglib.FormatHistogram(context.Background(), root)
+	nilErr(t, err)
+	eq(t, len(counts), 3)
+	eq(t, counts[taglib.FormatFLAC], 2)
+	eq(t, counts[taglib.FormatMPEG], 2)
+	eq(t, counts[taglib.FormatOggOpus], 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = taglib.FormatHistogram(ctx, root)
+	eq(t, errors.Is(err, context.Canceled), true)
+}
+
+func TestOggSpeexAndFLACRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name   string
+		data   []byte
+		format taglib.FileFormat
+	}{
+		{"eg.oga", egOggFLAC, taglib.FormatOggFLAC},
+		{"eg.spx", egSpeex, taglib.FormatOggSpeex},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := tmpf(t, tt.data, tt.name)
+
+			format, err := taglib.DetectFormat(path)
+			nilErr(t, err)
+			eq(t, format, tt.format)
+
+			tags, err := taglib.ReadTags(path)
+			nilErr(t, err)
+			tagEq(t, tags, map[string][]string{
+				taglib.Artist: {"example artist"},
+				taglib.Album:  {"example album"},
+			})
+
+			want := map[string][]string{
+				taglib.Title:  {"New Title"},
+				taglib.Artist: {"Artist A", "Artist B"},
+				taglib.Lyrics: {longString},
+			}
+			err = taglib.WriteTags(path, want, taglib.Clear)
+			nilErr(t, err)
+
+			tags, err = taglib.ReadTags(path)
+			nilErr(t, err)
+			tagEq(t, tags, want)
+
+			// audio properties should survive the rewrite
+			props, err := taglib.ReadProperties(path)
+			nilErr(t, err)
+			eq(t, props.Length, time.Second)
+		})
+	}
+}
+
+func TestFileFormatExtension(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		format taglib.FileFormat
+		want   string
+	}{
+		{taglib.FormatMPEG, ".mp3"},
+		{taglib.FormatMP4, ".m4a"},
+		{taglib.FormatFLAC, ".flac"},
+		{taglib.FormatOggVorbis, ".ogg"},
+		{taglib.FormatOggOpus, ".opus"},
+		{taglib.FormatOggFLAC, ".oga"},
+		{taglib.FormatOggSpeex, ".spx"},
+		{taglib.FormatMatroska, ".mka"},
+		{taglib.FormatUnknown, ""},
+	}
+
+	for _, tt := range tests {
+		eq(t, tt.format.Extension(), tt.want)
+		if tt.format != taglib.FormatUnknown {
+			eq(t, taglib.FileFormatFromExtension(tt.want), tt.format)
+		}
+	}
+
+	eq(t, taglib.FileFormatFromExtension("FLAC"), taglib.FormatFLAC)
+	eq(t, taglib.FileFormatFromExtension(".M4B"), taglib.FormatMP4)
+	eq(t, taglib.FileFormatFromExtension(".txt"), taglib.FormatUnknown)
+	eq(t, taglib.FileFormatFromExtension(""), taglib.FormatUnknown)
+}
+
+func TestVerify(t *testing.T) {
+	t.Parallel()
+
+	for _, tt := range []struct {
+		name string
+		data []byte
+	}{
+		{"eg.flac", egFLAC},
+		{"eg.mp3", egMP3},
+		{"eg.m4a", egM4a},
+		{"eg.ogg", egOgg},
+	} {
+		path := tmpf(t, tt.data, tt.name)
+		nilErr(t, taglib.Verify(path))
+
+		// Nothing is written
+		data, err := os.ReadFile(path)
+		nilErr(t, err)
+		eq(t, bytes.Equal(data, tt.data), true)
+	}
+
+	err := taglib.Verify(filepath.Join(t.TempDir(), "missing.flac"))
+	eq(t, errors.Is(err, os.ErrNotExist), true)
+
+	err = taglib.Verify(t.TempDir())
+	eq(t, errors.Is(err, taglib.ErrInvalidFile), true)
+
+	err = taglib.Verify(tmpf(t, []byte("not audio"), "notes.txt"))
+	eq(t, errors.Is(err, taglib.ErrUnsupportedFormat), true)
+
+	err = taglib.Verify(tmpf(t, []byte("not audio"), "broken.flac"))
+	eq(t, errors.Is(err, taglib.ErrInvalidFile), true)
+}
+
+func TestCheckExtension(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name             string
+		data             []byte
+		expected, actual taglib.FileFormat
+		match            bool
+	}{
+		{"eg.mp3", egMP3, taglib.FormatMPEG, taglib.FormatMPEG, true},
+		{"eg.flac", egFLAC, taglib.FormatFLAC, taglib.FormatFLAC, true},
+		{"eg.m4b", egM4a, taglib.FormatMP4, taglib.FormatMP4, true},
+		{"eg.ogg", egOpus, taglib.FormatOggVorbis, taglib.FormatOggOpus, true},
+		{"aac.mp3", egM4a, taglib.FormatMPEG, taglib.FormatMP4, false},
+		{"ogg.flac", egOgg, taglib.FormatFLAC, taglib.FormatOggVorbis, false},
+		{"flac.MP3", egFLAC, taglib.FormatMPEG, taglib.FormatFLAC, false},
+		{"eg.bin", egFLAC, taglib.FormatUnknown, taglib.FormatFLAC, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expected, actual, match, err := taglib.CheckExtension(tmpf(t, tt.data, tt.name))
+			nilErr(t, err)
+			eq(t, expected, tt.expected)
+			eq(t, actual, tt.actual)
+			eq(t, match, tt.match)
+		})
+	}
+
+	_, _, _, err := taglib.CheckExtension(tmpf(t, []byte("not audio"), "notes.mp3"))
+	eq(t, errors.Is(err, taglib.ErrInvalidFile), true)
+}
+
+func TestFileFormatFamilies(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		format                taglib.FileFormat
+		ogg, dsd, mpeg4, riff bool
+	}{
+		{taglib.FormatOggVorbis, true, false, false, false},
+		{taglib.FormatOggOpus, true, false, false, false},
+		{taglib.FormatOggFLAC, true, false, false, false},
+		{taglib.FormatOggSpeex, true, false, false, false},
+		{taglib.FormatDSF, false, true, false, false},
+		{taglib.FormatDSDIFF, false, true, false, false},
+		{taglib.FormatMP4, false, false, true, false},
+		{taglib.FormatWAV, false, false, false, true},
+		{taglib.FormatAIFF, false, false, false, true},
+		{taglib.FormatFLAC, false, false, false, false},
+		{taglib.FormatMPEG, false, false, false, false},
+		{taglib.FormatUnknown, false, false, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format.String(), func(t *testing.T) {
+			eq(t, tt.format.IsOgg(), tt.ogg)
+			eq(t, tt.format.IsDSD(), tt.dsd)
+			eq(t, tt.format.IsMPEG4(), tt.mpeg4)
+			eq(t, tt.format.IsRIFF(), tt.riff)
+		})
+	}
+}
+
+func TestCompilation(t *testing.T) {
+	t.Parallel()
+
+	for _, path := range testPaths(t) {
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			f, err := taglib.Open(path)
+			nilErr(t, err)
+			defer func() { _ = f.Close() }()
+
+			compilation, err := f.IsCompilation()
+			nilErr(t, err)
+			eq(t, compilation, false)
+
+			nilErr(t, f.SetCompilation(true))
+			compilation, err = f.IsCompilation()
+			nilErr(t, err)
+			eq(t, compilation, true)
+
+			nilErr(t, f.SetCompilation(false))
+			compilation, err = f.IsCompilation()
+			nilErr(t, err)
+			eq(t, compilation, false)
+		})
+	}
+}
+
+func TestCompilationMP4Atom(t *testing.T) {
+	t.Parallel()
+
+	path := tmpf(t, egM4a, "eg.m4a")
+	f, err := taglib.Open(path)
+	nilErr(t, err)
+	defer func() { _ = f.Close() }()
+
+	nilErr(t, f.SetCompilation(true))
+
+	atoms, err := taglib.ReadMP4Atoms(path)
+	nilErr(t, err)
+	eq(t, len(atoms["cpil"]), 1)
+	eq(t, atoms["cpil"][0], "1")
+}
+
+func TestSortNames(t *testing.T) {
+	t.Parallel()
+
+	names := taglib.SortNames{
+		Title:       "Title, The",
+		Album:       "Album, The",
+		Artist:      "Beatles, The",
+		AlbumArtist: "Various",
+		Composer:    "Lennon, John",
+	}
+
+	for _, path := range testPaths(t) {
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			f, err := taglib.Open(path)
+			nilErr(t, err)
+			defer func() { _ = f.Close() }()
+
+			got, err := f.SortNames()
+			nilErr(t, err)
+			eq(t, got, taglib.SortNames{})
+
+			nilErr(t, f.SetSortNames(names))
+			got, err = f.SortNames()
+			nilErr(t, err)
+			eq(t, got, names)
+
+			nilErr(t, f.SetSortNames(taglib.SortNames{Artist: "Beatles, The"}))
+			got, err = f.SortNames()
+			nilErr(t, err)
+			eq(t, got, taglib.SortNames{Artist: "Beatles, The"})
+		})
+	}
+}
+
+func TestArtistCredits(t *testing.T) {
+	t.Parallel()
+
+	credits := taglib.ArtistCredits{
+		Artist:            "Prince",
+		ArtistCredit:      "The Artist Formerly Known as Prince",
+		AlbumArtist:       "Prince and The Revolution",
+		AlbumArtistCredit: "Prince & The Revolution",
+	}
+
+	for _, path := range testPaths(t) {
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			f, err := taglib.Open(path)
+			nilErr(t, err)
+			defer func() { _ = f.Close() }()
+
+			nilErr(t, f.SetArtistCredits(credits))
+			got, err := f.ArtistCredits()
+			nilErr(t, err)
+			eq(t, got, credits)
+
+			// Credits left out are removed rather than going stale
+			nilErr(t, f.SetArtistCredits(taglib.ArtistCredits{Artist: "Prince"}))
+			got, err = f.ArtistCredits()
+			nilErr(t, err)
+			eq(t, got, taglib.ArtistCredits{Artist: "Prince"})
+		})
+	}
+
+	path := tmpf(t, egMP3, "eg.mp3")
+	f, err := taglib.Open(path)
+	nilErr(t, err)
+	nilErr(t, f.SetArtistCredits(credits))
+	nilErr(t, f.Close())
+	frames, err := taglib.ReadID3v2Frames(path)
+	nilErr(t, err)
+	eq(t, frames["TXXX:ARTIST_CREDIT"][0], credits.ArtistCredit)
+	eq(t, frames["TXXX:ALBUMARTIST_CREDIT"][0], credits.AlbumArtistCredit)
+
+	path = tmpf(t, egWMA, "eg.wma")
+	f, err = taglib.Open(path)
+	nilErr(t, err)
+	defer func() { _ = f.Close() }()
+	var unsupported *taglib.UnsupportedKeysError
+	eq(t, errors.As(f.SetArtistCredits(credits), &unsupported), true)
+	nilErr(t, f.SetArtistCredits(taglib.ArtistCredits{Artist: "Prince"}))
+	got, err := f.ArtistCredits()
+	nilErr(t, err)
+	eq(t, got, taglib.ArtistCredits{Artist: "Prince"})
+}
+
+func TestReleaseInfo(t *testing.T) {
+	t.Parallel()
+
+	info := taglib.ReleaseInfo{
+		Media:          "Vinyl",
+		ReleaseType:    []string{"album", "live"},
+		ReleaseStatus:  "official",
+		ReleaseCountry: "GB",
+		Barcode:        "5099902894225",
+		CatalogNumber:  "CDP 7 46446 2",
+		Label:          "Parlophone",
+	}
+
+	for _, path := range testPaths(t) {
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			f, err := taglib.Open(path)
+			nilErr(t, err)
+			defer func() { _ = f.Close() }()
+
+			nilErr(t, f.SetReleaseInfo(info))
+			got, err := f.ReleaseInfo()
+			nilErr(t, err)
+			eq(t, fmt.Sprint(got), fmt.Sprint(info))
+
+			nilErr(t, f.SetReleaseInfo(taglib.ReleaseInfo{Label: "EMI"}))
+			got, err = f.ReleaseInfo()
+			nilErr(t, err)
+			eq(t, fmt.Sprint(got), fmt.Sprint(taglib.ReleaseInfo{Label: "EMI"}))
+		})
+	}
+}
+
+func TestOriginalInfo(t *testing.T) {
+	t.Parallel()
+
+	info := taglib.OriginalInfo{
+		Album:    "Hunky Dory",
+		Artist:   "David Bowie",
+		Date:     "1971-12-17",
+		Lyricist: "David Bowie",
+		Filename: "life_on_mars.wav",
+	}
+
+	for _, path := range testPaths(t) {
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			f, err := taglib.Open(path)
+			nilErr(t, err)
+			defer func() { _ = f.Close() }()
+
+			nilErr(t, f.SetOriginalInfo(info))
+			got, err := f.OriginalInfo()
+			nilErr(t, err)
+			eq(t, got, info)
+
+			nilErr(t, f.SetOriginalInfo(taglib.OriginalInfo{Artist: "Frank Sinatra"}))
+			got, err = f.OriginalInfo()
+			nilErr(t, err)
+			eq(t, got, taglib.OriginalInfo{Artist: "Frank Sinatra"})
+		})
+	}
+
+	path := tmpf(t, egMP3, "eg.mp3")
+	f, err := taglib.Open(path)
+	nilErr(t, err)
+	nilErr(t, f.SetOriginalInfo(info))
+	nilErr(t, f.Close())
+
+	frames, err := taglib.ReadID3v2Frames(path)
+	nilErr(t, err)
+	eq(t, frames["TOAL"][0], info.Album)
+	eq(t, frames["TOPE"][0], info.Artist)
+	eq(t, frames["TDOR"][0], info.Date)
+	eq(t, frames["TOLY"][0], info.Lyricist)
+	eq(t, frames["TOFN"][0], info.Filename)
+}
+
+func TestSeriesInfo(t *testing.T) {
+	t.Parallel()
+
+	info := taglib.SeriesInfo{SeriesName: "The Stormlight Archive", SeriesIndex: "2.5"}
+
+	for _, path := range testPaths(t) {
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			f, err := taglib.Open(path)
+			nilErr(t, err)
+			defer func() { _ = f.Close() }()
+
+			if f.Format() == taglib.FormatASF {
+				var unsupported *taglib.UnsupportedKeysError
+				eq(t, errors.As(f.SetSeriesInfo(info), &unsupported), true)
+				return
+			}
+
+			nilErr(t, f.SetSeriesInfo(info))
+			got, err := f.SeriesInfo()
+			nilErr(t, err)
+			eq(t, got, info)
+
+			nilErr(t, f.SetSeriesInfo(taglib.SeriesInfo{}))
+			got, err = f.SeriesInfo()
+			nilErr(t, err)
+			eq(t, got, taglib.SeriesInfo{})
+		})
+	}
+
+	// Other spellings of the position are read, and replaced when writing
+	path := tmpf(t, egFLAC, "eg.flac")
+	err := taglib.WriteTags(path, map[string][]string{"SERIES": {"Discworld"}, "SERIES_PART": {"4"}}, 0)
+	nilErr(t, err)
+	f, err := taglib.Open(path)
+	nilErr(t, err)
+	defer func() { _ = f.Close() }()
+	got, err := f.SeriesInfo()
+	nilErr(t, err)
+	eq(t, got, taglib.SeriesInfo{SeriesName: "Discworld", SeriesIndex: "4"})
+
+	nilErr(t, f.SetSeriesInfo(taglib.SeriesInfo{SeriesName: "Discworld", SeriesIndex: "5"}))
+	tags := f.Tags()
+	eq(t, len(tags["SERIES_PART"]), 0)
+	eq(t, tags["SERIES-PART"][0], "5")
+}
+
+func TestSortNamesNativeKeys(t *testing.T) {
+	t.Parallel()
+
+	names := taglib.SortNames{Title: "t", Album: "a", Artist: "ar", AlbumArtist: "aa", Composer: "c"}
+
+	mp3 := tmpf(t, egMP3, "eg.mp3")
+	f, err := taglib.Open(mp3)
+	nilErr(t, err)
+	nilErr(t, f.SetSortNames(names))
+	nilErr(t, f.Close())
+
+	frames, err := taglib.ReadID3v2Frames(mp3)
+	nilErr(t, err)
+	eq(t, frames["TSOT"][0], "t")
+	eq(t, frames["TSOA"][0], "a")
+	eq(t, frames["TSOP"][0], "ar")
+	eq(t, frames["TSO2"][0], "aa")
+	eq(t, frames["TSOC"][0], "c")
+
+	m4a := tmpf(t, egM4a, "eg.m4a")
+	f, err = taglib.Open(m4a)
+	nilErr(t, err)
+	nilErr(t, f.SetSortNames(names))
+	nilErr(t, f.Close())
+
+	atoms, err := taglib.ReadMP4Atoms(m4a)
+	nilErr(t, err)
+	eq(t, atoms["sonm"][0], "t")
+	eq(t, atoms["soal"][0], "a")
+	eq(t, atoms["soar"][0], "ar")
+	eq(t, atoms["soaa"][0], "aa")
+	eq(t, atoms["soco"][0], "c")
+}
+
+func TestPodcastInfo(t *testing.T) {
+	t.Parallel()
+
+	info := taglib.PodcastInfo{
+		Podcast:     true,
+		Category:    "Technology",
+		Description: "An episode about tags",
+		EpisodeGUID: "urn:uuid:4a3c6d1e-0b7f-4d55-9d7e-1f8c2b6a9e01",
+		FeedURL:     "https://example.com/feed.xml",
+	}
+
+	for _, tt := range []struct {
+		name string
+		data []byte
+	}{
+		{"eg.mp3", egMP3},
+		{"eg.m4a", egM4a},
+		{"eg.flac", egFLAC},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			path := tmpf(t, tt.data, tt.name)
+			f, err := taglib.Open(path)
+			nilErr(t, err)
+			defer func() { _ = f.Close() }()
+
+			got, err := f.PodcastInfo()
+			nilErr(t, err)
+			eq(t, got, taglib.PodcastInfo{})
+
+			nilErr(t, f.SetPodcastInfo(info))
+			got, err = f.PodcastInfo()
+			nilErr(t, err)
+			eq(t, got, info)
+
+			nilErr(t, f.SetPodcastInfo(taglib.PodcastInfo{FeedURL: info.FeedURL}))
+			got, err = f.PodcastInfo()
+			nilErr(t, err)
+			eq(t, got, taglib.PodcastInfo{FeedURL: info.FeedURL})
+		})
+	}
+}
+
+func TestFlags(t *testing.T) {
+	t.Parallel()
+
+	all := taglib.Flags{Compilation: true, GaplessPlayback: true, ShowWorkMovement: true, Podcast: true}
+
+	for _, path := range testPaths(t) {
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			f, err := taglib.Open(path)
+			nilErr(t, err)
+			defer func() { _ = f.Close() }()
+
+			nilErr(t, f.SetFlags(all))
+			got, err := f.Flags()
+			nilErr(t, err)
+			eq(t, got, all)
+
+			nilErr(t, f.SetFlags(taglib.Flags{GaplessPlayback: true}))
+			got, err = f.Flags()
+			nilErr(t, err)
+			eq(t, got, taglib.Flags{GaplessPlayback: true})
+			eq(t, len(f.Tags()[taglib.Compilation]), 0)
+
+			nilErr(t, f.SetFlags(taglib.Flags{}))
+			got, err = f.Flags()
+			nilErr(t, err)
+			eq(t, got, taglib.Flags{})
+		})
+	}
+
+	// Other spellings of true are read too
+	path := tmpf(t, egFLAC, "eg.flac")
+	err := taglib.WriteTags(path, map[string][]string{taglib.Compilation: {"true"}, taglib.GaplessPlayback: {"0"}}, 0)
+	nilErr(t, err)
+	f, err := taglib.Open(path)
+	nilErr(t, err)
+	defer func() { _ = f.Close() }()
+	got, err := f.Flags()
+	nilErr(t, err)
+	eq(t, got, taglib.Flags{Compilation: true})
+
+	g, err := taglib.Open(tmpf(t, egWMA, "eg.wma"))
+	nilErr(t, err)
+	defer func() { _ = g.Close() }()
+	var unsupported *taglib.UnsupportedKeysError
+	if !errors.As(g.SetFlags(all), &unsupported) {
+		t.Fatalf("expected UnsupportedKeysError")
+	}
+}
+
+func TestWriteImageInvalid(t *testing.T) {
+	t.Parallel()
+
+	path := tmpf(t, egFLAC, "eg.flac")
+
+	err := taglib.WriteImage(path, []byte("not an image"))
+	eq(t, errors.Is(err, taglib.ErrInvalidImage), true)
+
+	err = taglib.WriteImageOptions(path, []byte("not an image"), 0, "Front Cover", "", "")
+	eq(t, errors.Is(err, taglib.ErrInvalidImage), true)
+
+	// images are left untouched
+	properties, err := taglib.ReadProperties(path)
+	nilErr(t, err)
+	eq(t, len(properties.Images), 2)
+	eq(t, properties.Images[0].Description, "The first image")
+
+	// an explicit MIME type skips detection
+	err = taglib.WriteImageOptions(path, []byte("not an image"), 0, "Front Cover", "", "image/x-custom")
+	nilErr(t, err)
+
+	properties, err = taglib.ReadProperties(path)
+	nilErr(t, err)
+	eq(t, properties.Images[0].MIMEType, "image/x-custom")
+}
+
+func TestWriteImageDetectMIME(t *testing.T) {
+	t.Parallel()
+
+	ftyp := func(major string, compatible ...string) []byte {
+		b := []byte{0, 0, 0, byte(16 + 4*len(compatible))}
+		b = append(b, "ftyp"+major+"\x00\x00\x00\x00"...)
+		for _, c := range compatible {
+			b = append(b, c...)
+		}
+		return append(b, "\x00\x00\x00\x00meta"...)
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"png", coverJPG, "image/png"}, // despite the name, cover.jpg is a PNG
+		{"avif", ftyp("avif", "mif1", "miaf"), "image/avif"},
+		{"avif sequence", ftyp("avis", "msf1"), "image/avif"},
+		{"avif compatible brand", ftyp("mif1", "avif", "miaf"), "image/avif"},
+		{"heic", ftyp("heic", "mif1"), "image/heic"},
+		{"heic compatible brand", ftyp("mif1", "heic"), "image/heic"},
+		{"heif", ftyp("mif1", "miaf"), "image/heif"},
+		{"tiff little endian", []byte("II*\x00\x08\x00\x00\x00"), "image/tiff"},
+		{"tiff big endian", []byte("MM\x00*\x00\x00\x00\x08"), "image/tiff"},
+		{"jxl codestream", []byte("\xFF\x0A\xFA\x7F\x01\x90"), "image/jxl"},
+		{"jxl container", []byte("\x00\x00\x00\x0CJXL \x0D\x0A\x87\x0A\x00\x00\x00\x14ftypjxl "), "image/jxl"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := tmpf(t, egFLAC, "eg.flac")
+			nilErr(t, taglib.WriteImage(path, tt.data))
+
+			properties, err := taglib.ReadProperties(path)
+			nilErr(t, err)
+			eq(t, properties.Images[0].MIMEType, tt.want)
+		})
+	}
+}
+
+func TestDetectTagCharset(t *testing.T) {
+	t.Parallel()
+
+	// An MP3 with a title frame declared as Latin-1 holding the raw bytes
+	latin1MP3 := func(t *testing.T, title []byte) string {
+		frame := append([]byte("TIT2"), 0, 0, 0, byte(len(title)+1), 0, 0, 0)
+		frame = append(frame, title...)
+		size := len(frame)
+		tag := []byte{'I', 'D', '3', 3, 0, 0, byte(size >> 21 & 0x7F), byte(size >> 14 & 0x7F), byte(size >> 7 & 0x7F), byte(size & 0x7F)}
+		tag = append(tag, frame...)
+
+		audio := egMP3
+		if string(audio[:3]) == "ID3" {
+			audio = audio[10+(int(audio[6])<<21|int(audio[7])<<14|int(audio[8])<<7|int(audio[9])):]
+		}
+		return tmpf(t, append(tag, audio...), "eg.mp3")
+	}
+
+	// A FLAC file with the title written as UTF-8, as Vorbis comments should be
+	utf8FLAC := func(t *testing.T, title []byte) string {
+		path := tmpf(t, egFLAC, "eg.flac")
+		nilErr(t, taglib.WriteTags(path, map[string][]string{taglib.Title: {string(title)}}, taglib.Clear))
+		return path
+	}
+
+	tests := []struct {
+		name    string
+		path    func(*testing.T, []byte) string
+		title   []byte
+		charset string
+	}{
+		{"ascii", latin1MP3, []byte("Example Title"), "UTF-8"},
+		{"utf-8 in latin-1 frame", latin1MP3, []byte("Été à Paris"), "UTF-8"},
+		{"windows-1252", latin1MP3, []byte("Caf\xe9 M\xfcller"), "windows-1252"},
+		{"windows-1251", latin1MP3, []byte("\xcf\xf0\xe8\xe2\xe5\xf2 \xec\xe8\xf0"), "windows-1251"}, // "Привет мир"
+		{"utf-8 vorbis comment", utf8FLAC, []byte("Café, Beyoncé"), "UTF-8"},
+		{"cyrillic vorbis comment", utf8FLAC, []byte("Привет мир"), "UTF-8"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			charset, confidence, err := taglib.DetectTagCharset(tt.path(t, tt.title))
+			nilErr(t, err)
+			eq(t, charset, tt.charset)
+			if confidence <= 0.5 || confidence > 1 {
+				t.Fatalf("unexpected confidence %v", confidence)
+			}
+		})
+	}
+}
+
+func TestExportImportMetadata(t *testing.T) {
+	t.Parallel()
+
+	src := tmpf(t, egFLAC, "eg.flac")
+	want := map[string][]string{
+		taglib.Title:   {"Title"},
+		taglib.Artist:  {"Artist One", "Artist Two"},
+		taglib.Comment: {"Line one\nLine two with a \\ backslash"},
+	}
+	nilErr(t, taglib.WriteTags(src, want, taglib.Clear))
+
+	for _, format := range []taglib.ExportFormat{taglib.ExportVorbisComment, taglib.ExportJSON} {
+		var buf bytes.Buffer
+		nilErr(t, taglib.ExportMetadata(src, &buf, format))
+
+		// Into another container
+		dst := tmpf(t, egMP3, "eg.mp3")
+		nilErr(t, taglib.ImportMetadata(dst, bytes.NewReader(buf.Bytes()), format))
+
+		tags, err := taglib.ReadTags(dst)
+		nilErr(t, err)
+		tagEq(t, tags, want)
+	}
+
+	var buf bytes.Buffer
+	nilErr(t, taglib.ExportMetadata(src, &buf, taglib.ExportVorbisComment))
+	eq(t, strings.Contains(buf.String(), "COMMENT=Line one\\nLine two with a \\\\ backslash\n"), true)
+	eq(t, strings.Contains(buf.String(), "# image Front Cover\tThe first image\timage/png\n"), true)
+
+	buf.Reset()
+	nilErr(t, taglib.ExportMetadata(src, &buf, taglib.ExportJSON))
+	var m struct {
+		Format string
+		Raw    map[string][]string
+		Images []struct{ Type, Description, MIMEType string }
+	}
+	nilErr(t, json.Unmarshal(buf.Bytes(), &m))
+	eq(t, m.Format, "FLAC")
+	eq(t, m.Raw["TITLE"][0], "Title")
+	eq(t, len(m.Images), 2)
+	eq(t, m.Images[1].Type, "Lead Artist")
+	eq(t, m.Images[1].MIMEType, "image/jpeg")
+
+	err := taglib.ImportMetadata(src, strings.NewReader("no separator"), taglib.ExportVorbisComment)
+	eq(t, err != nil, true)
+}
+
+func TestCredits(t *testing.T) {
+	t.Parallel()
+
+	credits := []taglib.Credit{
+		{Role: "", Name: "John Bonham"},
+		{Role: "guitar", Name: "Jeff Beck"},
+		{Role: "guitar", Name: "Jimmy Page"},
+		{Role: "producer", Name: "Jimmy Page"},
+		{Role: "vocals", Name: "Robert Plant"},
+	}
+	sortCredits := func(cs []taglib.Credit) []taglib.Credit {
+		slices.SortFunc(cs, func(a, b taglib.Credit) int {
+			return cmp.Or(strings.Compare(a.Role, b.Role), strings.Compare(a.Name, b.Name))
+		})
+		return cs
+	}
+
+	for _, tt := range []struct {
+		name string
+		data []byte
+	}{
+		{"eg.mp3", egMP3},
+		{"eg.flac", egFLAC},
+		{"eg.m4a", egM4a},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			path := tmpf(t, tt.data, tt.name)
+
+			got, err := taglib.ReadCredits(path)
+			nilErr(t, err)
+			eq(t, len(got), 0)
+
+			nilErr(t, taglib.WriteCredits(path, credits))
+			got, err = taglib.ReadCredits(path)
+			nilErr(t, err)
+			eq(t, slices.Equal(sortCredits(got), credits), true)
+
+			// Credits are replaced, other tags are kept
+			nilErr(t, taglib.WriteCredits(path, credits[1:2]))
+			got, err = taglib.ReadCredits(path)
+			nilErr(t, err)
+			eq(t, slices.Equal(got, credits[1:2]), true)
+
+			tags, err := taglib.ReadTags(path)
+			nilErr(t, err)
+			eq(t, tags[taglib.Artist][0], "example artist")
+		})
+	}
+
+	// Roles are kept in pairs in the ID3v2 frames
+	path := tmpf(t, egMP3, "eg.mp3")
+	nilErr(t, taglib.WriteCredits(path, credits))
+	frames, err := taglib.ReadID3v2Frames(path)
+	nilErr(t, err)
+	eq(t, frames["TMCL"][0], "GUITAR Jeff Beck,Jimmy Page VOCALS Robert Plant")
+	eq(t, frames["TIPL"][0], "PRODUCER Jimmy Page")
+
+	// Vorbis comments use "name (role)"
+	path = tmpf(t, egFLAC, "eg.flac")
+	err = taglib.WriteTags(path, map[string][]string{taglib.Performer: {"Robert Plant (vocals)", "Foo (Band) (drums)"}}, 0)
+	nilErr(t, err)
+	got, err := taglib.ReadCredits(path)
+	nilErr(t, err)
+	eq(t, slices.Equal(got, []taglib.Credit{{"vocals", "Robert Plant"}, {"drums", "Foo (Band)"}}), true)
+}
+
+func TestDates(t *testing.T) {
+	t.Parallel()
+
+	path := tmpf(t, egMP3, "eg.mp3")
+
+	want := taglib.Dates{
+		RecordingTime:       "2003-11-02",
+		ReleaseTime:         "2004-01-12",
+		OriginalReleaseTime: "1998",
+		TaggingTime:         "2024-06-01T10:20:30",
+	}
+	err := taglib.WriteDates(path, want)
+	nilErr(t, err)
+
+	dates, err := taglib.ReadDates(path)
+	nilErr(t, err)
+	eq(t, dates, want)
+
+	tags, err := taglib.ReadTags(path)
+	nilErr(t, err)
+	eq(t, len(tags[taglib.Date]), 1)
+	eq(t, tags[taglib.Date][0], "2003-11-02")
+	eq(t, len(tags[taglib.OriginalDate]), 1)
+	eq(t, tags[taglib.OriginalDate][0], "1998")
+
+	// Clearing a field removes just that frame
+	want.ReleaseTime = ""
+	err = taglib.WriteDates(path, want)
+	nilErr(t, err)
+
+	dates, err = taglib.ReadDates(path)
+	nilErr(t, err)
+	eq(t, dates, want)
+
+	frames, err := taglib.ReadID3v2Frames(path)
+	nilErr(t, err)
+	if _, ok := frames["TDRL"]; ok {
+		t.Fatalf("expected TDRL to be removed")
+	}
+}
+
+func TestOptimizeTags(t *testing.T) {
+	t.Parallel()
+
+	for _, tt := range []struct {
+		name string
+		data []byte
+	}{
+		{"eg.flac", egFLAC},
+		{"eg.mp3", egMP3},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			path := tmpf(t, tt.data, tt.name)
+
+			// Grow the tag then shrink it again, leaving behind a lot of padding
+			err := taglib.WriteTags(path, bigTags, 0)
+			nilErr(t, err)
+			err = taglib.WriteTags(path, map[string][]string{
+				taglib.Title: {"Short"},
+			}, taglib.Clear)
+			nilErr(t, err)
+
+			tagsBefore, err := taglib.ReadTags(path)
+			nilErr(t, err)
+			propsBefore, err := taglib.ReadProperties(path)
+			nilErr(t, err)
+			sizeBefore := fileSize(t, path)
+
+			saved, err := taglib.OptimizeTags(path, 0)
+			nilErr(t, err)
+			if saved <= 0 {
+				t.Fatalf("expected bytes to be saved, got %d", saved)
+			}
+			eq(t, fileSize(t, path), sizeBefore-saved)
+
+			tagsAfter, err := taglib.ReadTags(path)
+			nilErr(t, err)
+			tagEq(t, tagsAfter, tagsBefore)
+			propsAfter, err := taglib.ReadProperties(path)
+			nilErr(t, err)
+			eq(t, propsAfter.Length, propsBefore.Length)
+			eq(t, propsAfter.Bitrate, propsBefore.Bitrate)
+
+			// Already optimal
+			saved, err = taglib.OptimizeTags(path, 0)
+			nilErr(t, err)
+			eq(t, saved, 0)
+
+			// Padding can be added back too
+			saved, err = taglib.OptimizeTags(path, 512)
+			nilErr(t, err)
+			if saved >= 0 {
+				t.Fatalf("expected file to grow, got %d", saved)
+			}
+
+			tagsAfter, err = taglib.ReadTags(path)
+			nilErr(t, err)
+			tagEq(t, tagsAfter, tagsBefore)
+		})
+	}
+}
+
+func TestOptimizeTagsFLACWithID3v2(t *testing.T) {
+	t.Parallel()
+
+	plain := tmpf(t, egFLAC, "eg.flac")
+	_, err := taglib.OptimizeTags(plain, 256)
+	nilErr(t, err)
+
+	// A leading ID3v2.4 tag with a single TIT2 frame followed by 100 bytes of padding
+	frame := append([]byte("TIT2\x00\x00\x00\x06\x00\x00\x03"), "Title"...)
+	size := len(frame) + 100
+	tag := append([]byte{'I', 'D', '3', 4, 0, 0, 0, 0, byte(size >> 7), byte(size & 0x7F)}, frame...)
+	tag = append(tag, make([]byte, 100)...)
+	path := tmpf(t, append(tag, egFLAC...), "eg.flac")
+
+	_, err = taglib.OptimizeTags(path, 256)
+	nilErr(t, err)
+
+	// Only the FLAC metadata is padded
+	eq(t, fileSize(t, path), fileSize(t, plain)+int64(10+len(frame)))
+}
+
+func TestOptimizeTagsUnsupported(t *testing.T) {
+	t.Parallel()
+
+	path := tmpf(t, egM4a, "eg.m4a")
+	_, err := taglib.OptimizeTags(path, 0)
+	if !errors.Is(err, taglib.ErrUnsupportedFormat) {
+		t.Fatalf("expected ErrUnsupportedFormat, got %v", err)
+	}
+
+	_, err = taglib.OptimizeTags(path, -1)
+	if err == nil {
+		t.Fatalf("expected error for negative padding")
+	}
+}
+
+func fileSize(t *testing.T, path string) int64 {
+	t.Helper()
+
+	info, err := os.Stat(path)
+	nilErr(t, err)
+	return info.Size()
+}
+
+func TestLyrics(t *testing.T) {
+	t.Parallel()
+
+	t.Run("id3v2", func(t *testing.T) {
+		path := tmpf(t, egMP3Lyrics, "eg.mp3")
+		f, err := taglib.Open(path)
+		nilErr(t, err)
+		defer func() { _ = f.Close() }()
+
+		lyrics, err := f.Lyrics()
+		nilErr(t, err)
+		eq(t, lyrics, "English lyrics content here")
+
+		lrc, err := f.SyncedLyrics()
+		nilErr(t, err)
+		eq(t, lrc, "[00:00.00]Line one\n[00:05.00]Line two\n[00:10.00]Line three\n")
+	})
+
+	const lrc = "[ar:example artist]\n[00:01.00]Line one\n[00:05.50]Line two\n"
+
+	tests := []struct {
+		name   string
+		lyrics string
+		synced string
+	}{
+		{"none", "", ""},
+		{"plain", "Line one\nLine two", ""},
+		{"lrc", lrc, lrc},
+		{"bracketed", "[Chorus]\nLine one", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := tmpf(t, egFLAC, "eg.flac")
+			f, err := taglib.Open(path)
+			nilErr(t, err)
+			defer func() { _ = f.Close() }()
+
+			var vs []string
+			if tt.lyrics != "" {
+				vs = []string{tt.lyrics}
+			}
+			err = f.WriteTags(map[string][]string{taglib.Lyrics: vs}, 0)
+			nilErr(t, err)
+
+			lyrics, err := f.Lyrics()
+			nilErr(t, err)
+			eq(t, lyrics, tt.lyrics)
+
+			synced, err := f.SyncedLyrics()
+			nilErr(t, err)
+			eq(t, synced, tt.synced)
+		})
+	}
+}
+
+func TestDiffTags(t *testing.T) {
+	t.Parallel()
+
+	d := taglib.DiffTags(map[string][]string{
+		"TITLE":   {"Title"},
+		"ARTISTS": {"One", "Two"},
+		"ALBUM":   {"Old Album"},
+		"GENRE":   {"Rock"},
+		"EMPTY":   {},
+	}, map[string][]string{
+		"title":   {"Title"},
+		"ARTISTS": {"Two", "One"},
+		"ALBUM":   {"New Album"},
+		"DATE":    {"2004"},
+	})
+	eq(t, d.Empty(), false)
+	eq(t, len(d.Added), 1)
+	eq(t, d.Added["DATE"][0], "2004")
+	eq(t, len(d.Removed), 1)
+	eq(t, d.Removed["GENRE"][0], "Rock")
+	eq(t, len(d.Changed), 2)
+	eq(t, d.Changed["ALBUM"].Old[0], "Old Album")
+	eq(t, d.Changed["ALBUM"].New[0], "New Album")
+	eq(t, d.Changed["ALBUM"].Reordered, false)
+	eq(t, d.Changed["ARTISTS"].Reordered, true)
+
+	eq(t, taglib.DiffTags(map[string][]string{"A": {"1"}}, map[string][]string{"a": {"1"}}).Empty(), true)
+}
+
+func TestDiffFiles(t *testing.T) {
+	t.Parallel()
+
+	a := tmpf(t, egFLAC, "eg.flac")
+	b := tmpf(t, egFLAC, "eg.flac")
+
+	d, err := taglib.DiffFiles(a, b)
+	nilErr(t, err)
+	eq(t, d.Empty(), true)
+
+	nilErr(t, taglib.WriteTags(b, map[string][]string{taglib.Artist: {"Changed"}, taglib.Mood: {"Calm"}}, 0))
+	d, err = taglib.DiffFiles(a, b)
+	nilErr(t, err)
+	eq(t, d.Changed[taglib.Artist].Old[0], "example artist")
+	eq(t, d.Changed[taglib.Artist].New[0], "Changed")
+	eq(t, d.Added[taglib.Mood][0], "Calm")
+	eq(t, len(d.Removed), 0)
+
+	_, err = taglib.DiffFiles(a, filepath.Join(t.TempDir(), "missing.flac"))
+	eq(t, err != nil, true)
+}
+
+func TestWriteTagsIfChanged(t *testing.T) {
+	t.Parallel()
+
+	path := tmpf(t, egFLAC, "eg.flac")
+	err := taglib.WriteTags(path, map[string][]string{
+		taglib.Artist: {"Artist A", "Artist B"},
+		taglib.Title:  {"Title"},
+	}, taglib.Clear)
+	nilErr(t, err)
+
+	modTime := func() time.Time {
+		info, err := os.Stat(path)
+		nilErr(t, err)
+		return info.ModTime()
+	}
+	// Make sure a rewrite would be visible in the modification time
+	past := time.Now().Add(-time.Hour).Truncate(time.Second)
+	nilErr(t, os.Chtimes(path, past, past))
+
+	tests := []struct {
+		name    string
+		tags    map[string][]string
+		opts    taglib.WriteOption
+		changed bool
+	}{
+		{"same", map[string][]string{taglib.Title: {"Title"}}, 0, false},
+		{"same lower case key", map[string][]string{"title": {"Title"}}, 0, false},
+		{"remove missing", map[string][]string{taglib.Album: nil}, 0, false},
+		{"remove missing empty value", map[string][]string{taglib.Album: {""}}, 0, false},
+		{"same with clear", map[string][]string{
+			taglib.Artist: {"Artist A", "Artist B"},
+			taglib.Title:  {"Title"},
+		}, taglib.Clear, false},
+		{"clear removes", map[string][]string{taglib.Title: {"Title"}}, taglib.Clear, true},
+		{"value order", map[string][]string{taglib.Artist: {"Artist B", "Artist A"}}, 0, true},
+		{"new value", map[string][]string{taglib.Title: {"Other"}}, 0, true},
+		{"remove existing", map[string][]string{taglib.Title: nil}, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before, err := taglib.ReadTags(path)
+			nilErr(t, err)
+
+			changed, err := taglib.WriteTagsIfChanged(path, tt.tags, tt.opts)
+			nilErr(t, err)
+			eq(t, changed, tt.changed)
+			eq(t, modTime().Equal(past), !tt.changed)
+
+			// Restore the original state for the next case
+			if changed {
+				err := taglib.WriteTags(path, before, taglib.Clear)
+				nilErr(t, err)
+				nilErr(t, os.Chtimes(path, past, past))
+			}
+		})
+	}
+}
+
+func TestMP4NumericGenre(t *testing.T) {
+	t.Parallel()
+
+	// eg_gnre.m4a has a legacy numeric gnre atom (18, "Rock") and no ©gen
+	path := tmpf(t, egM4aGnre, "eg.m4a")
+
+	tags, err := taglib.ReadTags(path)
+	nilErr(t, err)
+	tagEq(t, tags, map[string][]string{
+		taglib.Artist: {"example artist"},
+		taglib.Album:  {"example album"},
+		taglib.Genre:  {"Rock"},
+	})
+
+	err = taglib.WriteTags(path, map[string][]string{
+		taglib.Genre: {"Jazz"},
+	}, 0)
+	nilErr(t, err)
+
+	atoms, err := taglib.ReadMP4Atoms(path)
+	nilErr(t, err)
+	eq(t, len(atoms["©gen"]), 1)
+	eq(t, atoms["©gen"][0], "Jazz")
+
+	// The stale numeric atom is gone
+	data, err := os.ReadFile(path)
+	nilErr(t, err)
+	if bytes.Contains(data, []byte("gnre")) {
+		t.Fatalf("expected gnre atom to be removed")
+	}
+}
+
+func TestMP4NumericAndFreeFormGenre(t *testing.T) {
+	t.Parallel()
+
+	// eg_gnre_gen.m4a has a numeric gnre atom (18, "Rock") followed by a ©gen atom ("Jazz Fusion")
+	path := tmpf(t, egM4aGnreGen, "eg.m4a")
+
+	tags, err := taglib.ReadTags(path)
+	nilErr(t, err)
+	tagEq(t, tags, map[string][]string{
+		taglib.Artist: {"example artist"},
+		taglib.Album:  {"example album"},
+		taglib.Genre:  {"Jazz Fusion"},
+	})
+
+	f, err := taglib.OpenStream(bytes.NewReader(egM4aGnreGen))
+	nilErr(t, err)
+	eq(t, slices.Equal(f.Tags()[taglib.Genre], []string{"Jazz Fusion"}), true)
+	nilErr(t, f.Close())
+
+	err = taglib.WriteTags(path, map[string][]string{
+		taglib.Genre: {"Jazz"},
+	}, 0)
+	nilErr(t, err)
+
+	tags, err = taglib.ReadTags(path)
+	nilErr(t, err)
+	eq(t, slices.Equal(tags[taglib.Genre], []string{"Jazz"}), true)
+
+	data, err := os.ReadFile(path)
+	nilErr(t, err)
+	if bytes.Contains(data, []byte("gnre")) {
+		t.Fatalf("expected gnre atom to be removed")
+	}
+}
+
+func TestReadImageColor(t *testing.T) {
+	t.Parallel()
+
+	// Two thirds red, one third blue
+	img := image.NewRGBA(image.Rect(0, 0, 90, 90))
+	for y := range 90 {
+		for x := range 90 {
+			c := color.RGBA{R: 255, A: 255}
+			if x >= 60 {
+				c = color.RGBA{B: 255, A: 255}
+			}
+			img.Set(x, y, c)
+		}
+	}
+	var buf bytes.Buffer
+	nilErr(t, png.Encode(&buf, img))
+
+	path := tmpf(t, egFLAC, "eg.flac")
+	err := taglib.WriteImage(path, buf.Bytes())
+	nilErr(t, err)
+
+	decoded, err := taglib.ReadImageDecoded(path)
+	nilErr(t, err)
+	eq(t, decoded.Bounds(), img.Bounds())
+
+	avg, err := taglib.ReadImageColor(path)
+	nilErr(t, err)
+	eq(t, avg, color.RGBA{R: 170, B: 85, A: 255})
+
+	palette, err := taglib.ReadImagePalette(path, 5)
+	nilErr(t, err)
+	eq(t, len(palette), 2)
+	eq(t, palette[0], color.RGBA{R: 255, A: 255})
+	eq(t, palette[1], color.RGBA{B: 255, A: 255})
+
+	palette, err = taglib.ReadImagePalette(path, 1)
+	nilErr(t, err)
+	eq(t, len(palette), 1)
+}
+
+func TestReadImageColorNoImage(t *testing.T) {
+	t.Parallel()
+
+	path := tmpf(t, egMP3, "eg.mp3")
+	_, err := taglib.ReadImageColor(path)
+	if !errors.Is(err, taglib.ErrNoImage) {
+		t.Fatalf("expected ErrNoImage, got %v", err)
+	}
+}
+
+func TestReadImageThumbnail(t *testing.T) {
+	t.Parallel()
+
+	// A 120x60 image, left half red and right half blue
+	img := image.NewRGBA(image.Rect(0, 0, 120, 60))
+	for y := range 60 {
+		for x := range 120 {
+			c := color.RGBA{R: 255, A: 255}
+			if x >= 60 {
+				c = color.RGBA{B: 255, A: 255}
+			}
+			img.Set(x, y, c)
+		}
+	}
+	var buf bytes.Buffer
+	nilErr(t, png.Encode(&buf, img))
+
+	path := tmpf(t, egFLAC, "eg.flac")
+	nilErr(t, taglib.WriteImage(path, buf.Bytes()))
+
+	thumb, err := taglib.ReadImageThumbnail(path, 30)
+	nilErr(t, err)
+	eq(t, thumb.Bounds(), image.Rect(0, 0, 30, 15))
+	eq(t, color.RGBAModel.Convert(thumb.At(0, 0)).(color.RGBA), color.RGBA{R: 255, A: 255})
+	eq(t, color.RGBAModel.Convert(thumb.At(29, 14)).(color.RGBA), color.RGBA{B: 255, A: 255})
+
+	// Images that already fit aren't scaled up
+	thumb, err = taglib.ReadImageThumbnail(path, 500)
+	nilErr(t, err)
+	eq(t, thumb.Bounds(), img.Bounds())
+
+	_, err = taglib.ReadImageThumbnail(path, 0)
+	if err == nil {
+		t.Fatalf("expected error for zero size")
+	}
+
+	path = tmpf(t, egMP3, "eg.mp3")
+	_, err = taglib.ReadImageThumbnail(path, 30)
+	if !errors.Is(err, taglib.ErrNoImage) {
+		t.Fatalf("expected ErrNoImage, got %v", err)
+	}
+}
+
+func TestReadImageDataURI(t *testing.T) {
+	t.Parallel()
+
+	path := tmpf(t, egMP3, "eg.mp3")
+	uri, err := taglib.ReadImageDataURI(path)
+	nilErr(t, err)
+	eq(t, uri, "")
+
+	nilErr(t, taglib.WriteImage(path, coverJPG))
+	uri, err = taglib.ReadImageDataURI(path)
+	nilErr(t, err)
+	eq(t, uri, "data:image/png;base64,"+base64.StdEncoding.EncodeToString(coverJPG)) // The cover fixture is a PNG
+}
+
+func TestReadImageReference(t *testing.T) {
+	t.Parallel()
+
+	// An ID3v2.4 tag with a linked front cover, then an embedded back cover
+	frame := func(id string, body ...byte) []byte {
+		size := len(body)
+		return append([]byte{id[0], id[1], id[2], id[3], 0, 0, byte(size >> 7), byte(size & 0x7F), 0, 0}, body...)
+	}
+	var frames []byte
+	frames = append(frames, frame("APIC", append([]byte{0}, "-->\x00\x03\x00https://example.com/cover.jpg"...)...)...)
+	frames = append(frames, frame("APIC", append([]byte{0}, "image/png\x00\x04\x00png"...)...)...)
+	size := len(frames)
+	tag := append([]byte{'I', 'D', '3', 4, 0, 0, 0, 0, byte(size >> 7), byte(size & 0x7F)}, frames...)
+	audio := egMP3[10+(int(egMP3[6])<<21|int(egMP3[7])<<14|int(egMP3[8])<<7|int(egMP3[9])):]
+	path := tmpf(t, append(tag, audio...), "eg.mp3")
+
+	url, err := taglib.ReadImageReference(path, 0)
+	nilErr(t, err)
+	eq(t, url, "https://example.com/cover.jpg")
+
+	url, err = taglib.ReadImageReference(path, 1)
+	nilErr(t, err)
+	eq(t, url, "")
+
+	_, err = taglib.ReadImageReference(path, 2)
+	eq(t, errors.Is(err, taglib.ErrNoImage), true)
+}
+
+func TestSetPictures(t *testing.T) {
+	t.Parallel()
+
+	path := tmpf(t, egFLAC, "eg.flac")
+	f, err := taglib.Open(path)
+	nilErr(t, err)
+	t.Cleanup(func() { f.Close() })
+
+	back := append([]byte{}, coverJPG...)
+	err = f.SetPictures([]taglib.Picture{
+		{Data: coverJPG, Type: taglib.PictureFrontCover, Description: "Front\tcover"},
+		{Data: back, Type: taglib.PictureBackCover, MIMEType: "image/x-custom"},
+	})
+	nilErr(t, err)
+
+	props := f.Properties()
+	eq(t, props.ImageCount, 2)
+	eq(t, props.Images[0], taglib.ImageDesc{Type: "Front Cover", Description: "Front\tcover", MIMEType: "image/png"})
+	eq(t, props.Images[1], taglib.ImageDesc{Type: "Back Cover", MIMEType: "image/x-custom"})
+	img, err := f.Image(1)
+	nilErr(t, err)
+	eq(t, bytes.Equal(img, back), true)
+
+	// The whole set is replaced
+	nilErr(t, f.SetPictures([]taglib.Picture{{Data: coverJPG, Type: taglib.PictureBackCover}}))
+	props = f.Properties()
+	eq(t, props.ImageCount, 1)
+	eq(t, props.Images[0].Type, "Back Cover")
+
+	nilErr(t, f.SetPictures(nil))
+	eq(t, f.Properties().ImageCount, 0)
+
+	err = f.SetPictures([]taglib.Picture{{Data: []byte("not an image")}})
+	eq(t, errors.Is(err, taglib.ErrInvalidImage), true)
+
+	// The pictures are written to a copy that replaces the file
+	entries, err := os.ReadDir(filepath.Dir(path))
+	nilErr(t, err)
+	eq(t, len(entries), 1)
+	eq(t, f.Tags()[taglib.Artist][0], "example artist")
+
+	// The handle is reopened on the new file, so writing through it keeps the pictures
+	nilErr(t, f.SetPictures([]taglib.Picture{{Data: coverJPG}}))
+	nilErr(t, f.WriteTags(map[string][]string{taglib.Title: {"After"}}, 0))
+	props, err = taglib.ReadProperties(path)
+	nilErr(t, err)
+	eq(t, props.ImageCount, 1)
+	tags, err := taglib.ReadTags(path)
+	nilErr(t, err)
+	eq(t, tags[taglib.Title][0], "After")
+
+	ro, err := taglib.OpenReadOnly(path)
+	nilErr(t, err)
+	t.Cleanup(func() { ro.Close() })
+	err = ro.SetPictures([]taglib.Picture{{Data: coverJPG}})
+	eq(t, errors.Is(err, taglib.ErrSavingFile), true)
+}
+
+func TestImageTo(t *testing.T) {
+	t.Parallel()
+
+	path := tmpf(t, egFLAC, "eg.flac")
+	nilErr(t, taglib.WriteImage(path, coverJPG))
+
+	var buf bytes.Buffer
+	n, err := taglib.ReadImageTo(path, 0, &buf)
+	nilErr(t, err)
+	eq(t, n, int64(len(coverJPG)))
+	eq(t, bytes.Equal(buf.Bytes(), coverJPG), true)
+
+	f, err := taglib.OpenReadOnly(path)
+	nilErr(t, err)
+	t.Cleanup(func() { f.Close() })
+
+	buf.Reset()
+	n, err = f.ImageTo(&buf, 0)
+	nilErr(t, err)
+	eq(t, n, int64(len(coverJPG)))
+	eq(t, bytes.Equal(buf.Bytes(), coverJPG), true)
+
+	// Out of range
+	buf.Reset()
+	n, err = f.ImageTo(&buf, 99)
+	nilErr(t, err)
+	eq(t, n, int64(0))
+	eq(t, buf.Len(), 0)
+
+	// Write errors are returned
+	_, err = f.ImageTo(errWriter{}, 0)
+	eq(t, errors.Is(err, errWrite), true)
+}
+
+var errWrite = errors.New("write failed")
+
+type errWriter struct{}
+
+func (errWriter) Write([]byte) (int, error) { return 0, errWrite }
+
+func TestGaplessInfoMP3(t *testing.T) {
+	t.Parallel()
+
+	path := tmpf(t, egMP3, "eg.mp3")
+
+	// eg.mp3 is one second at 44.1kHz, with the delay and padding written by its encoder
+	info, err := taglib.ReadGaplessInfo(path)
+	nilErr(t, err)
+	eq(t, info, taglib.GaplessInfo{EncoderDelay: 576, Padding: 1404, SampleCount: 44100})
+
+	err = taglib.WriteGaplessInfo(path, taglib.GaplessInfo{EncoderDelay: 1105, Padding: 875})
+	nilErr(t, err)
+
+	info, err = taglib.ReadGaplessInfo(path)
+	nilErr(t, err)
+	eq(t, info, taglib.GaplessInfo{EncoderDelay: 1105, Padding: 875, SampleCount: 44100})
+
+	// The file is still valid, with its tags intact
+	tags, err := taglib.ReadTags(path)
+	nilErr(t, err)
+	eq(t, tags[taglib.Artist][0], "example artist")
+	properties, err := taglib.ReadProperties(path)
+	nilErr(t, err)
+	eq(t, properties.SampleRate, uint(44100))
+
+	err = taglib.WriteGaplessInfo(path, taglib.GaplessInfo{EncoderDelay: 4096})
+	if err == nil {
+		t.Fatalf("expected error for delay too large for LAME tag")
+	}
+}
+
+func TestGaplessInfoMP4(t *testing.T) {
+	t.Parallel()
+
+	path := tmpf(t, egM4a, "eg.m4a")
+
+	info, err := taglib.ReadGaplessInfo(path)
+	nilErr(t, err)
+	eq(t, info, taglib.GaplessInfo{})
+
+	// Written by other tools, the atom name is matched case-insensitively
+	err = taglib.WriteTags(path, map[string][]string{
+		"iTunSMPB": {" 00000000 00000840 000001CC 0000000000046E00 00000000 00000000 00000000 00000000 00000000 00000000 00000000 00000000"},
+	}, 0)
+	nilErr(t, err)
+
+	info, err = taglib.ReadGaplessInfo(path)
+	nilErr(t, err)
+	eq(t, info, taglib.GaplessInfo{EncoderDelay: 2112, Padding: 460, SampleCount: 290304})
+
+	// Written with the exact name iTunes uses, replacing the atom with the other case
+	want := taglib.GaplessInfo{EncoderDelay: 1024, Padding: 1000, SampleCount: 44100}
+	nilErr(t, taglib.WriteGaplessInfo(path, want))
+	info, err = taglib.ReadGaplessInfo(path)
+	nilErr(t, err)
+	eq(t, info, want)
+
+	atoms, err := taglib.ReadMP4Atoms(path)
+	nilErr(t, err)
+	eq(t, len(atoms["----:com.apple.iTunes:iTunSMPB"]), 1)
+	eq(t, len(atoms["----:com.apple.iTunes:ITUNSMPB"]), 0)
+
+	// The rest of the file is intact
+	tags, err := taglib.ReadTags(path)
+	nilErr(t, err)
+	eq(t, tags[taglib.Artist][0], "example artist")
+	properties, err := taglib.ReadProperties(path)
+	nilErr(t, err)
+	eq(t, properties.SampleRate, uint(44100))
+}
+
+func TestGaplessInfoUnsupported(t *testing.T) {
+	t.Parallel()
+
+	path := tmpf(t, egFLAC, "eg.flac")
+	err := taglib.WriteGaplessInfo(path, taglib.GaplessInfo{EncoderDelay: 1})
+	if !errors.Is(err, taglib.ErrUnsupportedFormat) {
+		t.Fatalf("expected ErrUnsupportedFormat, got %v", err)
+	}
+}
+
+func TestReplayGainMP4(t *testing.T) {
+	t.Parallel()
+
+	// Write freeform atoms, then rename them to the lowercase form most software writes
+	path := tmpf(t, egM4a, "eg.m4a")
+	nilErr(t, taglib.WriteTags(path, map[string][]string{
+		"REPLAYGAIN_TRACK_GAIN": {"-6.50 dB"},
+		"REPLAYGAIN_TRACK_PEAK": {"0.988553"},
+	}, 0))
+	data, err := os.ReadFile(path)
+	nilErr(t, err)
+	data = bytes.ReplaceAll(data, []byte("REPLAYGAIN_TRACK_GAIN"), []byte("replaygain_track_gain"))
+	data = bytes.ReplaceAll(data, []byte("REPLAYGAIN_TRACK_PEAK"), []byte("replaygain_track_peak"))
+	nilErr(t, os.WriteFile(path, data, 0o644))
+
+	atoms, err := taglib.ReadMP4Atoms(path)
+	nilErr(t, err)
+	eq(t, len(atoms["----:com.apple.iTunes:replaygain_track_gain"]), 1)
+
+	tags, err := taglib.ReadTags(path)
+	nilErr(t, err)
+	eq(t, len(tags["REPLAYGAIN_TRACK_GAIN"]), 1)
+
+	rg, err := taglib.ReadReplayGain(path)
+	nilErr(t, err)
+	eq(t, rg, taglib.ReplayGain{TrackGain: -6.5, TrackPeak: 0.988553})
+
+	err = taglib.WriteReplayGain(path, taglib.ReplayGain{TrackGain: 1.25, AlbumGain: -3, AlbumPeak: 1})
+	nilErr(t, err)
+
+	rg, err = taglib.ReadReplayGain(path)
+	nilErr(t, err)
+	eq(t, rg, taglib.ReplayGain{TrackGain: 1.25, AlbumGain: -3, AlbumPeak: 1})
+
+	atoms, err = taglib.ReadMP4Atoms(path)
+	nilErr(t, err)
+	eq(t, atoms["----:com.apple.iTunes:replaygain_track_gain"][0], "1.25 dB")
+	eq(t, atoms["----:com.apple.iTunes:replaygain_album_peak"][0], "1.000000")
+	eq(t, len(atoms["----:com.apple.iTunes:replaygain_track_peak"]), 0)
+}
+
+func TestReplayGain(t *testing.T) {
+	t.Parallel()
+
+	for _, name := range []string{"eg.mp3", "eg.flac"} {
+		data := map[string][]byte{"eg.mp3": egMP3, "eg.flac": egFLAC}[name]
+		path := tmpf(t, data, name)
+
+		want := taglib.ReplayGain{TrackGain: -7.01, TrackPeak: 0.5, AlbumGain: 2.5, AlbumPeak: 0.75}
+		nilErr(t, taglib.WriteReplayGain(path, want))
+		rg, err := taglib.ReadReplayGain(path)
+		nilErr(t, err)
+		eq(t, rg, want)
+
+		tags, err := taglib.ReadTags(path)
+		nilErr(t, err)
+		eq(t, tags["REPLAYGAIN_TRACK_GAIN"][0], "-7.01 dB")
+		eq(t, tags["REPLAYGAIN_TRACK_PEAK"][0], "0.500000")
+
+		// Zero fields remove their tags
+		nilErr(t, taglib.WriteReplayGain(path, taglib.ReplayGain{TrackGain: -7.01}))
+		tags, err = taglib.ReadTags(path)
+		nilErr(t, err)
+		eq(t, len(tags["REPLAYGAIN_ALBUM_GAIN"]), 0)
+		eq(t, len(tags["REPLAYGAIN_TRACK_GAIN"]), 1)
+	}
+}
+
+func TestSameRecording(t *testing.T) {
+	t.Parallel()
+
+	// Fingerprints of 300 items, one with every 20th bit flipped, and an unrelated one
+	items := make([]uint32, 300)
+	nearby := make([]uint32, 300)
+	unrelated := make([]uint32, 300)
+	for i := range items {
+		items[i] = uint32(i)*2654435761 ^ 0x5bd1e995
+		nearby[i] = items[i]
+		if i%20 == 0 {
+			nearby[i] ^= 1 << (i % 32)
+		}
+		unrelated[i] = uint32(i)*40503 ^ 0xdeadbeef
+	}
+
+	const (
+		mbid      = "5b11f4ce-a62d-471e-81fc-a69a8278c7da"
+		otherMBID = "7d3e4b4e-1c7a-4a42-93a2-5c0fce3a0b0e"
+		acoustID  = "a2ad5e1b-7e4a-4e73-a3d5-c8f0e1bd4a11"
+	)
+	tests := []struct {
+		name       string
+		a, b       map[string][]string
+		same       bool
+		confidence func(float64) bool
+	}{
+		{"nothing to compare", nil, nil, false, func(c float64) bool { return c == 0 }},
+		{"same mbid", map[string][]string{taglib.MusicBrainzTrackID: {mbid}}, map[string][]string{taglib.MusicBrainzTrackID: {mbid}},
+			true, func(c float64) bool { return c == 1 }},
+		{"same acoustid", map[string][]string{taglib.AcoustIDID: {acoustID}}, map[string][]string{taglib.AcoustIDID: {acoustID}},
+			true, func(c float64) bool { return c == 0.9 }},
+		{"identical fingerprints", map[string][]string{taglib.AcoustIDFingerprint: {compressFingerprint(items)}},
+			map[string][]string{taglib.AcoustIDFingerprint: {compressFingerprint(items)}},
+			true, func(c float64) bool { return c == 1 }},
+		{"similar fingerprints", map[string][]string{taglib.AcoustIDFingerprint: {compressFingerprint(items)}},
+			map[string][]string{taglib.AcoustIDFingerprint: {compressFingerprint(nearby[5:])}},
+			true, func(c float64) bool { return c > 0.9 && c < 1 }},
+		{"unrelated fingerprints", map[string][]string{taglib.AcoustIDFingerprint: {compressFingerprint(items)}},
+			map[string][]string{taglib.AcoustIDFingerprint: {compressFingerprint(unrelated)}},
+			false, func(c float64) bool { return c < 0.3 }},
+		{"different mbids", map[string][]string{taglib.MusicBrainzTrackID: {mbid}, taglib.AcoustIDID: {acoustID}},
+			map[string][]string{taglib.MusicBrainzTrackID: {otherMBID}, taglib.AcoustIDID: {acoustID}},
+			false, func(c float64) bool { return c == 0.3 }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			a, b := tmpf(t, egFLAC, "a.flac"), tmpf(t, egMP3, "b.mp3")
+			nilErr(t, taglib.WriteTags(a, tt.a, taglib.Clear))
+			nilErr(t, taglib.WriteTags(b, tt.b, taglib.Clear))
+
+			same, confidence, err := taglib.SameRecording(a, b)
+			nilErr(t, err)
+			eq(t, same, tt.same)
+			if !tt.confidence(confidence) {
+				t.Errorf("unexpected confidence %f", confidence)
+			}
+		})
+	}
+}
+
+// compressFingerprint compresses and encodes fingerprint items the way Chromaprint does.
+func compressFingerprint(items []uint32) string {
+	var gaps []uint32
+	var prev uint32
+	for _, item := range items {
+		x := item ^ prev
+		prev = item
+		last := uint32(0)
+		for bit := uint32(1); x != 0; bit, x = bit+1, x>>1 {
+			if x&1 != 0 {
+				gaps = append(gaps, bit-last)
+				last = bit
+			}
+		}
+		gaps = append(gaps, 0)
+	}
+
+	var out []byte
+	var acc, n uint32
+	pack := func(v uint32, width uint32) {
+		acc |= v << n
+		for n += width; n >= 8; n -= 8 {
+			out = append(out, byte(acc))
+			acc >>= 8
+		}
+	}
+	flush := func() {
+		if n > 0 {
+			out = append(out, byte(acc))
+		}
+		acc, n = 0, 0
+	}
+	for _, gap := range gaps {
+		pack(min(gap, 7), 3)
+	}
+	flush()
+	for _, gap := range gaps {
+		if gap >= 7 {
+			pack(gap-7, 5)
+		}
+	}
+	flush()
+
+	header := []byte{1, byte(len(items) >> 16), byte(len(items) >> 8), byte(len(items))}
+	return base64.RawURLEncoding.EncodeToString(append(header, out...))
+}
+
+func TestReadLoudness(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		data []byte
+		tags map[string][]string
+		want taglib.Loudness
+	}{
+		{"eg.flac", egFLAC, map[string][]string{
+			"REPLAYGAIN_TRACK_GAIN":         {"-5.50 dB"},
+			"REPLAYGAIN_TRACK_PEAK":         {"0.988553"},
+			"REPLAYGAIN_TRACK_RANGE":        {"6.20 dB"},
+			"REPLAYGAIN_REFERENCE_LOUDNESS": {"-18.00 LUFS"},
+		}, taglib.Loudness{IntegratedLUFS: -12.5, TruePeak: 0.988553, LoudnessRange: 6.2}},
+		{"eg.mp3", egMP3, map[string][]string{
+			"REPLAYGAIN_TRACK_GAIN":         {"-4.00 dB"},
+			"REPLAYGAIN_TRACK_PEAK":         {"0.5"},
+			"REPLAYGAIN_REFERENCE_LOUDNESS": {"89.0 dB"},
+		}, taglib.Loudness{IntegratedLUFS: -14}},
+		{"eg.opus", egOpus, map[string][]string{
+			"R128_TRACK_GAIN": {"-512"},
+		}, taglib.Loudness{IntegratedLUFS: -21}},
+		// A gain alone doesn't say what it's relative to
+		{"eg.ogg", egOgg, map[string][]string{
+			"REPLAYGAIN_TRACK_GAIN": {"-4.00 dB"},
+			"REPLAYGAIN_TRACK_PEAK": {"0.5"},
+		}, taglib.Loudness{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			path := tmpf(t, tt.data, tt.name)
+			nilErr(t, taglib.WriteTags(path, tt.tags, taglib.Clear))
+			l, err := taglib.ReadLoudness(path)
+			nilErr(t, err)
+			eq(t, l, tt.want)
+		})
+	}
+}
+
+func TestReadDurations(t *testing.T) {
+	t.Parallel()
+
+	flac := tmpf(t, egFLAC, "eg.flac")
+	mp3 := tmpf(t, egMP3, "eg.mp3")
+	text := tmpf(t, []byte("not audio"), "eg.txt")
+	missing := filepath.Join(t.TempDir(), "missing.flac")
+
+	durations, err := taglib.ReadDurations([]string{flac, mp3, text, missing})
+
+	var pathErrs taglib.PathErrors
+	if !errors.As(err, &pathErrs) {
+		t.Fatalf("expected PathErrors, got %v", err)
+	}
+	eq(t, len(pathErrs), 2)
+	if !errors.Is(pathErrs[text], taglib.ErrInvalidFile) {
+		t.Fatalf("expected ErrInvalidFile for %s, got %v", text, pathErrs[text])
+	}
+	if pathErrs[missing] == nil {
+		t.Fatalf("expected error for %s", missing)
+	}
+
+	eq(t, len(durations), 2)
+	for _, path := range []string{flac, mp3} {
+		properties, err := taglib.ReadProperties(path)
+		nilErr(t, err)
+		eq(t, durations[path], properties.Length)
+	}
+
+	durations, err = taglib.ReadDurations([]string{flac})
+	nilErr(t, err)
+	eq(t, durations[flac], 1*time.Second)
+}
+
+func TestUFID(t *testing.T) {
+	t.Parallel()
+
+	path := tmpf(t, egMP3, "eg.mp3")
+
+	const owner = "http://musicbrainz.org"
+	err := taglib.WriteUFID(path, map[string][]byte{
+		owner:                []byte("a1b2c3d4-0000-1111-2222-333344445555"),
+		"http://example.com": {0x00, 0xFF, 0x10},
+	})
+	nilErr(t, err)
+
+	ids, err := taglib.ReadUFID(path)
+	nilErr(t, err)
+	eq(t, len(ids), 2)
+	eq(t, string(ids[owner]), "a1b2c3d4-0000-1111-2222-333344445555")
+	eq(t, bytes.Equal(ids["http://example.com"], []byte{0x00, 0xFF, 0x10}), true)
+
+	// TagLib maps the MusicBrainz owner to the normalised track ID
+	tags, err := taglib.ReadTags(path)
+	nilErr(t, err)
+	eq(t, tags[taglib.MusicBrainzTrackID][0], "a1b2c3d4-0000-1111-2222-333344445555")
+
+	err = taglib.WriteUFID(path, map[string][]byte{"http://example.com": nil})
+	nilErr(t, err)
+
+	ids, err = taglib.ReadUFID(path)
+	nilErr(t, err)
+	eq(t, len(ids), 1)
+	eq(t, string(ids[owner]), "a1b2c3d4-0000-1111-2222-333344445555")
+
+	// Rewriting an owner replaces its frame rather than adding another
+	nilErr(t, taglib.WriteUFID(path, map[string][]byte{owner: []byte("f4a31f0a-51dd-4fa7-986d-3095c40c5ed9")}))
+	ids, err = taglib.ReadUFID(path)
+	nilErr(t, err)
+	eq(t, len(ids), 1)
+	eq(t, string(ids[owner]), "f4a31f0a-51dd-4fa7-986d-3095c40c5ed9")
+
+	err = taglib.WriteUFID(tmpf(t, egFLAC, "eg.flac"), map[string][]byte{owner: []byte("id")})
+	if !errors.Is(err, taglib.ErrUnsupportedFormat) {
+		t.Fatalf("expected ErrUnsupportedFormat, got %v", err)
+	}
+}
+
+func TestOwnership(t *testing.T) {
+	t.Parallel()
+
+	path := tmpf(t, egMP3, "eg.mp3")
+	_, err := taglib.ReadOwnership(path)
+	if !errors.Is(err, taglib.ErrNoOwnership) {
+		t.Fatalf("expected ErrNoOwnership, got %v", err)
+	}
+
+	want := taglib.Ownership{PricePaid: "EUR0.99", DatePurchased: "20240131", Seller: "Plattenläden"}
+	nilErr(t, taglib.WriteOwnership(path, want))
+	got, err := taglib.ReadOwnership(path)
+	nilErr(t, err)
+	eq(t, got, want)
+
+	// TagLib keeps the frame when writing other tags
+	nilErr(t, taglib.WriteTags(path, map[string][]string{taglib.Title: {"Title"}}, 0))
+	got, err = taglib.ReadOwnership(path)
+	nilErr(t, err)
+	eq(t, got, want)
+	tags, err := taglib.ReadTags(path)
+	nilErr(t, err)
+	eq(t, tags[taglib.Title][0], "Title")
+	eq(t, tags[taglib.Artist][0], "example artist")
+
+	want = taglib.Ownership{PricePaid: "JPY250", Seller: "レコード店"}
+	nilErr(t, taglib.WriteOwnership(path, want))
+	got, err = taglib.ReadOwnership(path)
+	nilErr(t, err)
+	eq(t, got, want)
+
+	nilErr(t, taglib.WriteOwnership(path, taglib.Ownership{}))
+	_, err = taglib.ReadOwnership(path)
+	if !errors.Is(err, taglib.ErrNoOwnership) {
+		t.Fatalf("expected ErrNoOwnership, got %v", err)
+	}
+
+	// A tag is added to files without one, keeping the audio
+	audio := egMP3[10+(int(egMP3[6])<<21|int(egMP3[7])<<14|int(egMP3[8])<<7|int(egMP3[9])):]
+	path = tmpf(t, audio, "eg.mp3")
+	nilErr(t, taglib.WriteOwnership(path, want))
+	got, err = taglib.ReadOwnership(path)
+	nilErr(t, err)
+	eq(t, got, want)
+	props, err := taglib.ReadProperties(path)
+	nilErr(t, err)
+	eq(t, props.Length > 0, true)
+	offset, err := taglib.ReadAudioOffset(path)
+	nilErr(t, err)
+	data, err := os.ReadFile(path)
+	nilErr(t, err)
+	eq(t, bytes.Equal(data[offset:], audio), true)
+
+	path = tmpf(t, egFLAC, "eg.flac")
+	err = taglib.WriteOwnership(path, want)
+	if !errors.Is(err, taglib.ErrUnsupportedFormat) {
+		t.Fatalf("expected ErrUnsupportedFormat, got %v", err)
+	}
+}
+
+func TestPlayCount(t *testing.T) {
+	t.Parallel()
+
+	path := tmpf(t, egMP3, "eg.mp3")
+	count, err := taglib.ReadPlayCount(path)
+	nilErr(t, err)
+	eq(t, count, uint64(0))
+
+	// Larger than 32 bits, so the counter grows past its minimum of 4 bytes
+	nilErr(t, taglib.WritePlayCount(path, 1<<40+7))
+	count, err = taglib.ReadPlayCount(path)
+	nilErr(t, err)
+	eq(t, count, uint64(1<<40+7))
+
+	nilErr(t, taglib.WritePlayCount(path, 12))
+	count, err = taglib.ReadPlayCount(path)
+	nilErr(t, err)
+	eq(t, count, uint64(12))
+
+	// TagLib keeps the frame when writing other tags
+	nilErr(t, taglib.WriteTags(path, map[string][]string{taglib.Title: {"Title"}}, 0))
+	count, err = taglib.ReadPlayCount(path)
+	nilErr(t, err)
+	eq(t, count, uint64(12))
+
+	nilErr(t, taglib.WritePlayCount(path, 0))
+	count, err = taglib.ReadPlayCount(path)
+	nilErr(t, err)
+	eq(t, count, uint64(0))
+
+	// Without a play counter, the highest popularimeter counter is used
+	frame := func(id string, body ...byte) []byte {
+		size := len(body)
+		return append([]byte{id[0], id[1], id[2], id[3], 0, 0, byte(size >> 7), byte(size & 0x7F), 0, 0}, body...)
+	}
+	var frames []byte
+	frames = append(frames, frame("POPM", append([]byte("a@example.com\x00"), 196, 0, 0, 0, 3)...)...)
+	frames = append(frames, frame("POPM", append([]byte("b@example.com\x00"), 255, 0, 0, 1, 0)...)...)
+	frames = append(frames, frame("POPM", append([]byte("c@example.com\x00"), 64)...)...)
+	size := len(frames)
+	tag := append([]byte{'I', 'D', '3', 4, 0, 0, 0, 0, byte(size >> 7), byte(size & 0x7F)}, frames...)
+	audio := egMP3[10+(int(egMP3[6])<<21|int(egMP3[7])<<14|int(egMP3[8])<<7|int(egMP3[9])):]
+	path = tmpf(t, append(tag, audio...), "eg.mp3")
+
+	count, err = taglib.ReadPlayCount(path)
+	nilErr(t, err)
+	eq(t, count, uint64(256))
+
+	nilErr(t, taglib.WritePlayCount(path, 5))
+	count, err = taglib.ReadPlayCount(path)
+	nilErr(t, err)
+	eq(t, count, uint64(5))
+	raw, err := taglib.ReadID3v2Frames(path)
+	nilErr(t, err)
+	eq(t, len(raw["POPM:a@example.com"]), 1)
+
+	_, err = taglib.ReadPlayCount(tmpf(t, egFLAC, "eg.flac"))
+	if !errors.Is(err, taglib.ErrUnsupportedFormat) {
+		t.Fatalf("expected ErrUnsupportedFormat, got %v", err)
+	}
+}
+
+func TestRenameFromTemplate(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "eg.flac")
+	nilErr(t, os.WriteFile(path, egFLAC, 0o644))
+
+	tags := map[string][]string{
+		taglib.Artist:      {"AC/DC"},
+		taglib.Album:       {"Back in Black"},
+		taglib.Title:       {"Back in Black"},
+		taglib.TrackNumber: {"6/10"},
+		taglib.DiscNumber:  {"1"},
+		taglib.Date:        {"1980-07-25"},
+	}
+	err := taglib.WriteTags(path, tags, taglib.Clear)
+	nilErr(t, err)
+
+	newPath, err := taglib.RenameFromTemplate(path, "{artist}/{year} {album}/{disc}-{track:2} {title}{missing}.{ext}")
+	nilErr(t, err)
+	eq(t, newPath, filepath.Join(dir, "AC_DC", "1980 Back in Black", "1-06 Back in Black.flac"))
+
+	_, err = os.Stat(path)
+	eq(t, errors.Is(err, os.ErrNotExist), true)
+	renamedTags, err := taglib.ReadTags(newPath)
+	nilErr(t, err)
+	eq(t, renamedTags[taglib.Title][0], "Back in Black")
+
+	// Renaming to the current name is a no-op
+	samePath, err := taglib.RenameFromTemplate(newPath, "{disc}-{track:2} {title}.{ext}")
+	nilErr(t, err)
+	eq(t, samePath, newPath)
+
+	// Collisions get a counter
+	other := filepath.Join(dir, "other.flac")
+	nilErr(t, os.WriteFile(other, egFLAC, 0o644))
+	err = taglib.WriteTags(other, tags, taglib.Clear)
+	nilErr(t, err)
+	otherPath, err := taglib.RenameFromTemplate(other, "{artist}/{year} {album}/{disc}-{track:2} {title}.{ext}")
+	nilErr(t, err)
+	eq(t, otherPath, filepath.Join(dir, "AC_DC", "1980 Back in Black", "1-06 Back in Black (1).flac"))
+}
+
+func TestRenameFromTemplateInvalid(t *testing.T) {
+	t.Parallel()
+
+	path := tmpf(t, egFLAC, "eg.flac")
+	for _, template := range []string{
+		"{title",
+		"{track:x}.flac",
+		"{missing}/{title}.flac",
+	} {
+		_, err := taglib.RenameFromTemplate(path, template)
+		if err == nil {
+			t.Errorf("expected error for template %q", template)
+		}
+	}
+
+	// Values can't escape the directory
+	err := taglib.WriteTags(path, map[string][]string{taglib.Title: {".."}}, 0)
+	nilErr(t, err)
+	newPath, err := taglib.RenameFromTemplate(path, "{title}")
+	nilErr(t, err)
+	eq(t, filepath.Base(newPath), "__")
+	eq(t, filepath.Dir(newPath), filepath.Dir(path))
+}
+
+func TestTemplateFields(t *testing.T) {
+	t.Parallel()
+
+	path := tmpf(t, egFLAC, "eg.flac")
+	err := taglib.WriteTags(path, map[string][]string{
+		taglib.Title:       {"  AC/DC Live  "},
+		taglib.AlbumArtist: {"First", "Second"},
+		taglib.TrackNumber: {"3/12"},
+		taglib.DiscNumber:  {"1"},
+		taglib.Date:        {"1991-10-28"},
+	}, taglib.Clear)
+	nilErr(t, err)
+
+	title, err := taglib.TagValue(path, "title")
+	nilErr(t, err)
+	eq(t, title, "AC_DC Live")
+
+	albumArtist, err := taglib.TagValue(path, taglib.AlbumArtist)
+	nilErr(t, err)
+	eq(t, albumArtist, "First")
+
+	missing, err := taglib.TagValue(path, taglib.Composer)
+	nilErr(t, err)
+	eq(t, missing, "")
+
+	fields, err := taglib.TemplateFields(path)
+	nilErr(t, err)
+	eq(t, fields["title"], "AC_DC Live")
+	eq(t, fields["albumartist"], "First")
+	eq(t, fields["track"], "3")
+	eq(t, fields["tracknumber"], "3_12")
+	eq(t, fields["disc"], "1")
+	eq(t, fields["year"], "1991")
+	eq(t, fields["ext"], "flac")
+	_, ok := fields["composer"]
+	eq(t, ok, false)
+}
+
+func TestReadOggStreamInfo(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		data     []byte
+		filename string
+		want     taglib.OggStreamInfo
+	}{
+		{egOpus, "eg.opus", taglib.OggStreamInfo{SerialNumber: 2206016576, LastGranulePos: 48312}},
+		{egSpeex, "eg.spx", taglib.OggStreamInfo{SerialNumber: 1592614637, LastGranulePos: 16000}},
+		{egOggFLAC, "eg.oga", taglib.OggStreamInfo{SerialNumber: 305441741, LastGranulePos: 48000}},
+		// Only the header pages
+		{egOgg, "eg.ogg", taglib.OggStreamInfo{SerialNumber: 1529926019, LastGranulePos: 0}},
+		// Another stream following the first
+		{slices.Concat(egOpus, egSpeex), "chained.opus", taglib.OggStreamInfo{SerialNumber: 2206016576, LastGranulePos: 48312, Chained: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.filename, func(t *testing.T) {
+			t.Parallel()
+
+			info, err := taglib.ReadOggStreamInfo(tmpf(t, tt.data, tt.filename))
+			nilErr(t, err)
+			eq(t, info, tt.want)
+		})
+	}
+
+	_, err := taglib.ReadOggStreamInfo(tmpf(t, egFLAC, "eg.flac"))
+	eq(t, errors.Is(err, taglib.ErrUnsupportedFormat), true)
+}
+
+func TestAudioHash(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		data     []byte
+		filename string
+	}{
+		{egMP3, "eg.mp3"},
+		{egFLAC, "eg.flac"},
+		{egM4a, "eg.m4a"},
+		{egOgg, "eg.ogg"},
+		{egOpus, "eg.opus"},
+		{egSpeex, "eg.spx"},
+		{egOggFLAC, "eg.oga"},
+		{egWAV, "eg.wav"},
+		{egAIFF, "eg.aiff"},
+		{egAPE, "eg.ape"},
+		{egWavPack, "eg.wv"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.filename, func(t *testing.T) {
+			t.Parallel()
+
+			path := tmpf(t, tt.data, tt.filename)
+			want, err := taglib.AudioHash(path)
+			nilErr(t, err)
+			eq(t, len(want), 32)
+
+			// Tags and images that change the size of the metadata don't change the hash
+			nilErr(t, taglib.WriteTags(path, map[string][]string{
+				taglib.Title:   {"Title"},
+				taglib.Comment: {strings.Repeat("long comment ", 10000)},
+			}, taglib.Clear))
+			nilErr(t, taglib.WriteImage(path, coverJPG))
+			got, err := taglib.AudioHash(path)
+			nilErr(t, err)
+			eq(t, bytes.Equal(got, want), true)
+
+			nilErr(t, taglib.WriteTags(path, nil, taglib.Clear))
+			got, err = taglib.AudioHash(path)
+			nilErr(t, err)
+			eq(t, bytes.Equal(got, want), true)
+		})
+	}
+
+	// Changing the audio changes the hash
+	path := tmpf(t, egMP3, "eg.mp3")
+	want, err := taglib.AudioHash(path)
+	nilErr(t, err)
+	offset, err := taglib.ReadAudioOffset(path)
+	nilErr(t, err)
+	data, err := os.ReadFile(path)
+	nilErr(t, err)
+	data[offset+1000] ^= 0xFF
+	nilErr(t, os.WriteFile(path, data, 0o644))
+	got, err := taglib.AudioHash(path)
+	nilErr(t, err)
+	eq(t, bytes.Equal(got, want), false)
+
+	// The audio of every link of a chained Ogg file is hashed
+	chained := slices.Concat(egOpus, egOpus)
+	path = tmpf(t, chained, "eg.opus")
+	want, err = taglib.AudioHash(path)
+	nilErr(t, err)
+	single, err := taglib.AudioHash(tmpf(t, egOpus, "eg.opus"))
+	nilErr(t, err)
+	eq(t, bytes.Equal(want, single), false)
+	chained[len(egOpus)-10] ^= 0xFF
+	nilErr(t, os.WriteFile(path, chained, 0o644))
+	got, err = taglib.AudioHash(path)
+	nilErr(t, err)
+	eq(t, bytes.Equal(got, want), false)
+
+	_, err = taglib.AudioHash(tmpf(t, egWMA, "eg.wma"))
+	eq(t, errors.Is(err, taglib.ErrUnsupportedFormat), true)
+}
+
+func TestMetadataHash(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		data     []byte
+		filename string
+	}{
+		{egMP3, "eg.mp3"},
+		{egFLAC, "eg.flac"},
+		{egM4a, "eg.m4a"},
+		{egOgg, "eg.ogg"},
+		{egOpus, "eg.opus"},
+		{egWAV, "eg.wav"},
+		{egAIFF, "eg.aiff"},
+		{egAPE, "eg.ape"},
+		{egWavPack, "eg.wv"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.filename, func(t *testing.T) {
+			t.Parallel()
+
+			path := tmpf(t, tt.data, tt.filename)
+			before, err := taglib.MetadataHash(path)
+			nilErr(t, err)
+			eq(t, len(before), 32)
+			audio, err := taglib.AudioHash(path)
+			nilErr(t, err)
+
+			// Changing the tags changes the metadata hash but not the audio one
+			nilErr(t, taglib.WriteTags(path, map[string][]string{taglib.Title: {"Another Title"}}, 0))
+			after, err := taglib.MetadataHash(path)
+			nilErr(t, err)
+			eq(t, bytes.Equal(after, before), false)
+			got, err := taglib.AudioHash(path)
+			nilErr(t, err)
+			eq(t, bytes.Equal(got, audio), true)
+
+			again, err := taglib.MetadataHash(path)
+			nilErr(t, err)
+			eq(t, bytes.Equal(again, after), true)
+		})
+	}
+
+	// Changing the audio doesn't change the metadata hash
+	path := tmpf(t, egMP3, "eg.mp3")
+	want, err := taglib.MetadataHash(path)
+	nilErr(t, err)
+	offset, err := taglib.ReadAudioOffset(path)
+	nilErr(t, err)
+	data, err := os.ReadFile(path)
+	nilErr(t, err)
+	data[offset+1000] ^= 0xFF
+	nilErr(t, os.WriteFile(path, data, 0o644))
+	got, err := taglib.MetadataHash(path)
+	nilErr(t, err)
+	eq(t, bytes.Equal(got, want), true)
+
+	_, err = taglib.MetadataHash(tmpf(t, egWMA, "eg.wma"))
+	eq(t, errors.Is(err, taglib.ErrUnsupportedFormat), true)
+}
+
+func TestReadAudioOffset(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		data []byte
+		// The bytes at the offset, and the box, chunk, or tag before it
+		at, before []byte
+	}{
+		{"eg.mp3", egMP3, []byte{0xFF, 0xFB}, nil},
+		{"eg.flac", egFLAC, []byte{0xFF, 0xF8}, nil},
+		{"eg.m4a", egM4a, nil, []byte("mdat")},
+		{"eg.wav", egWAV, nil, []byte("data")},
+		{"eg.aiff", egAIFF, nil, []byte("SSND")},
+		{"eg.ogg", egOgg, []byte("OggS"), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			offset, err := taglib.ReadAudioOffset(tmpf(t, tt.data, tt.name))
+			nilErr(t, err)
+			if tt.at != nil {
+				eq(t, bytes.HasPrefix(tt.data[offset:], tt.at), true)
+			}
+			if tt.before != nil {
+				i := bytes.Index(tt.data, tt.before)
+				eq(t, i > 0 && offset > int64(i) && offset <= int64(i)+16, true)
+			}
+		})
+	}
+
+	// The ID3v2 tag is skipped
+	path := tmpf(t, egMP3, "eg.mp3")
+	nilErr(t, taglib.WriteTags(path, bigTags, 0))
+	data, err := os.ReadFile(path)
+	nilErr(t, err)
+	offset, err := taglib.ReadAudioOffset(path)
+	nilErr(t, err)
+	eq(t, string(data[:3]), "ID3")
+	eq(t, offset > 1<<10, true)
+	eq(t, bytes.HasPrefix(data[offset:], []byte{0xFF, 0xFB}), true)
+}
+
+func TestCompressionMode(t *testing.T) {
+	t.Parallel()
+
+	// Set the hybrid flag in the block header
+	egWavPackHybrid := slices.Clone(egWavPack)
+	egWavPackHybrid[24] |= 0x8
+
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"eg.ape", egAPE, "extra high"},
+		{"eg.wv", egWavPack, "high lossless"},
+		{"hybrid.wv", egWavPackHybrid, "high hybrid"},
+		{"eg.flac", egFLAC, ""},
+		{"eg.mp3", egMP3, ""},
+	}
+	for _, tt := range tests[:2] {
+		properties, err := taglib.ReadProperties(tmpf(t, tt.data, tt.name))
+		nilErr(t, err)
+		eq(t, properties.Length, 1*time.Second)
+		eq(t, properties.SampleRate, uint(44100))
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := tmpf(t, tt.data, tt.name)
+
+			properties, err := taglib.ReadProperties(path)
+			nilErr(t, err)
+			eq(t, properties.CompressionMode, tt.want)
+
+			f, err := taglib.Open(path)
+			nilErr(t, err)
+			defer func() { _ = f.Close() }()
+			eq(t, f.Properties().CompressionMode, tt.want)
+
+			s, err := taglib.OpenStream(bytes.NewReader(tt.data), taglib.WithFilename(tt.name))
+			nilErr(t, err)
+			defer func() { _ = s.Close() }()
+			eq(t, s.Properties().CompressionMode, tt.want)
+		})
+	}
+}
+
+func TestPropertiesCounts(t *testing.T) {
+	t.Parallel()
+
+	cueFLAC := tmpf(t, egFLAC, "eg.flac")
+	err := taglib.WriteTags(cueFLAC, map[string][]string{
+		"CUESHEET": {"FILE \"eg.flac\" WAVE\n  TRACK 01 AUDIO\n    INDEX 01 00:00:00\n  TRACK 02 AUDIO\n    INDEX 01 00:00:37\n"},
+	}, 0)
+	nilErr(t, err)
+
+	// eg.flac already has two images
+	imageM4a := tmpf(t, egM4a, "eg.m4a")
+	err = taglib.WriteImage(imageM4a, coverJPG)
+	nilErr(t, err)
+
+	tests := []struct {
+		name                        string
+		path                        string
+		images, chapters, cueTracks int
+	}{
+		{"chapters", tmpf(t, egMP3Chapters, "eg.mp3"), 0, 2, 0},
+		{"cue sheet", cueFLAC, 2, 0, 2},
+		{"image", imageM4a, 1, 0, 0},
+		{"none", tmpf(t, egMP3, "eg.mp3"), 0, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			properties, err := taglib.ReadProperties(tt.path)
+			nilErr(t, err)
+			eq(t, properties.ImageCount, tt.images)
+			eq(t, properties.ChapterCount, tt.chapters)
+			eq(t, properties.CueTrackCount, tt.cueTracks)
+
+			f, err := taglib.Open(tt.path)
+			nilErr(t, err)
+			defer func() { _ = f.Close() }()
+			properties = f.Properties()
+			eq(t, properties.ImageCount, tt.images)
+			eq(t, properties.ChapterCount, tt.chapters)
+			eq(t, properties.CueTrackCount, tt.cueTracks)
+		})
+	}
+}
+
+func TestWriteTagsPreserveUnmapped(t *testing.T) {
+	t.Parallel()
+
+	path := tmpf(t, egMP3Lyrics, "eg.mp3")
+	err := taglib.WriteID3v2Frames(path, map[string][]string{
+		"TFOO": {"custom"},
+		"TBAR": {"Shared"},
+		"TIT2": {"Shared"},
+	}, 0)
+	nilErr(t, err)
+
+	err = taglib.WriteTags(path, map[string][]string{
+		taglib.Title: {"New Title"},
+	}, taglib.Clear|taglib.PreserveUnmapped)
+	nilErr(t, err)
+
+	frames, err := taglib.ReadID3v2Frames(path)
+	nilErr(t, err)
+
+	// Unmapped frames are kept, even with the value of a mapped one
+	eq(t, frames["TFOO"][0], "custom")
+	eq(t, frames["TBAR"][0], "Shared")
+	eq(t, len(frames["SYLT:eng"]), 1)
+
+	// Mapped frames are still cleared
+	eq(t, frames["TIT2"][0], "New Title")
+	eq(t, len(frames["TPE1"]), 0)
+	eq(t, len(frames["TALB"]), 0)
+	eq(t, len(frames["USLT:eng"]), 0)
+}
+
+func TestWriteTagsPreserveModTime(t *testing.T) {
+	t.Parallel()
+
+	path := tmpf(t, egFLAC, "eg.flac")
+	old := time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC)
+	nilErr(t, os.Chtimes(path, old, old))
+
+	err := taglib.WriteTags(path, map[string][]string{
+		taglib.Title: {"Kept"},
+	}, taglib.PreserveModTime)
+	nilErr(t, err)
+
+	info, err := os.Stat(path)
+	nilErr(t, err)
+	eq(t, info.ModTime().Equal(old), true)
+
+	f, err := taglib.Open(path)
+	nilErr(t, err)
+	err = f.WriteTags(map[string][]string{
+		taglib.Title: {"Kept Again"},
+	}, taglib.PreserveModTime)
+	nilErr(t, err)
+	nilErr(t, f.Close())
+
+	info, err = os.Stat(path)
+	nilErr(t, err)
+	eq(t, info.ModTime().Equal(old), true)
+
+	err = taglib.WriteTags(path, map[string][]string{
+		taglib.Title: {"Changed"},
+	}, 0)
+	nilErr(t, err)
+
+	info, err = os.Stat(path)
+	nilErr(t, err)
+	eq(t, info.ModTime().Equal(old), false)
+}
+
+func TestWriteTagsPreserveEncoding(t *testing.T) {
+	t.Parallel()
+
+	// An ID3v2.3 tag with ISO-8859-1 frames
+	frame := func(id string, body ...string) []byte {
+		data := []byte{0} // ISO-8859-1
+		data = append(data, strings.Join(body, "\x00")...)
+		size := len(data)
+		header := append([]byte(id), byte(size>>24), byte(size>>16), byte(size>>8), byte(size), 0, 0)
+		return append(header, data...)
+	}
+	var frames []byte
+	frames = append(frames, frame("TIT2", "Title")...)
+	frames = append(frames, frame("TPE1", "Caf\xe9")...)
+	frames = append(frames, frame("TXXX", "desc", "value")...)
+	size := len(frames)
+	tag := []byte{'I', 'D', '3', 3, 0, 0, byte(size >> 21 & 0x7F), byte(size >> 14 & 0x7F), byte(size >> 7 & 0x7F), byte(size & 0x7F)}
+	tag = append(tag, frames...)
+	audio := egMP3[10+(int(egMP3[6])<<21|int(egMP3[7])<<14|int(egMP3[8])<<7|int(egMP3[9])):]
+	data := append(tag, audio...)
+
+	// The text encodings of the frames of the ID3v2.4 tag TagLib writes, by frame ID
+	encodings := func(path string) map[string]byte {
+		b, err := os.ReadFile(path)
+		nilErr(t, err)
+		eq(t, b[3], 4)
+		size := int(b[6])<<21 | int(b[7])<<14 | int(b[8])<<7 | int(b[9])
+		encs := map[string]byte{}
+		for pos := 10; pos+10 <= 10+size && b[pos] != 0; {
+			n := int(b[pos+4])<<21 | int(b[pos+5])<<14 | int(b[pos+6])<<7 | int(b[pos+7])
+			encs[string(b[pos:pos+4])] = b[pos+10]
+			pos += 10 + n
+		}
+		return encs
+	}
+
+	tags := map[string][]string{
+		taglib.Title:  {"Nouveau Café"},
+		taglib.Album:  {"Album"},
+		taglib.Lyrics: {"日本語"},
+	}
+
+	// TagLib writes the changed frames as UTF-8
+	path := tmpf(t, data, "eg.mp3")
+	nilErr(t, taglib.WriteTags(path, tags, 0))
+	encs := encodings(path)
+	eq(t, encs["TIT2"], 3)
+	eq(t, encs["TPE1"], 0)
+
+	path = tmpf(t, data, "eg.mp3")
+	nilErr(t, taglib.WriteTags(path, tags, taglib.PreserveEncoding))
+	encs = encodings(path)
+	eq(t, encs["TIT2"], 0)
+	eq(t, encs["TPE1"], 0)
+	eq(t, encs["TXXX"], 0)
+	eq(t, encs["TALB"], 0) // New frames use the encoding of the others
+	eq(t, encs["USLT"], 3) // Values that don't fit are left as they are
+
+	// Both saves are done on a copy that replaces the file
+	entries, err := os.ReadDir(filepath.Dir(path))
+	nilErr(t, err)
+	eq(t, len(entries), 1)
+
+	got, err := taglib.ReadTags(path)
+	nilErr(t, err)
+	eq(t, got[taglib.Title][0], "Nouveau Café")
+	eq(t, got[taglib.Artist][0], "Café")
+	eq(t, got[taglib.Album][0], "Album")
+	eq(t, got[taglib.Lyrics][0], "日本語")
+	eq(t, got["DESC"][0], "value")
+
+	// The audio is kept
+	b, err := os.ReadFile(path)
+	nilErr(t, err)
+	eq(t, bytes.Contains(b, audio[:1000]), true)
+
+	// A UTF-16 tag, which takes more space than the UTF-8 TagLib writes
+	utf16Frame := []byte("TIT2\x00\x00\x00\x0b\x00\x00\x01\xff\xfeT\x00i\x00t\x00l\x00e\x00")
+	size = len(utf16Frame)
+	tag = []byte{'I', 'D', '3', 3, 0, 0, byte(size >> 21 & 0x7F), byte(size >> 14 & 0x7F), byte(size >> 7 & 0x7F), byte(size & 0x7F)}
+	path = tmpf(t, append(append(tag, utf16Frame...), audio...), "eg.mp3")
+	nilErr(t, taglib.WriteTags(path, map[string][]string{
+		taglib.Title:  {"Nouveau 日本語"},
+		taglib.Artist: {strings.Repeat("Artist ", 500)},
+	}, taglib.PreserveEncoding))
+	encs = encodings(path)
+	eq(t, encs["TIT2"], 1)
+	eq(t, encs["TPE1"], 1)
+
+	got, err = taglib.ReadTags(path)
+	nilErr(t, err)
+	eq(t, got[taglib.Title][0], "Nouveau 日本語")
+	eq(t, got[taglib.Artist][0], strings.Repeat("Artist ", 500))
+}
+
+func TestGrouping(t *testing.T) {
+	t.Parallel()
+
+	path := tmpf(t, egMP3, "eg.mp3")
+	f, err := taglib.Open(path)
+	nilErr(t, err)
+	t.Cleanup(func() { f.Close() })
+
+	nilErr(t, f.SetITunesGrouping("iTunes Grouping"))
+	nilErr(t, f.SetContentGroup("Content Group"))
+
+	grouping, err := f.ITunesGrouping()
+	nilErr(t, err)
+	eq(t, grouping, "iTunes Grouping")
+	group, err := f.ContentGroup()
+	nilErr(t, err)
+	eq(t, group, "Content Group")
+
+	raw := f.RawTags()
+	eq(t, slices.Equal(raw["GRP1"], []string{"iTunes Grouping"}), true)
+	eq(t, slices.Equal(raw["TIT1"], []string{"Content Group"}), true)
+
+	nilErr(t, f.SetContentGroup(""))
+	group, err = f.ContentGroup()
+	nilErr(t, err)
+	eq(t, group, "")
+	grouping, err = f.ITunesGrouping()
+	nilErr(t, err)
+	eq(t, grouping, "iTunes Grouping")
+}
+
+func TestGroupingMP4(t *testing.T) {
+	t.Parallel()
+
+	path := tmpf(t, egM4a, "eg.m4a")
+	f, err := taglib.Open(path)
+	nilErr(t, err)
+	t.Cleanup(func() { f.Close() })
+
+	nilErr(t, f.SetITunesGrouping("iTunes Grouping"))
+	nilErr(t, f.SetContentGroup("Content Group"))
+
+	raw := f.RawTags()
+	eq(t, slices.Equal(raw["©grp"], []string{"iTunes Grouping"}), true)
+	eq(t, slices.Equal(raw["----:com.apple.iTunes:CONTENTGROUP"], []string{"Content Group"}), true)
+
+	grouping, err := f.ITunesGrouping()
+	nilErr(t, err)
+	eq(t, grouping, "iTunes Grouping")
+	group, err := f.ContentGroup()
+	nilErr(t, err)
+	eq(t, group, "Content Group")
+}
+
+func TestContentRating(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name string
+		data []byte
+	}{
+		{"eg.mp3", egMP3},
+		{"eg.flac", egFLAC},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			path := tmpf(t, tc.data, tc.name)
+			f, err := taglib.Open(path)
+			nilErr(t, err)
+			t.Cleanup(func() { f.Close() })
+
+			rating, err := f.ContentRating()
+			nilErr(t, err)
+			eq(t, rating, taglib.ContentRatingNone)
+
+			nilErr(t, f.SetContentRating(taglib.ContentRatingExplicit))
+			tags, err := taglib.ReadTags(path)
+			nilErr(t, err)
+			eq(t, strings.Join(tags["ITUNESADVISORY"], "|"), "1")
+			rating, err = f.ContentRating()
+			nilErr(t, err)
+			eq(t, rating, taglib.ContentRatingExplicit)
+
+			nilErr(t, f.SetContentRating(taglib.ContentRatingClean))
+			rating, err = f.ContentRating()
+			nilErr(t, err)
+			eq(t, rating, taglib.ContentRatingClean)
+
+			nilErr(t, f.SetContentRating(taglib.ContentRatingNone))
+			tags, err = taglib.ReadTags(path)
+			nilErr(t, err)
+			eq(t, len(tags["ITUNESADVISORY"]), 0)
+
+			eq(t, f.SetContentRating("mature") != nil, true)
+		})
+	}
+
+	t.Run("eg.m4a", func(t *testing.T) {
+		t.Parallel()
+
+		// Without an rtng atom, a freeform ITUNESADVISORY is read
+		path := tmpf(t, egM4a, "eg.m4a")
+		nilErr(t, taglib.WriteTags(path, map[string][]string{"ITUNESADVISORY": {"explicit"}}, 0))
+		f, err := taglib.Open(path)
+		nilErr(t, err)
+		t.Cleanup(func() { f.Close() })
+
+		rating, err := f.ContentRating()
+		nilErr(t, err)
+		eq(t, rating, taglib.ContentRatingExplicit)
+
+		err = f.SetContentRating(taglib.ContentRatingClean)
+		nilErr(t, err)
+		rating, err = f.ContentRating()
+		nilErr(t, err)
+		eq(t, rating, taglib.ContentRatingClean)
+		atoms, err := taglib.ReadMP4Atoms(path)
+		nilErr(t, err)
+		eq(t, strings.Join(atoms["rtng"], "|"), "2")
+
+		// Later writes through the file keep the rating
+		nilErr(t, f.WriteTags(map[string][]string{taglib.Title: {"Rated"}}, 0))
+		nilErr(t, f.Close())
+		f, err = taglib.Open(path)
+		nilErr(t, err)
+		rating, err = f.ContentRating()
+		nilErr(t, err)
+		eq(t, rating, taglib.ContentRatingClean)
+		eq(t, f.Tags()[taglib.Title][0], "Rated")
+
+		nilErr(t, f.SetContentRating(taglib.ContentRatingNone))
+		atoms, err = taglib.ReadMP4Atoms(path)
+		nilErr(t, err)
+		eq(t, len(atoms["rtng"]), 0)
+
+		ro, err := taglib.OpenReadOnly(path)
+		nilErr(t, err)
+		t.Cleanup(func() { ro.Close() })
+		eq(t, errors.Is(ro.SetContentRating(taglib.ContentRatingClean), taglib.ErrSavingFile), true)
+	})
+}
+
+func TestStoreMetadata(t *testing.T) {
+	t.Parallel()
+
+	// eg.m4a has a free box after its ilst box, so items can be added in its place without moving anything else
+	item := func(name string, typ byte, value []byte) []byte {
+		data := append([]byte{0, 0, 0, byte(16 + len(value)), 'd', 'a', 't', 'a', 0, 0, 0, typ, 0, 0, 0, 0}, value...)
+		return append([]byte{0, 0, 0, byte(8 + len(data)), name[0], name[1], name[2], name[3]}, data...)
+	}
+	items := slices.Concat(
+		item("purd", 1, []byte("2012-05-03 12:34:56")),
+		item("apID", 1, []byte("buyer@example.com")),
+		item("cnID", 21, []byte{0x1D, 0xCD, 0x65, 0x00}),
+		item("plID", 21, []byte{0, 0, 0, 1, 0, 0, 0, 2}),
+		item("sfID", 21, []byte{0, 0x02, 0x30, 0x51}),
+	)
+	ilst := bytes.Index(egM4a, []byte("ilst")) - 4
+	ilstSize := int(egM4a[ilst+2])<<8 | int(egM4a[ilst+3])
+	free := ilst + ilstSize
+	freeSize := int(egM4a[free+2])<<8 | int(egM4a[free+3])
+	data := slices.Concat(egM4a[:free], items, []byte{0, 0, byte((freeSize - len(items)) >> 8), byte(freeSize - len(items))}, egM4a[free+4:free+freeSize-len(items)], egM4a[free+freeSize:])
+	data[ilst+2], data[ilst+3] = byte((ilstSize+len(items))>>8), byte(ilstSize+len(items))
+
+	path := tmpf(t, data, "eg.m4a")
+	f, err := taglib.OpenReadOnly(path)
+	nilErr(t, err)
+	defer func() { _ = f.Close() }()
+	eq(t, f.Tags()[taglib.Artist][0], "example artist")
+
+	meta, err := f.StoreMetadata()
+	nilErr(t, err)
+	eq(t, meta.PurchaseDate, time.Date(2012, 5, 3, 12, 34, 56, 0, time.UTC))
+	eq(t, meta.AccountID, "buyer@example.com")
+	eq(t, meta.CatalogID, int64(500000000))
+	eq(t, meta.PlaylistID, int64(1<<32|2))
+	eq(t, meta.StorefrontID, int64(143441)) // The US store
+	eq(t, meta.ArtistID, int64(0))
+
+	for _, tt := range []struct {
+		name string
+		data []byte
+	}{
+		{"eg.m4a", egM4a},
+		{"eg.mp3", egMP3},
+	} {
+		f, err := taglib.OpenReadOnly(tmpf(t, tt.data, tt.name))
+		nilErr(t, err)
+		meta, err := f.StoreMetadata()
+		nilErr(t, err)
+		eq(t, meta, taglib.StoreMetadata{})
+		nilErr(t, f.Close())
+	}
+}
+
+func TestRawTagsUniform(t *testing.T) {
+	t.Parallel()
+
+	// An ID3v2.4 tag with a multi-valued TPE1, a multi-valued TXXX, and an APIC frame
+	frame := func(id string, body ...byte) []byte {
+		size := len(body)
+		return append([]byte{id[0], id[1], id[2], id[3], 0, 0, byte(size >> 7), byte(size & 0x7F), 0, 0}, body...)
+	}
+	var frames []byte
+	frames = append(frames, frame("TPE1", append([]byte{3}, "Artist A\x00Artist B"...)...)...)
+	frames = append(frames, frame("TXXX", append([]byte{3}, "Mood\x00Calm\x00Dark\x00"...)...)...)
+	frames = append(frames, frame("APIC", append([]byte{0}, "image/png\x00\x03\x00png"...)...)...)
+	size := len(frames)
+	tag := append([]byte{'I', 'D', '3', 4, 0, 0, 0, 0, byte(size >> 7), byte(size & 0x7F)}, frames...)
+	audio := egMP3[10+(int(egMP3[6])<<21|int(egMP3[7])<<14|int(egMP3[8])<<7|int(egMP3[9])):]
+
+	path := tmpf(t, append(tag, audio...), "eg.mp3")
+	f, err := taglib.OpenReadOnly(path)
+	nilErr(t, err)
+	t.Cleanup(func() { f.Close() })
+
+	raw := f.RawTags()
+	eq(t, strings.Join(raw["TPE1"], "|"), "Artist A Artist B")
+	eq(t, len(raw["APIC"]), 1)
+
+	tagEq(t, f.RawTagsUniform(), map[string][]string{
+		"TPE1":      {"Artist A", "Artist B"},
+		"TXXX:Mood": {"Calm", "Dark"},
+	})
+
+	// Cover art is binary, so it's left out
+	path = tmpf(t, egM4a, "eg.m4a")
+	nilErr(t, taglib.WriteImage(path, coverJPG))
+	f, err = taglib.OpenReadOnly(path)
+	nilErr(t, err)
+	t.Cleanup(func() { f.Close() })
+
+	raw = f.RawTags()
+	eq(t, len(raw["covr"]), 1)
+	uniform := f.RawTagsUniform()
+	eq(t, len(uniform["covr"]), 0)
+	eq(t, strings.Join(uniform["©ART"], "|"), strings.Join(raw["©ART"], "|"))
+}
+
+func TestRawEntries(t *testing.T) {
+	t.Parallel()
+
+	// An ID3v2.3 tag with frames out of ID order, and two with the same ID
+	frame := func(id string, body ...string) []byte {
+		data := []byte{0} // ISO-8859-1
+		data = append(data, strings.Join(body, "\x00")...)
+		size := len(data)
+		header := append([]byte(id), byte(size>>24), byte(size>>16), byte(size>>8), byte(size), 0, 0)
+		return append(header, data...)
+	}
+	var frames []byte
+	frames = append(frames, frame("TXXX", "b", "second")...)
+	frames = append(frames, frame("TIT2", "Title")...)
+	frames = append(frames, frame("TXXX", "a", "first")...)
+	frames = append(frames, frame("TPE1", "Artist")...)
+	size := len(frames)
+	tag := []byte{'I', 'D', '3', 3, 0, 0, byte(size >> 21 & 0x7F), byte(size >> 14 & 0x7F), byte(size >> 7 & 0x7F), byte(size & 0x7F)}
+	tag = append(tag, frames...)
+
+	// Replace the tag of the fixture, keeping its audio
+	audio := egMP3
+	if string(audio[:3]) == "ID3" {
+		audio = audio[10+(int(audio[6])<<21|int(audio[7])<<14|int(audio[8])<<7|int(audio[9])):]
+	}
+	path := tmpf(t, append(tag, audio...), "eg.mp3")
+
+	f, err := taglib.OpenReadOnly(path)
+	nilErr(t, err)
+	t.Cleanup(func() { f.Close() })
+
+	var keys []string
+	for _, e := range f.RawEntries() {
+		keys = append(keys, e.Key+"="+e.Value)
+	}
+	eq(t, strings.Join(keys, ","), "TXXX:b=second,TIT2=Title,TXXX:a=first,TPE1=Artist")
+
+	// Other formats keep each value as its own entry
+	path = tmpf(t, egFLAC, "eg.flac")
+	nilErr(t, taglib.WriteTags(path, map[string][]string{
+		taglib.Artist: {"One", "Two"},
+	}, taglib.Clear))
+	f, err = taglib.OpenReadOnly(path)
+	nilErr(t, err)
+	t.Cleanup(func() { f.Close() })
+	eq(t, slices.Equal(f.RawEntries(), []taglib.RawEntry{{Key: "ARTIST", Value: "One"}, {Key: "ARTIST", Value: "Two"}}), true)
+}
+
+func TestSupportedKeys(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		data     []byte
+		filename string
+	}{
+		{egMP3, "eg.mp3"},
+		{egM4a, "eg.m4a"},
+		{egFLAC, "eg.flac"},
+		{egOgg, "eg.ogg"},
+		{egWAV, "eg.wav"},
+		{egWMA, "eg.wma"},
+		{egAPE, "eg.ape"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.filename, func(t *testing.T) {
+			t.Parallel()
+
+			path := tmpf(t, tt.data, tt.filename)
+			format, err := taglib.DetectFormat(path)
+			nilErr(t, err)
+
+			// Every supported key reads back what was written
+			keys := taglib.SupportedKeys(format)
+			tags := map[string][]string{}
+			for _, key := range keys {
+				tags[key] = []string{"1"}
+			}
+			tags[taglib.Genre] = []string{"Rock"} // ID3v2 reads numbers as ID3v1 genres
+			nilErr(t, taglib.WriteTags(path, tags, taglib.Clear))
+
+			got, err := taglib.ReadTags(path)
+			nilErr(t, err)
+			for _, key := range keys {
+				if !slices.Equal(got[key], tags[key]) {
+					t.Errorf("key %s: got %q, want %q", key, got[key], tags[key])
+				}
+			}
+		})
+	}
+
+	wma := taglib.SupportedKeys(taglib.FormatASF)
+	eq(t, slices.Contains(wma, taglib.Album), true)
+	eq(t, slices.Contains(wma, taglib.Grouping), false)
+	eq(t, len(wma) < len(taglib.SupportedKeys(taglib.FormatFLAC)), true)
+	eq(t, slices.Contains(taglib.SupportedKeys(taglib.FormatMPEG), taglib.Podcast), false)
+	eq(t, len(taglib.SupportedKeys(taglib.FormatUnknown)), 0)
+}
+
+func TestTagSet(t *testing.T) {
+	t.Parallel()
+
+	ts := taglib.TagSet{
+		Tags: map[string][]string{
+			taglib.Title:       {"Title"},
+			taglib.Artist:      {"Artist"},
+			taglib.TrackNumber: {"3/12"},
+			taglib.DiscNumber:  {"1"},
+			"DISCTOTAL":        {"2"},
+			taglib.BPM:         {"128.4"},
+		},
+		Images: []taglib.Picture{{Data: coverJPG, Type: taglib.PictureFrontCover, Description: "Front"}},
+	}
+
+	tests := []struct {
+		data     []byte
+		filename string
+		want     map[string][]string
+	}{
+		{egFLAC, "eg.flac", map[string][]string{
+			taglib.Title:       {"Title"},
+			taglib.Artist:      {"Artist"},
+			taglib.TrackNumber: {"3"},
+			"TRACKTOTAL":       {"12"},
+			taglib.DiscNumber:  {"1"},
+			"DISCTOTAL":        {"2"},
+			taglib.BPM:         {"128.4"},
+		}},
+		{egMP3, "eg.mp3", map[string][]string{
+			taglib.Title:       {"Title"},
+			taglib.Artist:      {"Artist"},
+			taglib.TrackNumber: {"3/12"},
+			taglib.DiscNumber:  {"1/2"},
+			taglib.BPM:         {"128"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.filename, func(t *testing.T) {
+			t.Parallel()
+
+			path := tmpf(t, tt.data, tt.filename)
+			nilErr(t, ts.WriteTo(path))
+
+			tags, err := taglib.ReadTags(path)
+			nilErr(t, err)
+			tagEq(t, tags, tt.want)
+
+			img, err := taglib.ReadImage(path)
+			nilErr(t, err)
+			eq(t, bytes.Equal(img, coverJPG), true)
+
+			props, err := taglib.ReadProperties(path)
+			nilErr(t, err)
+			eq(t, props.ImageCount, 1)
+		})
+	}
+
+	t.Run("unsupported", func(t *testing.T) {
+		t.Parallel()
+
+		path := tmpf(t, egWMA, "eg.wma")
+		err := taglib.TagSet{Tags: map[string][]string{
+			taglib.Title:    {"Title"},
+			taglib.Grouping: {"Grouping"},
+		}}.WriteTo(path)
+
+		var uerr *taglib.UnsupportedKeysError
+		eq(t, errors.As(err, &uerr), true)
+		eq(t, uerr.Format, taglib.FormatASF)
+		eq(t, strings.Join(uerr.Keys, ","), taglib.Grouping)
+
+		tags, err := taglib.ReadTags(path)
+		nilErr(t, err)
+		tagEq(t, tags, map[string][]string{taglib.Title: {"Title"}})
+	})
+}
+
+func TestExplain(t *testing.T) {
+	t.Parallel()
+
+	rawKeys := func(r taglib.ExplainResult) string {
+		var keys []string
+		for _, e := range r.Raw {
+			keys = append(keys, e.Key+"="+e.Value)
+		}
+		return strings.Join(keys, ",")
+	}
+
+	path := tmpf(t, egMP3, "eg.mp3")
+	nilErr(t, taglib.WriteTags(path, map[string][]string{
+		taglib.AlbumArtist:        {"Album Artist"},
+		taglib.MusicBrainzAlbumID: {"abc"},
+		taglib.Comment:            {"Comment"},
+	}, taglib.Clear))
+	f, err := taglib.OpenReadOnly(path)
+	nilErr(t, err)
+	t.Cleanup(func() { f.Close() })
+
+	r, err := f.Explain(taglib.AlbumArtist)
+	nilErr(t, err)
+	eq(t, r.Key, taglib.AlbumArtist)
+	eq(t, slices.Equal(r.Values, []string{"Album Artist"}), true)
+	eq(t, rawKeys(r), "TPE2=Album Artist")
+
+	r, err = f.Explain("musicbrainz_albumid")
+	nilErr(t, err)
+	eq(t, r.Key, taglib.MusicBrainzAlbumID)
+	eq(t, rawKeys(r), "TXXX:MUSICBRAINZ ALBUM ID=abc")
+
+	r, err = f.Explain(taglib.Comment)
+	nilErr(t, err)
+	eq(t, len(r.Raw), 1)
+	eq(t, strings.HasPrefix(r.Raw[0].Key, "COMM"), true)
+
+	r, err = f.Explain(taglib.Title)
+	nilErr(t, err)
+	eq(t, len(r.Values), 0)
+	eq(t, len(r.Raw), 0)
+
+	path = tmpf(t, egM4a, "eg.m4a")
+	nilErr(t, taglib.WriteTags(path, map[string][]string{
+		taglib.TrackNumber: {"3/12"},
+		taglib.Grouping:    {"Grouping"},
+	}, taglib.Clear))
+	f, err = taglib.OpenReadOnly(path)
+	nilErr(t, err)
+	t.Cleanup(func() { f.Close() })
+
+	r, err = f.Explain(taglib.TrackNumber)
+	nilErr(t, err)
+	eq(t, rawKeys(r), "trkn:num=3,trkn:total=12")
+	r, err = f.Explain(taglib.Grouping)
+	nilErr(t, err)
+	eq(t, rawKeys(r), "©grp=Grouping")
+
+	// Formats without a separate raw representation map keys to themselves
+	path = tmpf(t, egFLAC, "eg.flac")
+	nilErr(t, taglib.WriteTags(path, map[string][]string{
+		taglib.Artist: {"One", "Two"},
+	}, taglib.Clear))
+	f, err = taglib.OpenReadOnly(path)
+	nilErr(t, err)
+	t.Cleanup(func() { f.Close() })
+
+	r, err = f.Explain(taglib.Artist)
+	nilErr(t, err)
+	eq(t, rawKeys(r), "ARTIST=One,ARTIST=Two")
+}
+
+func TestReadImageInfo(t *testing.T) {
+	t.Parallel()
+
+	path := tmpf(t, egFLAC, "eg.flac")
+	for index, mime := range []string{"image/png", "image/jpeg"} {
+		data, err := taglib.ReadImageOptions(path, index)
+		nilErr(t, err)
+		cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+		nilErr(t, err)
+
+		info, err := taglib.ReadImageInfo(path, index)
+		nilErr(t, err)
+		eq(t, info, taglib.ImageInfo{Width: cfg.Width, Height: cfg.Height, MIMEType: mime, Size: len(data)})
+	}
+
+	_, err := taglib.ReadImageInfo(path, 2)
+	eq(t, errors.Is(err, taglib.ErrNoImage), true)
+
+	// A lossless WebP header for a 300x200 image
+	webp := []byte("RIFF\x16\x00\x00\x00WEBPVP8L\x0a\x00\x00\x00\x2f")
+	bits := uint32(300-1) | uint32(200-1)<<14
+	webp = append(webp, byte(bits), byte(bits>>8), byte(bits>>16), byte(bits>>24), 0, 0, 0, 0, 0)
+	nilErr(t, taglib.WriteImageOptions(path, webp, 0, string(taglib.PictureFrontCover), "", ""))
+	info, err := taglib.ReadImageInfo(path, 0)
+	nilErr(t, err)
+	eq(t, info, taglib.ImageInfo{Width: 300, Height: 200, MIMEType: "image/webp", Size: len(webp)})
+
+	nilErr(t, taglib.WriteImageOptions(path, []byte("BM\x00\x00\x00\x00"), 0, string(taglib.PictureFrontCover), "", ""))
+	_, err = taglib.ReadImageInfo(path, 0)
+	eq(t, errors.Is(err, taglib.ErrInvalidImage), true)
+}
+
+func TestDiscInfo(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name   string
+		data   []byte
+		number []string
+		total  []string
+	}{
+		{"eg.mp3", egMP3, []string{"2/3"}, nil},
+		{"eg.m4a", egM4a, []string{"2/3"}, nil},
+		{"eg.flac", egFLAC, []string{"2"}, []string{"3"}},
+		{"eg.ogg", egOgg, []string{"2"}, []string{"3"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			path := tmpf(t, tc.data, tc.name)
+			f, err := taglib.Open(path)
+			nilErr(t, err)
+			t.Cleanup(func() { f.Close() })
+
+			want := taglib.DiscInfo{DiscNumber: 2, DiscTotal: 3, DiscSubtitle: "The Second Disc"}
+			nilErr(t, f.SetDiscInfo(want))
+
+			info, err := f.DiscInfo()
+			nilErr(t, err)
+			eq(t, info, want)
+
+			tags := f.Tags()
+			eq(t, slices.Equal(tags[taglib.DiscNumber], tc.number), true)
+			eq(t, slices.Equal(tags["DISCTOTAL"], tc.total), true)
+
+			nilErr(t, f.SetDiscInfo(taglib.DiscInfo{}))
+			info, err = f.DiscInfo()
+			nilErr(t, err)
+			eq(t, info, taglib.DiscInfo{})
+		})
+	}
+}
+
+func TestDiscInfoTotalDiscs(t *testing.T) {
+	t.Parallel()
+
+	path := tmpf(t, egFLAC, "eg.flac")
+	nilErr(t, taglib.WriteTags(path, map[string][]string{
+		taglib.DiscNumber: {"1"},
+		"TOTALDISCS":      {"2"},
+	}, 0))
+
+	f, err := taglib.OpenReadOnly(path)
+	nilErr(t, err)
+	t.Cleanup(func() { f.Close() })
+
+	info, err := f.DiscInfo()
+	nilErr(t, err)
+	eq(t, info, taglib.DiscInfo{DiscNumber: 1, DiscTotal: 2})
+}
+
+func TestTagLocations(t *testing.T) {
+	t.Parallel()
+
+	// eg.mp3 has an ID3v2 tag at the start and an ID3v1 tag at the end
+	path := tmpf(t, egMP3, "eg.mp3")
+	locs, err := taglib.TagLocations(path)
+	nilErr(t, err)
+	size := int64(len(egMP3))
+	eq(t, len(locs), 2)
+	eq(t, locs[0].Kind, taglib.TagID3v2)
+	eq(t, locs[0].Start, int64(0))
+	eq(t, locs[0].End, int64(10+(int(egMP3[6])<<21|int(egMP3[7])<<14|int(egMP3[8])<<7|int(egMP3[9]))))
+	eq(t, locs[1], taglib.TagLocation{Kind: taglib.TagID3v1, Start: size - 128, End: size})
+
+	// An ID3v2 tag with a footer appended after the audio, before an APE tag and the ID3v1 tag
+	appended := []byte("ID3\x04\x00\x10\x00\x00\x00\x0aTIT2\x00\x00\x00\x00\x00\x00")
+	appended = append(appended, "3DI\x04\x00\x10\x00\x00\x00\x0a"...)
+	ape := make([]byte, 32)
+	copy(ape, "APETAGEX")
+	ape[8], ape[12] = 0xD0, 32 // Version 2000, and a size of just the footer
+	data := slices.Concat(egMP3[:size-128], appended, ape, egMP3[size-128:])
+	path = tmpf(t, data, "eg.mp3")
+
+	locs, err = taglib.TagLocations(path)
+	nilErr(t, err)
+	size = int64(len(data))
+	eq(t, len(locs), 4)
+	eq(t, locs[0].Kind, taglib.TagID3v2)
+	eq(t, locs[1], taglib.TagLocation{Kind: taglib.TagID3v2, Start: size - 128 - 32 - 30, End: size - 128 - 32})
+	eq(t, locs[2], taglib.TagLocation{Kind: taglib.TagAPE, Start: size - 128 - 32, End: size - 128})
+	eq(t, locs[3], taglib.TagLocation{Kind: taglib.TagID3v1, Start: size - 128, End: size})
+
+	// TagLib writes APE tags with a header
+	path = tmpf(t, egAPE, "eg.ape")
+	nilErr(t, taglib.WriteTags(path, map[string][]string{taglib.Title: {"Title"}}, 0))
+	locs, err = taglib.TagLocations(path)
+	nilErr(t, err)
+	eq(t, len(locs), 1)
+	eq(t, locs[0].Kind, taglib.TagAPE)
+	eq(t, locs[0].Start, int64(len(egAPE)))
+	eq(t, locs[0].End, fileSize(t, path))
+}
+
+func TestTagSizes(t *testing.T) {
+	t.Parallel()
+
+	id3Size := int64(10 + (int(egMP3[6])<<21 | int(egMP3[7])<<14 | int(egMP3[8])<<7 | int(egMP3[9])))
+
+	// The appended ID3v2 tag and the one at the start add up
+	appended := []byte("ID3\x04\x00\x10\x00\x00\x00\x0aTIT2\x00\x00\x00\x00\x00\x00")
+	appended = append(appended, "3DI\x04\x00\x10\x00\x00\x00\x0a"...)
+	ape := make([]byte, 32)
+	copy(ape, "APETAGEX")
+	ape[8], ape[12] = 0xD0, 32 // Version 2000, and a size of just the footer
+	size := len(egMP3)
+	path := tmpf(t, slices.Concat(egMP3[:size-128], appended, ape, egMP3[size-128:]), "eg.mp3")
+	sizes, err := taglib.TagSizes(path)
+	nilErr(t, err)
+	eq(t, len(sizes), 3)
+	eq(t, sizes[taglib.TagSystemID3v2], id3Size+30)
+	eq(t, sizes[taglib.TagSystemAPE], int64(32))
+	eq(t, sizes[taglib.TagSystemID3v1], int64(128))
+
+	path = tmpf(t, egFLAC, "eg.flac")
+	sizes, err = taglib.TagSizes(path)
+	nilErr(t, err)
+	eq(t, len(sizes), 1)
+	if n := sizes[taglib.TagSystemXiph]; n <= 0 || n >= int64(len(egFLAC)) {
+		t.Fatalf("unexpected Xiph size %d", n)
+	}
+
+	// The images are in the metadata blocks
+	nilErr(t, taglib.WriteImage(path, nil))
+	smaller, err := taglib.TagSizes(path)
+	nilErr(t, err)
+	if smaller[taglib.TagSystemXiph] >= sizes[taglib.TagSystemXiph] {
+		t.Fatalf("expected Xiph size below %d after removing an image, got %d", sizes[taglib.TagSystemXiph], smaller[taglib.TagSystemXiph])
+	}
+
+	path = tmpf(t, egM4a, "eg.m4a")
+	sizes, err = taglib.TagSizes(path)
+	nilErr(t, err)
+	eq(t, len(sizes), 1)
+	if n := sizes[taglib.TagSystemMP4]; n <= 0 || n >= int64(len(egM4a)) {
+		t.Fatalf("unexpected MP4 size %d", n)
+	}
+
+	path = tmpf(t, egWAV, "eg.wav")
+	sizes, err = taglib.TagSizes(path)
+	nilErr(t, err)
+	eq(t, len(sizes), 2)
+	if sizes[taglib.TagSystemID3v2] <= 0 || sizes[taglib.TagSystemRIFFInfo] <= 0 {
+		t.Fatalf("expected ID3v2 and INFO sizes, got %v", sizes)
+	}
+
+	path = tmpf(t, egAPE, "eg.ape")
+	sizes, err = taglib.TagSizes(path)
+	nilErr(t, err)
+	eq(t, len(sizes), 0)
+}
+
+func TestYear(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		tags map[string][]string
+		year int
+	}{
+		{map[string][]string{taglib.Date: {"1993-04-02"}}, 1993},
+		{map[string][]string{taglib.Date: {"1993"}}, 1993},
+		{map[string][]string{taglib.Date: {" 1993/04 "}}, 1993},
+		{map[string][]string{taglib.Date: {"Spring"}, taglib.OriginalDate: {"1971-11-08"}}, 1971},
+		{map[string][]string{taglib.OriginalDate: {"1971"}}, 1971},
+		{map[string][]string{taglib.Date: {"19930"}}, 0},
+		{map[string][]string{}, 0},
+	} {
+		path := tmpf(t, egFLAC, "eg.flac")
+		nilErr(t, taglib.WriteTags(path, tc.tags, taglib.Clear))
+
+		f, err := taglib.OpenReadOnly(path)
+		nilErr(t, err)
+		year, err := f.Year()
+		nilErr(t, err)
+		eq(t, year, tc.year)
+		nilErr(t, f.Close())
+	}
+
+	// TagLib reads legacy TYER frames as DATE
+	path := tmpf(t, egMP3, "eg.mp3")
+	nilErr(t, taglib.WriteID3v2Frames(path, map[string][]string{"TYER": {"1985"}}, 0))
+	f, err := taglib.OpenReadOnly(path)
+	nilErr(t, err)
+	t.Cleanup(func() { f.Close() })
+	year, err := f.Year()
+	nilErr(t, err)
+	eq(t, year, 1985)
+}
+
+func TestGenres(t *testing.T) {
+	t.Parallel()
+
+	// ID3v2.3 tags with a TCON frame using numeric references
+	tcon := func(value string) []byte {
+		data := append([]byte{0}, value...) // ISO-8859-1
+		size := len(data)
+		frame := append([]byte("TCON"), byte(size>>24), byte(size>>16), byte(size>>8), byte(size), 0, 0)
+		frame = append(frame, data...)
+		size = len(frame)
+		tag := []byte{'I', 'D', '3', 3, 0, 0, byte(size >> 21 & 0x7F), byte(size >> 14 & 0x7F), byte(size >> 7 & 0x7F), byte(size & 0x7F)}
+		audio := egMP3[10+(int(egMP3[6])<<21|int(egMP3[7])<<14|int(egMP3[8])<<7|int(egMP3[9])):]
+		return append(append(tag, frame...), audio...)
+	}
+
+	tests := []struct {
+		tcon   string
+		genres []string
+	}{
+		{"(17)", []string{"Rock"}},
+		{"(17)Rock", []string{"Rock"}},
+		{"(17)(4)", []string{"Rock", "Disco"}},
+		{"(RX)(17)", []string{"Remix", "Rock"}},
+		{"(CR)", []string{"Cover"}},
+		{"Rock", []string{"Rock"}},
+	}
+	for _, tt := range tests {
+		path := tmpf(t, tcon(tt.tcon), "eg.mp3")
+		f, err := taglib.OpenReadOnly(path)
+		nilErr(t, err)
+		genres, err := f.Genres()
+		nilErr(t, err)
+		nilErr(t, f.Close())
+		eq(t, strings.Join(genres, "|"), strings.Join(tt.genres, "|"))
+	}
+
+	// Other formats keep numeric genres as they are, so they are resolved too
+	path := tmpf(t, egFLAC, "eg.flac")
+	nilErr(t, taglib.WriteTags(path, map[string][]string{
+		taglib.Genre: {"17", "(4)Disco", "rock", "(200)", "Jazz", "((17) in brackets"},
+	}, 0))
+	f, err := taglib.Open(path)
+	nilErr(t, err)
+	t.Cleanup(func() { f.Close() })
+
+	genres, err := f.Genres()
+	nilErr(t, err)
+	eq(t, strings.Join(genres, "|"), "Rock|Disco|Jazz|(17) in brackets")
+
+	nilErr(t, f.SetGenres([]string{"(17)", "Jazz", "8"}))
+	tags, err := taglib.ReadTags(path)
+	nilErr(t, err)
+	eq(t, strings.Join(tags[taglib.Genre], "|"), "Rock|Jazz")
+
+	nilErr(t, f.SetGenres(nil))
+	tags, err = taglib.ReadTags(path)
+	nilErr(t, err)
+	eq(t, len(tags[taglib.Genre]), 0)
+}
+
+func TestMusicalKey(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		in                    string
+		key, camelot, openKey string
+	}{
+		{"Am", "Am", "8A", "1m"},
+		{"a minor", "Am", "8A", "1m"},
+		{"8A", "Am", "8A", "1m"},
+		{"1m", "Am", "8A", "1m"},
+		{"C", "C", "8B", "1d"},
+		{"8b", "C", "8B", "1d"},
+		{"F#", "F#", "2B", "7d"},
+		{"Gbmaj", "F#", "2B", "7d"},
+		{"B♭m", "Bbm", "3A", "8m"},
+		{"A#min", "Bbm", "3A", "8m"},
+		{"12A", "C#m", "12A", "5m"},
+		{"12d", "F", "7B", "12d"},
+		{" Ebm ", "Ebm", "2A", "7m"},
+	}
+	for _, tt := range tests {
+		key, err := taglib.ParseMusicalKey(tt.in)
+		nilErr(t, err)
+		eq(t, key.String(), tt.key)
+		eq(t, key.Camelot(), tt.camelot)
+		eq(t, key.OpenKey(), tt.openKey)
+
+		// Every notation parses back to the same key
+		for _, s := range []string{key.String(), key.Camelot(), key.OpenKey()} {
+			k, err := taglib.ParseMusicalKey(s)
+			nilErr(t, err)
+			eq(t, k, key)
+		}
+	}
+	for _, in := range []string{"", "H", "13A", "0A", "Amajor minor", "o"} {
+		_, err := taglib.ParseMusicalKey(in)
+		eq(t, err != nil, true)
+	}
+
+	for _, tc := range []struct {
+		name string
+		data []byte
+	}{
+		{"eg.mp3", egMP3},
+		{"eg.flac", egFLAC},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			path := tmpf(t, tc.data, tc.name)
+			nilErr(t, taglib.WriteTags(path, map[string][]string{taglib.InitialKey: nil}, 0))
+			f, err := taglib.Open(path)
+			nilErr(t, err)
+			t.Cleanup(func() { f.Close() })
+
+			_, err = f.MusicalKey()
+			eq(t, errors.Is(err, taglib.ErrNoMusicalKey), true)
+
+			nilErr(t, f.SetMusicalKey(taglib.MusicalKey{Tonic: 9, Minor: true}))
+			tags, err := taglib.ReadTags(path)
+			nilErr(t, err)
+			eq(t, strings.Join(tags[taglib.InitialKey], "|"), "Am")
+
+			nilErr(t, taglib.WriteTags(path, map[string][]string{taglib.InitialKey: {"1B"}}, 0))
+			nilErr(t, f.Reopen(path))
+			key, err := f.MusicalKey()
+			nilErr(t, err)
+			eq(t, key, taglib.MusicalKey{Tonic: 11})
+		})
+	}
+}
+
+func TestTaggedLength(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name  string
+		data  []byte
+		value []string
+		want  time.Duration
+		ok    bool
+	}{
+		{"eg.mp3", egMP3, nil, 0, false},
+		{"eg.mp3", egMP3, []string{"215040"}, 215040 * time.Millisecond, true},
+		{"eg.flac", egFLAC, []string{"1000"}, time.Second, true},
+		{"eg.flac", egFLAC, []string{"3:35"}, 0, false},
+	} {
+		path := tmpf(t, tc.data, tc.name)
+		nilErr(t, taglib.WriteTags(path, map[string][]string{taglib.Length: tc.value}, 0))
+		f, err := taglib.OpenReadOnly(path)
+		nilErr(t, err)
+
+		length, ok, err := f.TaggedLength()
+		nilErr(t, err)
+		eq(t, length, tc.want)
+		eq(t, ok, tc.ok)
+
+		// The audio is a second long whatever the tag says
+		eq(t, f.Properties().Length.Round(time.Second), time.Second)
+		nilErr(t, f.Close())
+	}
+}
+
+func TestBPM(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name   string
+		data   []byte
+		stored string
+		bpm    float64
+	}{
+		{"eg.mp3", egMP3, "129", 129},
+		{"eg.m4a", egM4a, "129", 129},
+		{"eg.flac", egFLAC, "128.5", 128.5},
+		{"eg.ogg", egOgg, "128.5", 128.5},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			path := tmpf(t, tc.data, tc.name)
+			f, err := taglib.Open(path)
+			nilErr(t, err)
+			t.Cleanup(func() { f.Close() })
+
+			nilErr(t, f.SetBPM(128.5))
+			eq(t, slices.Equal(f.Tags()[taglib.BPM], []string{tc.stored}), true)
+			bpm, err := f.BPM()
+			nilErr(t, err)
+			eq(t, bpm, tc.bpm)
+
+			nilErr(t, f.SetBPM(0))
+			bpm, err = f.BPM()
+			nilErr(t, err)
+			eq(t, bpm, 0.0)
+
+			nilErr(t, f.SetBPM(128.5))
+			nilErr(t, f.SetBPM(math.Inf(1)))
+			eq(t, len(f.Tags()[taglib.BPM]), 0)
+		})
+	}
+}
+
+func TestBPMFractionalID3v2(t *testing.T) {
+	t.Parallel()
+
+	// Fractional BPMs written by other tools are still read as they are
+	path := tmpf(t, egMP3, "eg.mp3")
+	nilErr(t, taglib.WriteTags(path, map[string][]string{taglib.BPM: {" 128.5 "}}, 0))
+
+	f, err := taglib.OpenReadOnly(path)
+	nilErr(t, err)
+	t.Cleanup(func() { f.Close() })
+	bpm, err := f.BPM()
+	nilErr(t, err)
+	eq(t, bpm, 128.5)
+}
+
+func TestBatchWriter(t *testing.T) {
+	t.Parallel()
+
+	setup := func(t *testing.T) (dir, mp3, flac string) {
+		dir = t.TempDir()
+		mp3, flac = filepath.Join(dir, "01.mp3"), filepath.Join(dir, "02.flac")
+		nilErr(t, os.WriteFile(mp3, egMP3, 0o644))
+		nilErr(t, os.WriteFile(flac, egFLAC, 0o644))
+		return dir, mp3, flac
+	}
+	title := func(t *testing.T, path string) string {
+		tags, err := taglib.ReadTags(path)
+		nilErr(t, err)
+		return strings.Join(tags[taglib.Title], ",")
+	}
+	files := func(t *testing.T, dir string) int {
+		entries, err := os.ReadDir(dir)
+		nilErr(t, err)
+		return len(entries)
+	}
+
+	t.Run("commit", func(t *testing.T) {
+		t.Parallel()
+		dir, mp3, flac := setup(t)
+
+		b := taglib.NewBatchWriter()
+		nilErr(t, b.Stage(mp3, map[string][]string{taglib.Title: {"One"}}, 0))
+		nilErr(t, b.Stage(flac, map[string][]string{taglib.Title: {"Two"}}, 0))
+		nilErr(t, b.Stage(flac, map[string][]string{taglib.Album: {"Album"}}, 0))
+		nilErr(t, b.Commit())
+
+		eq(t, title(t, mp3), "One")
+		eq(t, title(t, flac), "Two")
+		tags, err := taglib.ReadTags(flac)
+		nilErr(t, err)
+		eq(t, tags[taglib.Album][0], "Album")
+		eq(t, files(t, dir), 2)
+	})
+
+	t.Run("failed write", func(t *testing.T) {
+		t.Parallel()
+		dir, mp3, flac := setup(t)
+		before := title(t, flac)
+
+		b := taglib.NewBatchWriter()
+		nilErr(t, b.Stage(flac, map[string][]string{taglib.Title: {"Two"}}, 0))
+		nilErr(t, b.Stage(mp3, map[string][]string{taglib.Title: {strings.Repeat("a", 31)}}, taglib.TruncateError))
+		var terr *taglib.TruncationError
+		eq(t, errors.As(b.Commit(), &terr), true)
+
+		eq(t, title(t, flac), before)
+		eq(t, files(t, dir), 2)
+	})
+
+	t.Run("missing file", func(t *testing.T) {
+		t.Parallel()
+		dir, mp3, _ := setup(t)
+		before := title(t, mp3)
+
+		b := taglib.NewBatchWriter()
+		nilErr(t, b.Stage(mp3, map[string][]string{taglib.Title: {"One"}}, 0))
+		nilErr(t, b.Stage(filepath.Join(dir, "03.mp3"), map[string][]string{taglib.Title: {"Three"}}, 0))
+		eq(t, errors.Is(b.Commit(), os.ErrNotExist), true)
+
+		eq(t, title(t, mp3), before)
+		eq(t, files(t, dir), 2)
+	})
+
+	t.Run("rollback", func(t *testing.T) {
+		t.Parallel()
+		dir, mp3, _ := setup(t)
+		before := title(t, mp3)
+
+		b := taglib.NewBatchWriter()
+		nilErr(t, b.Stage(mp3, map[string][]string{taglib.Title: {"One"}}, 0))
+		b.Rollback()
+		nilErr(t, b.Commit())
+
+		eq(t, title(t, mp3), before)
+		eq(t, files(t, dir), 2)
+	})
+
+	t.Run("preserve mod time", func(t *testing.T) {
+		t.Parallel()
+		_, mp3, _ := setup(t)
+		old := time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC)
+		nilErr(t, os.Chtimes(mp3, old, old))
+
+		b := taglib.NewBatchWriter()
+		nilErr(t, b.Stage(mp3, map[string][]string{taglib.Title: {"One"}}, taglib.PreserveModTime))
+		nilErr(t, b.Commit())
+
+		info, err := os.Stat(mp3)
+		nilErr(t, err)
+		eq(t, info.ModTime().Equal(old), true)
+		eq(t, info.Mode().Perm(), os.FileMode(0o644))
+	})
+}
+
+func TestEncodingInfo(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name string
+		data []byte
+		want taglib.EncodingInfo
+	}{
+		{"eg.flac", egFLAC, taglib.EncodingInfo{TaggingSoftware: "Lavf61.1.100"}},
+		{"eg.ogg", egOgg, taglib.EncodingInfo{TaggingSoftware: "Lavf61.7.100"}},
+		{"eg.opus", egOpus, taglib.EncodingInfo{EncoderSettings: "Lavc62.11.100 libopus", TaggingSoftware: "Lavf62.3.100"}},
+		{"eg.spx", egSpeex, taglib.EncodingInfo{TaggingSoftware: "Encoded with Speex speex-1.2.1"}},
+		{"eg.oga", egOggFLAC, taglib.EncodingInfo{TaggingSoftware: "reference libFLAC 1.4.3 20230623"}},
+		{"eg.wma", egWMA, taglib.EncodingInfo{EncoderSettings: "Lavf62.3.100", TaggingSoftware: "Lavf62.3.100"}},
+		{"eg.mp3", egMP3, taglib.EncodingInfo{}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			f, err := taglib.OpenReadOnly(tmpf(t, tc.data, tc.name))
+			nilErr(t, err)
+			t.Cleanup(func() { f.Close() })
+
+			info, err := f.EncodingInfo()
+			nilErr(t, err)
+			eq(t, info, tc.want)
+		})
+	}
+
+	path := tmpf(t, egMP3, "eg.mp3")
+	nilErr(t, taglib.WriteID3v2Frames(path, map[string][]string{
+		"TENC": {"Someone"},
+		"TSSE": {"LAME 3.100"},
+	}, 0))
+	f, err := taglib.OpenReadOnly(path)
+	nilErr(t, err)
+	t.Cleanup(func() { f.Close() })
+	info, err := f.EncodingInfo()
+	nilErr(t, err)
+	eq(t, info, taglib.EncodingInfo{EncodedBy: "Someone", EncoderSettings: "LAME 3.100", TaggingSoftware: "LAME 3.100"})
+}
+
+func TestReadVendorString(t *testing.T) {
+	t.Parallel()
+
+	vendor, err := taglib.ReadVendorString(tmpf(t, egOggFLAC, "eg.oga"))
+	nilErr(t, err)
+	eq(t, vendor, "reference libFLAC 1.4.3 20230623")
+
+	// Writing tags keeps the vendor string
+	path := tmpf(t, egFLAC, "eg.flac")
+	nilErr(t, taglib.WriteTags(path, map[string][]string{taglib.Title: {"Title"}}, 0))
+	vendor, err = taglib.ReadVendorString(path)
+	nilErr(t, err)
+	eq(t, vendor, "Lavf61.1.100")
+
+	vendor, err = taglib.ReadVendorString(tmpf(t, egMP3, "eg.mp3"))
+	nilErr(t, err)
+	eq(t, vendor, "")
+
+	_, err = taglib.ReadVendorString(filepath.Join(t.TempDir(), "missing.flac"))
+	eq(t, err != nil, true)
+}
+
+func TestReadChapters(t *testing.T) {
+	t.Parallel()
+
+	// eg_chapters.mp3 has two chapters, the first with a 1x1 red PNG
+	path := tmpf(t, egMP3Chapters, "eg.mp3")
+
+	chapters, err := taglib.ReadChapters(path)
+	nilErr(t, err)
+	eq(t, len(chapters), 2)
+	eq(t, chapters[0].ID, "ch0")
+	eq(t, chapters[0].Start, 0*time.Millisecond)
+	eq(t, chapters[0].End, 500*time.Millisecond)
+	eq(t, chapters[0].Title, "Intro")
+	eq(t, chapters[0].ImageMIME, "image/png")
+	eq(t, chapters[0].Image == nil, true)
+	eq(t, chapters[1].ID, "ch1")
+	eq(t, chapters[1].Start, 500*time.Millisecond)
+	eq(t, chapters[1].End, 1000*time.Millisecond)
+	eq(t, chapters[1].Title, "Outro")
+	eq(t, chapters[1].ImageMIME, "")
+
+	chapters, err = taglib.ReadChaptersWithImages(path)
+	nilErr(t, err)
+	eq(t, len(chapters), 2)
+	img, _, err := image.Decode(bytes.NewReader(chapters[0].Image))
+	nilErr(t, err)
+	eq(t, img.Bounds().Dx(), 1)
+	eq(t, chapters[1].Image == nil, true)
+
+	chapters, err = taglib.ReadChapters(tmpf(t, egMP3, "eg.mp3"))
+	nilErr(t, err)
+	eq(t, len(chapters), 0)
+}
+
+func TestFileReopen(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	flac := filepath.Join(dir, "eg.flac")
+	mp3 := filepath.Join(dir, "eg.mp3")
+	nilErr(t, os.WriteFile(flac, egFLAC, 0o644))
+	nilErr(t, os.WriteFile(mp3, egMP3, 0o644))
+	m4a := tmpf(t, egM4a, "eg.m4a") // Another directory
+
+	f, err := taglib.Open(flac)
+	nilErr(t, err)
+	defer func() { _ = f.Close() }()
+	eq(t, f.Format(), taglib.FormatFLAC)
+
+	// Same directory
+	err = f.Reopen(mp3)
+	nilErr(t, err)
+	eq(t, f.Format(), taglib.FormatMPEG)
+	eq(t, f.Tags()[taglib.Artist][0], "example artist")
+
+	// Writes still go to the current file
+	err = f.WriteTags(map[string][]string{taglib.Title: {"Reopened"}}, 0)
+	nilErr(t, err)
+	tags, err := taglib.ReadTags(mp3)
+	nilErr(t, err)
+	eq(t, tags[taglib.Title][0], "Reopened")
+
+	// Different directory
+	err = f.Reopen(m4a)
+	nilErr(t, err)
+	eq(t, f.Format(), taglib.FormatMP4)
+	eq(t, f.Properties().Length > 0, true)
+
+	// A failed reopen closes the file
+	err = f.Reopen(filepath.Join(dir, "missing.flac"))
+	if !errors.Is(err, taglib.ErrInvalidFile) {
+		t.Fatalf("expected ErrInvalidFile, got %v", err)
+	}
+	if err := f.Reopen(flac); err == nil {
+		t.Fatalf("expected error reopening closed file")
+	}
+	if _, err := f.Image(0); err == nil {
+		t.Fatalf("expected error reading closed file")
+	}
+	nilErr(t, f.Close())
+}
+
+func TestFileReopenStream(t *testing.T) {
+	t.Parallel()
+
+	f, err := taglib.OpenStream(bytes.NewReader(egFLAC))
+	nilErr(t, err)
+	defer func() { _ = f.Close() }()
+
+	path := tmpf(t, egMP3, "eg.mp3")
+	err = f.Reopen(path)
+	nilErr(t, err)
+	eq(t, f.Format(), taglib.FormatMPEG)
+	eq(t, f.Tags()[taglib.Album][0], "example album")
+}
+
+func TestSetReadStyle(t *testing.T) {
+	t.Parallel()
+
+	path := tmpf(t, egMP3, "eg.mp3")
+	err := taglib.WriteTags(path, map[string][]string{taglib.Title: {"Title"}}, 0)
+	nilErr(t, err)
+
+	accurate, err := taglib.ReadProperties(path)
+	nilErr(t, err)
+
+	f, err := taglib.OpenReadOnly(path, taglib.WithReadStyle(taglib.ReadStyleFast))
+	nilErr(t, err)
+	defer func() { _ = f.Close() }()
+
+	nilErr(t, f.SetReadStyle(taglib.ReadStyleAccurate))
+	eq(t, f.Properties().Length, accurate.Length)
+	eq(t, f.Properties().Bitrate, accurate.Bitrate)
+	eq(t, f.Format(), taglib.FormatMPEG)
+
+	// The file is still usable for everything else
+	eq(t, f.Tags()[taglib.Title][0], "Title")
+
+	s, err := taglib.OpenStream(bytes.NewReader(egFLAC), taglib.WithReadStyle(taglib.ReadStyleFast))
+	nilErr(t, err)
+	defer func() { _ = s.Close() }()
+	nilErr(t, s.SetReadStyle(taglib.ReadStyleAccurate))
+	eq(t, s.Properties().SampleRate, uint(48000))
+	eq(t, s.Format(), taglib.FormatFLAC)
+
+	nilErr(t, s.Close())
+	eq(t, s.SetReadStyle(taglib.ReadStyleFast) != nil, true)
+}
+
+func TestReadTagsMerged(t *testing.T) {
+	t.Parallel()
+
+	// An ID3v2 tag without a title, and an ID3v1 tag with one
+	frame := func(id string, text string) []byte {
+		size := 1 + len(text)
+		return append([]byte{id[0], id[1], id[2], id[3], 0, 0, byte(size >> 7), byte(size & 0x7F), 0, 0, 3}, text...)
+	}
+	frames := slices.Concat(frame("TPE1", "ID3v2 Artist"), frame("TALB", "ID3v2 Album"))
+	size := len(frames)
+	tag := append([]byte{'I', 'D', '3', 4, 0, 0, 0, 0, byte(size >> 7), byte(size & 0x7F)}, frames...)
+	field := func(s string, n int) []byte { return append([]byte(s), make([]byte, n-len(s))...) }
+	id3v1 := slices.Concat([]byte("TAG"), field("ID3v1 Title", 30), field("ID3v1 Artist", 30), field("", 30),
+		field("1999", 4), field("", 30), []byte{255})
+	audio := egMP3[10+(int(egMP3[6])<<21|int(egMP3[7])<<14|int(egMP3[8])<<7|int(egMP3[9])):]
+	path := tmpf(t, slices.Concat(tag, audio, id3v1), "eg.mp3")
+
+	tags, err := taglib.ReadTagsMerged(path, []taglib.TagSystem{taglib.TagSystemID3v2, taglib.TagSystemAPE, taglib.TagSystemID3v1})
+	nilErr(t, err)
+	eq(t, tags[taglib.Title][0], "ID3v1 Title")
+	eq(t, tags[taglib.Artist][0], "ID3v2 Artist")
+	eq(t, tags[taglib.Album][0], "ID3v2 Album")
+	eq(t, tags[taglib.Date][0], "1999")
+
+	tags, err = taglib.ReadTagsMerged(path, []taglib.TagSystem{taglib.TagSystemID3v1, taglib.TagSystemID3v2})
+	nilErr(t, err)
+	eq(t, tags[taglib.Artist][0], "ID3v1 Artist")
+	eq(t, tags[taglib.Album][0], "ID3v2 Album")
+
+	tags, err = taglib.ReadTagsMerged(path, []taglib.TagSystem{taglib.TagSystemXiph})
+	nilErr(t, err)
+	eq(t, len(tags), 0)
+
+	// The ID3v1 tag of a FLAC file is read even though the Vorbis comment has the same keys
+	path = tmpf(t, egFLAC, "eg.flac")
+	nilErr(t, taglib.WriteTags(path, map[string][]string{taglib.Title: {"Xiph Title"}}, taglib.Clear))
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	nilErr(t, err)
+	_, err = f.Write(id3v1)
+	nilErr(t, err)
+	nilErr(t, f.Close())
+
+	tags, err = taglib.ReadTagsMerged(path, []taglib.TagSystem{taglib.TagSystemXiph, taglib.TagSystemID3v1})
+	nilErr(t, err)
+	eq(t, tags[taglib.Title][0], "Xiph Title")
+	eq(t, tags[taglib.Artist][0], "ID3v1 Artist")
+
+	tags, err = taglib.ReadTagsMerged(path, []taglib.TagSystem{taglib.TagSystemID3v1, taglib.TagSystemXiph})
+	nilErr(t, err)
+	eq(t, tags[taglib.Title][0], "ID3v1 Title")
+
+	// The WAV file has different titles in its ID3v2 chunk and INFO chunk
+	path = tmpf(t, egWAV, "eg.wav")
+	tags, err = taglib.ReadTagsMerged(path, []taglib.TagSystem{taglib.TagSystemRIFFInfo, taglib.TagSystemID3v2})
+	nilErr(t, err)
+	eq(t, tags[taglib.Title][0], "WAV ID3 Test")
+
+	tags, err = taglib.ReadTagsMerged(path, []taglib.TagSystem{taglib.TagSystemID3v2, taglib.TagSystemRIFFInfo})
+	nilErr(t, err)
+	eq(t, tags[taglib.Title][0], "WAV ID3 Title")
+
+	_, err = taglib.ReadTagsMerged(tmpf(t, []byte("not a file"), "eg.flac"), []taglib.TagSystem{taglib.TagSystemXiph})
+	eq(t, err, taglib.ErrInvalidFile)
+}
+
+func TestSearchableText(t *testing.T) {
+	t.Parallel()
+
+	path := tmpf(t, egFLAC, "eg.flac")
+	err := taglib.WriteTags(path, map[string][]string{
+		taglib.Title:              {"  A   Day in\tthe Life "},
+		taglib.Artist:             {"The Beatles", "The Beatles"},
+		taglib.Genre:              {"Rock", "Pop"},
+		taglib.Comment:            {"f4a31f0a-51dd-4fa7-986d-3095c40c5ed9"},
+		taglib.Lyrics:             {"I read the news today\noh boy"},
+		taglib.MusicBrainzTrackID: {"f4a31f0a-51dd-4fa7-986d-3095c40c5ed9"},
+		taglib.TrackNumber:        {"13"},
+		"PERFORMER:GUITAR":        {"John Lennon"},
+	}, taglib.Clear)
+	nilErr(t, err)
+
+	text, err := taglib.SearchableText(path)
+	nilErr(t, err)
+	eq(t, fmt.Sprint(text), fmt.Sprint(map[string]string{
+		taglib.Title:       "A Day in the Life",
+		taglib.Artist:      "The Beatles",
+		taglib.Genre:       "Rock\nPop",
+		"PERFORMER:GUITAR": "John Lennon",
+	}))
+
+	text, err = taglib.SearchableTextWithLyrics(path)
+	nilErr(t, err)
+	eq(t, text[taglib.Lyrics], "I read the news today oh boy")
+	eq(t, len(text), 5)
+}
+
+func TestIdentify(t *testing.T) {
+	t.Parallel()
+
+	audio := egMP3[10+(int(egMP3[6])<<21|int(egMP3[7])<<14|int(egMP3[8])<<7|int(egMP3[9])):]
+	for _, tt := range []struct {
+		name       string
+		data       []byte
+		format     taglib.FileFormat
+		confidence float64
+	}{
+		{"mp3", egMP3, taglib.FormatMPEG, 0.9},
+		{"mp3 without tag", audio, taglib.FormatMPEG, 0.6},
+		{"flac", egFLAC, taglib.FormatFLAC, 0.99},
+		{"m4a", egM4a, taglib.FormatMP4, 0.95},
+		{"ogg", egOgg, taglib.FormatOggVorbis, 0.99},
+		{"opus", egOpus, taglib.FormatOggOpus, 0.99},
+		{"speex", egSpeex, taglib.FormatOggSpeex, 0.99},
+		{"ogg flac", egOggFLAC, taglib.FormatOggFLAC, 0.99},
+		{"wav", egWAV, taglib.FormatWAV, 0.99},
+		{"aiff", egAIFF, taglib.FormatAIFF, 0.99},
+		{"ape", egAPE, taglib.FormatAPE, 0.99},
+		{"wavpack", egWavPack, taglib.FormatWavPack, 0.99},
+		{"wma", egWMA, taglib.FormatASF, 0.99},
+		{"mka", egMKA, taglib.FormatMatroska, 0.9},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			format, confidence, err := taglib.Identify(bytes.NewReader(tt.data))
+			nilErr(t, err)
+			eq(t, format, tt.format)
+			eq(t, confidence, tt.confidence)
+		})
+	}
+
+	_, _, err := taglib.Identify(bytes.NewReader([]byte("not a file")))
+	eq(t, err, taglib.ErrInvalidFile)
+}
+
+func TestLanguageScript(t *testing.T) {
+	t.Parallel()
+
+	for _, path := range testPaths(t) {
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			f, err := taglib.Open(path)
+			nilErr(t, err)
+			defer func() { _ = f.Close() }()
+
+			nilErr(t, f.SetLanguage([]string{"English", "de", "fre", "eng"}))
+			langs, err := f.Language()
+			nilErr(t, err)
+			eq(t, fmt.Sprint(langs), "[eng deu fra]")
+
+			nilErr(t, f.SetScript("latn"))
+			script, err := f.Script()
+			nilErr(t, err)
+			eq(t, script, "Latn")
+
+			// Invalid values are rejected without writing anything
+			err = f.SetLanguage([]string{"eng", "klingon"})
+			eq(t, errors.Is(err, taglib.ErrInvalidLanguage), true)
+			err = f.SetScript("Elvish")
+			eq(t, errors.Is(err, taglib.ErrInvalidScript), true)
+			langs, err = f.Language()
+			nilErr(t, err)
+			eq(t, len(langs), 3)
+
+			nilErr(t, f.SetLanguage([]string{"zxx"}))
+			langs, err = f.Language()
+			nilErr(t, err)
+			eq(t, fmt.Sprint(langs), "[zxx]")
+
+			// Codes without an ISO 639-1 code are kept
+			nilErr(t, f.SetLanguage([]string{"bho", "KOK"}))
+			langs, err = f.Language()
+			nilErr(t, err)
+			eq(t, fmt.Sprint(langs), "[bho kok]")
+			err = f.SetLanguage([]string{"e1g"})
+			eq(t, errors.Is(err, taglib.ErrInvalidLanguage), true)
+
+			nilErr(t, f.SetLanguage(nil))
+			nilErr(t, f.SetScript(""))
+			langs, err = f.Language()
+			nilErr(t, err)
+			eq(t, len(langs), 0)
+			script, err = f.Script()
+			nilErr(t, err)
+			eq(t, script, "")
+		})
+	}
+}